@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/guonaihong/gout"
+)
+
+const (
+	tokenHeader       = "X-Token"
+	revisionHeader    = "X-Task-Revision"
+	idempotencyHeader = "Idempotency-Key"
+
+	defaultRetry   = 2
+	defaultBackoff = 100 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+)
+
+// gate接口的客户端, 可以并发使用
+// 需要登录的接口自动带上token, token失效时先刷新, 刷新失败再用账号密码重新登录
+type Client struct {
+	// gate的地址, 带上scheme, 比如http://127.0.0.1:3434
+	addr       string
+	httpClient *http.Client
+	userName   string
+	password   string
+	retry      int
+	backoff    time.Duration
+
+	mu    sync.Mutex
+	token string
+	// 登录或者刷新时gate返回的过期时间, 通过WithToken, SetToken设置时为零值
+	expiresAt time.Time
+	// 同一时间只有一个请求去刷新token
+	authMu sync.Mutex
+}
+
+func New(addr string, opts ...Option) *Client {
+	c := &Client{addr: strings.TrimSuffix(addr, "/"), retry: defaultRetry, backoff: defaultBackoff}
+	for _, o := range opts {
+		o(c)
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	return c
+}
+
+// 当前的token, 可以保存下来下次通过WithToken传进来
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Client) SetToken(token string) {
+	c.setToken(tokenRsp{Token: token})
+}
+
+// 当前token的过期时间, 可以在过期之前调用Refresh, 不知道时返回零值
+func (c *Client) TokenExpiresAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiresAt
+}
+
+func (c *Client) setToken(rsp tokenRsp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = rsp.Token
+	c.expiresAt = rsp.ExpiresAt
+}
+
+// gate返回的错误, 和gate的errorRsp是同一种结构
+type Error struct {
+	StatusCode int           `json:"-"`
+	Code       model.ErrCode `json:"code"`
+	Err        string        `json:"error"`
+	Message    string        `json:"message"`
+	// 批量接口出错时每一项的结果
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("crab client:http status:%d, code:%d, %s:%s", e.StatusCode, e.Code, e.Err, e.Message)
+}
+
+// 判断错误是不是gate返回的这个错误码
+func IsCode(err error, code model.ErrCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header gout.H
+	body   any
+	// 需要登录的接口
+	auth bool
+}
+
+// 发送请求, 成功时把响应的data字段解到out里面, out为nil时忽略
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	if req.auth && c.Token() == "" && c.userName != "" {
+		if _, err := c.Login(ctx, c.userName, c.password); err != nil {
+			return err
+		}
+	}
+
+	token := c.Token()
+	err := c.doRetry(ctx, req, out)
+	if !req.auth || !IsCode(err, model.ErrUnauthorized) {
+		return err
+	}
+
+	if e := c.reauth(ctx, token); e != nil {
+		return err
+	}
+	return c.doRetry(ctx, req, out)
+}
+
+// token失效之后先刷新, 刷新失败再登录, old是失效的token, 已经被别的请求换掉时直接返回
+func (c *Client) reauth(ctx context.Context, old string) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if token := c.Token(); token != old && token != "" {
+		return nil
+	}
+
+	if old != "" {
+		if _, err := c.Refresh(ctx); err == nil {
+			return nil
+		}
+	}
+
+	if c.userName == "" {
+		return errors.New("crab client:token expired and no login configured")
+	}
+	_, err := c.Login(ctx, c.userName, c.password)
+	return err
+}
+
+// 连接出错和502, 503, 504重试, 其他的错误直接返回
+func (c *Client) doRetry(ctx context.Context, req request, out any) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		code, body, err := c.send(ctx, req)
+		if err == nil {
+			err = decode(code, body, out)
+		}
+
+		if attempt >= c.retry || !retryable(code, err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func retryable(code int, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch code {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) send(ctx context.Context, req request) (code int, body string, err error) {
+	u := c.addr + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	header := gout.H{}
+	for k, v := range req.header {
+		header[k] = v
+	}
+	if token := c.Token(); req.auth && token != "" {
+		header[tokenHeader] = token
+	}
+
+	g := gout.New(c.httpClient).SetMethod(req.method).SetURL(u).WithContext(ctx).SetHeader(header)
+	if req.body != nil {
+		g.SetJSON(req.body)
+	}
+
+	err = g.Code(&code).BindBody(&body).Do()
+	return code, body, err
+}
+
+// 非2xx的响应解成Error, 不是gate的错误格式时message是原始的body
+func decode(code int, body string, out any) error {
+	if code < 200 || code >= 300 {
+		e := &Error{StatusCode: code}
+		if err := json.Unmarshal([]byte(body), e); err != nil || e.Err == "" {
+			e.Message = body
+		}
+		return e
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	var rsp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &rsp); err != nil {
+		return fmt.Errorf("crab client:decode response:%w", err)
+	}
+	if len(rsp.Data) == 0 || string(rsp.Data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(rsp.Data, out); err != nil {
+		return fmt.Errorf("crab client:decode data:%w", err)
+	}
+	return nil
+}
+
+type tokenRsp struct {
+	Token     string    `json:"token"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// 登录成功之后保存token, 后面的请求自动带上
+func (c *Client) Login(ctx context.Context, userName, password string) (string, error) {
+	var rsp tokenRsp
+	err := c.doRetry(ctx, request{
+		method: http.MethodPost,
+		path:   model.UI_USER_LOGIN,
+		body:   map[string]string{"username": userName, "password": password},
+	}, &rsp)
+	if err != nil {
+		return "", err
+	}
+
+	c.setToken(rsp)
+	return rsp.Token, nil
+}
+
+// 用当前的token换一个新的token, 刚过期的token也可以刷新
+func (c *Client) Refresh(ctx context.Context) (string, error) {
+	var rsp tokenRsp
+	err := c.doRetry(ctx, request{
+		method: http.MethodPost,
+		path:   model.UI_USER_REFRESH,
+		header: gout.H{tokenHeader: c.Token()},
+	}, &rsp)
+	if err != nil {
+		return "", err
+	}
+
+	c.setToken(rsp)
+	return rsp.Token, nil
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code model.ErrCode, msg string) {
+	writeJSON(w, code.Status(), Error{Code: code, Err: code.String(), Message: msg})
+}
+
+// 没有token时先登录, 503重试时带上同一个Idempotency-Key
+func Test_Client_LoginAndCreate(t *testing.T) {
+	var creates atomic.Int32
+	var keys []string
+	expiresAt := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc(model.UI_USER_LOGIN, func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "guest", req["username"])
+		assert.Equal(t, "pass", req["password"])
+		writeJSON(w, 200, map[string]any{"data": tokenRsp{Token: "t1", ExpiresAt: expiresAt}})
+	})
+	mux.HandleFunc(model.TASK_CREATE_URL, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "t1", r.Header.Get(tokenHeader))
+		keys = append(keys, r.Header.Get(idempotencyHeader))
+
+		if creates.Add(1) == 1 {
+			writeError(w, model.ErrUnavailable, "etcd unavailable")
+			return
+		}
+
+		var param model.Param
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&param))
+		writeJSON(w, 200, map[string]any{"data": TaskRevision{TaskName: param.Executer.TaskName, Revision: 7, State: model.CanRun}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL, WithLogin("guest", "pass"), WithRetry(2, time.Millisecond))
+	var param model.Param
+	param.Executer.TaskName = "hello"
+	rsp, err := c.CreateTask(context.Background(), &param)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", rsp.TaskName)
+	assert.Equal(t, int64(7), rsp.Revision)
+	assert.Equal(t, "t1", c.Token())
+	assert.True(t, expiresAt.Equal(c.TokenExpiresAt()), "%v", c.TokenExpiresAt())
+
+	assert.Equal(t, int32(2), creates.Load())
+	if assert.Len(t, keys, 2) {
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[1])
+	}
+}
+
+// token失效时先刷新, 刷新失败再重新登录
+func Test_Client_Reauth(t *testing.T) {
+	var logins atomic.Int32
+	var refreshOK atomic.Bool
+	refreshOK.Store(true)
+	mux := http.NewServeMux()
+	mux.HandleFunc(model.UI_USER_REFRESH, func(w http.ResponseWriter, r *http.Request) {
+		if !refreshOK.Load() || r.Header.Get(tokenHeader) != "old" {
+			writeError(w, model.ErrUnauthorized, "refresh token")
+			return
+		}
+		writeJSON(w, 200, map[string]any{"data": tokenRsp{Token: "refreshed"}})
+	})
+	mux.HandleFunc(model.UI_USER_LOGIN, func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		writeJSON(w, 200, map[string]any{"data": tokenRsp{Token: "login"}})
+	})
+	mux.HandleFunc(model.TASK_UI_STATUS_URL, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get(tokenHeader) {
+		case "refreshed", "login":
+		default:
+			writeError(w, model.ErrUnauthorized, "token expired")
+			return
+		}
+
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		assert.Equal(t, "a", r.URL.Query().Get("name_prefix"))
+		assert.Equal(t, []string{"env:prod", "team:x"}, r.URL.Query()["label"])
+		writeJSON(w, 200, map[string]any{"data": StatusList{Total: 1, Limit: 10, Items: []StatusItem{{TaskName: "a1", Status: "running"}}}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	q := StatusQuery{NamePrefix: "a", Label: []string{"env:prod", "team:x"}}
+	c := New(srv.URL, WithToken("old"), WithLogin("guest", "pass"))
+	list, err := c.Status(context.Background(), q)
+	assert.NoError(t, err)
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, "a1", list.Items[0].TaskName)
+	}
+	assert.Equal(t, "refreshed", c.Token())
+	assert.Equal(t, int32(0), logins.Load())
+
+	refreshOK.Store(false)
+	c.SetToken("old")
+	_, err = c.Status(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, "login", c.Token())
+	assert.Equal(t, int32(1), logins.Load())
+
+	// 没有配置账号时返回原来的401
+	c = New(srv.URL, WithToken("expired"))
+	_, err = c.Status(context.Background(), q)
+	assert.True(t, IsCode(err, model.ErrUnauthorized), "%v", err)
+}
+
+// gate返回的错误解成Error, 请求本身的错误不重试
+func Test_Client_Error(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		assert.Equal(t, http.MethodDelete, r.Method)
+		var req model.OnlyParam
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "missing", req.Executer.TaskName)
+		writeError(w, model.ErrNotFound, "task not found")
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithToken("t"), WithRetry(3, time.Millisecond))
+	err := c.DeleteTask(context.Background(), "missing")
+	assert.True(t, IsCode(err, model.ErrNotFound), "%v", err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	var e *Error
+	if assert.ErrorAs(t, err, &e) {
+		assert.Equal(t, http.StatusNotFound, e.StatusCode)
+		assert.Equal(t, "task not found", e.Message)
+	}
+}
+
+// 连上之后先发whoami, 收到gate推送的task之后先回ack, 重发的task不再返回, 最后上报结果
+func Test_Client_StreamTasks(t *testing.T) {
+	who := model.Whoami{Name: "runtime-1", Id: "id-1"}
+	got := make(chan model.RuntimeMsg, 3)
+	upgrader := websocket.Upgrader{Subprotocols: model.StreamProtocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, model.TASK_STREAM_URL, r.URL.Path)
+		con, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer con.Close()
+
+		var first model.Whoami
+		assert.NoError(t, con.ReadJSON(&first))
+		assert.Equal(t, who, first)
+
+		var param model.Param
+		param.Action = model.Create
+		param.Executer.TaskName = "hello"
+		param.DispatchID = "d1"
+		// 第二次是没有收到ack的重发
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, con.WriteJSON(param))
+		}
+
+		for i := 0; i < 3; i++ {
+			var msg model.RuntimeMsg
+			assert.NoError(t, con.ReadJSON(&msg))
+			got <- msg
+		}
+	}))
+	defer srv.Close()
+
+	s, err := New(srv.URL).StreamTasks(context.Background(), who)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	assert.Equal(t, model.StreamProtocolV2, s.Protocol())
+
+	param, err := s.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", param.Executer.TaskName)
+
+	// 重发的task只回ack, 不会从Recv返回
+	go s.Recv()
+	assert.NoError(t, s.ReportResult(&model.TaskResult{TaskName: "hello", ExitCode: 1}))
+
+	var acks int
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-got:
+			assert.Equal(t, who, msg.Whoami)
+			if msg.Ack != nil {
+				acks++
+				assert.Equal(t, model.TaskAck{TaskName: "hello", DispatchID: "d1"}, *msg.Ack)
+				continue
+			}
+			if assert.NotNil(t, msg.Result) {
+				assert.Equal(t, 1, msg.Result.ExitCode)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	assert.Equal(t, 2, acks)
+}
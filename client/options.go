@@ -0,0 +1,39 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+type Option func(c *Client)
+
+// 设置http.Client, 没有设置时用http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// 设置已经有的token, 比如从CRAB_TOKEN环境变量里面读出来的
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// 设置账号和密码, 没有token或者token刷新失败时自动登录
+func WithLogin(userName, password string) Option {
+	return func(c *Client) {
+		c.userName = userName
+		c.password = password
+	}
+}
+
+// 设置重试次数和第一次重试前的等待时间, 之后每次翻倍
+// 只重试连接出错和502, 503, 504, 创建task时带上同一个Idempotency-Key, 重试不会重复创建
+func WithRetry(retry int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retry = retry
+		c.backoff = backoff
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gorilla/websocket"
+)
+
+// runtime和gate之间的长连接, 接收gate推送的task, 上报执行的进度和结果
+// 连上之后每隔model.RuntimeKeepalive发一次whoami作为心跳, gate的ping由websocket库自动回复
+type Stream struct {
+	conn *websocket.Conn
+	who  model.Whoami
+	// 同一时间只能有一个写
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	// 每个task最近一次收到的DispatchID, 只在Recv里面用
+	dispatched map[string]string
+}
+
+// 连接gate的流式接口, ctx取消或者调用Close时断开
+// 这个接口不需要登录, who.Name是runtime在gate上注册的节点名
+func (c *Client) StreamTasks(ctx context.Context, who model.Whoami) (*Stream, error) {
+	d := *websocket.DefaultDialer
+	d.EnableCompression = true
+	d.Subprotocols = model.StreamProtocols
+
+	conn, rsp, err := d.DialContext(ctx, wsURL(c.addr)+model.TASK_STREAM_URL, nil)
+	if err != nil {
+		if rsp != nil {
+			return nil, fmt.Errorf("crab client:dial stream, http status:%d:%w", rsp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("crab client:dial stream:%w", err)
+	}
+
+	s := &Stream{conn: conn, who: who, done: make(chan struct{})}
+	if err = s.write(who); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go s.keepalive(ctx)
+	return s, nil
+}
+
+// http://, https://换成ws://, wss://
+func wsURL(addr string) string {
+	if strings.HasPrefix(addr, "http") {
+		return "ws" + strings.TrimPrefix(addr, "http")
+	}
+	return addr
+}
+
+func (s *Stream) keepalive(ctx context.Context) {
+	tk := time.NewTicker(model.RuntimeKeepalive)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return
+		case <-s.done:
+			return
+		case <-tk.C:
+			if err := s.write(s.who); err != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *Stream) write(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(model.RuntimeKeepalive))
+	return s.conn.WriteJSON(v)
+}
+
+// 阻塞读取gate推送的下一个task, 按param.Action区分创建, 更新, 停止和删除
+// 连接断开之后返回错误, 需要重新调用StreamTasks
+// v2协议下发的task带DispatchID, 收到之后自动回ack, gate没有收到ack重发的task不再返回
+func (s *Stream) Recv() (*model.Param, error) {
+	for {
+		var param model.Param
+		if err := s.conn.ReadJSON(&param); err != nil {
+			return nil, err
+		}
+
+		if param.DispatchID == "" {
+			return &param, nil
+		}
+
+		ack := &model.TaskAck{TaskName: param.Executer.TaskName, DispatchID: param.DispatchID}
+		if err := s.write(model.RuntimeMsg{Whoami: s.who, Ack: ack}); err != nil {
+			return nil, err
+		}
+
+		if s.dispatched == nil {
+			s.dispatched = make(map[string]string)
+		}
+		if s.dispatched[param.Executer.TaskName] == param.DispatchID {
+			continue
+		}
+		s.dispatched[param.Executer.TaskName] = param.DispatchID
+		return &param, nil
+	}
+}
+
+// 开始执行, 只有设置了Timeout或者StallWindow的task需要上报
+func (s *Stream) ReportStarted(started *model.TaskStarted) error {
+	return s.write(model.RuntimeMsg{Whoami: s.who, Started: started})
+}
+
+// 执行中的心跳, 只有设置了StallWindow的task需要上报
+func (s *Stream) ReportAlive(alive *model.TaskAlive) error {
+	return s.write(model.RuntimeMsg{Whoami: s.who, Alive: alive})
+}
+
+// 执行结果, gate保存到task的状态里面
+func (s *Stream) ReportResult(result *model.TaskResult) error {
+	return s.write(model.RuntimeMsg{Whoami: s.who, Result: result})
+}
+
+// 协商出来的消息格式版本
+func (s *Stream) Protocol() string {
+	return model.StreamProtocol(s.conn.Subprotocol())
+}
+
+func (s *Stream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
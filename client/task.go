@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/guonaihong/gout"
+)
+
+// 创建和更新task的结果
+type TaskRevision struct {
+	// 实际保存的task名, gate开启归一化之后可能和请求里面的不一样
+	TaskName string `json:"taskName"`
+	// 数据的revision, 更新时传回来做乐观锁
+	Revision int64  `json:"revision"`
+	Key      string `json:"key"`
+	State    string `json:"state"`
+}
+
+// 创建task, 重试时带上同一个Idempotency-Key, gate返回第一次创建的结果
+func (c *Client) CreateTask(ctx context.Context, param *model.Param) (*TaskRevision, error) {
+	var rsp TaskRevision
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   model.TASK_CREATE_URL,
+		header: gout.H{idempotencyHeader: uuid.New().String()},
+		body:   param,
+		auth:   true,
+	}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+// 更新task, revision大于0时只有task的revision没有变过才会更新, 否则返回model.ErrConflict
+func (c *Client) UpdateTask(ctx context.Context, param *model.Param, revision int64) (*TaskRevision, error) {
+	req := request{
+		method: http.MethodPut,
+		path:   model.TASK_UPDATE_URL,
+		body:   param,
+		auth:   true,
+	}
+	if revision > 0 {
+		req.header = gout.H{revisionHeader: strconv.FormatInt(revision, 10)}
+	}
+
+	var rsp TaskRevision
+	if err := c.do(ctx, req, &rsp); err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+// 删除, 停止和继续只需要task名
+func onlyParam(taskName string) model.OnlyParam {
+	var p model.OnlyParam
+	p.Executer.TaskName = taskName
+	return p
+}
+
+func (c *Client) DeleteTask(ctx context.Context, taskName string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: model.TASK_DELETE_URL, body: onlyParam(taskName), auth: true}, nil)
+}
+
+func (c *Client) StopTask(ctx context.Context, taskName string) error {
+	return c.do(ctx, request{method: http.MethodPatch, path: model.TASK_STOP_URL, body: onlyParam(taskName), auth: true}, nil)
+}
+
+func (c *Client) ContinueTask(ctx context.Context, taskName string) error {
+	return c.do(ctx, request{method: http.MethodPatch, path: model.TASK_CONTINUE_URL, body: onlyParam(taskName), auth: true}, nil)
+}
+
+// status接口的过滤和分页参数, 零值表示不过滤
+type StatusQuery struct {
+	Limit int
+	// 游标分页, 上一页返回的NextStartKey
+	StartKey   string
+	State      string
+	NamePrefix string
+	// 格式是key:value, 多个标签之间是and的关系
+	Label []string
+}
+
+// status接口返回的一个task
+type StatusItem struct {
+	TaskName     string    `json:"task_name"`
+	Trigger      string    `json:"trigger"`
+	TriggerValue string    `json:"trigger_value"`
+	Status       string    `json:"status"`
+	CreateTime   time.Time `json:"create_time"`
+	UpdateTime   time.Time `json:"update_time"`
+	RuntimeID    string    `json:"runtime_id"`
+	Priority     int       `json:"priority"`
+
+	Task        json.RawMessage   `json:"task"`
+	Revision    int64             `json:"revision"`
+	NextWindow  *time.Time        `json:"next_window,omitempty"`
+	NextRun     *time.Time        `json:"next_run,omitempty"`
+	RuntimeNode string            `json:"runtime_node,omitempty"`
+	LastResult  *model.TaskResult `json:"last_result,omitempty"`
+	Disabled    bool              `json:"disabled"`
+	StopReason  string            `json:"stop_reason,omitempty"`
+	Liveness    string            `json:"liveness,omitempty"`
+	// 最近一次变更推送给runtime的次数
+	DispatchAttempts int `json:"dispatch_attempts,omitempty"`
+}
+
+// 解出task的数据
+func (s *StatusItem) Param() (*model.Param, error) {
+	var p model.Param
+	if err := json.Unmarshal(s.Task, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+type StatusList struct {
+	Total int64        `json:"total"`
+	Limit int          `json:"limit"`
+	Items []StatusItem `json:"items"`
+	// 还有下一页时才有值
+	NextStartKey string `json:"next_start_key,omitempty"`
+}
+
+func (c *Client) Status(ctx context.Context, q StatusQuery) (*StatusList, error) {
+	query := url.Values{"format": {"json"}}
+	if q.Limit > 0 {
+		query["limit"] = []string{strconv.Itoa(q.Limit)}
+	}
+	for k, v := range map[string]string{"start_key": q.StartKey, "state": q.State, "name_prefix": q.NamePrefix} {
+		if v != "" {
+			query[k] = []string{v}
+		}
+	}
+	if len(q.Label) > 0 {
+		query["label"] = q.Label
+	}
+
+	var rsp StatusList
+	err := c.do(ctx, request{method: http.MethodGet, path: model.TASK_UI_STATUS_URL, query: query, auth: true}, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
@@ -17,6 +17,7 @@ type CrudOpt struct {
 	FileName string   `clop:"short;long" usage:"config filename" valid:"required"`
 	GateAddr []string `clop:"short;long" usage:"gate address" valid:"required"`
 	Debug    bool     `clop:"short;long" usage:"debug mode"`
+	Token    string   `clop:"long;env=CRAB_TOKEN" usage:"token returned by the login api"`
 }
 
 type Rm struct {
@@ -30,6 +31,7 @@ func (r *Rm) SubMain() {
 
 type Start struct {
 	CrudOpt
+	DryRun bool `clop:"long" usage:"only validate the task, do not write to etcd"`
 }
 
 // start子命令入口函数
@@ -39,7 +41,12 @@ func (r *Start) SubMain() {
 		return
 	}
 
-	err := r.Crud(r.GateAddr[0]+model.TASK_CREATE_URL, http.MethodPost)
+	url := r.GateAddr[0] + model.TASK_CREATE_URL
+	if r.DryRun {
+		url += "?dry_run=true"
+	}
+
+	err := r.Crud(url, http.MethodPost)
 	if err != nil {
 		fmt.Printf("start: %s\n", err)
 	}
@@ -78,6 +85,9 @@ func (c *CrudOpt) Crud(url string, method string) error {
 	code := 0
 	s := ""
 	req := gout.New().SetMethod(strings.ToUpper(method)).SetURL(url).Debug(c.Debug)
+	if c.Token != "" {
+		req.SetHeader(gout.H{"X-Token": c.Token})
+	}
 
 	var param model.Param
 	if strings.HasSuffix(fileName, ".yaml") || strings.HasSuffix(fileName, ".yml") {
@@ -19,6 +19,8 @@ type Etcd struct {
 	TaskName string   `clop:"short;long" usage:"task name" valid:"required"`
 	Get      bool     `clop:"long" usage:"get etcd value"`
 	Debug    bool     `clop:"long" usage:"debug mode"`
+	// 和gate, mjobs, runtime的namespace一致
+	Namespace string `clop:"long;env=CRAB_NAMESPACE" usage:"etcd key namespace"`
 }
 
 var (
@@ -28,11 +30,11 @@ var (
 
 func (e *Etcd) init() (err error) {
 
-	if defautlClient, err = utils.NewEtcdClient(e.EtcdAddr); err != nil { //初始etcd客户端
+	if defautlClient, err = utils.NewEtcdClientWithConfig(e.EtcdAddr, utils.EtcdConfig{Namespace: e.Namespace}); err != nil { //初始etcd客户端
 		return err
 	}
 
-	defaultKVC = clientv3.NewKV(defautlClient) // 内置自动重试的逻辑
+	defaultKVC = defautlClient.KV // 内置自动重试的逻辑, 配置了namespace时自动加上前缀
 	return nil
 }
 
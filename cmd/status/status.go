@@ -1,29 +1,55 @@
 package status
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/1whour/crab/client"
 	"github.com/1whour/crab/model"
 	"github.com/guonaihong/gout"
 )
 
 type Status struct {
 	GateAddr []string `clop:"short;long" usage:"gate address" valid:"required"`
-	UserName string   `clop:"short;long" usage:"username" valid:"required"`
+	// 没有传token时用账号密码登录
+	UserName string `clop:"short;long" usage:"username, used to login when token is empty"`
 
-	Password string `clop:"short;long" usage:"password" valid:"required"`
+	Password string `clop:"short;long" usage:"password, used to login when token is empty"`
+
+	Token      string `clop:"long;env=CRAB_TOKEN" usage:"token returned by the login api"`
+	State      string `clop:"long" usage:"only show tasks in this state(running, stop)"`
+	NamePrefix string `clop:"long" usage:"only show tasks whose name has this prefix"`
 
 	Debug bool `clop:"short;long" usage:"debug"`
 }
 
+// 优先用传进来的token, 没有时用账号密码登录拿一个
+func (s *Status) token() (string, error) {
+	if s.Token != "" {
+		return s.Token, nil
+	}
+
+	if s.UserName == "" {
+		return "", fmt.Errorf("status:token or username is required")
+	}
+	return client.New(s.GateAddr[0]).Login(context.Background(), s.UserName, s.Password)
+}
+
 func (s *Status) SubMain() {
+	token, err := s.token()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
 	u := fmt.Sprintf("%s%s", s.GateAddr[0], model.TASK_UI_STATUS_URL)
 
-	err := gout.
+	err = gout.
 		GET(u).
 		Debug(s.Debug).
-		SetQuery(gout.H{"format": "table"}).
+		SetQuery(gout.H{"format": "table", "state": s.State, "name_prefix": s.NamePrefix}).
+		SetHeader(gout.H{"X-Token": token}).
 		BindBody(os.Stdout).Do()
 	if err != nil {
 		fmt.Println(err.Error())
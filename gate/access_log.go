@@ -0,0 +1,30 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 访问日志, 通过request_id和error系列函数打印的日志关联起来
+func (r *Gate) accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		e := r.Info()
+		if status >= 500 {
+			e = r.Warn()
+		}
+
+		e.RequestID(getRequestID(c)).
+			Str("method", c.Request.Method).
+			Str("path", path).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("client_ip", c.ClientIP()).
+			Msg("access")
+	}
+}
@@ -0,0 +1,35 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 访问日志带上request id
+func Test_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	g := Gate{RequestIDHeader: defaultRequestIDHeader, Slog: slog.New(&buf).SetLevel("info")}
+
+	router := gin.New()
+	router.Use(g.requestID(), g.accessLog())
+	router.GET("/hello", func(c *gin.Context) { c.String(201, "") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello?a=b", nil))
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/hello", line["path"])
+	assert.Equal(t, float64(201), line["status"])
+	assert.Equal(t, w.Header().Get(defaultRequestIDHeader), line["request_id"])
+	assert.Contains(t, line, "latency")
+	assert.Contains(t, line, "client_ip")
+}
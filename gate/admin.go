@@ -0,0 +1,53 @@
+package gate
+
+import (
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 管理员的角色名
+	adminRule = "admin"
+	// 保存在gin.Context里面的用户名
+	userNameKey = "crab_user_name"
+)
+
+// 从查询字符串或者http header(X-Token或者token)里面取token
+func getToken(c *gin.Context) string {
+	token := c.Query(tokenQuery)
+	if len(token) == 0 {
+		token = c.GetHeader(tokenHeader)
+	}
+	if len(token) == 0 {
+		token = c.GetHeader(tokenQuery)
+	}
+	return token
+}
+
+// 当前登录的用户, 以及是不是管理员, 要在authRequired之后调用
+func (r *Gate) currentUser(c *gin.Context) (LoginCore, bool, error) {
+	rv, err := r.loginTable.query(LoginCore{UserName: c.GetString(userNameKey)})
+	if err != nil {
+		return rv, false, err
+	}
+	return rv, rv.Rule == adminRule, nil
+}
+
+// 只允许管理员访问
+func (r *Gate) adminOnly(c *gin.Context) {
+	userName, err := r.parseToken(getToken(c))
+	if err != nil {
+		r.error(c, model.ErrUnauthorized, "invalid token:%s", err)
+		c.Abort()
+		return
+	}
+
+	rv, err := r.loginTable.query(LoginCore{UserName: userName})
+	if err != nil || rv.Rule != adminRule {
+		r.error(c, model.ErrForbidden, "user(%s) is not admin", userName)
+		c.Abort()
+		return
+	}
+
+	c.Set(userNameKey, userName)
+}
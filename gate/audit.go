@@ -0,0 +1,116 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 任务变更的审计记录, 保存在etcd里面, 和普通日志不一样, 需要持久化并且可以查询
+type auditRecord struct {
+	ID string `json:"id"`
+	// 操作人, 来自jwt的subject
+	User     string    `json:"user"`
+	Action   string    `json:"action"`
+	TaskName string    `json:"taskName"`
+	Time     time.Time `json:"time"`
+	// 变更前后数据的revision, 创建时before是0, 删除时after是0
+	BeforeRevision int64  `json:"beforeRevision"`
+	AfterRevision  int64  `json:"afterRevision"`
+	RequestID      string `json:"requestId"`
+}
+
+type pageAudit struct {
+	Limit int64 `form:"limit" json:"limit"`
+	// 和status接口一样的游标分页, 结果包含start_key本身
+	StartKey string `form:"start_key" json:"start_key"`
+}
+
+// id以纳秒时间戳开头, etcd里面按key排序就是按时间排序
+func newAuditID(now time.Time) string {
+	return fmt.Sprintf("%019d-%s", now.UnixNano(), uuid.New().String())
+}
+
+// 生成审计记录的写操作, 和任务的变更放在同一个事务里面, 变更成功就一定有审计记录
+// 变更后的revision就是这条记录的ModRevision, 写入时还不知道, 查询时补上
+func (r *Gate) auditOp(c *gin.Context, action, taskName string, before int64) clientv3.Op {
+	now := time.Now()
+	rec := auditRecord{
+		ID:             newAuditID(now),
+		User:           c.GetString(userNameKey),
+		Action:         action,
+		TaskName:       taskName,
+		Time:           now,
+		BeforeRevision: before,
+		RequestID:      getRequestID(c),
+	}
+
+	// 字段都是基本类型, 不会序列化失败
+	value, _ := json.Marshal(rec)
+	return clientv3.OpPut(model.AuditPrefix+"/"+rec.ID, string(value))
+}
+
+// 批量接口里面给每个task生成一条审计记录, nil表示不写
+type auditFunc func(taskName string, before int64) clientv3.Op
+
+func (r *Gate) auditFunc(c *gin.Context, action string) auditFunc {
+	return func(taskName string, before int64) clientv3.Op {
+		return r.auditOp(c, action, taskName, before)
+	}
+}
+
+// 按时间顺序翻页查询审计记录
+func (g *Gate) auditList(c *gin.Context) {
+	p := pageAudit{}
+	if err := c.ShouldBindQuery(&p); err != nil {
+		g.error(c, model.ErrValidation, "auditList:%s", err)
+		return
+	}
+
+	// 和status接口一样有上限
+	p.Limit = int64(g.statusLimit(int(p.Limit)))
+
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+	prefix := model.AuditPrefix + "/"
+	total, err := g.etcdGet(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		g.etcdError(c, ctx, err, "auditList")
+		return
+	}
+
+	rsp, err := g.etcdGet(ctx, prefix+p.StartKey,
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		clientv3.WithLimit(p.Limit))
+	if err != nil {
+		g.etcdError(c, ctx, err, "auditList")
+		return
+	}
+
+	list := make([]auditRecord, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		var rec auditRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			g.Warn().Msgf("auditList:unmarshal %s:%s", kv.Key, err)
+			continue
+		}
+		// 删除之后没有新的数据, after保持0
+		if rec.AfterRevision == 0 && rec.Action != model.Rm {
+			rec.AfterRevision = kv.ModRevision
+		}
+		list = append(list, rec)
+	}
+
+	next := ""
+	if rsp.More && len(list) > 0 {
+		next = list[len(list)-1].ID + "\x00"
+	}
+
+	c.JSON(200, wrapData{Data: taskStatusList{Total: total.Count, Items: list, NextStartKey: next}})
+}
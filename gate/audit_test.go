@@ -0,0 +1,80 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 审计记录带上操作人和revision, 按时间顺序游标翻页
+func Test_AuditList(t *testing.T) {
+	g := testInitEtcdGate(t)
+	start := fmt.Sprintf("%019d", time.Now().UnixNano())
+
+	router := gin.New()
+	router.Use(g.requestID(), func(c *gin.Context) { c.Set(userNameKey, "guest") })
+	router.POST("/", func(c *gin.Context) {
+		_, err := defaultKVC.Do(c.Request.Context(), g.auditOp(c, c.Query("action"), c.Query("task"), 1))
+		assert.NoError(t, err)
+	})
+	router.GET("/", g.auditList)
+
+	actions := []string{model.Create, model.Update, model.Stop, model.Rm}
+	for _, action := range actions {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/?task=audit-test&action="+action, nil))
+	}
+
+	list := func(startKey string) (rv []auditRecord, next string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?limit=2&start_key="+url.QueryEscape(startKey), nil))
+		assert.Equal(t, 200, w.Code)
+
+		var rsp struct {
+			Data struct {
+				Items        []auditRecord `json:"items"`
+				NextStartKey string        `json:"next_start_key"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp.Data.Items, rsp.Data.NextStartKey
+	}
+
+	page1, next := list(start)
+	assert.Len(t, page1, 2)
+	assert.NotEmpty(t, next)
+	page2, next := list(next)
+	assert.Len(t, page2, 2)
+	assert.Empty(t, next)
+
+	for i, rec := range append(page1, page2...) {
+		assert.Equal(t, actions[i], rec.Action)
+		assert.Equal(t, "guest", rec.User)
+		assert.Equal(t, "audit-test", rec.TaskName)
+		assert.Equal(t, int64(1), rec.BeforeRevision)
+		// 变更后的revision是记录自己的ModRevision, 删除之后是0
+		if rec.Action == model.Rm {
+			assert.Equal(t, int64(0), rec.AfterRevision)
+		} else {
+			assert.True(t, rec.AfterRevision > 1)
+		}
+		assert.NotEmpty(t, rec.RequestID)
+	}
+
+	// limit超过上限时截断
+	g.MaxStatusLimit = 1
+	page1, next = list(start)
+	assert.Len(t, page1, 1)
+	assert.NotEmpty(t, next)
+
+	defaultKVC.Delete(g.ctx, model.AuditPrefix+"/"+start, clientv3.WithRange(clientv3.GetPrefixRangeEnd(model.AuditPrefix+"/")))
+}
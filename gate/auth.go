@@ -0,0 +1,28 @@
+package gate
+
+import (
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 需要登录才能访问的接口, token无效直接返回401
+func (r *Gate) authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := getToken(c)
+		if len(token) == 0 {
+			r.error(c, model.ErrUnauthorized, "missing token, set the %s header or the %s query", tokenHeader, tokenQuery)
+			c.Abort()
+			return
+		}
+
+		userName, err := r.parseToken(token)
+		if err != nil {
+			r.error(c, model.ErrUnauthorized, "invalid token:%s", err)
+			c.Abort()
+			return
+		}
+
+		c.Set(userNameKey, userName)
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 没有token或者token无效返回401, 有效的token放行并记录用户名
+func Test_AuthRequired(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error")}
+	g.initToken()
+
+	router := gin.New()
+	router.GET("/", g.authRequired(), func(c *gin.Context) { c.String(200, c.GetString(userNameKey)) })
+
+	tok, err := g.genToken("guest")
+	assert.NoError(t, err)
+	token := tok.Token
+
+	for _, tc := range []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"", "", 401},
+		{tokenHeader, "bad token", 401},
+		{tokenHeader, token, 200},
+		{tokenQuery, token, 200},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.code, w.Code, tc.header)
+		if tc.code == 200 {
+			assert.Equal(t, "guest", w.Body.String())
+		} else {
+			assert.Contains(t, w.Body.String(), "token")
+		}
+	}
+}
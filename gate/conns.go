@@ -0,0 +1,169 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultSendQueueSize = 64
+
+var (
+	errSendQueueFull = errors.New("send queue is full, close the connection")
+	errSendExpired   = errors.New("write deadline exceeded while waiting in the send queue")
+	errConnClosed    = errors.New("connection is closed")
+)
+
+// runtime的长连接, gorilla/websocket不支持并发写, 所有的写都放到队列里面, 由writeLoop串行写入
+type runtimeConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+	// 待发送的消息, 满了说明runtime读得太慢, 直接断开
+	sendq     chan *sendReq
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// 协商出来的协议会回ack, 下发之后等ack再更新状态
+	ack atomic.Bool
+}
+
+type sendReq struct {
+	v        any
+	deadline time.Time
+	errc     chan error
+}
+
+func newRuntimeConn(conn *websocket.Conn, queueSize int) *runtimeConn {
+	c := &runtimeConn{conn: conn, sendq: make(chan *sendReq, queueSize), done: make(chan struct{})}
+	go c.writeLoop()
+	return c
+}
+
+// 放入发送队列, 等待写入的结果, 排队的时间也算在超时时间里面
+func (c *runtimeConn) writeJSON(v any, to time.Duration) error {
+	req, err := c.enqueue(v, to)
+	if err != nil {
+		return err
+	}
+	return c.wait(req)
+}
+
+// 只放入发送队列不等结果, 不会阻塞, 先放入的先写
+func (c *runtimeConn) enqueue(v any, to time.Duration) (*sendReq, error) {
+	req := &sendReq{v: v, deadline: time.Now().Add(to), errc: make(chan error, 1)}
+	select {
+	case c.sendq <- req:
+		return req, nil
+	case <-c.done:
+		return nil, errConnClosed
+	default:
+		c.close()
+		return nil, errSendQueueFull
+	}
+}
+
+func (c *runtimeConn) wait(req *sendReq) error {
+	select {
+	case err := <-req.errc:
+		return err
+	case <-c.done:
+		return errConnClosed
+	}
+}
+
+// 写失败之后连接已经不可用, 直接断开, runtime会重连
+func (c *runtimeConn) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case req := <-c.sendq:
+			err := c.write(req)
+			req.errc <- err
+			if err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *runtimeConn) write(req *sendReq) error {
+	if time.Now().After(req.deadline) {
+		return errSendExpired
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(req.deadline)
+	err := c.conn.WriteJSON(req.v)
+	c.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+// 可以重复调用
+func (c *runtimeConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (r *Gate) sendQueueSize() int {
+	if r.SendQueueSize <= 0 {
+		return defaultSendQueueSize
+	}
+	return r.SendQueueSize
+}
+
+// 保存runtime的长连接, 同名的runtime重连时覆盖旧的连接
+func (r *Gate) addConn(name string, conn *websocket.Conn) *runtimeConn {
+	r.connsMu.Lock()
+	defer r.connsMu.Unlock()
+
+	c := newRuntimeConn(conn, r.sendQueueSize())
+	r.conns.Store(name, c)
+	return c
+}
+
+// 连接断开时删除, 如果已经被新的连接覆盖就不删除
+func (r *Gate) removeConn(name string, c *runtimeConn) {
+	r.connsMu.Lock()
+	defer r.connsMu.Unlock()
+
+	if old, ok := r.conns.Load(name); ok && old == c {
+		r.conns.Delete(name)
+	}
+}
+
+func (r *Gate) loadConn(name string) (*runtimeConn, bool) {
+	c, ok := r.conns.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(*runtimeConn), true
+}
+
+// 按runtime名获取长连接
+func (r *Gate) getConn(name string) (*websocket.Conn, bool) {
+	c, ok := r.loadConn(name)
+	if !ok {
+		return nil, false
+	}
+	return c.conn, true
+}
+
+// 按runtime名找到对应的长连接, 往runtime下发任务
+func (r *Gate) dispatch(runtimeName string, v any) error {
+	c, ok := r.loadConn(runtimeName)
+	if !ok {
+		return fmt.Errorf("runtime(%s) is not connected to this gate", runtimeName)
+	}
+
+	return c.writeJSON(v, r.WriteTime)
+}
@@ -0,0 +1,142 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// 按runtime名找到长连接下发任务
+func Test_Dispatch(t *testing.T) {
+	g := Gate{WriteTime: time.Second}
+
+	stored := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := testUpgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		g.addConn("runtime-1", con)
+		close(stored)
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	<-stored
+
+	var param model.Param
+	param.Executer.TaskName = "task"
+	param.SetCreate()
+	assert.NoError(t, g.dispatch("runtime-1", &param))
+
+	var got model.Param
+	assert.NoError(t, client.ReadJSON(&got))
+	assert.Equal(t, param, got)
+
+	assert.Error(t, g.dispatch("runtime-2", &param))
+}
+
+// 并发写同一个连接
+func Test_Dispatch_Concurrent(t *testing.T) {
+	g := Gate{WriteTime: time.Second}
+
+	stored := make(chan *runtimeConn)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := testUpgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		stored <- g.addConn("runtime-1", con)
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	rc := <-stored
+
+	conn, ok := g.getConn("runtime-1")
+	assert.True(t, ok)
+	assert.Equal(t, rc.conn, conn)
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var param model.Param
+			param.Executer.TaskName = "task"
+			assert.NoError(t, g.dispatch("runtime-1", &param))
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		var got model.Param
+		assert.NoError(t, client.ReadJSON(&got))
+		assert.Equal(t, "task", got.Executer.TaskName)
+	}
+	wg.Wait()
+
+	// 被新连接覆盖之后, 旧连接断开不会删除新连接
+	newRc := &runtimeConn{conn: conn}
+	g.conns.Store("runtime-1", newRc)
+	g.removeConn("runtime-1", rc)
+	_, ok = g.getConn("runtime-1")
+	assert.True(t, ok)
+
+	g.removeConn("runtime-1", newRc)
+	_, ok = g.getConn("runtime-1")
+	assert.False(t, ok)
+}
+
+// runtime读得太慢, 发送队列满了之后断开连接, 排队超时的消息不再发送
+func Test_Dispatch_QueueOverflow(t *testing.T) {
+	g := Gate{WriteTime: time.Second, SendQueueSize: 1}
+
+	stored := make(chan *runtimeConn)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := testUpgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		stored <- g.addConn("runtime-1", con)
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	rc := <-stored
+
+	var param model.Param
+	param.Executer.TaskName = "task"
+
+	// 卡住writeLoop, 第一条被取出来等锁, 第二条留在队列里面
+	rc.mu.Lock()
+	errs := make(chan error, 2)
+	go func() { errs <- g.dispatch("runtime-1", &param) }()
+	assert.Eventually(t, func() bool { return len(rc.sendq) == 0 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	go func() { errs <- g.dispatch("runtime-1", &param) }()
+	assert.Eventually(t, func() bool { return len(rc.sendq) == 1 }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, g.dispatch("runtime-1", &param), errSendQueueFull)
+	rc.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		assert.Error(t, <-errs)
+	}
+	assert.ErrorIs(t, g.dispatch("runtime-1", &param), errConnClosed)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = client.ReadMessage()
+	assert.Error(t, err)
+
+	// 排队的时间超过写超时
+	c := &runtimeConn{}
+	assert.ErrorIs(t, c.write(&sendReq{deadline: time.Now().Add(-time.Second)}), errSendExpired)
+}
@@ -0,0 +1,76 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// task已经存在时返回409, 而不是500
+func Test_CreateTask_Duplicate(t *testing.T) {
+	g := testInitEtcdGate(t)
+	assert.NoError(t, g.initTrace())
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+	defer defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+
+	router := gin.New()
+	router.POST(model.TASK_CREATE_URL, g.createTask)
+
+	body := `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"` + taskName + `","shell":{"command":"echo"}}}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, model.TASK_CREATE_URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 409, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), etcd.ErrTaskExists.Error())
+
+	var rsp errorRsp
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, model.ErrDuplicateTask, rsp.Code)
+	assert.Equal(t, "duplicate task", rsp.Error)
+}
+
+// 此函数依赖etcd是否存在
+// 序列化之后超过MaxTaskBytes返回413, 不写入etcd
+func Test_CreateTask_TooLarge(t *testing.T) {
+	g := testInitEtcdGate(t)
+	assert.NoError(t, g.initTrace())
+	g.MaxTaskBytes = 1024
+	var err error
+
+	router := gin.New()
+	router.POST(model.TASK_CREATE_URL, g.createTask)
+
+	taskName := uuid.New().String()
+	body := `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"` + taskName +
+		`","shell":{"command":"echo ` + strings.Repeat("x", 2048) + `"}}}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, model.TASK_CREATE_URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 413, w.Code, w.Body.String())
+
+	var rsp errorRsp
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, model.ErrTooLarge, rsp.Code)
+	assert.Contains(t, rsp.Message, "1024 bytes")
+
+	get, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+	assert.NoError(t, err)
+	assert.Empty(t, get.Kvs)
+}
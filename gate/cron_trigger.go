@@ -0,0 +1,164 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/robfig/cron/v3"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var errCronWatchClosed = errors.New("cron trigger:watch closed")
+
+// 和cronex.ParseStandard一样, 秒是可选的
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 一次性任务的触发时间, 触发过之后返回零值, cron不会再调度
+type onceSchedule time.Time
+
+func (o onceSchedule) Next(t time.Time) time.Time {
+	if at := time.Time(o); at.After(t) {
+		return at
+	}
+	return time.Time{}
+}
+
+// 按触发器生成调度, 一次性任务的时间已经过了时返回nil, 调用方直接触发
+// 配置了时间窗口时, 一次性任务推迟到窗口开始再触发
+func triggerSchedule(t model.Trigger, now time.Time) (cron.Schedule, error) {
+	if !t.IsOnce() {
+		return cronParser.Parse(t.Cron)
+	}
+
+	if _, err := t.OnceTime(); err != nil {
+		return nil, err
+	}
+	at, run := t.OnceRunAt(now)
+	if !run || !at.After(now) {
+		return nil, nil
+	}
+	return onceSchedule(at), nil
+}
+
+// 主节点的定时器, 只调度Waiting的任务, 到点之后置为CanRun, 由mjobs分配到runtime
+// 已经分配到runtime上的任务由runtime自己的定时器执行, 这里不重复触发
+type cronTrigger struct {
+	*Gate
+	ctx     context.Context
+	cron    *cron.Cron
+	entries map[string]cron.EntryID
+}
+
+// 主节点的job, watch断开之后重新加载一遍, 直到退出或者不再是主节点
+func (r *Gate) cronTriggerLoop(ctx context.Context) {
+	for {
+		err := r.cronTriggerOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.Warn().Msgf("gate.cronTrigger:%s, reload in %s\n", err, runtimeWatchRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(runtimeWatchRetry):
+		}
+	}
+}
+
+func (r *Gate) cronTriggerOnce(ctx context.Context) error {
+	t := &cronTrigger{Gate: r, ctx: ctx, cron: cron.New(cron.WithParser(cronParser)), entries: make(map[string]cron.EntryID)}
+	t.cron.Start()
+	// 等正在触发的任务写完再返回
+	defer func() { <-t.cron.Stop().Done() }()
+
+	prefix := model.GlobalTaskPrefixState + "/"
+	rsp, err := defaultKVC.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range rsp.Kvs {
+		t.schedule(string(kv.Key), kv.Value)
+	}
+
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	for ersp := range defautlClient.Watch(wctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rsp.Header.Revision+1)) {
+		if err := ersp.Err(); err != nil {
+			return err
+		}
+
+		for _, ev := range ersp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				t.remove(model.TaskName(string(ev.Kv.Key)))
+				continue
+			}
+			t.schedule(string(ev.Kv.Key), ev.Kv.Value)
+		}
+	}
+	return errCronWatchClosed
+}
+
+func (t *cronTrigger) remove(taskName string) {
+	if id, ok := t.entries[taskName]; ok {
+		t.cron.Remove(id)
+		delete(t.entries, taskName)
+	}
+}
+
+// 状态变化之后重新调度, 不是Waiting的任务去掉定时器
+func (t *cronTrigger) schedule(stateKey string, value []byte) {
+	taskName := model.TaskName(stateKey)
+	t.remove(taskName)
+
+	state, err := model.ValueToState(value)
+	if err != nil {
+		t.Warn().Msgf("gate.cronTrigger:%s, key:%s", err, stateKey)
+		return
+	}
+	if !state.IsWaiting() {
+		return
+	}
+
+	rsp, err := defaultKVC.Get(t.ctx, model.FullGlobalTask(taskName))
+	if err != nil || len(rsp.Kvs) == 0 {
+		return
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(rsp.Kvs[0].Value, &param); err != nil {
+		t.Warn().Msgf("gate.cronTrigger:%s, task:%s", err, taskName)
+		return
+	}
+
+	sched, err := triggerSchedule(param.Trigger, time.Now())
+	if err != nil {
+		t.Warn().Msgf("gate.cronTrigger:%s, task:%s", err, taskName)
+		return
+	}
+
+	// 主节点切换期间已经到点的任务直接触发
+	if sched == nil {
+		t.fire(taskName)
+		return
+	}
+	t.entries[taskName] = t.cron.Schedule(sched, cron.FuncJob(func() { t.fire(taskName) }))
+}
+
+func (t *cronTrigger) fire(taskName string) {
+	ok, err := defaultStore.LockTriggerTask(t.ctx, taskName, t.leaderFence()...)
+	if err != nil {
+		if t.ctx.Err() == nil {
+			t.Warn().Msgf("gate.cronTrigger:%s, task:%s", err, taskName)
+		}
+		return
+	}
+
+	if ok {
+		t.Debug().Msgf("gate.cronTrigger:task:%s triggered", taskName)
+	}
+}
@@ -0,0 +1,88 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TriggerSchedule(t *testing.T) {
+	now := time.Date(2022, 12, 1, 8, 0, 30, 0, time.UTC)
+
+	sched, err := triggerSchedule(model.Trigger{Cron: "0 * * * * *"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 12, 1, 8, 1, 0, 0, time.UTC), sched.Next(now))
+
+	sched, err = triggerSchedule(model.Trigger{Once: "2022-12-01T09:00:00Z"}, now)
+	assert.NoError(t, err)
+	at := time.Date(2022, 12, 1, 9, 0, 0, 0, time.UTC)
+	assert.True(t, sched.Next(now).Equal(at))
+	// 触发过之后不再调度
+	assert.True(t, sched.Next(at).IsZero())
+
+	// 时间已经过了
+	sched, err = triggerSchedule(model.Trigger{Once: "2022-12-01T07:00:00Z"}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, sched)
+
+	_, err = triggerSchedule(model.Trigger{Cron: "not a cron"}, now)
+	assert.Error(t, err)
+}
+
+// 此函数依赖etcd是否存在
+// 还没到时间的一次性任务是Waiting, 主节点的定时器到点之后置为CanRun
+func Test_CronTrigger_Once(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	create := func(once time.Time) string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Once: once.Format(time.RFC3339)}}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	// 一个在启动之前创建, 一个在启动之后, 一个过很久才触发
+	before := create(time.Now().Add(2 * time.Second))
+	later := create(time.Now().Add(time.Hour))
+	assert.True(t, testGetState(t, g, before).IsWaiting())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.cronTriggerLoop(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	after := create(time.Now().Add(2 * time.Second))
+	for _, taskName := range []string{before, after} {
+		assert.Eventually(t, func() bool { return testGetState(t, g, taskName).IsCanRun() }, 5*time.Second, 50*time.Millisecond)
+	}
+	assert.True(t, testGetState(t, g, later).IsWaiting())
+}
+
+// 一次性任务在窗口外时推迟到窗口开始, 配置了skip时不调度
+func Test_TriggerSchedule_Window(t *testing.T) {
+	// 2022-12-05是周一
+	now := time.Date(2022, 12, 5, 8, 0, 30, 0, time.UTC)
+	windows := []model.Window{{Days: []string{"mon"}, Start: "09:00", End: "10:00"}}
+
+	sched, err := triggerSchedule(model.Trigger{Once: "2022-12-05T08:30:00Z", Windows: windows}, now)
+	assert.NoError(t, err)
+	assert.True(t, sched.Next(now).Equal(time.Date(2022, 12, 5, 9, 0, 0, 0, time.UTC)))
+
+	sched, err = triggerSchedule(model.Trigger{Once: "2022-12-05T08:30:00Z", Windows: windows, OutsideWindow: model.OutsideWindowSkip}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, sched)
+}
@@ -0,0 +1,58 @@
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 删除任务时全局数据和状态一起删除, 绑定的runtime本地队列收到删除命令
+func Test_DeleteDataAndState(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+
+	// 模拟任务已经分配给runtime
+	runtimeNode := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	stateKey := model.FullGlobalTaskState(taskName)
+	rsp, err := defaultKVC.Get(g.ctx, stateKey)
+	assert.NoError(t, err)
+	state, err := model.UpdateState(rsp.Kvs[0].Value, runtimeNode, model.Running, model.Create, nil, taskName, "id")
+	assert.NoError(t, err)
+	_, err = defaultKVC.Put(g.ctx, stateKey, string(state))
+	assert.NoError(t, err)
+
+	assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, taskName))
+
+	for _, key := range []string{model.FullGlobalTask(taskName), stateKey} {
+		rsp, err = defaultKVC.Get(g.ctx, key)
+		assert.NoError(t, err)
+		assert.Len(t, rsp.Kvs, 0, key)
+	}
+
+	localKey := model.ToLocalTask(runtimeNode, taskName)
+	rsp, err = defaultKVC.Get(g.ctx, localKey)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+
+	var rm model.Param
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &rm))
+	assert.True(t, rm.IsRemove())
+	assert.Equal(t, taskName, rm.Executer.TaskName)
+
+	err = defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+	assert.True(t, errors.Is(err, etcd.ErrTaskNotFound))
+
+	defaultKVC.Delete(g.ctx, localKey)
+}
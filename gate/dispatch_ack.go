@@ -0,0 +1,231 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultDispatchAckTimeout = 5 * time.Second
+	defaultDispatchAttempts   = 3
+)
+
+// 下发给v2协议的runtime, 还没有收到ack的task
+type pendingAck struct {
+	runtimeName string
+	localKey    string
+	dispatchID  string
+	param       model.Param
+	// 下发时的状态, 收到ack之后更新status表用
+	state    model.State
+	attempts int
+	deadline time.Time
+}
+
+// 同一个task在一个runtime上只等最新的一次下发, 新的下发替换掉还没有ack的旧的
+func pendingAckKey(runtimeName, taskName string) string {
+	return runtimeName + "/" + taskName
+}
+
+func (r *Gate) dispatchAttempts() int {
+	if r.DispatchAttempts <= 0 {
+		return defaultDispatchAttempts
+	}
+	return r.DispatchAttempts
+}
+
+// 负数表示不等ack, 下发成功就更新状态
+func (r *Gate) dispatchAckTimeout() time.Duration {
+	if r.DispatchAckTimeout == 0 {
+		return defaultDispatchAckTimeout
+	}
+	return r.DispatchAckTimeout
+}
+
+// 协商出来的协议会回ack, 并且没有关掉等ack
+func (r *Gate) needAck(protocol string) bool {
+	return model.StreamAck(protocol) && r.dispatchAckTimeout() > 0
+}
+
+// 这个runtime的连接是否需要等ack
+func (r *Gate) connNeedAck(runtimeName string) bool {
+	c, ok := r.loadConn(runtimeName)
+	return ok && c.ack.Load()
+}
+
+// 下发之后等runtime的ack, 收到之后再把状态改成已经下发, 超时之后重新下发
+// 记录和放入发送队列都在acksMu里面, 旧的下发的重发不会排到新的下发后面
+func (r *Gate) dispatchWithAck(runtimeName, localKey string, param *model.Param, state model.State) {
+	taskName := param.Executer.TaskName
+	param.DispatchID = uuid.New().String()
+	p := pendingAck{
+		runtimeName: runtimeName,
+		localKey:    localKey,
+		dispatchID:  param.DispatchID,
+		param:       *param,
+		state:       state,
+		attempts:    1,
+		deadline:    time.Now().Add(r.dispatchAckTimeout()),
+	}
+	key := pendingAckKey(runtimeName, taskName)
+
+	span := r.startDispatchSpan(param, runtimeName)
+	err := r.enqueueWithAck(key, p, param)
+	endSpan(span, err)
+	if err != nil {
+		r.Warn().Msgf("gate.dispatchWithAck, dispatch :%s, runtimeName:%s bye bye, taskName(%s)\n", err, runtimeName, taskName)
+		r.dispatchFailed(runtimeName, localKey, taskName, p.attempts)
+	}
+}
+
+// 先记下来再放入发送队列, ack可能比写入的结果返回得早
+func (r *Gate) enqueueWithAck(key string, p pendingAck, param *model.Param) error {
+	c, ok := r.loadConn(p.runtimeName)
+	if !ok {
+		return fmt.Errorf("runtime(%s) is not connected to this gate", p.runtimeName)
+	}
+
+	r.acksMu.Lock()
+	if r.pendingAcks == nil {
+		r.pendingAcks = make(map[string]pendingAck)
+	}
+	r.pendingAcks[key] = p
+	req, err := c.enqueue(param, r.WriteTime)
+	if err != nil && r.pendingAcks[key].dispatchID == p.dispatchID {
+		delete(r.pendingAcks, key)
+	}
+	r.acksMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = c.wait(req); err != nil {
+		r.removeAck(key, p.dispatchID)
+	}
+	return err
+}
+
+// 还是这一次下发时才删除, 已经被新的下发替换掉时不动
+func (r *Gate) removeAck(key, dispatchID string) (pendingAck, bool) {
+	r.acksMu.Lock()
+	defer r.acksMu.Unlock()
+	p, ok := r.pendingAcks[key]
+	if !ok || p.dispatchID != dispatchID {
+		return pendingAck{}, false
+	}
+	delete(r.pendingAcks, key)
+	return p, true
+}
+
+// runtime确认收到, 重复的ack和旧的下发的ack忽略
+func (r *Gate) ackDispatch(who model.Whoami, ack *model.TaskAck) {
+	p, ok := r.removeAck(pendingAckKey(who.Name, ack.TaskName), ack.DispatchID)
+	if !ok {
+		r.Debug().Msgf("gate.ackDispatch:unknown or stale ack, runtime:%s, taskName:%s, dispatchID:%s\n", who.Name, ack.TaskName, ack.DispatchID)
+		return
+	}
+
+	r.dispatchSucceeded(p.runtimeName, &p.param, p.state, p.attempts)
+}
+
+// 超时没有ack的重新下发, 次数用完之后标记为失败并断开连接, runtime重连之后重新同步
+func (r *Gate) checkAcks(now time.Time) {
+	var failed []pendingAck
+	r.acksMu.Lock()
+	for key, p := range r.pendingAcks {
+		if now.Before(p.deadline) {
+			continue
+		}
+
+		if p.attempts >= r.dispatchAttempts() {
+			delete(r.pendingAcks, key)
+			failed = append(failed, p)
+			continue
+		}
+
+		p.attempts++
+		p.deadline = now.Add(r.dispatchAckTimeout())
+		r.pendingAcks[key] = p
+		r.Info().Msgf("gate.checkAcks:redispatch, attempt:%d, runtime:%s, taskName:%s\n", p.attempts, p.runtimeName, p.param.Executer.TaskName)
+		r.redispatch(p)
+	}
+	r.acksMu.Unlock()
+
+	for _, p := range failed {
+		taskName := p.param.Executer.TaskName
+		r.Warn().Msgf("gate.checkAcks:no ack after %d attempts, runtime:%s, taskName:%s\n", p.attempts, p.runtimeName, taskName)
+		r.dispatchFailed(p.runtimeName, p.localKey, taskName, p.attempts)
+		if c, ok := r.loadConn(p.runtimeName); ok {
+			c.close()
+		}
+	}
+}
+
+// 在acksMu里面放入发送队列, 和dispatchWithAck保持顺序, 写可能要等WriteTime, 不阻塞检查超时
+func (r *Gate) redispatch(p pendingAck) {
+	c, ok := r.loadConn(p.runtimeName)
+	if !ok {
+		return
+	}
+
+	param := p.param
+	span := r.startDispatchSpan(&param, p.runtimeName)
+	req, err := c.enqueue(&param, r.WriteTime)
+	if err != nil {
+		endSpan(span, err)
+		r.Warn().Msgf("gate.checkAcks, redispatch :%s, runtimeName:%s, taskName(%s)\n", err, p.runtimeName, param.Executer.TaskName)
+		return
+	}
+
+	go func() {
+		err := c.wait(req)
+		endSpan(span, err)
+		if err != nil {
+			r.Warn().Msgf("gate.checkAcks, redispatch :%s, runtimeName:%s, taskName(%s)\n", err, p.runtimeName, param.Executer.TaskName)
+		}
+	}()
+}
+
+// runtime断开之后不再等它的ack, 任务由重连之后的同步或者重新分配处理
+func (r *Gate) untrackAcks(who model.Whoami) {
+	r.acksMu.Lock()
+	defer r.acksMu.Unlock()
+	for key, p := range r.pendingAcks {
+		if p.runtimeName == who.Name {
+			delete(r.pendingAcks, key)
+		}
+	}
+}
+
+// 更新全局状态, 修改为成功标志
+func (r *Gate) dispatchSucceeded(runtimeName string, param *model.Param, state model.State, attempts int) {
+	taskName := param.Executer.TaskName
+	if err := defaultStore.LockUpdateCallStateAttempts(r.ctx, taskName, true, attempts); err != nil {
+		r.Error().Msgf("gate.watchLocalRunq, write successed ack fail %s, runtimeName:%s taskName(%s)\n", err, runtimeName, taskName)
+	}
+
+	// 没有配置数据库时(测试里面)跳过
+	if r.statusTable == nil {
+		return
+	}
+	if err := r.statusTable.update(onlyParamToStatus(*param, state)); err != nil {
+		r.Warn().Msgf("status table:update db fail:%s", err)
+	}
+}
+
+// 更新全局状态, 修改为失败标志, 删除runtime节点触发重新分配
+func (r *Gate) dispatchFailed(runtimeName, localKey, taskName string, attempts int) {
+	defaultStore.LockUnlock(r.ctx, taskName, func() error {
+		err := defaultStore.UpdateCallStateInner(r.ctx, taskName, false, attempts)
+		if err != nil {
+			r.Error().Msgf("gate.watchLocalRunq, write failed ack fail %s, runtimeName:%s bye bye, taskName(%s)\n", err, runtimeName, taskName)
+			return err
+		}
+		r.delRuntimeNode(model.Whoami{Name: runtimeName, Lambda: strings.Contains(localKey, model.LambdaKey)})
+		return nil
+	})
+}
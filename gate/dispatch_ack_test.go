@@ -0,0 +1,138 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// 连上一个v2协议的runtime, read跳过连上之后的同步完成通知, 返回下一个下发的task
+func testAckStream(t *testing.T) (g *Gate, client *websocket.Conn, who model.Whoami, read func() model.Param) {
+	g = testInitEtcdGate(t)
+	g.HeartbeatTimeout = 3 * time.Second
+	g.DispatchAttempts = 2
+	g.upgrader = g.newUpgrader()
+	var err error
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	d := *websocket.DefaultDialer
+	d.Subprotocols = model.StreamProtocols
+	client, _, err = d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { client.Close() })
+	assert.Equal(t, model.StreamProtocolV2, client.Subprotocol())
+
+	who = model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	t.Cleanup(func() { defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who)) })
+	assert.NoError(t, client.WriteJSON(who))
+	assert.Eventually(t, func() bool { return g.connNeedAck(who.Name) }, 3*time.Second, 10*time.Millisecond)
+
+	read = func() (param model.Param) {
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for assert.NoError(t, client.ReadJSON(&param)) && param.IsSyncDone() {
+			param = model.Param{}
+		}
+		return param
+	}
+	return g, client, who, read
+}
+
+func testDispatchWithAck(t *testing.T, g *Gate, who model.Whoami, taskName string) {
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+	assert.NoError(t, err)
+	var param model.Param
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &param))
+	g.dispatchWithAck(who.Name, model.ToLocalTask(model.FullRuntimeNode(who), taskName), &param, testGetState(t, g, taskName))
+}
+
+// 此函数依赖etcd是否存在
+// v2协议的runtime没有回ack时重新下发同一个DispatchID, 收到ack之后状态里面记录下发次数
+func Test_DispatchAck_Redispatch(t *testing.T) {
+	g, client, who, read := testAckStream(t)
+
+	dispatch := func() (string, model.Param) {
+		taskName := testCreateRunningTask(t, g, who)
+		testDispatchWithAck(t, g, who, taskName)
+
+		got := read()
+		assert.Equal(t, taskName, got.Executer.TaskName)
+		assert.NotEmpty(t, got.DispatchID)
+		return taskName, got
+	}
+
+	taskName, first := dispatch()
+	assert.False(t, testGetState(t, g, taskName).Ack)
+
+	// 超时之后重发, 还是同一个DispatchID
+	g.checkAcks(time.Now().Add(time.Minute))
+	assert.Equal(t, first.DispatchID, read().DispatchID)
+
+	ack := &model.TaskAck{TaskName: taskName, DispatchID: first.DispatchID}
+	assert.NoError(t, client.WriteJSON(model.RuntimeMsg{Whoami: who, Ack: ack}))
+	assert.Eventually(t, func() bool { return testGetState(t, g, taskName).Ack }, 3*time.Second, 10*time.Millisecond)
+	state := testGetState(t, g, taskName)
+	assert.True(t, state.InRuntime)
+	assert.Equal(t, 2, state.DispatchAttempts)
+
+	// 次数用完还没有ack, 标记为失败并断开连接
+	taskName, _ = dispatch()
+	g.checkAcks(time.Now().Add(time.Minute))
+	read()
+	g.checkAcks(time.Now().Add(2 * time.Minute))
+
+	state = testGetState(t, g, taskName)
+	assert.True(t, state.Ack)
+	assert.Equal(t, model.Failed, state.State)
+	assert.Equal(t, 2, state.DispatchAttempts)
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := client.ReadMessage()
+	assert.Error(t, err)
+}
+
+// 此函数依赖etcd是否存在
+// 还没有ack时task又被修改, 只等最新的一次下发, 旧的下发不会重发, 它的ack也不生效
+func Test_DispatchAck_Replace(t *testing.T) {
+	g, client, who, read := testAckStream(t)
+
+	taskName := testCreateRunningTask(t, g, who)
+	testDispatchWithAck(t, g, who, taskName)
+	first := read()
+	testDispatchWithAck(t, g, who, taskName)
+	second := read()
+	assert.NotEqual(t, first.DispatchID, second.DispatchID)
+
+	// 只重发最新的一次
+	g.checkAcks(time.Now().Add(time.Minute))
+	assert.Equal(t, second.DispatchID, read().DispatchID)
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var extra model.Param
+	assert.Error(t, client.ReadJSON(&extra), "stale dispatch resent:%v", extra.DispatchID)
+
+	// 旧的ack忽略, 新的ack生效
+	ack := func(dispatchID string) {
+		msg := model.RuntimeMsg{Whoami: who, Ack: &model.TaskAck{TaskName: taskName, DispatchID: dispatchID}}
+		assert.NoError(t, client.WriteJSON(msg))
+	}
+	ack(first.DispatchID)
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, testGetState(t, g, taskName).Ack)
+
+	ack(second.DispatchID)
+	assert.Eventually(t, func() bool { return testGetState(t, g, taskName).Ack }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, testGetState(t, g, taskName).DispatchAttempts)
+}
@@ -0,0 +1,58 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// dry_run只做检查, 不写入etcd
+func Test_CreateTask_DryRun(t *testing.T) {
+	g := testInitEtcdGate(t)
+	assert.NoError(t, g.initTrace())
+
+	router := gin.New()
+	router.Use(g.requestID())
+	router.POST(model.TASK_CREATE_URL, g.createTask)
+
+	taskName := uuid.New().String()
+	body := `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"` + taskName + `","shell":{"command":"echo"}}}`
+
+	post := func(query string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, model.TASK_CREATE_URL+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("?dry_run=true", body)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	var rsp struct {
+		Data taskDryRunRsp `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.True(t, rsp.Data.DryRun)
+	assert.Equal(t, taskName, rsp.Data.TaskName)
+	assert.NotNil(t, rsp.Data.Task)
+	assert.True(t, rsp.Data.Task.IsCreate())
+
+	task, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+	assert.NoError(t, err)
+	assert.Len(t, task.Kvs, 0)
+
+	// 检查不通过的一样返回400
+	w = post("?dry_run=true", strings.Replace(body, `"oneRuntime"`, `"oneRuntime","runtime":"a/b"`, 1))
+	assert.Equal(t, 400, w.Code, w.Body.String())
+
+	w = post("?dry_run=abc", body)
+	assert.Equal(t, 400, w.Code, w.Body.String())
+}
@@ -0,0 +1,43 @@
+package gate
+
+import (
+	"fmt"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 所有接口的错误响应, code是model.ErrCode, error是code的英文描述, message是具体的原因
+type errorRsp struct {
+	Code    model.ErrCode `json:"code"`
+	Error   string        `json:"error"`
+	Message string        `json:"message"`
+	// 批量接口出错时带上每一项的结果
+	Data any `json:"data,omitempty"`
+}
+
+func newErrorRsp(code model.ErrCode, msg string) errorRsp {
+	return errorRsp{Code: code, Error: code.String(), Message: msg}
+}
+
+// 错误的包装函数, http状态码由错误码决定
+func (r *Gate) error(c *gin.Context, code model.ErrCode, format string, a ...any) {
+	countTaskRequest(c, true)
+
+	msg := fmt.Sprintf(format, a...)
+	r.Error().RequestID(getRequestID(c)).Caller(1).Msg(msg)
+	c.JSON(code.Status(), newErrorRsp(code, msg))
+}
+
+// 没有匹配的路由, 和其他接口一样返回json
+func (r *Gate) noRoute(c *gin.Context) {
+	c.JSON(404, newErrorRsp(model.ErrNotFound, "no route:"+c.Request.Method+" "+c.Request.URL.Path))
+}
+
+// handler panic时返回500, 不直接断开连接
+func (r *Gate) recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		r.Error().RequestID(getRequestID(c)).Msgf("panic:%v", err)
+		c.AbortWithStatusJSON(500, newErrorRsp(model.ErrInternal, fmt.Sprintf("panic:%v", err)))
+	})
+}
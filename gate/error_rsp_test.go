@@ -0,0 +1,59 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 不同的错误路径返回的都是同一种结构, http状态码和code对应
+func Test_ErrorRsp_Shape(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled"), JWTSecret: "secret"}
+
+	router := gin.New()
+	router.Use(g.recovery())
+	router.NoRoute(g.noRoute)
+	router.DELETE(model.TASK_DELETE_URL, g.deleteTask)
+	router.POST(model.TASK_BATCH_URL, g.createBatch)
+	router.GET(model.READYZ_URL, g.readyz)
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	auth := router.Group("", g.authRequired())
+	auth.GET(model.UI_USERS_INFO_LIST, g.GetUserInfoList)
+
+	for _, tc := range []struct {
+		method string
+		url    string
+		body   string
+		code   model.ErrCode
+	}{
+		{http.MethodDelete, model.TASK_DELETE_URL, "{}", model.ErrValidation},
+		{http.MethodPost, model.TASK_BATCH_URL, "[]", model.ErrValidation},
+		{http.MethodGet, model.READYZ_URL, "", model.ErrUnavailable},
+		{http.MethodGet, model.UI_USERS_INFO_LIST, "", model.ErrUnauthorized},
+		{http.MethodGet, "/not/found", "", model.ErrNotFound},
+		{http.MethodGet, "/panic", "", model.ErrInternal},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.code.Status(), w.Code, tc.url)
+
+		var rsp errorRsp
+		dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+		dec.DisallowUnknownFields()
+		assert.NoError(t, dec.Decode(&rsp), w.Body.String())
+		assert.Equal(t, tc.code, rsp.Code, tc.url)
+		assert.Equal(t, tc.code.String(), rsp.Error, tc.url)
+		assert.NotEmpty(t, rsp.Message, tc.url)
+	}
+}
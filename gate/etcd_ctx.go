@@ -0,0 +1,57 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultEtcdOpTimeout = 3 * time.Second
+
+func (r *Gate) etcdOpTimeout() time.Duration {
+	if r.EtcdOpTimeout <= 0 {
+		return defaultEtcdOpTimeout
+	}
+	return r.EtcdOpTimeout
+}
+
+// 接口里面的etcd操作使用请求的ctx加上超时, etcd变慢或者客户端断开时尽快返回
+func (r *Gate) etcdCtx(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), r.etcdOpTimeout())
+}
+
+// 读etcd的重试次数用完返回503, 已经处理时返回true
+func (r *Gate) etcdUnavailable(c *gin.Context, err error, prefix string) bool {
+	var retryErr *etcdRetryError
+	if !errors.As(err, &retryErr) {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(r.etcdOpAttemptTimeout().Seconds()))))
+	r.error(c, model.ErrUnavailable, "%s:%s", prefix, err)
+	return true
+}
+
+// etcd操作超时返回504, 已经处理时返回true
+func (r *Gate) etcdTimeout(c *gin.Context, ctx context.Context, err error, prefix string) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		r.error(c, model.ErrEtcdTimeout, "%s:etcd timeout:%s", prefix, err)
+		return true
+	}
+	return false
+}
+
+// etcd操作出错, 重试次数用完返回503, 超时返回504, 其他的错误返回500
+func (r *Gate) etcdError(c *gin.Context, ctx context.Context, err error, prefix string) {
+	if r.etcdUnavailable(c, err, prefix) {
+		return
+	}
+	if !r.etcdTimeout(c, ctx, err, prefix) {
+		r.error(c, model.ErrEtcd, "%s:%s", prefix, err)
+	}
+}
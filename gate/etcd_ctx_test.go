@@ -0,0 +1,73 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// etcd操作超时返回504, 其他错误返回500
+func Test_EtcdError_Timeout(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.EtcdOpTimeout = time.Millisecond
+
+	router := gin.New()
+	router.GET("/timeout", func(c *gin.Context) {
+		ctx, cancel := g.etcdCtx(c)
+		defer cancel()
+		<-ctx.Done()
+		_, err := defaultKVC.Get(ctx, "/crab/test/etcd-timeout")
+		g.etcdError(c, ctx, err, "timeout")
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		ctx, cancel := g.etcdCtx(c)
+		defer cancel()
+		g.etcdError(c, ctx, errors.New("fail"), "fail")
+	})
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/timeout", 504},
+		{"/fail", 500},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tc.url, nil).WithContext(context.Background())
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.url)
+	}
+}
+
+// 此函数依赖etcd是否存在
+// 接口里面的etcd操作都带上超时, 包括批量扫描
+func Test_EtcdCtx_Handlers(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.EtcdOpTimeout = time.Nanosecond
+
+	router := gin.New()
+	router.GET("/registry", g.registryList)
+	router.GET("/dead", g.webhookDeadList)
+	router.POST("/stop-all", g.stopAll)
+
+	for _, tc := range []struct {
+		method string
+		url    string
+	}{
+		{http.MethodGet, "/registry"},
+		{http.MethodGet, "/dead"},
+		{http.MethodPost, "/stop-all"},
+		{http.MethodPost, "/stop-all?label=team:a"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 504, w.Code, tc.url)
+	}
+}
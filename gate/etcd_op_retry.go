@@ -0,0 +1,133 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultEtcdOpRetry          = 3
+	defaultEtcdOpAttemptTimeout = time.Second
+	defaultEtcdOpBackoff        = 100 * time.Millisecond
+	// 接口里面重试间隔翻倍的上限
+	maxEtcdOpBackoff = time.Second
+)
+
+// 重试次数用完之后返回的错误, 接口返回503
+type etcdRetryError struct {
+	attempts int
+	err      error
+}
+
+func (e *etcdRetryError) Error() string {
+	return fmt.Sprintf("etcd retry budget exhausted after %d attempts:%s", e.attempts, e.err)
+}
+
+func (e *etcdRetryError) Unwrap() error {
+	return e.err
+}
+
+func (r *Gate) etcdOpRetry() int {
+	if r.EtcdOpRetry <= 0 {
+		return defaultEtcdOpRetry
+	}
+	return r.EtcdOpRetry
+}
+
+func (r *Gate) etcdOpAttemptTimeout() time.Duration {
+	if r.EtcdOpAttemptTimeout <= 0 {
+		return defaultEtcdOpAttemptTimeout
+	}
+	return r.EtcdOpAttemptTimeout
+}
+
+func (r *Gate) etcdOpBackoff() time.Duration {
+	if r.EtcdOpBackoff <= 0 {
+		return defaultEtcdOpBackoff
+	}
+	return r.EtcdOpBackoff
+}
+
+// 接口里面的etcd读操作, 每次尝试单独超时, 失败时按指数退避重试
+// clientv3自带的重试没法控制次数, etcd部分节点不可用时接口可能一直等到EtcdOpTimeout
+// 只用在读操作上, 写操作超时之后不知道有没有成功, 重试可能写两次
+// ctx本身超时或者取消时直接返回ctx的错误, 还是504
+func (r *Gate) etcdRead(ctx context.Context, op func(ctx context.Context) error) (err error) {
+	attempts := r.etcdOpRetry()
+	backoff := r.etcdOpBackoff()
+
+	for i := 1; ; i++ {
+		actx, cancel := context.WithTimeout(ctx, r.etcdOpAttemptTimeout())
+		err = op(actx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if !retryableEtcdError(err) {
+			return err
+		}
+
+		if i >= attempts {
+			return &etcdRetryError{attempts: i, err: err}
+		}
+
+		r.Debug().Msgf("etcd read fail, attempt:%d/%d, retry after:%s, err:%s", i, attempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		if backoff *= 2; backoff > maxEtcdOpBackoff {
+			backoff = maxEtcdOpBackoff
+		}
+	}
+}
+
+// etcd没有leader, 连接断开或者单次超时可以重试, 其他的错误重试也不会成功
+func retryableEtcdError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		return etcdErr.Code() == codes.Unavailable
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// 带重试的Get
+func (r *Gate) etcdGet(ctx context.Context, key string, opts ...clientv3.OpOption) (rsp *clientv3.GetResponse, err error) {
+	err = r.etcdRead(ctx, func(ctx context.Context) (err error) {
+		rsp, err = defaultKVC.Get(ctx, key, opts...)
+		return err
+	})
+	return rsp, err
+}
+
+// 带重试的只读事务, 多个key在同一个revision下读取
+func (r *Gate) etcdGetTxn(ctx context.Context, ops ...clientv3.Op) (rsp *clientv3.TxnResponse, err error) {
+	err = r.etcdRead(ctx, func(ctx context.Context) (err error) {
+		rsp, err = defaultKVC.Txn(ctx).Then(ops...).Commit()
+		return err
+	})
+	return rsp, err
+}
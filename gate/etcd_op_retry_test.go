@@ -0,0 +1,113 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testRetryGate() *Gate {
+	return &Gate{
+		Slog:                 slog.New(os.Stdout).SetLevel("disabled"),
+		EtcdOpRetry:          3,
+		EtcdOpAttemptTimeout: 20 * time.Millisecond,
+		EtcdOpBackoff:        time.Millisecond,
+	}
+}
+
+// 可以重试的错误重试到成功为止, 其他的错误直接返回
+func Test_EtcdRead_Retry(t *testing.T) {
+	g := testRetryGate()
+
+	calls := 0
+	err := g.etcdRead(context.TODO(), func(ctx context.Context) error {
+		if calls++; calls < 3 {
+			return rpctypes.ErrNoLeader
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	fail := errors.New("fail")
+	err = g.etcdRead(context.TODO(), func(ctx context.Context) error {
+		calls++
+		return fail
+	})
+	assert.ErrorIs(t, err, fail)
+	assert.Equal(t, 1, calls)
+
+	// 每次尝试单独超时
+	calls = 0
+	err = g.etcdRead(context.TODO(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	var retryErr *etcdRetryError
+	assert.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 3, calls)
+
+	// 请求本身的ctx结束时不再重试
+	calls = 0
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err = g.etcdRead(ctx, func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	assert.False(t, errors.As(err, &retryErr))
+	assert.Equal(t, 1, calls)
+}
+
+func Test_RetryableEtcdError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		need bool
+	}{
+		{context.DeadlineExceeded, true},
+		{rpctypes.ErrNoLeader, true},
+		{rpctypes.ErrTimeoutDueToConnectionLost, true},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{rpctypes.ErrKeyNotFound, false},
+		{rpctypes.ErrPermissionDenied, false},
+		{context.Canceled, false},
+		{errors.New("fail"), false},
+	} {
+		assert.Equal(t, tc.need, retryableEtcdError(tc.err), tc.err.Error())
+	}
+}
+
+// 重试次数用完返回503, 带上Retry-After
+func Test_EtcdError_RetryExhausted(t *testing.T) {
+	g := testRetryGate()
+
+	router := gin.New()
+	router.GET("/unavailable", func(c *gin.Context) {
+		ctx, cancel := g.etcdCtx(c)
+		defer cancel()
+		err := g.etcdRead(ctx, func(ctx context.Context) error {
+			return rpctypes.ErrNoLeader
+		})
+		g.etcdError(c, ctx, err, "unavailable")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unavailable", nil))
+	assert.Equal(t, model.ErrUnavailable.Status(), w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), model.ErrUnavailable.String())
+}
@@ -0,0 +1,83 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1whour/crab/utils"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultEtcdRetry         = 5
+	defaultEtcdRetryInterval = time.Second
+	// 重试间隔翻倍的上限
+	maxEtcdRetryInterval = 30 * time.Second
+)
+
+// 容器环境里面etcd可能比gate晚起来, 连接失败时按指数退避重试, 而不是直接退出
+func (r *Gate) newEtcdClient() (client *clientv3.Client, err error) {
+	err = r.retryEtcd(func() (err error) {
+		client, err = utils.NewEtcdClientWithConfig(r.EtcdAddr, r.etcdConfig())
+		if err != nil {
+			return err
+		}
+
+		if err = r.probeEtcd(client); err != nil {
+			client.Close()
+			client = nil
+		}
+		return err
+	})
+	return client, err
+}
+
+// clientv3.New不会等连接建立, 没有开认证时etcd没起来也返回成功, 这里读一次确认集群可用
+// 和etcdctl endpoint health一样, 没有权限也说明etcd是通的
+func (r *Gate) probeEtcd(client *clientv3.Client) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	_, err := client.Get(ctx, "health")
+	if err == nil || errors.Is(err, rpctypes.ErrPermissionDenied) {
+		return nil
+	}
+	return fmt.Errorf("probe etcd %v:%w", r.EtcdAddr, err)
+}
+
+func (r *Gate) retryEtcd(connect func() error) (err error) {
+	attempts := r.EtcdRetry
+	if attempts <= 0 {
+		attempts = defaultEtcdRetry
+	}
+
+	interval := r.EtcdRetryInterval
+	if interval <= 0 {
+		interval = defaultEtcdRetryInterval
+	}
+
+	for i := 1; ; i++ {
+		if err = connect(); err == nil {
+			return nil
+		}
+
+		if i >= attempts {
+			r.Error().Msgf("connect etcd fail, attempt:%d/%d, err:%s", i, attempts, err)
+			return err
+		}
+
+		r.Warn().Msgf("connect etcd fail, attempt:%d/%d, retry after:%s, err:%s", i, attempts, interval, err)
+		select {
+		case <-time.After(interval):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+
+		if interval *= 2; interval > maxEtcdRetryInterval {
+			interval = maxEtcdRetryInterval
+		}
+	}
+}
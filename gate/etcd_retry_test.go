@@ -0,0 +1,66 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/slog"
+	"github.com/stretchr/testify/assert"
+)
+
+// 连接失败时重试, 成功之后不再重试, 超过次数返回最后一次的错误
+func Test_RetryEtcd(t *testing.T) {
+	g := Gate{EtcdRetry: 3, EtcdRetryInterval: time.Millisecond, Slog: slog.New(os.Stdout).SetLevel("error"), ctx: context.TODO()}
+
+	n := 0
+	err := g.retryEtcd(func() error {
+		if n++; n < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n = 0
+	errRefused := errors.New("connection refused")
+	err = g.retryEtcd(func() error {
+		n++
+		return errRefused
+	})
+	assert.Equal(t, errRefused, err)
+	assert.Equal(t, 3, n)
+
+	// 退出时不再等待
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	g.ctx, g.EtcdRetryInterval = ctx, time.Hour
+	err = g.retryEtcd(func() error { return errRefused })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// 此函数依赖etcd是否存在
+// etcd连不上时clientv3.New也会返回成功, 要读一次才知道, 连不上时重试并返回错误
+func Test_NewEtcdClient_Unreachable(t *testing.T) {
+	g := Gate{
+		EtcdAddr:          []string{"127.0.0.1:1"},
+		EtcdRetry:         2,
+		EtcdRetryInterval: time.Millisecond,
+		EtcdOpTimeout:     200 * time.Millisecond,
+		Slog:              slog.New(os.Stdout).SetLevel("error"),
+		ctx:               context.TODO(),
+	}
+
+	client, err := g.newEtcdClient()
+	assert.Error(t, err)
+	assert.Nil(t, client)
+
+	g.EtcdAddr = []string{"127.0.0.1:2379"}
+	client, err = g.newEtcdClient()
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+}
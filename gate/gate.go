@@ -2,8 +2,13 @@ package gate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1whour/crab/model"
@@ -14,21 +19,23 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/guonaihong/gutil/jwt"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-var upgrader = websocket.Upgrader{}
-
 const (
 	tokenQuery  = "token"
 	tokenHeader = "X-Token"
+	// 乐观锁用的revision
+	revisionHeader = "X-Task-Revision"
+	// 只检查不写入
+	dryRunQuery = "dry_run"
 )
 
-// TODO, 规范下错误码
-
 // Gate模块定位是网关
 // 1.注册自己的信息至etcd中
 // 2.维护runtime或者lambda过来的长连接
@@ -39,24 +46,178 @@ type Gate struct {
 	EtcdAddr     []string      `clop:"short;long;greedy" usage:"etcd address" valid:"required"`
 	Name         string        `clop:"short;long" usage:"The name of the gate. If it is not filled, the default is uuid"`
 	Level        string        `clop:"short;long" usage:"log level" default:"error"`
-	LeaseTime    time.Duration `clop:"long" usage:"lease time" default:"7s"`
+	LeaseTime    time.Duration `clop:"long" usage:"lease time of the gate node, must be in [1s, 5m]" default:"7s"`
 	WriteTime    time.Duration `clop:"long" usage:"write timeout" default:"4s"`
 	DSN          string        `clop:"--dsn" usage:"database dsn" valid:"requried"`
-
-	// etcd 租约id
-	leaseID clientv3.LeaseID
+	// 注册到etcd的地址, 多机部署时ServerAddr可以监听0.0.0.0, 这里填其他节点能访问的地址
+	AdvertiseAddr string `clop:"long" usage:"address registered to etcd, defaults to the server address"`
+	OutboundIP    bool   `clop:"long" usage:"use the ip of the primary outbound interface when AutoFindAddr is set"`
+	// 日志输出, 默认json格式输出到stdout, 配置LogMaxSize之后按大小切割文件
+	LogFile       string `clop:"long" usage:"log file, log to stdout when empty"`
+	LogFormat     string `clop:"long" usage:"log format, json or console" default:"json"`
+	LogMaxSize    int    `clop:"long" usage:"max size(MB) of the log file before it gets rotated, 0 disables rotation"`
+	LogMaxBackups int    `clop:"long" usage:"max number of rotated log files to retain, 0 retains all"`
+	LogMaxAge     int    `clop:"long" usage:"max days to retain rotated log files, 0 retains all"`
+	// etcd开启认证或者tls时配置
+	EtcdUsername string `clop:"long" usage:"etcd username"`
+	EtcdPassword string `clop:"long;env=CRAB_ETCD_PASSWORD" usage:"etcd password"`
+	EtcdCA       string `clop:"long" usage:"etcd tls ca file"`
+	EtcdCert     string `clop:"long" usage:"etcd tls client certificate file"`
+	EtcdKey      string `clop:"long" usage:"etcd tls client private key file"`
+	// 多个部署共用一个etcd集群时用来隔离key, 同一个部署的gate, mjobs, runtime必须一致
+	Namespace string `clop:"long;env=CRAB_NAMESPACE" usage:"etcd key namespace, isolates deployments sharing one etcd cluster"`
+	// 启动时连接etcd失败的重试次数和初始间隔, 间隔每次翻倍
+	EtcdRetry         int           `clop:"long" usage:"max attempts to connect etcd on startup" default:"5"`
+	EtcdRetryInterval time.Duration `clop:"long" usage:"initial retry interval of connecting etcd, doubled on each failure" default:"1s"`
+	// 和上游网关对齐request id
+	RequestIDHeader string   `clop:"long" usage:"request id header name" default:"X-Request-Id"`
+	TrustedProxy    []string `clop:"long;greedy" usage:"trusted proxy CIDR, only requests from these sources can reuse the request id header"`
+	// opentelemetry, TraceEndpoint为空时不开启
+	TraceEndpoint    string  `clop:"long" usage:"opentelemetry otlp/http endpoint(host:port), tracing is disabled when empty"`
+	TraceInsecure    bool    `clop:"long" usage:"use http instead of https for the trace exporter"`
+	TraceSampleRatio float64 `clop:"long" usage:"trace sample ratio, [0, 1]" default:"1"`
+	// 创建任务时归一化task名, 减少大小写和空白不同导致的重复任务
+	NormalizeTaskName bool `clop:"long" usage:"trim, lowercase and collapse whitespace of the task name on create"`
+	// 更新时必须带上X-Task-Revision, 默认不要求, 兼容老的客户端, 这时候以当前的revision更新, 后写的覆盖先写的
+	RequireRevision bool `clop:"long" usage:"reject updates without the X-Task-Revision header with 428"`
+	// 任务执行结束之后的回调, 为空时不开启
+	WebhookURL        string        `clop:"long" usage:"webhook url, called after the task result is saved"`
+	WebhookMaxRetries int           `clop:"long" usage:"max delivery attempts of a webhook before moving it to the dead letter list" default:"10"`
+	WebhookBackoff    time.Duration `clop:"long" usage:"initial retry backoff of webhook, doubled on each failure" default:"1s"`
+	WebhookMaxAge     time.Duration `clop:"long" usage:"max age of a webhook before moving it to the dead letter list" default:"24h"`
+	WebhookTimeout    time.Duration `clop:"long" usage:"timeout of one webhook delivery" default:"3s"`
+	// 出站http调用的并发数和排队数
+	OutboundWorkers   int `clop:"long" usage:"max concurrent outbound http calls" default:"8"`
+	OutboundQueueSize int `clop:"long" usage:"max queued outbound http calls, the rest are dropped and retried later" default:"128"`
+	// 启动时从目录加载task定义, 同步到etcd
+	TaskDir       string `clop:"long" usage:"directory of task definitions(yaml/json), reconciled into etcd on startup"`
+	TaskDirPrune  bool   `clop:"long" usage:"remove tasks which are not defined in the task dir"`
+	TaskDirStrict bool   `clop:"long" usage:"abort startup if any task file is invalid or fails to reconcile"`
+	// 超过这个时间没有收到runtime的心跳就断开连接, 需要大于runtime的心跳间隔
+	HeartbeatTimeout time.Duration `clop:"long" usage:"close the runtime connection if no heartbeat is received within this time" default:"10s"`
+	// websocket协议层的ping间隔, 连续3个间隔没有pong就断开, 能发现半开的连接
+	PingInterval time.Duration `clop:"long" usage:"interval of websocket pings, the connection is closed when pongs stop" default:"5s"`
+	// 同时配置证书和私钥时, http和websocket都走tls
+	CertFile string `clop:"long" usage:"tls certificate file, serve https/wss when set with key-file"`
+	KeyFile  string `clop:"long" usage:"tls private key file"`
+	// 允许跨域发起websocket连接的Origin, 默认只允许同源
+	AllowedOrigins []string `clop:"long;greedy" usage:"origins allowed to open the task stream cross-origin, * allows all"`
+	// 长连接开启per-message deflate, runtime不支持时协商失败, 退回不压缩
+	WsCompression bool `clop:"long" usage:"enable per-message deflate on the task stream"`
+	// gate元数据在etcd里面的前缀和刷新间隔
+	MetaPrefix   string        `clop:"long" usage:"etcd key prefix of the gate metadata" default:"/crab/v1/meta/gate"`
+	MetaInterval time.Duration `clop:"long" usage:"interval of publishing the gate metadata" default:"10s"`
+	// 选主的租约时间, 主节点挂掉之后最多这么久其他gate接管
+	LeaderTTL time.Duration `clop:"long" usage:"lease ttl of the gate leader election" default:"10s"`
+	// 接口里面单次etcd操作的超时时间, 超时返回504
+	EtcdOpTimeout time.Duration `clop:"long" usage:"timeout of etcd operations in http handlers, 504 is returned on timeout" default:"3s"`
+	// 接口里面读etcd的重试策略, 每次尝试单独超时, 间隔每次翻倍, 次数用完返回503
+	EtcdOpRetry          int           `clop:"long" usage:"max attempts of etcd reads in http handlers, 503 is returned when exhausted" default:"3"`
+	EtcdOpAttemptTimeout time.Duration `clop:"long" usage:"timeout of each etcd read attempt in http handlers" default:"1s"`
+	EtcdOpBackoff        time.Duration `clop:"long" usage:"initial backoff between etcd read attempts, doubled on each failure" default:"100ms"`
+	// status接口单页的上限, 超过时按上限返回
+	MaxStatusLimit int `clop:"long" usage:"max page size of the task status list, larger limits are clamped" default:"1000"`
+	// 用户列表单页的默认大小和上限, 超过上限时按上限返回
+	UserLimit    int `clop:"long" usage:"default page size of the user list" default:"10"`
+	MaxUserLimit int `clop:"long" usage:"max page size of the user list, larger limits are clamped" default:"100"`
+	// 每个任务保留最近多少次的执行历史
+	TaskHistoryLimit int `clop:"long" usage:"number of runs kept in the history of each task, negative disables the history" default:"100"`
+	// 检查任务执行超时的间隔
+	TimeoutCheckInterval time.Duration `clop:"long" usage:"interval of checking the tasks running longer than their timeout" default:"1s"`
+	// 一次性任务执行完之后在etcd里面保留的时间, 到期自动删除
+	OnceTaskTTL time.Duration `clop:"long" usage:"retention of run-once tasks in etcd after they finish, 0 keeps them forever"`
+	// http服务的超时和大小限制, 防止慢连接和超大的请求, 0表示不限制
+	ReadHeaderTimeout time.Duration `clop:"long" usage:"timeout of reading the request headers" default:"5s"`
+	ReadTimeout       time.Duration `clop:"long" usage:"timeout of reading the entire request, including the body" default:"30s"`
+	WriteTimeout      time.Duration `clop:"long" usage:"timeout of writing the response, the task stream and the watch stream are not limited" default:"60s"`
+	IdleTimeout       time.Duration `clop:"long" usage:"timeout of keep-alive connections waiting for the next request" default:"120s"`
+	MaxHeaderBytes    int           `clop:"long" usage:"max size of the request headers" default:"1048576"`
+	MaxBodyBytes      int64         `clop:"long" usage:"max size of the task request body, 413 is returned when exceeded" default:"1048576"`
+	// 序列化之后保存到etcd的task大小, 必须比etcd的--max-request-bytes(默认1.5MiB)小
+	MaxTaskBytes int64 `clop:"long" usage:"max size of a marshaled task saved to etcd, 413 is returned when exceeded, keep it below the etcd --max-request-bytes" default:"1048576"`
+	// 最多允许多少个runtime连接到本gate, 超过之后返回503, 0表示不限制
+	MaxRuntimeConns int `clop:"long" usage:"max number of runtime connections, 503 is returned when exceeded, 0 means no limit" default:"10000"`
+	// 每个runtime连接的发送队列长度, 满了说明runtime读得太慢, 断开连接
+	SendQueueSize int `clop:"long" usage:"size of the send queue of each runtime connection, the connection is closed when it overflows" default:"64"`
+	// 创建task时Idempotency-Key的保留时间, 这段时间里面用同一个key重试返回第一次的结果
+	IdempotencyTTL time.Duration `clop:"long" usage:"retention of the Idempotency-Key of task creation" default:"10m"`
+	// 优雅退出的超时时间
+	ShutdownTimeout time.Duration `clop:"long" usage:"graceful shutdown timeout" default:"10s"`
+	// jwt的密钥和签发者, 不同环境需要配置成不同的值
+	JWTSecret string        `clop:"long;env=CRAB_JWT_SECRET" usage:"secret used to sign the login token"`
+	JWTIssuer string        `clop:"long" usage:"issuer of the login token" default:"crab"`
+	TokenTTL  time.Duration `clop:"long" usage:"lifetime of the login token" default:"24h"`
+	// 登录失败的限流, 窗口内失败次数到了上限之后锁定, 连续锁定时锁定时间翻倍
+	LoginMaxFailures int           `clop:"long" usage:"failed logins allowed per ip or user within the window before locking" default:"5"`
+	LoginFailWindow  time.Duration `clop:"long" usage:"window of counting failed logins" default:"1m"`
+	LoginLockout     time.Duration `clop:"long" usage:"initial lockout after too many failed logins, doubled on each lockout" default:"1m"`
+	// 过期不超过这个时间的token还可以刷新
+	TokenRefreshGrace time.Duration `clop:"long" usage:"expired tokens can still be refreshed within this window" default:"1h"`
+	// 拒绝json请求里面的未知字段, 默认关闭兼容老的客户端, 推荐打开
+	StrictJSON bool `clop:"long" usage:"reject unknown fields in json task requests with 400(recommended)"`
+	// gin的运行模式, 生产环境用release, 不打印路由和调试信息
+	GinMode string `clop:"long" usage:"gin mode, debug, release or test" default:"release"`
+	// runtime断开时默认马上删除节点信息并撤销租约, 打开之后保留到租约过期, 短暂的网络抖动不会触发重新调度
+	KeepLeaseOnDisconnect bool `clop:"long" usage:"keep the runtime node until its lease expires instead of revoking it on disconnect"`
+
+	// status接口的状态缓存, 由一个watch维护, 超过StateCacheMaxAge没有确认同步时直接读etcd
+	StateCache       bool          `clop:"long" usage:"serve task states of the status api from a watch cache"`
+	StateCacheMaxAge time.Duration `clop:"long" usage:"fall back to etcd reads when the state cache has not synced for this long" default:"3s"`
+
+	// v2协议的runtime收到task之后回ack, 超过DispatchAckTimeout没有ack重新下发, 最多下发DispatchAttempts次
+	DispatchAckTimeout time.Duration `clop:"long" usage:"redispatch a task when the runtime has not acked it within this time, negative disables acks" default:"5s"`
+	DispatchAttempts   int           `clop:"long" usage:"mark the dispatch failed after this many attempts without an ack" default:"3"`
+
+	// etcd 租约id, 通过gateLease读取
+	leaseID atomic.Int64
+	// gate节点的注册(申请租约, 写入节点信息)同一时间只有一个
+	registerMu sync.Mutex
 	// 日志对象
 	*slog.Slog
-	// ctx
-	ctx context.Context
+	// ctx, 退出时取消
+	ctx    context.Context
+	cancel context.CancelFunc
 	// login表
 	loginTable *LoginTable
 	// result表
 	resultTable *ResultTable
 	// status 表
 	statusTable *StatusTable
+	// 没有打开时为nil
+	stateCache *stateCache
 	// 统计runtime个数
 	runtimeCount int32
+	// websocket upgrader, 检查Origin
+	upgrader websocket.Upgrader
+	// gate节点是否已经注册到etcd, readyz使用
+	registered atomic.Bool
+	// 连接到本gate的runtime, key是runtime名, value是*runtimeConn
+	conns   sync.Map
+	connsMu sync.Mutex
+	// 可信代理
+	trustedProxy []*net.IPNet
+	// 链路追踪
+	tracer         trace.Tracer
+	tracerProvider *sdktrace.TracerProvider
+	// 唤醒webhook投递
+	webhookNotify chan struct{}
+	// 出站http调用的协程池
+	outbound *outboundPool
+	// 登录失败的限流
+	loginLimit *loginLimiter
+	// 选主, 当选时不为空
+	election *concurrency.Election
+	leaderMu sync.Mutex
+	leaderWg sync.WaitGroup
+	// runtime上报开始执行的任务, key是runtime名/taskName, value是runningTask
+	running sync.Map
+	// 这个gate注册的runtime节点, key是节点的etcd key, value是注册时用的租约
+	runtimeLeases sync.Map
+	// 下发之后等ack的task, key是runtime名/taskName, 同一个task只等最新的一次下发
+	pendingAcks map[string]pendingAck
+	acksMu      sync.Mutex
+	// 启动时间, 写入元数据
+	startTime time.Time
 }
 
 func (g *Gate) NodeName() string {
@@ -71,8 +232,32 @@ var (
 
 func (r *Gate) init() (err error) {
 
-	r.Slog = slog.New(os.Stdout).SetLevel(r.Level).Str("gate", r.Name)
+	var logErr error
+	r.Slog, logErr = slog.NewWithOptions(slog.Options{
+		File:       r.LogFile,
+		Format:     r.LogFormat,
+		MaxSize:    r.LogMaxSize,
+		MaxBackups: r.LogMaxBackups,
+		MaxAge:     r.LogMaxAge,
+	})
+	if logErr != nil {
+		// 保证SubMain里面还能打印出错误
+		r.Slog = slog.New(os.Stdout)
+	}
+	r.Slog = r.Slog.SetLevel(r.Level).Str("gate", r.Name)
+	if logErr != nil {
+		return logErr
+	}
 	r.getAddress()
+	if err = r.checkAdvertiseAddr(); err != nil {
+		return err
+	}
+	if err = r.checkGinMode(); err != nil {
+		return err
+	}
+	if err = r.checkStateCache(); err != nil {
+		return err
+	}
 
 	db, err := gorm.Open(mysql.New(mysql.Config{
 		DSN: r.DSN,
@@ -82,33 +267,82 @@ func (r *Gate) init() (err error) {
 	}
 	// 初始化数据库
 	r.loginTable = newLoginTable(db)
+	if err = r.loginTable.migratePassword(); err != nil {
+		return err
+	}
 
 	r.resultTable = newResultTable(db)
 
 	r.statusTable = newStatusTable(db)
 
-	r.ctx = context.TODO()
+	if r.RequestIDHeader == "" {
+		r.RequestIDHeader = defaultRequestIDHeader
+	}
+
+	if r.trustedProxy, err = parseTrustedProxy(r.TrustedProxy); err != nil {
+		return err
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.startTime = time.Now()
+	r.upgrader = r.newUpgrader()
+	if (r.CertFile == "") != (r.KeyFile == "") {
+		return errors.New("cert-file and key-file must be set together")
+	}
+
+	if r.ShutdownTimeout <= 0 {
+		r.ShutdownTimeout = defaultShutdownTimeout
+	}
 	if r.Name == "" {
 		r.Name = uuid.New().String()
 	}
+	if err = model.ValidateNodeName(r.Name); err != nil {
+		return err
+	}
+
+	if err = r.initTrace(); err != nil {
+		return err
+	}
+
+	r.initWebhook()
+	r.initToken()
+	r.loginLimit = newLoginLimiter(r.LoginMaxFailures, r.LoginFailWindow, r.LoginLockout)
 
-	if r.LeaseTime < model.RuntimeKeepalive {
-		r.LeaseTime = model.RuntimeKeepalive + time.Second
+	if err = r.checkLeaseTime(); err != nil {
+		return err
 	}
 
-	if defautlClient, err = utils.NewEtcdClient(r.EtcdAddr); err != nil { //初始etcd客户端
+	r.checkHeartbeatTimeout()
+
+	if defautlClient, err = r.newEtcdClient(); err != nil { //初始etcd客户端
 		return err
 	}
 
-	defaultKVC = clientv3.NewKV(defautlClient) // 内置自动重试的逻辑
-	defaultStore, err = etcd.NewStore(r.EtcdAddr, r.Slog, nil)
-	return err
+	defaultKVC = defautlClient.KV // 内置自动重试的逻辑, 配置了namespace时自动加上前缀
+	defaultStore = etcd.NewStoreWithClient(defautlClient, r.Slog, nil)
+	go utils.WatchEtcdConn(r.ctx, r.Slog, defautlClient)
+	return nil
+}
+
+func (r *Gate) etcdConfig() utils.EtcdConfig {
+	return utils.EtcdConfig{
+		Username:  r.EtcdUsername,
+		Password:  r.EtcdPassword,
+		CAFile:    r.EtcdCA,
+		CertFile:  r.EtcdCert,
+		KeyFile:   r.EtcdKey,
+		Namespace: r.Namespace,
+	}
 }
 
 func (r *Gate) autoNewAddr() (addr string) {
 
 	if r.AutoFindAddr {
-		r.ServerAddr = utils.GetUnusedAddr()
+		if r.OutboundIP {
+			r.ServerAddr = utils.GetUnusedOutboundAddr()
+		} else {
+			r.ServerAddr = utils.GetUnusedAddr()
+		}
 	}
 	return r.ServerAddr
 }
@@ -122,52 +356,265 @@ func (r *Gate) getAddress() string {
 	return r.autoNewAddr()
 }
 
+// AdvertiseAddr必须是host:port, 并且别的节点能访问
+// 没有配置时注册的是监听地址, 监听0.0.0.0这样的地址只打印警告
+func (r *Gate) checkAdvertiseAddr() error {
+	if r.AdvertiseAddr != "" {
+		host, port, err := net.SplitHostPort(r.AdvertiseAddr)
+		if err != nil {
+			return fmt.Errorf("invalid advertise-addr:%s, %w", r.AdvertiseAddr, err)
+		}
+		if host == "" || port == "" || port == "0" || isUnspecifiedHost(host) {
+			return fmt.Errorf("advertise-addr(%s) is not reachable by other nodes", r.AdvertiseAddr)
+		}
+		return nil
+	}
+
+	if host, _, err := net.SplitHostPort(r.ServerAddr); err == nil && (host == "" || isUnspecifiedHost(host)) {
+		r.Warn().Msgf("gate:server address(%s) is registered to etcd, set advertise-addr for other nodes", r.ServerAddr)
+	}
+	return nil
+}
+
+func isUnspecifiedHost(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// 注册到etcd里面给别的节点访问的地址
+func (r *Gate) advertiseAddr() string {
+	if r.AdvertiseAddr != "" {
+		return r.AdvertiseAddr
+	}
+	return r.getAddress()
+}
+
+// 写入注册中心的地址, 开启tls时带上wss://, runtime按这个scheme建立连接
+// 没有开启时还是ip:port, 老版本的runtime也能用
+func (r *Gate) registryAddr() string {
+	addr := r.advertiseAddr()
+	if addr != "" && r.tlsEnabled() {
+		return "wss://" + addr
+	}
+	return addr
+}
+
 func (r *Gate) ok(c *gin.Context, msg string) {
-	r.Debug().Caller(1).Msg(msg)
+	countTaskRequest(c, false)
+	r.Debug().RequestID(getRequestID(c)).Caller(1).Msg(msg)
 	c.JSON(200, gin.H{"code": 0, "message": ""})
 }
 
-func (r *Gate) error2(c *gin.Context, code int, format string, a ...any) {
+// 和ok一样，多带一个data字段
+func (r *Gate) okWithData(c *gin.Context, msg string, data any) {
+	countTaskRequest(c, false)
+	r.Debug().RequestID(getRequestID(c)).Caller(1).Msg(msg)
+	c.JSON(200, wrapData{Data: data})
+}
+
+// createTask, updateTask的响应
+type taskRevisionRsp struct {
+	// 实际保存的task名, 开启归一化之后可能和请求里面的不一样
+	TaskName string `json:"taskName"`
+	// 数据的revision, 更新时通过X-Task-Revision带回来
+	Revision int64 `json:"revision"`
+	// 数据在etcd里面的key
+	Key string `json:"key"`
+	// 写入的状态
+	State string `json:"state"`
+}
+
+// createTask dry_run=true时的响应, 只做检查不写etcd
+type taskDryRunRsp struct {
+	DryRun   bool   `json:"dryRun"`
+	TaskName string `json:"taskName"`
+	// 将会写入etcd的task
+	Task *model.Param `json:"task"`
+}
+
+// 从query里面取dry_run, 没有带的时候是false
+func getDryRun(c *gin.Context) (bool, error) {
+	v := c.Query(dryRunQuery)
+	if v == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s:%s", dryRunQuery, v)
+	}
+	return dryRun, nil
+}
+
+// 从请求头里面取revision, 没有带的时候不做检查
+func getRevision(c *gin.Context) (revision int64, ok bool, err error) {
+	h := c.GetHeader(revisionHeader)
+	if h == "" {
+		return 0, false, nil
+	}
 
-	msg := fmt.Sprintf(format, a...)
-	r.Error().Caller(1).Msg(msg)
-	c.JSON(200, gin.H{"code": code, "message": msg})
+	revision, err = strconv.ParseInt(h, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, false, fmt.Errorf("invalid %s:%s", revisionHeader, h)
+	}
+	return revision, true, nil
 }
 
-// 简单的包装函数
-func (r *Gate) error(c *gin.Context, code int, format string, a ...any) {
+// 创建之前的检查, 出错时返回400
+func (r *Gate) checkCreate(req *model.Param) error {
+	if r.NormalizeTaskName {
+		req.Executer.TaskName = normalizeTaskName(req.Executer.TaskName)
+		if req.Executer.TaskName == "" {
+			return errors.New("the task name is empty after normalization")
+		}
+	}
+
+	return req.Validate()
+}
+
+// 指定了runtime时, 这个runtime必须已经注册到etcd
+func (r *Gate) checkTargetRuntime(ctx context.Context, req *model.Param) error {
+	node := req.TargetRuntimeNode()
+	if node == "" {
+		return nil
+	}
+
+	rsp, err := defaultKVC.Get(ctx, node, clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
 
-	msg := fmt.Sprintf(format, a...)
-	r.Error().Caller(1).Msg(msg)
-	c.JSON(500, gin.H{"code": code, "message": msg})
+	if rsp.Count == 0 {
+		return &badRequestError{err: fmt.Errorf("runtime(%s) is not registered", req.Runtime)}
+	}
+	return nil
 }
 
 // 把task信息保存至etcd
 func (r *Gate) createTask(c *gin.Context) {
+	dryRun, err := getDryRun(c)
+	if err != nil {
+		r.error(c, model.ErrValidation, "createTask:%s", err)
+		return
+	}
+
+	idemKey, err := getIdempotencyKey(c)
+	if err != nil {
+		r.error(c, model.ErrValidation, "createTask:%s", err)
+		return
+	}
+
 	var req model.Param
-	err := c.ShouldBind(&req)
+	err = r.shouldBindStrict(c, &req)
 	if err != nil {
-		r.error(c, 500, "createTask:%v, type:%s", err, c.ContentType())
+		if r.badRequest(c, err, "createTask") {
+			return
+		}
+		r.error(c, model.ErrValidation, "createTask:%v, type:%s", err, c.ContentType())
 		return
 	}
 
 	r.Debug().Msgf("start create \n")
+	if err = r.checkCreate(&req); err != nil {
+		r.error(c, model.ErrValidation, "createTask:%s", err)
+		return
+	}
+
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	if err = r.checkTargetRuntime(ctx, &req); err != nil {
+		if r.badRequest(c, err, "createTask") {
+			return
+		}
+		r.etcdError(c, ctx, err, "createTask")
+		return
+	}
+
 	taskName := req.Executer.TaskName
 	// 创建数据队列
 	globalTaskName := model.FullGlobalTask(taskName)
 
+	// 客户端重试, 之前已经创建成功了
+	var idemPath string
+	if idemKey != "" && !dryRun {
+		idemPath = idempotencyPath(c, idemKey)
+		prev, err := r.loadIdempotent(ctx, idemPath)
+		if err != nil {
+			r.etcdError(c, ctx, err, "createTask")
+			return
+		}
+		if prev != nil {
+			r.replayIdempotent(c, prev, taskName)
+			return
+		}
+	}
+
 	// 先get，如果有值直接返回
-	rsp, err := defaultKVC.Get(r.ctx, globalTaskName, clientv3.WithKeysOnly())
+	spanCtx, span := r.startEtcdSpan(ctx, "get", globalTaskName)
+	rsp, err := r.etcdGet(spanCtx, globalTaskName, clientv3.WithKeysOnly())
+	endSpan(span, err)
+	if err != nil {
+		r.etcdError(c, ctx, err, "createTask")
+		return
+	}
 	if len(rsp.Kvs) > 0 {
-		r.error(c, 500, "duplicate creation:%s", globalTaskName)
+		// 同一个key的重试, 第一次在上面读key之后刚创建成功
+		if r.replayIfIdempotent(c, ctx, idemPath, taskName) {
+			return
+		}
+		r.error(c, model.ErrDuplicateTask, "createTask:%s:%s, duplicate creation", etcd.ErrTaskExists, taskName)
 		return
 	}
 
 	req.SetCreate() //设置action
+	injectTrace(c.Request.Context(), &req)
+	if err = r.checkTaskSize(&req); err != nil {
+		r.error(c, model.ErrTooLarge, "createTask:%s", err)
+		return
+	}
 
-	err = defaultStore.LockCreateDataAndState(r.ctx, taskName, &req)
+	if dryRun {
+		r.okWithData(c, "createTask dry run succeeded", taskDryRunRsp{DryRun: true, TaskName: taskName, Task: &req})
+		return
+	}
+
+	created := taskRevisionRsp{
+		TaskName: taskName,
+		Key:      globalTaskName,
+		State:    model.CanRun,
+	}
+
+	// 创建结果, 审计记录和task在同一个事务里面写入
+	var extra []clientv3.Op
+	var idemLease clientv3.LeaseID
+	if idemPath != "" {
+		op, lease, err := r.idempotentOp(ctx, idemPath, created)
+		if err != nil {
+			r.etcdError(c, ctx, err, "createTask")
+			return
+		}
+		extra, idemLease = append(extra, op), lease
+	}
+	extra = append(extra, r.auditOp(c, model.Create, taskName, 0))
+
+	spanCtx, span = r.startEtcdSpan(ctx, "createDataAndState", globalTaskName)
+	revision, err := defaultStore.LockCreateDataAndState(spanCtx, taskName, &req, extra...)
+	endSpan(span, err)
 	if err != nil {
-		r.error(c, 500, err.Error())
+		if idemLease != 0 {
+			defautlClient.Revoke(context.Background(), idemLease)
+		}
+		// 并发创建同名的task, 只有一个会成功
+		if errors.Is(err, etcd.ErrTaskExists) {
+			// 同一个key的并发重试, 成功的那个已经和task一起保存了结果
+			if r.replayIfIdempotent(c, ctx, idemPath, taskName) {
+				return
+			}
+			r.error(c, model.ErrDuplicateTask, "createTask:%s", err)
+			return
+		}
+		r.etcdError(c, ctx, err, "createTask")
 		return
 	}
 
@@ -175,12 +622,59 @@ func (r *Gate) createTask(c *gin.Context) {
 	if err != nil {
 		r.Warn().Msgf("status table:insert db fail:%s", err)
 	}
-	r.ok(c, "createTask Execution succeeded") //返回正确业务码
+	created.Revision = revision
+	c.Header(revisionHeader, strconv.FormatInt(revision, 10))
+	r.okWithData(c, "createTask Execution succeeded", created) //返回正确业务码
 }
 
 // 删除etcd里面task信息，也直接下发命令更新runtime里面信息
-func (r *Gate) removeTask(c *gin.Context) {
-	r.onlyUpdateAction(c, model.Rm)
+// 全局数据和状态在一个事务里面删除, 删除命令通过本地队列推送给runtime
+func (r *Gate) deleteTask(c *gin.Context) {
+	var req model.OnlyParam
+	if err := r.shouldBindStrict(c, &req); err != nil {
+		if r.badRequest(c, err, model.Rm) {
+			return
+		}
+		r.error(c, model.ErrValidation, "%s:%v", model.Rm, err)
+		return
+	}
+
+	taskName := req.Executer.TaskName
+	globalTaskName := model.FullGlobalTask(taskName)
+
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	// 删除前的revision, 写审计记录用
+	rsp, err := r.etcdGet(ctx, globalTaskName, clientv3.WithKeysOnly())
+	if err != nil {
+		r.etcdError(c, ctx, err, model.Rm)
+		return
+	}
+
+	spanCtx, span := r.startEtcdSpan(ctx, "deleteDataAndState", globalTaskName)
+	var before int64
+	if len(rsp.Kvs) > 0 {
+		before = rsp.Kvs[0].ModRevision
+	}
+	err = defaultStore.LockDeleteDataAndState(spanCtx, taskName, r.auditOp(c, model.Rm, taskName, before))
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, etcd.ErrTaskNotFound) {
+			r.error(c, model.ErrNotFound, "Task is empty and cannot be %s:%s", model.Rm, globalTaskName)
+			return
+		}
+		r.etcdError(c, ctx, err, model.Rm)
+		return
+	}
+
+	req.Action = model.Rm
+	if err = r.statusTable.delete(onlyParamToStatus(req, model.State{})); err != nil {
+		r.Warn().Msgf("status table:delete db fail:%s", err)
+	}
+
+	r.deleteHistory(ctx, taskName)
+	r.ok(c, fmt.Sprintf("%s Execution succeeded", model.Rm)) //返回正确业务码
 }
 
 // 更新etcd里面的action信息，置为停止，下发命令取消正在执行中的task
@@ -196,9 +690,12 @@ func (r *Gate) continueTask(c *gin.Context) {
 func (r *Gate) onlyUpdateAction(c *gin.Context, action string) {
 
 	var req model.OnlyParam
-	err := c.ShouldBind(&req)
+	err := r.shouldBindStrict(c, &req)
 	if err != nil {
-		r.error(c, 500, "%s:%v", action, err)
+		if r.badRequest(c, err, action) {
+			return
+		}
+		r.error(c, model.ErrValidation, "%s:%v", action, err)
 		return
 	}
 
@@ -219,16 +716,28 @@ func (r *Gate) onlyUpdateAction(c *gin.Context, action string) {
 			r.Warn().Msgf("status table:update db fail:%s", err)
 		}
 	}
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
 	// 先get，更新时如果没有值直接返回
-	rsp, err := defaultKVC.Get(r.ctx, globalTaskName, clientv3.WithKeysOnly())
+	spanCtx, span := r.startEtcdSpan(ctx, "get", globalTaskName)
+	rsp, err := r.etcdGet(spanCtx, globalTaskName, clientv3.WithKeysOnly())
+	endSpan(span, err)
+	if err != nil {
+		r.etcdError(c, ctx, err, action)
+		return
+	}
 	if len(rsp.Kvs) == 0 {
-		r.error(c, 500, "Task is empty and cannot be %s:%s", action, globalTaskName)
+		r.error(c, model.ErrNotFound, "Task is empty and cannot be %s:%s", action, globalTaskName)
 		return
 	}
 
-	err = defaultStore.LockUpdateAction(r.ctx, req.Executer.TaskName, &req, rsp.Kvs[0].ModRevision, model.CanRun, action)
+	spanCtx, span = r.startEtcdSpan(ctx, "updateAction", globalTaskName)
+	_, err = defaultStore.LockUpdateAction(spanCtx, req.Executer.TaskName, &req, rsp.Kvs[0].ModRevision, model.CanRun, action,
+		r.auditOp(c, action, req.Executer.TaskName, rsp.Kvs[0].ModRevision))
+	endSpan(span, err)
 	if err != nil {
-		r.error(c, 500, err.Error())
+		r.etcdError(c, ctx, err, action)
 		return
 	}
 
@@ -243,42 +752,101 @@ func (r *Gate) updateTask(c *gin.Context) {
 func (r *Gate) updateTaskCore(c *gin.Context, action string) {
 
 	var req model.Param
-	err := c.ShouldBind(&req)
+	err := r.shouldBindStrict(c, &req)
 	if err != nil {
-		r.error(c, 500, "%s:%v", action, err)
+		if r.badRequest(c, err, action) {
+			return
+		}
+		r.error(c, model.ErrValidation, "%s:%v", action, err)
 		return
 	}
 
-	// 创建全局数据队列key名
-	globalTaskName := model.FullGlobalTask(req.Executer.TaskName)
+	if err = req.Validate(); err != nil {
+		r.error(c, model.ErrValidation, "%s:%s", action, err)
+		return
+	}
 
-	switch action {
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
 
-	case model.Update:
-		err = r.statusTable.update(paramToStatus(&req))
-		if err != nil {
-			r.Warn().Msgf("status table:update db fail:%s", err)
+	if err = r.checkTargetRuntime(ctx, &req); err != nil {
+		if r.badRequest(c, err, action) {
+			return
 		}
+		r.etcdError(c, ctx, err, action)
+		return
 	}
+
+	// 创建全局数据队列key名
+	globalTaskName := model.FullGlobalTask(req.Executer.TaskName)
+
+	// 客户端带上读取时的revision, 不一致说明被别人修改过
+	revision, hasRevision, err := getRevision(c)
+	if err != nil {
+		r.error(c, model.ErrValidation, "%s:%s", action, err)
+		return
+	}
+	if !hasRevision && r.RequireRevision {
+		r.error(c, model.ErrPreconditionRequired, "%s:missing %s, get the task first and send back its revision", action, revisionHeader)
+		return
+	}
+
 	// 先get，更新时如果没有值直接返回
-	rsp, err := defaultKVC.Get(r.ctx, globalTaskName, clientv3.WithKeysOnly())
+	spanCtx, span := r.startEtcdSpan(ctx, "get", globalTaskName)
+	rsp, err := r.etcdGet(spanCtx, globalTaskName, clientv3.WithKeysOnly())
+	endSpan(span, err)
+	if err != nil {
+		r.etcdError(c, ctx, err, action)
+		return
+	}
 	if len(rsp.Kvs) == 0 {
-		r.error(c, 500, "Task is empty and cannot be %s:%s", action, globalTaskName)
+		r.error(c, model.ErrNotFound, "Task is empty and cannot be %s:%s", action, globalTaskName)
 		return
 	}
 
+	// 没有带revision时不做检查, 以当前的revision更新, 响应里面返回新的revision
+	if !hasRevision {
+		revision = rsp.Kvs[0].ModRevision
+	}
+
 	switch action {
 	case model.Update:
 		req.SetUpdate()
 	}
+	injectTrace(c.Request.Context(), &req)
+	if err = r.checkTaskSize(&req); err != nil {
+		r.error(c, model.ErrTooLarge, "%s:%s", action, err)
+		return
+	}
 
-	err = defaultStore.LockUpdateDataAndState(r.ctx, req.Executer.TaskName, &req, rsp.Kvs[0].ModRevision, model.CanRun, action)
+	spanCtx, span = r.startEtcdSpan(ctx, "updateDataAndState", globalTaskName)
+	newRevision, err := defaultStore.LockUpdateDataAndState(spanCtx, req.Executer.TaskName, &req, revision, model.CanRun, action,
+		r.auditOp(c, action, req.Executer.TaskName, revision))
+	endSpan(span, err)
 	if err != nil {
-		r.error(c, 500, err.Error())
+		if errors.Is(err, etcd.ErrRevisionMismatch) {
+			r.error(c, model.ErrConflict, "%s:task has been modified, revision(%d), current revision(%d)", action, revision, rsp.Kvs[0].ModRevision)
+			return
+		}
+		r.etcdError(c, ctx, err, action)
 		return
 	}
 
-	r.ok(c, fmt.Sprintf("%s Execution succeeded", action)) //返回正确业务码
+	switch action {
+	case model.Update:
+		err = r.statusTable.updateParam(&req)
+		if err != nil {
+			r.Warn().Msgf("status table:update db fail:%s", err)
+		}
+	}
+
+	c.Header(revisionHeader, strconv.FormatInt(newRevision, 10))
+	r.okWithData(c, fmt.Sprintf("%s Execution succeeded", action), taskRevisionRsp{
+		TaskName: req.Executer.TaskName,
+		Revision: newRevision,
+		Key:      globalTaskName,
+		State:    model.CanRun,
+	}) //返回正确业务码
 }
 
 // 该模块入口函数
@@ -288,86 +856,134 @@ func (r *Gate) SubMain() {
 		return
 	}
 
-	go func() {
-		if err := r.registerGateNode(); err != nil {
-			r.Error().Msgf("gate:registerGateNode fail:%s\n", err)
+	r.registerMetrics()
+
+	if r.TaskDir != "" {
+		if err := r.reconcileTaskDir(); err != nil {
+			r.Error().Msgf("gate:reconcileTaskDir fail:%s\n", err)
+			return
 		}
+	}
+
+	// 只有主节点投递webhook, 重新分配断开runtime的任务和触发到点的任务, 其他的gate只是把webhook写入队列
+	// 当选之后先对账一次, 修复上一个主节点留下的卡住的任务
+	leaderJobs := []func(ctx context.Context){r.watchRuntimeDown, r.syncOnLeader, r.cronTriggerLoop}
+	if r.WebhookURL != "" {
+		leaderJobs = append(leaderJobs, r.webhookLoop)
+	}
+	r.leaderWg.Add(1)
+	go func() {
+		defer r.leaderWg.Done()
+		r.runLeader(leaderJobs...)
 	}()
+	// 每个gate只检查连接到自己的runtime上的任务
+	go r.timeoutLoop(r.ctx)
+	go r.metaLoop(r.ctx)
+	if r.StateCache {
+		r.stateCache = newStateCache()
+		go r.stateCacheLoop(r.ctx)
+	}
 
-	//gin.SetMode(gin.ReleaseMode)
+	gin.SetMode(r.ginMode())
 	g := gin.New()
 	// 跨域
 	config := cors.Config{
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", tokenHeader},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", tokenHeader, r.RequestIDHeader, revisionHeader, idempotencyHeader},
+		ExposeHeaders:    []string{r.RequestIDHeader, revisionHeader, nextStartKeyHeader, idempotentReplayedHeader, stateCacheRevisionHeader, stateCacheAgeHeader, "Content-Disposition"},
 		AllowCredentials: false,
 		AllowAllOrigins:  true,
 		MaxAge:           12 * time.Hour,
 	}
 
 	g.Use(cors.New(config))
-	// result相关接口
-	// TODO token验证下
+	g.Use(r.requestID())
+	g.Use(r.accessLog())
+	g.Use(r.recovery())
+	g.Use(r.traceHandler())
+	g.NoRoute(r.noRoute)
+	// runtime上报结果, runtime没有token
 	g.POST(model.TASK_EXECUTER_RESULT_URL, r.saveResult)
-	g.GET(model.TASK_EXECUTER_RESULT_LIST_URL, r.getResultList)
-	g.DELETE(model.TASK_EXECUTER_RESULT_URL, r.deleteResult)
-
 	g.GET(model.TASK_STREAM_URL, r.stream) //流式接口，主动推送任务至runtime
-	g.POST(model.TASK_CREATE_URL, r.createTask)
-	g.PUT(model.TASK_UPDATE_URL, r.updateTask)
-
-	// delete 和 stop, continue，只使用客户端传递过来的taskName，忽略别的字段数据
-	g.DELETE(model.TASK_DELETE_URL, r.removeTask)
-	g.PATCH(model.TASK_STOP_URL, r.stopTask)
-	g.PATCH(model.TASK_CONTINUE_URL, r.continueTask)
-
+	// gate之间互相调用
 	g.GET(model.UI_GATE_COUNT, r.gateCount)
 
-	g.Use(func(ctx *gin.Context) {
+	g.GET(model.METRICS_URL, metricsHandler())
+	// 健康检查
+	g.GET(model.HEALTHZ_URL, r.healthz)
+	g.GET(model.READYZ_URL, r.readyz)
+	g.GET(model.VERSION_URL, r.versionInfo)
 
-		// 登录不检查token
-		if ctx.Request.URL.Path == model.UI_USER_LOGIN {
-			return
-		}
-
-		token := ctx.Query(tokenQuery)
-		if len(token) == 0 {
-			token = ctx.GetHeader(tokenHeader)
-		}
-		_, err := jwt.ParseToken(token, secretToken)
-		if err != nil {
-			ctx.Abort()
-			return
-		}
-	})
-
-	g.GET(model.TASK_UI_STATUS_URL, r.status)
-
-	g.GET(model.UI_GATE_LIST, r.gateList)
-
-	g.GET(model.UI_RUNTIME_LIST, r.runtimeList)
 	// 注册
 	g.POST(model.UI_USER_REGISTER_URL, r.register)
 	// 登录
 	g.POST(model.UI_USER_LOGIN, r.login)
+	// 刷新token, 刚过期的token也可以刷新, 所以不走authRequired
+	g.POST(model.UI_USER_REFRESH, r.refreshToken)
 	// 注销
 	g.POST(model.UI_USER_LOGOUT, r.logout)
-	// 删除用户
-	g.DELETE(model.UI_USER_DELETE_URL, r.deleteUser)
+
+	// 下面的接口都需要登录
+	auth := g.Group("", r.authRequired())
+
+	// result相关接口
+	auth.GET(model.TASK_EXECUTER_RESULT_LIST_URL, r.getResultList)
+	auth.DELETE(model.TASK_EXECUTER_RESULT_URL, r.deleteResult)
+
+	// task的请求body会写入etcd, 限制大小
+	limitBody := r.limitBody()
+	auth.POST(model.TASK_CREATE_URL, limitBody, r.createTask)
+	auth.POST(model.TASK_BATCH_URL, limitBody, r.createBatch)
+	auth.PUT(model.TASK_UPDATE_URL, limitBody, r.updateTask)
+
+	// delete 和 stop, continue，只使用客户端传递过来的taskName，忽略别的字段数据
+	auth.DELETE(model.TASK_DELETE_URL, limitBody, r.deleteTask)
+	auth.PATCH(model.TASK_STOP_URL, limitBody, r.stopTask)
+	auth.PATCH(model.TASK_CONTINUE_URL, limitBody, r.continueTask)
+	// 维护之前停止所有的task, 只有管理员可以调用
+	auth.POST(model.TASK_STOP_ALL_URL, r.adminOnly, r.stopAll)
+	// 下线一组task, 只有管理员可以调用
+	auth.DELETE(model.TASK_DELETE_BATCH_URL, r.adminOnly, r.deleteAll)
+
+	auth.GET(model.TASK_UI_STATUS_URL, r.status)
+	// 状态变化的推送, 给看板用
+	auth.GET(model.TASK_UI_WATCH_URL, r.watchState)
+	// 单个task的详情, 编辑页面预加载用
+	auth.GET(model.TASK_DETAIL_URL, r.taskDetail)
+	// 执行历史, 排查时好时坏的任务用
+	auth.GET(model.TASK_HISTORY_URL, r.taskHistory)
+	// 禁用和stop不一样, 只是不再调度, 正在运行的会继续执行完
+	auth.POST(model.TASK_DISABLE_URL, r.setTaskDisabled(true))
+	auth.POST(model.TASK_ENABLE_URL, r.setTaskDisabled(false))
+
+	auth.GET(model.UI_GATE_LIST, r.gateList)
+	auth.GET(model.GATES_URL, r.gates)
+
+	auth.GET(model.UI_RUNTIME_LIST, r.runtimeList)
+	// 删除用户, 只有管理员可以操作
+	auth.DELETE(model.UI_USER_DELETE_URL, r.adminOnly, r.deleteUser)
+	// 恢复删除的用户, 只有管理员可以操作
+	auth.POST(model.UI_USER_RESTORE_URL, r.adminOnly, r.restoreUser)
 	// 更新用户
-	g.PUT(model.UI_USER_UPDATE, r.updateUser)
+	auth.PUT(model.UI_USER_UPDATE, r.updateUser)
+	// 修改自己的密码
+	auth.POST(model.UI_USER_PASSWORD, r.changePassword)
 	// 获取某个用户
-	g.GET(model.UI_USER_INFO, r.getUserInfo)
+	auth.GET(model.UI_USER_INFO, r.getUserInfo)
 	// 获取用户列表
-	g.GET(model.UI_USERS_INFO_LIST, r.GetUserInfoList)
+	auth.GET(model.UI_USERS_INFO_LIST, r.GetUserInfoList)
+
+	// 注册中心的原始数据, 只有管理员可以访问
+	auth.GET(model.UI_REGISTRY_LIST, r.adminOnly, r.registryList)
+	auth.DELETE(model.UI_REGISTRY_URL, r.adminOnly, r.registryDelete)
+
+	// webhook的死信队列, 里面有回调的地址和结果, 只有管理员可以访问
+	auth.GET(model.UI_WEBHOOK_DEAD_LIST, r.adminOnly, r.webhookDeadList)
+	// 任务变更的审计记录
+	auth.GET(model.UI_AUDIT_LIST, r.auditList)
 
 	r.Debug().Msgf("gate:serverAddr:%s\n", r.ServerAddr)
-	for i := 0; i < 3; i++ {
-		if err := g.Run(r.ServerAddr); err != nil {
-			r.Debug().Msgf("run fail:%v\n", err)
-			r.autoNewAddrAndRegister()
-			r.Debug().Msgf("gate:serverAddr:%s\n", r.ServerAddr)
-			time.Sleep(time.Millisecond * 500)
-		}
+	if err := r.serve(g); err != nil {
+		r.Error().Msgf("gate:serve:%s\n", err)
 	}
 }
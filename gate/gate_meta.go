@@ -0,0 +1,156 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/version"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const defaultMetaInterval = 10 * time.Second
+
+// gate的元数据, 其他gate和运维工具通过它了解整个集群
+type gateMeta struct {
+	version.Info
+	Name         string    `json:"name"`
+	Addr         string    `json:"addr"`
+	StartTime    time.Time `json:"start_time"`
+	UpdateTime   time.Time `json:"update_time"`
+	Capabilities []string  `json:"capabilities"`
+	// 连接到本gate的runtime个数
+	Conns int32 `json:"conns"`
+}
+
+// GET /crab/gates 的item
+type gateMetaItem struct {
+	Name string    `json:"name"`
+	Addr string    `json:"addr"`
+	Meta *gateMeta `json:"meta,omitempty"`
+}
+
+func (r *Gate) metaPrefix() string {
+	if r.MetaPrefix == "" {
+		return model.GateMetaPrefix
+	}
+	return strings.TrimSuffix(r.MetaPrefix, "/")
+}
+
+func (r *Gate) metaInterval() time.Duration {
+	if r.MetaInterval <= 0 {
+		return defaultMetaInterval
+	}
+	return r.MetaInterval
+}
+
+// 根据配置得出本gate开启的功能
+func (r *Gate) capabilities() []string {
+	c := []string{}
+	if r.tlsEnabled() {
+		c = append(c, "tls")
+	}
+	if r.WsCompression {
+		c = append(c, "ws-compression")
+	}
+	if r.WebhookURL != "" {
+		c = append(c, "webhook")
+	}
+	if r.TraceEndpoint != "" {
+		c = append(c, "trace")
+	}
+	if r.TaskDir != "" {
+		c = append(c, "task-dir")
+	}
+	return c
+}
+
+func (r *Gate) meta() gateMeta {
+	return gateMeta{
+		Info:         version.Get(),
+		Name:         r.NodeName(),
+		Addr:         r.advertiseAddr(),
+		StartTime:    r.startTime,
+		UpdateTime:   time.Now(),
+		Capabilities: r.capabilities(),
+		Conns:        atomic.LoadInt32(&r.runtimeCount),
+	}
+}
+
+// 把元数据写入etcd, 和gate节点用同一个租约, gate挂掉之后一起消失
+// 元数据只是给人看的, 写入失败只打日志
+func (r *Gate) publishMeta() {
+	leaseID := r.gateLease()
+	if leaseID == 0 {
+		return
+	}
+
+	value, err := json.Marshal(r.meta())
+	if err != nil {
+		r.Error().Msgf("gate.publishMeta marshal:%s\n", err)
+		return
+	}
+
+	key := r.metaPrefix() + "/" + r.NodeName()
+	if _, err = defautlClient.Put(r.ctx, key, string(value), clientv3.WithLease(leaseID)); err != nil {
+		r.Warn().Msgf("gate.publishMeta:%s %s\n", key, err)
+	}
+}
+
+// 定时刷新元数据, 连接数会一直变化
+func (r *Gate) metaLoop(ctx context.Context) {
+	tk := time.NewTicker(r.metaInterval())
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if r.registered.Load() {
+				r.publishMeta()
+			}
+		}
+	}
+}
+
+// 列出所有注册的gate和它们的元数据, 老版本的gate没有元数据, 也会列出来
+func (r *Gate) gates(c *gin.Context) {
+	ectx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	nodes, err := r.etcdGet(ectx, model.GateNodePrefix+"/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		r.etcdError(c, ectx, err, "gates")
+		return
+	}
+
+	prefix := r.metaPrefix() + "/"
+	metas, err := r.etcdGet(ectx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		r.etcdError(c, ectx, err, "gates")
+		return
+	}
+
+	byName := make(map[string]*gateMeta, len(metas.Kvs))
+	for _, kv := range metas.Kvs {
+		var m gateMeta
+		if err := json.Unmarshal(kv.Value, &m); err != nil {
+			r.Warn().Msgf("gates:unmarshal %s %s\n", kv.Key, err)
+			continue
+		}
+		byName[strings.TrimPrefix(string(kv.Key), prefix)] = &m
+	}
+
+	items := make([]gateMetaItem, 0, len(nodes.Kvs))
+	for _, kv := range nodes.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), model.GateNodePrefix+"/")
+		items = append(items, gateMetaItem{Name: name, Addr: string(kv.Value), Meta: byName[name]})
+	}
+
+	c.JSON(200, wrapData{Data: gateList{Total: int64(len(items)), Items: items}})
+}
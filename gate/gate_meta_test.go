@@ -0,0 +1,51 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/version"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 注册之后元数据和地址一起出现在/crab/gates里面
+func Test_Gates(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.WsCompression = true
+	g.runtimeCount = 2
+
+	assert.NoError(t, g.registerGateNode())
+	defer defautlClient.Revoke(g.ctx, g.gateLease())
+
+	router := gin.New()
+	router.GET(model.GATES_URL, g.gates)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, model.GATES_URL, nil))
+	assert.Equal(t, 200, w.Code)
+
+	var rsp struct {
+		Data struct {
+			Total int64          `json:"total"`
+			Items []gateMetaItem `json:"items"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+
+	var item *gateMetaItem
+	for i := range rsp.Data.Items {
+		if rsp.Data.Items[i].Name == g.NodeName() {
+			item = &rsp.Data.Items[i]
+		}
+	}
+	if assert.NotNil(t, item) && assert.NotNil(t, item.Meta) {
+		assert.Equal(t, g.ServerAddr, item.Addr)
+		assert.Equal(t, g.ServerAddr, item.Meta.Addr)
+		assert.Equal(t, version.Get().Version, item.Meta.Version)
+		assert.Equal(t, int32(2), item.Meta.Conns)
+		assert.Equal(t, []string{"ws-compression"}, item.Meta.Capabilities)
+	}
+}
@@ -52,7 +52,7 @@ func (g *Gate) gateList(ctx *gin.Context) {
 	// TODO 把分页逻辑抽离出来
 	p := pageGate{}
 	if err := ctx.ShouldBindQuery(&p); err != nil {
-		g.error2(ctx, 500, err.Error())
+		g.error(ctx, model.ErrValidation, "%s", err)
 		return
 	}
 
@@ -72,19 +72,26 @@ func (g *Gate) gateList(ctx *gin.Context) {
 		sortOrder = clientv3.SortDescend
 	}
 
-	resp, err := defaultKVC.Get(g.ctx,
+	ectx, cancel := g.etcdCtx(ctx)
+	defer cancel()
+
+	resp, err := defaultKVC.Get(ectx,
 		startKey,
 		clientv3.WithRange(endGateKey),
 		clientv3.WithSort(clientv3.SortByKey, sortOrder),
 		clientv3.WithLimit(p.Limit))
 	if err != nil {
-		g.error2(ctx, 500, err.Error())
+		if !g.etcdTimeout(ctx, ectx, err, "gateList") {
+			g.error(ctx, model.ErrEtcd, "%s", err)
+		}
 		return
 	}
 
-	resp2, err2 := defaultKVC.Get(g.ctx, model.GateNodePrefix, clientv3.WithCountOnly(), clientv3.WithPrefix())
+	resp2, err2 := defaultKVC.Get(ectx, model.GateNodePrefix, clientv3.WithCountOnly(), clientv3.WithPrefix())
 	if err2 != nil {
-		g.error2(ctx, 500, err2.Error())
+		if !g.etcdTimeout(ctx, ectx, err2, "gateList") {
+			g.error(ctx, model.ErrEtcd, "%s", err2)
+		}
 		return
 	}
 
@@ -0,0 +1,23 @@
+package gate
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gin的运行模式, 为空时是release, debug模式会打印所有的路由和警告
+func (r *Gate) ginMode() string {
+	if r.GinMode == "" {
+		return gin.ReleaseMode
+	}
+	return r.GinMode
+}
+
+func (r *Gate) checkGinMode() error {
+	switch r.ginMode() {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		return nil
+	}
+	return fmt.Errorf("gin-mode(%s) must be debug, release or test", r.GinMode)
+}
@@ -0,0 +1,26 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckGinMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		need string
+		ok   bool
+	}{
+		{"", gin.ReleaseMode, true},
+		{"debug", gin.DebugMode, true},
+		{"release", gin.ReleaseMode, true},
+		{"test", gin.TestMode, true},
+		{"prod", "prod", false},
+	} {
+		g := Gate{GinMode: tc.mode}
+		assert.Equal(t, tc.need, g.ginMode(), tc.mode)
+		assert.Equal(t, tc.ok, g.checkGinMode() == nil, tc.mode)
+	}
+}
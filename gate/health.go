@@ -0,0 +1,60 @@
+package gate
+
+import (
+	"context"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+// readyz检查etcd的超时时间
+const readyzTimeout = time.Second
+
+// 存活检查, 进程还在就返回200
+func (r *Gate) healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"code": 0, "message": "ok"})
+}
+
+// 就绪检查, etcd可以访问并且gate节点已经注册成功才返回200
+func (r *Gate) readyz(c *gin.Context) {
+	if !r.registered.Load() {
+		c.JSON(503, newErrorRsp(model.ErrUnavailable, "gate node is not registered"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyzTimeout)
+	defer cancel()
+	if _, err := defaultKVC.Get(ctx, model.HealthKey); err != nil {
+		c.JSON(503, newErrorRsp(model.ErrUnavailable, "etcd is unreachable:"+err.Error()))
+		return
+	}
+
+	c.JSON(200, gin.H{"code": 0, "message": "ok"})
+}
+
+// gate租约的自动续约停止了, 节点信息会随着租约过期被删除
+// 标记为未就绪, 并且一直重试注册, 直到etcd恢复
+func (r *Gate) onGateLeaseStop() {
+	r.registered.Store(false)
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	r.Error().Msgf("gate lease:%x keepalive stopped, re-register gate node\n", r.gateLease())
+	go r.registerGateNodeLoop()
+}
+
+// 一直重试注册, 直到成功或者退出
+func (r *Gate) registerGateNodeLoop() {
+	for r.ctx.Err() == nil {
+		if err := r.registerGateNode(); err == nil {
+			return
+		}
+
+		select {
+		case <-r.ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// gate节点注册成功之前readyz返回503
+func Test_Readyz(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	router := gin.New()
+	router.GET(model.HEALTHZ_URL, g.healthz)
+	router.GET(model.READYZ_URL, g.readyz)
+
+	code := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, 200, code(model.HEALTHZ_URL))
+	assert.Equal(t, 503, code(model.READYZ_URL))
+
+	assert.NoError(t, g.registerGateNode())
+	assert.Equal(t, 200, code(model.READYZ_URL))
+
+	// 续约停止之后重新变成未就绪
+	g.registered.Store(false)
+	assert.Equal(t, 503, code(model.READYZ_URL))
+
+	defautlClient.Revoke(g.ctx, g.gateLease())
+}
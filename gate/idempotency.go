@@ -0,0 +1,104 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// 重放的响应带上这个header, 客户端可以知道这次没有真正创建
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL = 10 * time.Minute
+)
+
+func (r *Gate) idempotencyTTL() time.Duration {
+	if r.IdempotencyTTL <= 0 {
+		return defaultIdempotencyTTL
+	}
+	return r.IdempotencyTTL
+}
+
+// 没有带header时返回空, 格式和request id一样只接受可打印的ascii字符
+func getIdempotencyKey(c *gin.Context) (string, error) {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		return "", nil
+	}
+	if !validRequestID(key) {
+		return "", fmt.Errorf("invalid %s, must be printable ascii and at most %d bytes", idempotencyHeader, maxRequestIDLen)
+	}
+	return key, nil
+}
+
+// 不同用户的key互不影响
+func idempotencyPath(c *gin.Context, key string) string {
+	return model.FullIdempotencyKey(c.GetString(userNameKey), key)
+}
+
+// 读取之前成功创建的结果, 没有时返回nil
+func (r *Gate) loadIdempotent(ctx context.Context, path string) (*taskRevisionRsp, error) {
+	rsp, err := defaultKVC.Get(ctx, path)
+	if err != nil || len(rsp.Kvs) == 0 {
+		return nil, err
+	}
+
+	var prev taskRevisionRsp
+	if err = json.Unmarshal(rsp.Kvs[0].Value, &prev); err != nil {
+		return nil, err
+	}
+	// 和task在同一个事务里面写入, key的revision就是创建时的revision
+	if prev.Revision == 0 {
+		prev.Revision = rsp.Kvs[0].ModRevision
+	}
+	return &prev, nil
+}
+
+// 保存创建结果的写操作, 放到创建task的事务里面, 创建成功和key一起生效, 不会有创建成功但是key还没写入的窗口
+// 返回的租约由调用方在创建失败时回收, 成功时到期由etcd删除
+func (r *Gate) idempotentOp(ctx context.Context, path string, rsp taskRevisionRsp) (clientv3.Op, clientv3.LeaseID, error) {
+	value, err := json.Marshal(rsp)
+	if err != nil {
+		return clientv3.Op{}, 0, err
+	}
+
+	lease, err := defautlClient.Grant(ctx, int64(r.idempotencyTTL()/time.Second))
+	if err != nil {
+		return clientv3.Op{}, 0, err
+	}
+	return clientv3.OpPut(path, string(value), clientv3.WithLease(lease.ID)), lease.ID, nil
+}
+
+// task已经存在时, 如果是同一个key的重试返回第一次创建的结果, 已经处理时返回true
+func (r *Gate) replayIfIdempotent(c *gin.Context, ctx context.Context, path, taskName string) bool {
+	if path == "" {
+		return false
+	}
+
+	prev, _ := r.loadIdempotent(ctx, path)
+	if prev == nil {
+		return false
+	}
+	r.replayIdempotent(c, prev, taskName)
+	return true
+}
+
+// 同一个key重试时返回第一次创建的结果, key被别的task用过返回409
+func (r *Gate) replayIdempotent(c *gin.Context, prev *taskRevisionRsp, taskName string) {
+	if prev.TaskName != taskName {
+		r.error(c, model.ErrConflict, "createTask:%s has been used by task(%s)", idempotencyHeader, prev.TaskName)
+		return
+	}
+
+	c.Header(revisionHeader, strconv.FormatInt(prev.Revision, 10))
+	c.Header(idempotentReplayedHeader, "true")
+	r.okWithData(c, "createTask Execution succeeded", prev)
+}
@@ -0,0 +1,88 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 同一个Idempotency-Key重试返回第一次创建的结果, 被别的task用过返回409
+func Test_CreateTask_Idempotency(t *testing.T) {
+	g := testInitEtcdGate(t)
+	assert.NoError(t, g.initTrace())
+	var err error
+
+	user, key := "guest", uuid.New().String()
+	path := model.FullIdempotencyKey(user, key)
+	taskName := uuid.New().String()
+	defer defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(userNameKey, user) })
+	router.POST(model.TASK_CREATE_URL, g.createTask)
+
+	post := func(name, key string) *httptest.ResponseRecorder {
+		body := `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"` + name + `","shell":{"command":"echo"}}}`
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, model.TASK_CREATE_URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyHeader, key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 模拟第一次创建, 结果和task在同一个事务里面写入
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	op, lease, err := g.idempotentOp(g.ctx, path, taskRevisionRsp{TaskName: taskName, Key: model.FullGlobalTask(taskName), State: model.CanRun})
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, lease)
+	revision, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param, op)
+	assert.NoError(t, err)
+
+	rsp, err := defaultKVC.Get(g.ctx, path)
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, int64(lease), rsp.Kvs[0].Lease)
+		assert.Equal(t, revision, rsp.Kvs[0].ModRevision)
+	}
+
+	// 创建失败时key不会写入
+	other := model.FullIdempotencyKey(user, uuid.New().String())
+	op, lease2, err := g.idempotentOp(g.ctx, other, taskRevisionRsp{TaskName: taskName})
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, lease2)
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param, op)
+	assert.ErrorIs(t, err, etcd.ErrTaskExists)
+	rsp, err = defaultKVC.Get(g.ctx, other)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+
+	// 重试返回第一次的结果
+	w := post(taskName, key)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, strconv.FormatInt(revision, 10), w.Header().Get(revisionHeader))
+	var got struct {
+		Data taskRevisionRsp `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, taskName, got.Data.TaskName)
+	assert.Equal(t, revision, got.Data.Revision)
+
+	// 没有带key的重复创建还是409
+	assert.Equal(t, 409, post(taskName, "").Code)
+	// key被别的task用过
+	assert.Equal(t, 409, post(uuid.New().String(), key).Code)
+	assert.Equal(t, 400, post(taskName, "bad key").Code)
+}
@@ -0,0 +1,132 @@
+package gate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// 选主session的租约时间(秒), 主节点挂掉之后最多这么久其他gate接管
+const defaultLeaderTTL = 10
+
+// 多个gate之间选出一个主节点, 只有主节点运行jobs, 其他的gate只提供接口
+// 失去主节点身份时取消jobs的ctx, 然后重新参与选举, 直到gate退出
+func (r *Gate) runLeader(jobs ...func(ctx context.Context)) {
+	for r.ctx.Err() == nil {
+		if err := r.campaign(jobs); err != nil && r.ctx.Err() == nil {
+			r.Warn().Msgf("gate.runLeader:%s", err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.ctx.Done():
+			}
+		}
+	}
+}
+
+// 一轮选举, 当选之后一直阻塞到失去主节点身份或者gate退出
+func (r *Gate) campaign(jobs []func(ctx context.Context)) error {
+	// session的ctx不能用r.ctx, 否则退出时Close没法回收租约
+	s, err := concurrency.NewSession(defautlClient, concurrency.WithTTL(r.leaderTTL()))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	e := concurrency.NewElection(s, model.GateLeaderPrefix)
+	if err = e.Campaign(r.ctx, r.Name); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	var wg sync.WaitGroup
+	r.setElection(e)
+	r.Info().Msgf("gate:%s become leader", r.Name)
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job func(ctx context.Context)) {
+			defer wg.Done()
+			job(ctx)
+		}(job)
+	}
+
+	select {
+	case <-s.Done():
+		r.Warn().Msgf("gate:%s lost leadership, session expired", r.Name)
+	case <-r.ctx.Done():
+	}
+
+	// 先停掉主节点的任务, 等它们退出之后再放弃主节点身份
+	// 否则新的主节点已经开始工作了, 旧的任务还在写
+	cancel()
+	wg.Wait()
+	r.setElection(nil)
+	select {
+	case <-s.Done():
+	default:
+		r.resignLeader(e)
+	}
+	return nil
+}
+
+// 按秒向上取整, 不足1秒的配置不能变成0
+func (r *Gate) leaderTTL() int {
+	if r.LeaderTTL > 0 {
+		return int((r.LeaderTTL + time.Second - 1) / time.Second)
+	}
+	return defaultLeaderTTL
+}
+
+func (r *Gate) setElection(e *concurrency.Election) {
+	r.leaderMu.Lock()
+	r.election = e
+	r.leaderMu.Unlock()
+}
+
+// 当前gate是否是主节点
+func (r *Gate) isLeader() bool {
+	r.leaderMu.Lock()
+	defer r.leaderMu.Unlock()
+	return r.election != nil
+}
+
+// 主节点的写操作带上这个条件, 选举的key还是自己当选时的那个才能写成功
+// 失去主节点身份之后, 还没有退出的任务也写不进去
+// 没有参与选举时(比如直接调用job)返回nil, 不加条件
+func (r *Gate) leaderFence() []clientv3.Cmp {
+	r.leaderMu.Lock()
+	e := r.election
+	r.leaderMu.Unlock()
+	if e == nil {
+		return nil
+	}
+	return []clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(e.Key()), "=", e.Rev())}
+}
+
+// 主动放弃主节点身份, 其他gate不用等租约过期就能接管
+// gate退出时r.ctx已经取消了, 用单独的超时
+func (r *Gate) resignLeader(e *concurrency.Election) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.etcdOpTimeout())
+	defer cancel()
+	if err := e.Resign(ctx); err != nil {
+		r.Warn().Msgf("gate:resign leader:%s", err)
+	}
+}
+
+// 等选主的goroutine退出, 主节点的任务都停掉并且已经放弃了主节点身份
+func (r *Gate) waitLeader(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		r.leaderWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.Warn().Msgf("gate:wait leader jobs:%s", ctx.Err())
+	}
+}
@@ -0,0 +1,118 @@
+package gate
+
+import (
+	"context"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 对账的结果, 日志和测试用
+type leaderSyncResult struct {
+	// 绑定的runtime已经不在了, 重置成CanRun的任务
+	Reset []string
+	// runtime还在, 重新推送的任务
+	Redispatched []string
+}
+
+// 当选主节点之后做一次全量的对账, 上一个主节点挂掉的这段时间里面可能漏掉了runtime断开的事件,
+// 推送任务的gate也可能在推送之前挂了, 这些任务会一直卡住
+// 1.绑定的runtime已经不在了, 重置成CanRun, 由mjobs重新分配
+// 2.runtime还在但是没有确认(InRuntime为false), 重新写一次本地队列, 让连接着它的gate再推送一次
+func (r *Gate) syncOnLeader(ctx context.Context) {
+	rv, err := r.leaderSync(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.Warn().Msgf("gate.syncOnLeader:%s", err)
+		}
+		return
+	}
+
+	if len(rv.Reset) > 0 || len(rv.Redispatched) > 0 {
+		r.Info().Msgf("gate.syncOnLeader:reset tasks:%v, redispatch tasks:%v", rv.Reset, rv.Redispatched)
+	}
+}
+
+func (r *Gate) leaderSync(ctx context.Context) (rv leaderSyncResult, err error) {
+	// 同一个runtime只查一次是否存活, 只重置一次
+	alive := make(map[string]bool)
+	key := model.GlobalTaskPrefixState + "/"
+	end := clientv3.GetPrefixRangeEnd(key)
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(labelScanBatch)}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		rsp, err := defaultKVC.Get(ctx, key, opts...)
+		if err != nil {
+			return rv, err
+		}
+		rev = rsp.Header.Revision
+
+		for _, kv := range rsp.Kvs {
+			if err = r.syncTaskState(ctx, kv, alive, &rv); err != nil {
+				return rv, err
+			}
+		}
+
+		if !rsp.More || len(rsp.Kvs) == 0 {
+			return rv, nil
+		}
+		key = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+func (r *Gate) syncTaskState(ctx context.Context, kv *mvccpb.KeyValue, alive map[string]bool, rv *leaderSyncResult) error {
+	state, err := model.ValueToState(kv.Value)
+	if err != nil {
+		r.Warn().Msgf("gate.syncOnLeader:%s, key:%s", err, kv.Key)
+		return nil
+	}
+
+	// 没有分配的和失败的任务由mjobs处理, 广播任务绑定的不止一个runtime, 也交给mjobs
+	// 执行过的一次性任务是Completed, 等待触发的是Waiting, 都不是Running, 不会被重置或者重新推送
+	if !state.IsRunning() || state.InRuntime || state.RuntimeNode == "" || state.IsBroadcast() {
+		return nil
+	}
+	if !(state.IsCreate() || state.IsUpdate() || state.IsContinue()) {
+		return nil
+	}
+	// 刚分配的任务可能还在推送中, 和NeedFix一样等一个心跳周期
+	if time.Since(state.UpdateTime) <= model.RuntimeKeepalive+time.Second {
+		return nil
+	}
+
+	ok, checked := alive[state.RuntimeNode]
+	if !checked {
+		node, err := defaultKVC.Get(ctx, state.RuntimeNode, clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+		ok = node.Count > 0
+		alive[state.RuntimeNode] = ok
+
+		if !ok {
+			taskNames, err := defaultStore.LockResetRuntime(ctx, state.RuntimeNode, r.leaderFence()...)
+			if err != nil {
+				return err
+			}
+			rv.Reset = append(rv.Reset, taskNames...)
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	redispatched, err := defaultStore.Redispatch(ctx, kv, state, r.leaderFence()...)
+	if err != nil {
+		return err
+	}
+	if redispatched {
+		rv.Redispatched = append(rv.Redispatched, model.TaskName(string(kv.Key)))
+	}
+	return nil
+}
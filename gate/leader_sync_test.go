@@ -0,0 +1,82 @@
+package gate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 此函数依赖etcd是否存在
+// 分配之后没有确认的任务: runtime不在了重置成CanRun, runtime还在重新写入本地队列
+func Test_LeaderSync(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	liveNode := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	deadNode := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	_, err = defaultKVC.Put(g.ctx, liveNode, "{}")
+	assert.NoError(t, err)
+	defer defaultKVC.Delete(g.ctx, liveNode)
+
+	newStuckTask := func(runtimeNode string, updateTime time.Time) (string, int64) {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+
+		state := model.State{TaskName: taskName, RuntimeNode: runtimeNode, State: model.Running, Action: model.Create, UpdateTime: updateTime}
+		value, err := json.Marshal(state)
+		assert.NoError(t, err)
+		_, err = defaultKVC.Put(g.ctx, model.FullGlobalTaskState(taskName), string(value))
+		assert.NoError(t, err)
+		rsp, err := defaultKVC.Put(g.ctx, model.ToLocalTask(runtimeNode, taskName), model.CanRun)
+		assert.NoError(t, err)
+		return taskName, rsp.Header.Revision
+	}
+
+	old := time.Now().Add(-time.Minute)
+	live, liveRev := newStuckTask(liveNode, old)
+	dead, _ := newStuckTask(deadNode, old)
+	// 刚分配的任务还在推送中, 不动
+	fresh, freshRev := newStuckTask(liveNode, time.Now())
+	defer func() {
+		for _, name := range []string{live, dead, fresh} {
+			defaultStore.LockDeleteDataAndState(g.ctx, name)
+		}
+		defaultKVC.Delete(g.ctx, model.ToLocalTask(liveNode, live))
+		defaultKVC.Delete(g.ctx, model.ToLocalTask(liveNode, fresh))
+	}()
+
+	rv, err := g.leaderSync(g.ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, rv.Redispatched, live)
+	assert.Contains(t, rv.Reset, dead)
+	assert.NotContains(t, rv.Redispatched, fresh)
+
+	rsp, err := defaultKVC.Get(g.ctx, model.ToLocalTask(liveNode, live))
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Greater(t, rsp.Kvs[0].ModRevision, liveRev)
+	}
+
+	rsp, err = defaultKVC.Get(g.ctx, model.ToLocalTask(liveNode, fresh))
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, freshRev, rsp.Kvs[0].ModRevision)
+	}
+
+	rsp, err = defaultKVC.Get(g.ctx, model.FullGlobalTaskState(dead))
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		assert.Equal(t, model.CanRun, state.State)
+		assert.Empty(t, state.RuntimeNode)
+	}
+}
@@ -0,0 +1,102 @@
+package gate
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// 此函数依赖etcd是否存在
+// 同一时间只有一个gate是主节点, 主节点退出之后另外一个gate接管
+func Test_RunLeader(t *testing.T) {
+	var running int32
+	job := func(ctx context.Context) {
+		atomic.AddInt32(&running, 1)
+		<-ctx.Done()
+		atomic.AddInt32(&running, -1)
+	}
+
+	gates := []*Gate{testInitEtcdGate(t), testInitEtcdGate(t)}
+	for _, g := range gates {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+		go g.runLeader(job)
+	}
+
+	leader := func() int {
+		for i, g := range gates {
+			if g.isLeader() {
+				return i
+			}
+		}
+		return -1
+	}
+
+	assert.Eventually(t, func() bool { return leader() != -1 }, 5*time.Second, 10*time.Millisecond)
+	first := leader()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&running))
+	assert.False(t, gates[1-first].isLeader())
+
+	// 退出时先停掉任务再放弃主节点身份, 另外一个gate不用等租约过期
+	gates[first].cancel()
+
+	assert.Eventually(t, func() bool { return leader() == 1-first }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 1 }, time.Second, 10*time.Millisecond)
+
+	gates[1-first].cancel()
+	assert.Eventually(t, func() bool { return leader() == -1 && atomic.LoadInt32(&running) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_LeaderTTL(t *testing.T) {
+	for _, tc := range []struct {
+		ttl  time.Duration
+		want int
+	}{
+		{0, defaultLeaderTTL},
+		{500 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{10 * time.Second, 10},
+	} {
+		g := &Gate{LeaderTTL: tc.ttl}
+		assert.Equal(t, tc.want, g.leaderTTL(), tc.ttl)
+	}
+}
+
+// 此函数依赖etcd是否存在
+// 主节点的写操作带上选举的条件, 失去主节点身份之后写不进去
+func Test_LeaderFence(t *testing.T) {
+	g := testInitEtcdGate(t)
+	assert.Nil(t, g.leaderFence())
+
+	s, err := concurrency.NewSession(defautlClient, concurrency.WithTTL(5))
+	assert.NoError(t, err)
+	defer s.Close()
+
+	e := concurrency.NewElection(s, model.GateLeaderPrefix+"/"+uuid.New().String())
+	assert.NoError(t, e.Campaign(context.Background(), g.Name))
+	g.setElection(e)
+
+	key := uuid.New().String()
+	defer defaultKVC.Delete(context.Background(), key)
+	put := func() bool {
+		rsp, err := defaultKVC.Txn(context.Background()).If(g.leaderFence()...).Then(clientv3.OpPut(key, "v")).Commit()
+		assert.NoError(t, err)
+		return rsp.Succeeded
+	}
+
+	fence := g.leaderFence()
+	assert.True(t, put())
+	assert.NoError(t, e.Resign(context.Background()))
+
+	rsp, err := defaultKVC.Txn(context.Background()).If(fence...).Then(clientv3.OpPut(key, "v")).Commit()
+	assert.NoError(t, err)
+	assert.False(t, rsp.Succeeded)
+}
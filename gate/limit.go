@@ -0,0 +1,56 @@
+package gate
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 配置成0表示不限制, 和http.Server的语义一致
+func (r *Gate) newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           r.longLived(handler),
+		ReadHeaderTimeout: r.ReadHeaderTimeout,
+		ReadTimeout:       r.ReadTimeout,
+		WriteTimeout:      r.WriteTimeout,
+		IdleTimeout:       r.IdleTimeout,
+		MaxHeaderBytes:    r.MaxHeaderBytes,
+	}
+}
+
+// 长连接不受读写超时的限制
+// websocket的读超时由心跳控制, 状态推送没有结束时间, http.Server的超时会把它们断开
+// http.ResponseWriter本身没有SetReadDeadline, 需要用ResponseController去掉超时
+func (r *Gate) longLived(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == model.TASK_STREAM_URL || req.URL.Path == model.TASK_UI_WATCH_URL {
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(time.Time{}); err != nil {
+				r.Warn().Msgf("longLived:clear read deadline:%s", err)
+			}
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+				r.Warn().Msgf("longLived:clear write deadline:%s", err)
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 限制请求body的大小, 超过之后读body会报错, 由badRequest返回413
+func (r *Gate) limitBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r.MaxBodyBytes > 0 && c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, r.MaxBodyBytes)
+		}
+		c.Next()
+	}
+}
+
+// 是否是body超过限制的错误
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
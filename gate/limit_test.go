@@ -0,0 +1,78 @@
+package gate
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// task的请求body超过MaxBodyBytes返回413
+func Test_LimitBody(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		g := Gate{MaxBodyBytes: 64, StrictJSON: strict, Slog: slog.New(os.Stdout).SetLevel("error")}
+
+		router := gin.New()
+		router.POST(model.TASK_CREATE_URL, g.limitBody(), g.createTask)
+		router.POST(model.TASK_BATCH_URL, g.limitBody(), g.createBatch)
+
+		body := `{"executer":{"taskName":"` + strings.Repeat("a", 128) + `"}}`
+		for _, url := range []string{model.TASK_CREATE_URL, model.TASK_BATCH_URL} {
+			if url == model.TASK_BATCH_URL {
+				body = "[" + body + "]"
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, 413, w.Code, "strict:%t, url:%s, body:%s", strict, url, w.Body.String())
+		}
+	}
+}
+
+// 写超时只作用于普通接口, 状态推送的长连接不受影响
+func Test_NewServer_LongLived(t *testing.T) {
+	g := Gate{WriteTimeout: 100 * time.Millisecond, ReadTimeout: 100 * time.Millisecond}
+
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("b"))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(model.TASK_UI_WATCH_URL, slow)
+	mux.HandleFunc("/slow", slow)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := g.newServer(mux)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	get := func(path string) (string, error) {
+		rsp, err := http.Get("http://" + ln.Addr().String() + path)
+		if err != nil {
+			return "", err
+		}
+		defer rsp.Body.Close()
+		all, err := io.ReadAll(rsp.Body)
+		return string(all), err
+	}
+
+	body, err := get(model.TASK_UI_WATCH_URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", body)
+
+	body, err = get("/slow")
+	assert.True(t, err != nil || body != "ab", body)
+}
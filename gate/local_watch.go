@@ -1,21 +1,28 @@
 package gate
 
 import (
+	"context"
 	"encoding/json"
-	"strings"
 
 	"github.com/1whour/crab/model"
-	"github.com/1whour/crab/utils"
-	"github.com/gorilla/websocket"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-func (r *Gate) watchLocalRunq(req *model.Whoami, conn *websocket.Conn) {
+// 任务通过dispatch按runtime名找到长连接推送下去
+func (r *Gate) watchLocalRunq(ctx context.Context, req *model.Whoami) {
 	runtimeName := req.Name
 	// 生成本地队列的前缀
 	localPath := model.WatchLocalRuntimePrefix(runtimeName)
+	// 先把已经分配的任务同步给runtime, 再从同步时的revision之后开始watch
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if rev, err := r.resync(req); err != nil {
+		r.Warn().Msgf("gate.watchLocalRunq:resync runtime(%s) fail:%s\n", runtimeName, err)
+	} else if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+
 	// watch本地队列的任务
-	localTask := defautlClient.Watch(r.ctx, localPath, clientv3.WithPrefix())
+	localTask := defautlClient.Watch(ctx, localPath, opts...)
 
 	r.Debug().Msgf(">>> watch local:%s\n", localPath)
 	for ersp := range localTask {
@@ -45,6 +52,10 @@ func (r *Gate) watchLocalRunq(req *model.Whoami, conn *websocket.Conn) {
 			}
 
 			if len(rsp.Kvs) == 0 || len(rspState.Kvs) == 0 {
+				// 任务已经被删除, 本地队列里面是删除命令, 直接推送给runtime
+				if ev.IsCreate() || ev.IsModify() {
+					r.pushRemove(ev.Kv.Value, localKey, runtimeName)
+				}
 				continue
 			}
 
@@ -71,21 +82,21 @@ func (r *Gate) watchLocalRunq(req *model.Whoami, conn *websocket.Conn) {
 
 			switch {
 			case ev.IsCreate(), ev.IsModify():
+				// v2协议的runtime收到ack之后再改状态, 删除命令不等ack
+				if !param.IsRemove() && r.connNeedAck(runtimeName) {
+					r.dispatchWithAck(runtimeName, localKey, &param, state)
+					continue
+				}
+
 				// 如果是新建或者被修改过的，直接推送到客户端
 				// 成功的状态是model.Succeeded, 失败的状态是model.Failed
-				if err := utils.WriteMessageTimeout(conn, value, r.WriteTime); err != nil {
-					r.Warn().Msgf("gate.watchLocalRunq, WriteMessageTimeout :%s, runtimeName:%s bye bye, taskName(%s), timeout(%v)\n",
+				span := r.startDispatchSpan(&param, runtimeName)
+				err := r.dispatch(runtimeName, &param)
+				endSpan(span, err)
+				if err != nil {
+					r.Warn().Msgf("gate.watchLocalRunq, dispatch :%s, runtimeName:%s bye bye, taskName(%s), timeout(%v)\n",
 						err, runtimeName, taskName, r.WriteTime)
-					// 更新全局状态, 修改为失败标志
-					defaultStore.LockUnlock(r.ctx, taskName, func() error {
-						err := defaultStore.UpdateCallStateFailed(r.ctx, taskName)
-						if err != nil {
-							r.Error().Msgf("gate.watchLocalRunq, write failed ack fail %s, runtimeName:%s bye bye, taskName(%s)\n", err, runtimeName, taskName)
-							return err
-						}
-						r.delRuntimeNode(model.Whoami{Name: runtimeName, Lambda: strings.Contains(localKey, model.LambdaKey)})
-						return nil
-					})
+					r.dispatchFailed(runtimeName, localKey, taskName, 1)
 				}
 
 				if param.IsRemove() {
@@ -93,15 +104,7 @@ func (r *Gate) watchLocalRunq(req *model.Whoami, conn *websocket.Conn) {
 					defaultKVC.Delete(r.ctx, localKey)
 					defaultKVC.Delete(r.ctx, model.FullGlobalTaskState(taskName)) //删除本地队列
 				} else {
-					// 更新全局状态, 修改为成功标志
-					if err := defaultStore.LockUpdateCallStateSuccessed(r.ctx, taskName); err != nil {
-						r.Error().Msgf("gate.watchLocalRunq, write successed ack fail %s, runtimeName:%s taskName(%s)\n", err, runtimeName, taskName)
-
-					}
-
-					if err = r.statusTable.update(onlyParamToStatus(param, state)); err != nil {
-
-					}
+					r.dispatchSucceeded(runtimeName, &param, state, 1)
 				}
 			case ev.Type == clientv3.EventTypeDelete:
 				r.Debug().Msgf("delete global task:%s, state:%s\n", ev.Kv.Key, ev.Kv.Value)
@@ -109,3 +112,20 @@ func (r *Gate) watchLocalRunq(req *model.Whoami, conn *websocket.Conn) {
 		}
 	}
 }
+
+// 推送删除命令, 任务的全局数据已经在删除的事务里面清理掉了, 这里只需要清理本地队列
+func (r *Gate) pushRemove(value []byte, localKey string, runtimeName string) {
+	var param model.Param
+	if err := json.Unmarshal(value, &param); err != nil || !param.IsRemove() {
+		return
+	}
+
+	span := r.startDispatchSpan(&param, runtimeName)
+	err := r.dispatch(runtimeName, &param)
+	endSpan(span, err)
+	if err != nil {
+		r.Warn().Msgf("gate.pushRemove, dispatch :%s, runtimeName:%s, taskName(%s)\n", err, runtimeName, param.Executer.TaskName)
+	}
+
+	defaultKVC.Delete(r.ctx, localKey)
+}
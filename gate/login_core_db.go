@@ -2,8 +2,13 @@ package gate
 
 import (
 	"crypto/md5"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -15,6 +20,8 @@ var (
 type PageLogin struct {
 	Page
 	UserName string `form:"username"`
+	// 列表里面带上已经软删除的用户
+	IncludeDeleted bool `form:"include_deleted"`
 }
 
 type LoginTable struct {
@@ -31,7 +38,7 @@ type LoginCore struct {
 	gorm.Model
 	UserName string `gorm:"index:,unique;not null" json:"username" binding:"required"`
 	Email    string `gorm:"index:,unique" json:"email"`
-	Password string `gorm:"type:varchar(50)" json:"password" binding:"required"`
+	Password string `gorm:"type:varchar(100)" json:"password" binding:"required"`
 	Rule     string `gorm:"type:varchar(10)" json:"rule"`
 }
 
@@ -40,23 +47,147 @@ func newLoginTable(db *gorm.DB) *LoginTable {
 	return &LoginTable{DB: db}
 }
 
+// bcrypt生成的hash长度
+const bcryptHashLen = 60
+
+// 老版本保存的是md5, 只用来兼容老数据
 func md5sum(s string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
 }
 
-// 插入数据
-func (l *LoginTable) insert(login *LoginCore) error {
-	// 密码换成md5串
-	login.Password = fmt.Sprintf("%x", md5.Sum([]byte(login.Password)))
-	return l.DB.Create(login).Error
+// 老版本的密码是32位的md5串
+func isLegacyPassword(hash string) bool {
+	if len(hash) != md5.Size*2 {
+		return false
+	}
+
+	for i := 0; i < len(hash); i++ {
+		c := hash[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
 }
 
-// 查询数据
+// 新密码的长度限制, bcrypt只使用前72个字节
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72
+)
+
+// 用户名的最大长度
+const maxUserNameLen = 64
+
+// 注册前的检查, 错误信息带上出错的字段
+func checkRegister(lc *LoginCore) error {
+	lc.UserName = strings.TrimSpace(lc.UserName)
+	if lc.UserName == "" {
+		return errors.New("username is required")
+	}
+	if len(lc.UserName) > maxUserNameLen {
+		return fmt.Errorf("username is too long, the max length is %d", maxUserNameLen)
+	}
+
+	if lc.Password == "" {
+		return errors.New("password is required")
+	}
+	if len(lc.Password) < minPasswordLen || len(lc.Password) > maxPasswordLen {
+		return fmt.Errorf("the length of password must be in [%d, %d]", minPasswordLen, maxPasswordLen)
+	}
+	return nil
+}
+
+// 检查新密码, 不能太短, 也不能和旧密码一样
+func checkNewPassword(oldPassword, newPassword string) error {
+	if len(newPassword) < minPasswordLen || len(newPassword) > maxPasswordLen {
+		return fmt.Errorf("the length of the new password must be in [%d, %d]", minPasswordLen, maxPasswordLen)
+	}
+	if oldPassword == newPassword {
+		return errors.New("the new password is the same as the current one")
+	}
+	return nil
+}
+
+// 密码换成bcrypt串
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// 检查密码, legacy为true时表示数据库里面还是md5, 需要升级
+func checkPassword(hash, password string) (ok bool, legacy bool) {
+	if isLegacyPassword(hash) {
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(md5sum(password))) == 1, true
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, false
+}
+
+// 老版本的password字段只有varchar(50), 放不下bcrypt串, 启动时加宽
+func (l *LoginTable) migratePassword() error {
+	m := l.DB.Migrator()
+	if !m.HasTable(&LoginCore{}) {
+		return nil
+	}
+
+	cols, err := m.ColumnTypes(&LoginCore{})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cols {
+		if c.Name() != "password" {
+			continue
+		}
+
+		if n, ok := c.Length(); ok && n < bcryptHashLen {
+			return m.AlterColumn(&LoginCore{}, "Password")
+		}
+	}
+	return nil
+}
+
+// 用户名已经存在, 软删除的用户也算
+var errUserExists = errors.New("user already exists")
+
+// mysql唯一索引冲突的错误码
+const mysqlDuplicateEntry = 1062
+
+// 插入数据, 用户名重复时返回errUserExists
+func (l *LoginTable) insert(login *LoginCore) (err error) {
+	var count int64
+	if err = l.DB.Unscoped().Model(&LoginCore{}).Where("user_name = ?", login.UserName).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errUserExists
+	}
+
+	if login.Password, err = hashPassword(login.Password); err != nil {
+		return err
+	}
+
+	// 并发注册时由唯一索引兜底
+	err = l.DB.Create(login).Error
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == mysqlDuplicateEntry {
+		return errUserExists
+	}
+	return err
+}
+
+// 查询数据, 带上密码的hash, 由调用方用checkPassword验证
 func (l *LoginTable) queryNeedPassword(login LoginCore) (ld LoginCore, err error) {
-	err = l.DB.Model(&LoginCore{}).Select(column, "password").Where("user_name = ? AND password = ?", login.UserName, md5sum(login.Password)).First(&ld).Error
+	err = l.DB.Model(&LoginCore{}).Select(column, "password").Where("user_name = ?", login.UserName).First(&ld).Error
 	return
 }
 
+// 更新密码的hash, 升级老的md5密码时使用
+func (l *LoginTable) updatePassword(id uint, hash string) error {
+	return l.DB.Model(&LoginCore{}).Where("id = ?", id).Update("password", hash).Error
+}
+
 // 查询数据
 func (l *LoginTable) query(login LoginCore) (ld LoginCore, err error) {
 	err = l.DB.Model(&LoginCore{}).Select(column).Where("user_name = ?", login.UserName).First(&ld).Error
@@ -70,9 +201,28 @@ func (l *LoginTable) update(login *LoginCore) (err error) {
 	return
 }
 
-// 删除用户, 真删除
+// 删除用户, 软删除, 可以通过restore恢复
+// 软删除的用户登录和查询都查不到
 func (l *LoginTable) delete(login *LoginCore) error {
-	l.DB.Unscoped().Delete(login)
+	db := l.DB
+	// 没有带id时按用户名删除
+	if login.ID == 0 {
+		db = db.Where("user_name = ?", login.UserName)
+	}
+	return db.Delete(login).Error
+}
+
+// 恢复软删除的用户
+func (l *LoginTable) restore(userName string) error {
+	rv := l.DB.Unscoped().Model(&LoginCore{}).
+		Where("user_name = ? AND deleted_at IS NOT NULL", userName).
+		Update("deleted_at", nil)
+	if rv.Error != nil {
+		return rv.Error
+	}
+	if rv.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
@@ -82,6 +232,12 @@ func (l *LoginTable) queryAndPage(p PageLogin, needPassword bool) (rv []LoginCor
 	if needPassword {
 		c = columnWithPassword
 	}
+
+	db := l.DB
+	if p.IncludeDeleted {
+		db = db.Unscoped()
+		c = append(append([]string{}, c...), "deleted_at")
+	}
 	order := ""
 	if len(p.Sort) > 0 {
 		if p.Sort[0] == '-' {
@@ -94,17 +250,22 @@ func (l *LoginTable) queryAndPage(p PageLogin, needPassword bool) (rv []LoginCor
 		where["user_name"] = p.UserName
 	}
 
-	err = l.DB.Debug().Model(&LoginCore{}).
+	offset := 0
+	if p.Page.Page > 1 {
+		offset = (p.Page.Page - 1) * p.Limit
+	}
+	err = db.Debug().Model(&LoginCore{}).
 		Select(c).
 		Where(where).
 		Order(order).
-		Offset(p.Page.Page - 1).
+		Offset(offset).
 		Limit(p.Limit).Find(&rv).Error
 	if err != nil {
 		return
 	}
 
-	l.DB.Debug().Model(&LoginCore{}).Count(&count)
+	// 总数和列表用同样的过滤条件
+	err = db.Debug().Model(&LoginCore{}).Where(where).Count(&count).Error
 	return
 }
 
@@ -2,9 +2,15 @@ package gate
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -42,8 +48,12 @@ func Test_Login_Create(t *testing.T) {
 	assert.Error(t, err)
 
 	rv, err := login.queryNeedPassword(LoginCore{UserName: "guo", Password: "123"})
-	assert.Equal(t, LoginCore{Model: gorm.Model{ID: 1}, UserName: "guo", Email: "1@qq.com", Password: md5sum("123")}, rv)
 	assert.NoError(t, err)
+	ok, legacy := checkPassword(rv.Password, "123")
+	assert.True(t, ok)
+	assert.False(t, legacy)
+	rv.Password = ""
+	assert.Equal(t, LoginCore{Model: gorm.Model{ID: 1}, UserName: "guo", Email: "1@qq.com"}, rv)
 }
 
 // 测试删除，先插入，删除，查询没有为正确
@@ -63,6 +73,35 @@ func Test_Login_Delete(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// 此函数依赖mysql是否存在
+// 软删除之后登录查不到, 列表带上include_deleted可以看到, 恢复之后可以正常登录
+func Test_Login_SoftDeleteRestore(t *testing.T) {
+	login := testInitLoginTable(t)
+
+	assert.NoError(t, login.insert(&LoginCore{UserName: "guo", Password: "123", Email: "1@qq.com"}))
+	assert.NoError(t, login.delete(&LoginCore{UserName: "guo"}))
+
+	_, err := login.queryNeedPassword(LoginCore{UserName: "guo"})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	rv, _, err := login.queryAndPage(PageLogin{Page: Page{Page: 1, Limit: 10}}, false)
+	assert.NoError(t, err)
+	assert.Len(t, rv, 0)
+
+	rv, _, err = login.queryAndPage(PageLogin{Page: Page{Page: 1, Limit: 10}, IncludeDeleted: true}, false)
+	assert.NoError(t, err)
+	assert.Len(t, rv, 1)
+	assert.True(t, rv[0].DeletedAt.Valid)
+
+	assert.NoError(t, login.restore("guo"))
+	assert.ErrorIs(t, login.restore("guo"), gorm.ErrRecordNotFound)
+
+	rv2, err := login.queryNeedPassword(LoginCore{UserName: "guo"})
+	assert.NoError(t, err)
+	ok, _ := checkPassword(rv2.Password, "123")
+	assert.True(t, ok)
+}
+
 // 测试更新, 先插入，更新，查询数据是否符合预期
 func Test_Login_Update(t *testing.T) {
 	var err error
@@ -110,7 +149,6 @@ func Test_Login_GetList(t *testing.T) {
 	for i := 0; i < 15; i++ {
 		val := LoginCore{UserName: fmt.Sprintf("g%d", i), Email: fmt.Sprintf("%d@x.com", i), Password: "111111", Rule: "admin"}
 		err = login.insert(&val)
-		val.Password = md5sum(val.Password)
 		insertAll = append(insertAll, val)
 
 		assert.NoError(t, err)
@@ -124,3 +162,22 @@ func Test_Login_GetList(t *testing.T) {
 	}
 
 }
+
+// 此函数依赖mysql是否存在
+// 重复注册同一个用户名返回409
+func Test_Login_RegisterDuplicate(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error"), loginTable: testInitLoginTable(t)}
+
+	router := gin.New()
+	router.POST(model.UI_USER_REGISTER_URL, g.register)
+
+	for _, code := range []int{200, 409} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, model.UI_USER_REGISTER_URL, strings.NewReader(`{"username":"guo","password":"12345678"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+
+	assert.ErrorIs(t, g.loginTable.insert(&LoginCore{UserName: "guo", Password: "12345678"}), errUserExists)
+}
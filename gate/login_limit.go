@@ -0,0 +1,125 @@
+package gate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultLoginMaxFailures = 5
+	defaultLoginFailWindow  = time.Minute
+	defaultLoginLockout     = time.Minute
+	// 锁定时间翻倍的上限
+	maxLoginLockout = time.Hour
+)
+
+// 登录失败的限流, 按ip和用户名分别计数
+// 每个key是一个容量为maxFailures的桶, 每次失败取走一个令牌, 窗口过去之后桶重新装满
+// 桶空了之后锁定一段时间, 连续被锁定时锁定时间翻倍, 登录成功之后清零
+type loginLimiter struct {
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+
+	entries sync.Map // key是ip或者用户名, value是*loginAttempt
+	// 上一次清理过期key的时间, 防止换ip攻击时内存一直增长
+	lastPrune atomic.Int64
+}
+
+type loginAttempt struct {
+	mu sync.Mutex
+	// 当前窗口的失败次数和窗口开始时间
+	failures    int
+	windowStart time.Time
+	// 连续锁定的次数
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+func newLoginLimiter(maxFailures int, window, lockout time.Duration) *loginLimiter {
+	if maxFailures <= 0 {
+		maxFailures = defaultLoginMaxFailures
+	}
+	if window <= 0 {
+		window = defaultLoginFailWindow
+	}
+	if lockout <= 0 {
+		lockout = defaultLoginLockout
+	}
+	return &loginLimiter{maxFailures: maxFailures, window: window, lockout: lockout}
+}
+
+// 被锁定时返回还需要等待的时间
+func (l *loginLimiter) locked(key string, now time.Time) (time.Duration, bool) {
+	v, ok := l.entries.Load(key)
+	if !ok {
+		return 0, false
+	}
+
+	a := v.(*loginAttempt)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now), true
+	}
+	return 0, false
+}
+
+// 记录一次失败, 失败次数到了上限就锁定
+func (l *loginLimiter) fail(key string, now time.Time) {
+	v, _ := l.entries.LoadOrStore(key, &loginAttempt{windowStart: now})
+	a := v.(*loginAttempt)
+
+	a.mu.Lock()
+	// 很久没有失败过, 之前的锁定不再累计
+	if !a.lastFailure.IsZero() && now.Sub(a.lastFailure) > maxLoginLockout {
+		a.lockouts = 0
+	}
+	if now.Sub(a.windowStart) > l.window {
+		a.failures, a.windowStart = 0, now
+	}
+
+	a.lastFailure = now
+	if a.failures++; a.failures >= l.maxFailures {
+		lockout := l.lockout
+		for i := 0; i < a.lockouts && lockout < maxLoginLockout; i++ {
+			lockout *= 2
+		}
+		if lockout > maxLoginLockout {
+			lockout = maxLoginLockout
+		}
+
+		a.lockedUntil = now.Add(lockout)
+		a.lockouts++
+		a.failures, a.windowStart = 0, now
+	}
+	a.mu.Unlock()
+
+	l.prune(now)
+}
+
+// 登录成功, 清空计数
+func (l *loginLimiter) reset(key string) {
+	l.entries.Delete(key)
+}
+
+// 最多每个窗口清理一次已经过期的key
+func (l *loginLimiter) prune(now time.Time) {
+	last := l.lastPrune.Load()
+	if now.Sub(time.Unix(0, last)) < l.window || !l.lastPrune.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	l.entries.Range(func(key, v any) bool {
+		a := v.(*loginAttempt)
+		a.mu.Lock()
+		expired := now.After(a.lockedUntil) && now.Sub(a.lastFailure) > maxLoginLockout
+		a.mu.Unlock()
+		if expired {
+			l.entries.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,60 @@
+package gate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 窗口内失败次数到了上限之后锁定, 连续锁定时间翻倍, 成功之后清零
+func Test_LoginLimiter(t *testing.T) {
+	l := newLoginLimiter(3, time.Minute, time.Minute)
+	now := time.Now()
+	key := "ip:127.0.0.1"
+
+	for i := 0; i < 2; i++ {
+		l.fail(key, now)
+	}
+	_, locked := l.locked(key, now)
+	assert.False(t, locked)
+
+	// 第3次失败之后锁定1分钟
+	l.fail(key, now)
+	wait, locked := l.locked(key, now)
+	assert.True(t, locked)
+	assert.Equal(t, time.Minute, wait)
+
+	// 其他的key不受影响
+	_, locked = l.locked("user:guest", now)
+	assert.False(t, locked)
+
+	// 解锁之后再错3次, 锁定时间翻倍
+	now = now.Add(time.Minute + time.Second)
+	_, locked = l.locked(key, now)
+	assert.False(t, locked)
+	for i := 0; i < 3; i++ {
+		l.fail(key, now)
+	}
+	wait, _ = l.locked(key, now)
+	assert.Equal(t, 2*time.Minute, wait)
+
+	// 登录成功之后清零
+	l.reset(key)
+	_, locked = l.locked(key, now)
+	assert.False(t, locked)
+	for i := 0; i < 3; i++ {
+		l.fail(key, now)
+	}
+	wait, _ = l.locked(key, now)
+	assert.Equal(t, time.Minute, wait)
+
+	// 窗口过去之后失败次数重新计算
+	l.reset(key)
+	l.fail(key, now)
+	l.fail(key, now)
+	now = now.Add(2 * time.Minute)
+	l.fail(key, now)
+	_, locked = l.locked(key, now)
+	assert.False(t, locked)
+}
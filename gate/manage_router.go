@@ -1,13 +1,19 @@
 package gate
 
 import (
+	"errors"
+	"math"
+	"strconv"
 	"time"
 
+	"github.com/1whour/crab/model"
 	"github.com/antlabs/deepcopy"
 	"github.com/gin-gonic/gin"
-	"github.com/guonaihong/gutil/jwt"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
 )
 
+// 没有配置jwt-secret和jwt-issuer时使用
 const (
 	secretToken = "@@112233"
 	serverName  = "crab"
@@ -22,7 +28,9 @@ type userInfoData struct {
 }
 
 type wrapToken struct {
-	Token string `json:"token"`
+	Token     string    `json:"token"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type wrapData struct {
@@ -33,23 +41,47 @@ type wrapData struct {
 
 type userList struct {
 	Total int64 `json:"total"`
-	Items any   `json:"items"`
+	// 实际使用的页码和单页大小, 只有用户列表返回
+	Page  int `json:"page,omitempty"`
+	Limit int `json:"limit,omitempty"`
+	Items any `json:"items"`
+}
+
+// 注册成功之后的响应, 不返回密码的hash
+type registerRsp struct {
+	ID       uint   `json:"id"`
+	UserName string `json:"username"`
 }
 
 // 注册账号
 func (g *Gate) register(c *gin.Context) {
 	lc := LoginCore{}
+	// 必填字段在checkRegister里面检查, 错误信息更清楚
 	if err := c.ShouldBindJSON(&lc); err != nil {
-		g.error2(c, 500, err.Error())
+		var verr validator.ValidationErrors
+		if !errors.As(err, &verr) {
+			g.error(c, model.ErrValidation, "register:%s", err)
+			return
+		}
+	}
+
+	// 注册接口不需要登录, 角色只能由管理员修改, 不能自己注册成管理员
+	lc.Rule = ""
+	if err := checkRegister(&lc); err != nil {
+		g.error(c, model.ErrValidation, "register:%s", err)
 		return
 	}
 
-	g.Debug().Msgf("register info :%v", lc)
+	g.Debug().Msgf("register user:%s", lc.UserName)
 	if err := g.loginTable.insert(&lc); err != nil {
-		g.error2(c, 500, err.Error())
+		if errors.Is(err, errUserExists) {
+			g.error(c, model.ErrConflict, "register:user(%s) already exists", lc.UserName)
+			return
+		}
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
-	c.JSON(200, wrapData{Data: lc})
+	c.JSON(200, wrapData{Data: registerRsp{ID: lc.ID, UserName: lc.UserName}})
 }
 
 // 登录
@@ -57,32 +89,123 @@ func (g *Gate) login(c *gin.Context) {
 	lc := LoginCore{}
 
 	if err := c.ShouldBindJSON(&lc); err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
 		return
 	}
 
+	// 同一个ip或者同一个用户连续失败太多次, 在比较密码之前直接拒绝
+	keys := [...]string{"ip:" + c.ClientIP(), "user:" + lc.UserName}
+	now := time.Now()
+	for _, key := range keys {
+		if wait, locked := g.loginLimit.locked(key, now); locked {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			g.error(c, model.ErrTooManyRequests, "too many failed login attempts, retry after %s", wait.Round(time.Second))
+			return
+		}
+	}
+
 	rv, err := g.loginTable.queryNeedPassword(lc)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			for _, key := range keys {
+				g.loginLimit.fail(key, now)
+			}
+			g.error(c, model.ErrUnauthorized, "wrong account")
+			return
+		}
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
 
-	if rv.UserName != lc.UserName || rv.Password != md5sum(lc.Password) {
-		g.Error().Msgf("rv.UserName:(%s):req.UserName(%s), rv.Password:(%s), md5sum(%s)", rv.UserName, lc.UserName,
-			rv.Password, md5sum(lc.Password))
-		g.error(c, 500, "wrong account")
+	ok, legacy := checkPassword(rv.Password, lc.Password)
+	if rv.UserName != lc.UserName || !ok {
+		for _, key := range keys {
+			g.loginLimit.fail(key, now)
+		}
+		g.Error().Msgf("rv.UserName:(%s):req.UserName(%s), wrong password", rv.UserName, lc.UserName)
+		g.error(c, model.ErrUnauthorized, "wrong account")
 		return
 	}
 
-	token, err := jwt.GenToken(time.Hour*24, lc.UserName, secretToken)
+	for _, key := range keys {
+		g.loginLimit.reset(key)
+	}
+
+	// 老的md5密码, 登录成功之后换成bcrypt
+	if legacy {
+		if hash, err := hashPassword(lc.Password); err != nil {
+			g.Warn().Msgf("login:hash password of %s:%s", lc.UserName, err)
+		} else if err = g.loginTable.updatePassword(rv.ID, hash); err != nil {
+			g.Warn().Msgf("login:upgrade password of %s:%s", lc.UserName, err)
+		}
+	}
+
+	token, err := g.genToken(lc.UserName)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrInternal, "%s", err)
 		return
 	}
 
 	//c.Header("token", token)
 	c.JSON(200, wrapData{
-		Data: wrapToken{token},
+		Data: token,
+	})
+}
+
+type changePassword struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// 修改当前登录用户的密码, 需要校验旧密码
+// token是无状态的, 修改之后旧的token在过期前还能用, 这里返回一个新的token给客户端替换
+func (g *Gate) changePassword(c *gin.Context) {
+	var req changePassword
+	if err := c.ShouldBindJSON(&req); err != nil {
+		g.error(c, model.ErrValidation, "changePassword:%s", err)
+		return
+	}
+
+	if err := checkNewPassword(req.OldPassword, req.NewPassword); err != nil {
+		g.error(c, model.ErrValidation, "changePassword:%s", err)
+		return
+	}
+
+	userName := c.GetString(userNameKey)
+	rv, err := g.loginTable.queryNeedPassword(LoginCore{UserName: userName})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			g.error(c, model.ErrNotFound, "changePassword:user(%s) not found", userName)
+			return
+		}
+		g.error(c, model.ErrDatabase, "changePassword:%s", err)
+		return
+	}
+
+	if ok, _ := checkPassword(rv.Password, req.OldPassword); !ok {
+		g.error(c, model.ErrForbidden, "changePassword:wrong password")
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		g.error(c, model.ErrInternal, "changePassword:%s", err)
+		return
+	}
+
+	if err = g.loginTable.updatePassword(rv.ID, hash); err != nil {
+		g.error(c, model.ErrDatabase, "changePassword:%s", err)
+		return
+	}
+
+	token, err := g.genToken(userName)
+	if err != nil {
+		g.error(c, model.ErrInternal, "%s", err)
+		return
+	}
+
+	c.JSON(200, wrapData{
+		Data: token,
 	})
 }
 
@@ -90,18 +213,59 @@ func (g *Gate) logout(c *gin.Context) {
 	c.JSON(200, wrapData{})
 }
 
+// 修改用户信息, 普通用户只能修改自己, 不能修改角色, 管理员可以修改所有用户
 func (g *Gate) updateUser(c *gin.Context) {
 
 	lc := LoginCore{}
 
 	err := c.ShouldBindJSON(&lc)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
+		return
+	}
+
+	me, admin, err := g.currentUser(c)
+	if err != nil {
+		g.error(c, model.ErrDatabase, "updateUser:%s", err)
 		return
 	}
 
-	lc.Password = md5sum(lc.Password)
-	g.loginTable.update(&lc)
+	if !admin {
+		if lc.UserName != me.UserName || lc.ID != 0 && lc.ID != me.ID {
+			g.error(c, model.ErrForbidden, "updateUser:user(%s) can only update itself", me.UserName)
+			return
+		}
+		if lc.Rule != "" && lc.Rule != me.Rule {
+			g.error(c, model.ErrForbidden, "updateUser:only admin can change the rule")
+			return
+		}
+		lc.ID, lc.Rule = me.ID, ""
+	}
+
+	// 没有带id时按用户名找
+	if lc.ID == 0 {
+		rv, err := g.loginTable.query(LoginCore{UserName: lc.UserName})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				g.error(c, model.ErrNotFound, "updateUser:user(%s) not found", lc.UserName)
+				return
+			}
+			g.error(c, model.ErrDatabase, "updateUser:%s", err)
+			return
+		}
+		lc.ID = rv.ID
+	}
+
+	if lc.Password != "" {
+		if lc.Password, err = hashPassword(lc.Password); err != nil {
+			g.error(c, model.ErrInternal, "%s", err)
+			return
+		}
+	}
+	if err = g.loginTable.update(&lc); err != nil {
+		g.error(c, model.ErrDatabase, "updateUser:%s", err)
+		return
+	}
 	c.JSON(200, wrapData{})
 }
 
@@ -112,7 +276,7 @@ func (g *Gate) deleteUser(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&lc)
 	if err != nil {
-		g.error2(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
 		return
 	}
 
@@ -120,24 +284,49 @@ func (g *Gate) deleteUser(c *gin.Context) {
 
 	lc2 := LoginCore{}
 	deepcopy.Copy(&lc2, &lc).Do()
-	g.loginTable.delete(&lc2)
+	if err = g.loginTable.delete(&lc2); err != nil {
+		g.error(c, model.ErrDatabase, "%s", err)
+		return
+	}
+	c.JSON(200, wrapData{})
+}
+
+type restoreUser struct {
+	UserName string `json:"username" binding:"required"`
+}
+
+// 恢复软删除的用户
+func (g *Gate) restoreUser(c *gin.Context) {
+	var req restoreUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		g.error(c, model.ErrValidation, "restoreUser:%s", err)
+		return
+	}
+
+	if err := g.loginTable.restore(req.UserName); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			g.error(c, model.ErrNotFound, "restoreUser:deleted user(%s) not found", req.UserName)
+			return
+		}
+		g.error(c, model.ErrDatabase, "restoreUser:%s", err)
+		return
+	}
 	c.JSON(200, wrapData{})
 }
 
 // 获取用户信息
 func (g *Gate) getUserInfo(c *gin.Context) {
 
-	val, err := jwt.ParseToken(c.Request.Header.Get(tokenHeader), secretToken)
+	userName, err := g.parseToken(getToken(c))
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrUnauthorized, "%s", err)
 		return
 	}
 
-	g.Debug().Msgf("token:%#v", val)
-	lc := LoginCore{UserName: val.Issuer}
+	lc := LoginCore{UserName: userName}
 	rv, err := g.loginTable.query(lc)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
 	c.JSON(200, wrapData{
@@ -147,27 +336,59 @@ func (g *Gate) getUserInfo(c *gin.Context) {
 	)
 }
 
+const (
+	defaultUserLimit    = 10
+	defaultMaxUserLimit = 100
+)
+
+// 没有传limit时用默认值, 超过上限时按上限返回
+func (g *Gate) userLimit(limit int) int {
+	def, max := g.UserLimit, g.MaxUserLimit
+	if def <= 0 {
+		def = defaultUserLimit
+	}
+	if max <= 0 {
+		max = defaultMaxUserLimit
+	}
+
+	if limit <= 0 {
+		limit = def
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit
+}
+
 // 获取用户信息列表
 func (g *Gate) GetUserInfoList(c *gin.Context) {
 	p := PageLogin{}
 	if err := c.ShouldBindQuery(&p); err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
 		return
 	}
 
-	// 默认10
-	if p.Limit == 0 {
-		p.Limit = 10
+	// 页码从1开始, 没有传时是第一页
+	if _, ok := c.GetQuery("page"); ok && p.Page.Page < 1 {
+		g.error(c, model.ErrValidation, "page must be >= 1, got %d", p.Page.Page)
+		return
+	}
+	if p.Page.Page == 0 {
+		p.Page.Page = 1
 	}
+	p.Limit = g.userLimit(p.Limit)
 
-	rv, count, err := g.loginTable.queryAndPage(p, true)
+	// 列表里面不返回密码的hash
+	rv, count, err := g.loginTable.queryAndPage(p, false)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
 
 	c.JSON(200, wrapData{Data: userList{
 		Total: count,
+		Page:  p.Page.Page,
+		Limit: p.Limit,
 		Items: rv,
 	}})
 }
@@ -0,0 +1,86 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 注册时缺少用户名或者密码返回400, 错误信息带上字段名
+func Test_Register_Validate(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error")}
+
+	router := gin.New()
+	router.POST(model.UI_USER_REGISTER_URL, g.register)
+
+	for _, tc := range []struct {
+		body    string
+		message string
+	}{
+		{`{"password":"12345678"}`, "username is required"},
+		{`{"username":"  ","password":"12345678"}`, "username is required"},
+		{`{"username":"guo"}`, "password is required"},
+		{`{"username":"guo","password":"123"}`, "the length of password"},
+		{`{"username":`, "register:"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, model.UI_USER_REGISTER_URL, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, tc.body)
+
+		var rsp wrapData
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		assert.Contains(t, rsp.Message, tc.message, tc.body)
+	}
+
+	lc := LoginCore{UserName: " guo ", Password: "12345678"}
+	assert.NoError(t, checkRegister(&lc))
+	assert.Equal(t, "guo", lc.UserName)
+}
+
+func Test_UserLimit(t *testing.T) {
+	g := Gate{}
+	for _, tc := range []struct {
+		limit int
+		want  int
+	}{
+		{0, defaultUserLimit},
+		{-1, defaultUserLimit},
+		{20, 20},
+		{1000000, defaultMaxUserLimit},
+	} {
+		assert.Equal(t, tc.want, g.userLimit(tc.limit), tc.limit)
+	}
+
+	g.UserLimit, g.MaxUserLimit = 5, 50
+	assert.Equal(t, 5, g.userLimit(0))
+	assert.Equal(t, 50, g.userLimit(1000000))
+}
+
+// 页码小于1时返回400, 不会查数据库
+func Test_UserInfoList_InvalidPage(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error")}
+
+	router := gin.New()
+	router.GET(model.UI_USERS_INFO_LIST, g.GetUserInfoList)
+
+	for _, page := range []string{"0", "-1"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, model.UI_USERS_INFO_LIST+"?page="+page, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, page)
+
+		var rsp wrapData
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		assert.Contains(t, rsp.Message, "page must be >= 1", page)
+	}
+}
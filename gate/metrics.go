@@ -0,0 +1,181 @@
+package gate
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	metricsNamespace = "crab"
+	// 统计etcd里面的任务个数的超时时间
+	metricsEtcdTimeout = time.Second
+)
+
+// 需要统计调用次数的task接口, key是handler的函数名
+var taskOps = map[string]bool{
+	"createTask":   true,
+	"createBatch":  true,
+	"deleteTask":   true,
+	"updateTask":   true,
+	"stopTask":     true,
+	"continueTask": true,
+}
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	// 出站http调用排队的个数
+	outboundQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "outbound_queue_depth",
+		Help:      "Number of outbound http calls waiting for a worker.",
+	})
+
+	// 队列满了被丢弃的出站http调用
+	outboundDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "outbound_dropped_total",
+		Help:      "Number of outbound http calls dropped because the queue is full.",
+	})
+
+	// 超过MaxRuntimeConns被拒绝的连接
+	runtimeConnRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "runtime_conn_rejected_total",
+		Help:      "Number of runtime connections rejected because of max-runtime-conns.",
+	})
+
+	// 长连接相关的goroutine里面恢复的panic
+	streamPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "stream_panics_total",
+		Help:      "Number of panics recovered in the runtime stream goroutines.",
+	})
+
+	// task接口的调用次数
+	taskRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "task_requests_total",
+		Help:      "Number of task api calls.",
+	}, []string{"op"})
+
+	// task接口的出错次数
+	taskErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gate",
+		Name:      "task_errors_total",
+		Help:      "Number of failed task api calls.",
+	}, []string{"op"})
+)
+
+// 注册指标, SubMain里面调用
+func (r *Gate) registerMetrics() {
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		outboundQueueDepth,
+		outboundDropped,
+		runtimeConnRejected,
+		streamPanics,
+		taskRequests,
+		taskErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "gate",
+			Name:      "connected_runtimes",
+			Help:      "Number of runtimes connected to this gate.",
+		}, r.connectedRuntimes),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "gate",
+			Name:      "runtime_conns",
+			Help:      "Number of open runtime websocket connections, including the ones not identified yet.",
+		}, func() float64 { return float64(atomic.LoadInt32(&r.runtimeCount)) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "gate",
+			Name:      "tasks",
+			Help:      "Number of tasks in etcd.",
+		}, r.taskCount),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "gate",
+			Name:      "leader",
+			Help:      "1 if this gate is the leader, otherwise 0.",
+		}, func() float64 {
+			if r.isLeader() {
+				return 1
+			}
+			return 0
+		}),
+	}
+
+	for _, c := range collectors {
+		if err := metricsRegistry.Register(c); err != nil {
+			r.Warn().Msgf("gate:register metrics:%s", err)
+		}
+	}
+}
+
+// 连接到本gate的runtime个数
+func (r *Gate) connectedRuntimes() float64 {
+	n := 0
+	r.conns.Range(func(key, value any) bool {
+		n++
+		return true
+	})
+	return float64(n)
+}
+
+// etcd里面的任务个数
+func (r *Gate) taskCount() float64 {
+	ctx, cancel := context.WithTimeout(r.ctx, metricsEtcdTimeout)
+	defer cancel()
+
+	rsp, err := defaultKVC.Get(ctx, model.GlobalTaskPrefix+"/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		r.Warn().Msgf("gate:metrics task count:%s", err)
+		return 0
+	}
+	return float64(rsp.Count)
+}
+
+// 从handler名里面取出方法名, github.com/1whour/crab/gate.(*Gate).createTask-fm -> createTask
+func handlerOp(c *gin.Context) string {
+	name := c.HandlerName()
+	if pos := strings.LastIndex(name, "."); pos != -1 {
+		name = name[pos+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// 统计task接口的调用次数, ok/error系列函数里面调用
+func countTaskRequest(c *gin.Context, failed bool) {
+	op := handlerOp(c)
+	if !taskOps[op] {
+		return
+	}
+
+	taskRequests.WithLabelValues(op).Inc()
+	if failed {
+		taskErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// prometheus的拉取接口
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
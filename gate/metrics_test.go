@@ -0,0 +1,35 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// task接口出错时, 调用次数和出错次数都会增加
+func Test_CountTaskRequest(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled")}
+
+	router := gin.New()
+	router.DELETE(model.TASK_DELETE_URL, g.deleteTask)
+
+	requests := testutil.ToFloat64(taskRequests.WithLabelValues("deleteTask"))
+	errors := testutil.ToFloat64(taskErrors.WithLabelValues("deleteTask"))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, model.TASK_DELETE_URL, strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	assert.Equal(t, requests+1, testutil.ToFloat64(taskRequests.WithLabelValues("deleteTask")))
+	assert.Equal(t, errors+1, testutil.ToFloat64(taskErrors.WithLabelValues("deleteTask")))
+}
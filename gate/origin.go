@@ -0,0 +1,47 @@
+package gate
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	"github.com/gorilla/websocket"
+)
+
+// websocket升级时检查Origin
+// 1.没有Origin的请求不是浏览器发起的(比如runtime), 直接放行
+// 2.同源的请求放行
+// 3.跨域的请求只有在AllowedOrigins里面才放行, *表示放行所有
+func (r *Gate) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+
+	for _, allowed := range r.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || strings.EqualFold(allowed, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// 每个gate自己的upgrader, 子协议用来和runtime协商消息格式的版本
+func (r *Gate) newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{CheckOrigin: r.checkOrigin, EnableCompression: r.WsCompression, Subprotocols: model.StreamProtocols}
+}
+
+// 同时配置了证书和私钥才开启tls
+func (r *Gate) tlsEnabled() bool {
+	return r.CertFile != "" && r.KeyFile != ""
+}
@@ -0,0 +1,36 @@
+package gate
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试用的websocket服务端
+var testUpgrader = websocket.Upgrader{}
+
+// 默认只允许同源和没有Origin的请求
+func Test_CheckOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{nil, "", true},
+		{nil, "http://gate.example.com", true},
+		{nil, "http://evil.example.com", false},
+		{[]string{"http://ui.example.com"}, "http://ui.example.com", true},
+		{[]string{"ui.example.com"}, "https://ui.example.com", true},
+		{[]string{"http://ui.example.com"}, "http://evil.example.com", false},
+		{[]string{"*"}, "http://evil.example.com", true},
+	} {
+		g := Gate{AllowedOrigins: tc.allowed}
+		req := httptest.NewRequest("GET", "http://gate.example.com/crab/task/stream", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		assert.Equal(t, tc.ok, g.checkOrigin(req), tc.origin)
+	}
+}
@@ -0,0 +1,55 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 出站http调用的协程池, 所有的调用共用一个http.Client
+// 限制并发数, 防止大量任务同时结束时把fd耗尽, 也保护下游
+type outboundPool struct {
+	jobs   chan func()
+	client *http.Client
+}
+
+func newOutboundPool(workers, queueSize int, timeout time.Duration) *outboundPool {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = workers
+	transport.MaxConnsPerHost = workers
+
+	return &outboundPool{
+		jobs:   make(chan func(), queueSize),
+		client: &http.Client{Transport: transport, Timeout: timeout},
+	}
+}
+
+// 启动workers个协程
+func (p *outboundPool) start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-p.jobs:
+					outboundQueueDepth.Dec()
+					job()
+				}
+			}
+		}()
+	}
+}
+
+// 提交任务, 队列满了直接丢弃, 返回false
+func (p *outboundPool) submit(job func()) bool {
+	outboundQueueDepth.Inc()
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		outboundQueueDepth.Dec()
+		outboundDropped.Inc()
+		return false
+	}
+}
@@ -0,0 +1,30 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// 队列满了之后丢弃, 并且记录到指标里面
+func Test_OutboundPool_Drop(t *testing.T) {
+	p := newOutboundPool(1, 1, time.Second)
+	dropped := testutil.ToFloat64(outboundDropped)
+	depth := testutil.ToFloat64(outboundQueueDepth)
+
+	assert.True(t, p.submit(func() {}))
+	assert.False(t, p.submit(func() {}))
+	assert.Equal(t, dropped+1, testutil.ToFloat64(outboundDropped))
+	assert.Equal(t, depth+1, testutil.ToFloat64(outboundQueueDepth))
+
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.start(ctx, 1)
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(outboundQueueDepth) == depth }, time.Second, time.Millisecond)
+	assert.True(t, p.submit(func() { close(done) }))
+	<-done
+}
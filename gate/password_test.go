@@ -0,0 +1,42 @@
+package gate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bcrypt和老的md5密码都能校验, md5的需要升级
+func Test_CheckPassword(t *testing.T) {
+	hash, err := hashPassword("123")
+	assert.NoError(t, err)
+	assert.Len(t, hash, bcryptHashLen)
+	assert.False(t, isLegacyPassword(hash))
+
+	ok, legacy := checkPassword(hash, "123")
+	assert.True(t, ok)
+	assert.False(t, legacy)
+
+	ok, _ = checkPassword(hash, "1234")
+	assert.False(t, ok)
+
+	old := md5sum("123")
+	assert.True(t, isLegacyPassword(old))
+
+	ok, legacy = checkPassword(old, "123")
+	assert.True(t, ok)
+	assert.True(t, legacy)
+
+	ok, legacy = checkPassword(old, "1234")
+	assert.False(t, ok)
+	assert.True(t, legacy)
+}
+
+// 新密码太短, 太长或者和旧密码一样都不允许
+func Test_CheckNewPassword(t *testing.T) {
+	assert.NoError(t, checkNewPassword("12345678", "87654321"))
+	assert.Error(t, checkNewPassword("12345678", "1234567"))
+	assert.Error(t, checkNewPassword("12345678", strings.Repeat("a", maxPasswordLen+1)))
+	assert.Error(t, checkNewPassword("12345678", "12345678"))
+}
@@ -0,0 +1,61 @@
+package gate
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval = 5 * time.Second
+	// 连续这么多个ping周期没有收到pong, 认为连接已经半开, 主动断开
+	pongWaitPings = 3
+)
+
+// 协议层的ping/pong保活, 和json心跳分开
+// pong在读循环里面处理, 所以pong handler要在读之前设置, 这里只负责发ping和检查pong
+func (r *Gate) pingLoop(c *runtimeConn, lastPong *atomic.Int64, done <-chan struct{}) {
+	interval := r.pingInterval()
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		if since := time.Since(time.Unix(0, lastPong.Load())); since > interval*pongWaitPings {
+			r.Warn().Msgf("gate.pingLoop:no pong for %s, close the connection", since)
+			c.conn.Close()
+			return
+		}
+
+		// WriteControl可以和其他的写并发调用
+		if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(r.WriteTime)); err != nil {
+			r.Warn().Msgf("gate.pingLoop:write ping:%s", err)
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (r *Gate) pingInterval() time.Duration {
+	if r.PingInterval <= 0 {
+		return defaultPingInterval
+	}
+	return r.PingInterval
+}
+
+// 收到pong时记录时间
+func setPongHandler(conn *websocket.Conn, lastPong *atomic.Int64) {
+	lastPong.Store(time.Now().UnixNano())
+	conn.SetPongHandler(func(string) error {
+		lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+}
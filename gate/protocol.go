@@ -0,0 +1,29 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gorilla/websocket"
+)
+
+// 升级之后检查协商的子协议
+// runtime没有带子协议说明是老版本, 按v1处理; 带了子协议却一个都不支持, 用close帧拒绝
+func (r *Gate) negotiateProtocol(con *websocket.Conn, offered []string) (string, bool) {
+	if con.Subprotocol() != "" || len(offered) == 0 {
+		return model.StreamProtocol(con.Subprotocol()), true
+	}
+
+	reason := fmt.Sprintf("unsupported protocol %s, supported:%s", strings.Join(offered, ","), strings.Join(model.StreamProtocols, ","))
+	// close帧的reason最多123个字节
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseProtocolError, reason)
+	if err := con.WriteControl(websocket.CloseMessage, msg, time.Now().Add(r.WriteTime)); err != nil {
+		r.Warn().Msgf("gate.stream:write close:%s", err)
+	}
+	return "", false
+}
@@ -1,55 +1,132 @@
 package gate
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/1whour/crab/model"
 	"github.com/1whour/crab/utils"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-func (r *Gate) autoNewAddrAndRegister() {
-	r.autoNewAddr()
-	_, err := defautlClient.Revoke(r.ctx, r.leaseID)
-	if err != nil {
-		r.Error().Msgf("revoke leaseID:%d %v\n", r.leaseID, err)
-		return
+// gate当前的租约, 还没有注册时为0
+func (r *Gate) gateLease() clientv3.LeaseID {
+	return clientv3.LeaseID(r.leaseID.Load())
+}
+
+// 检查租约时间, 超出合理范围直接报错
+// 比runtime的心跳间隔还短时, 租约会在两次心跳之间过期, 调大并打印警告
+func (r *Gate) checkLeaseTime() error {
+	if r.LeaseTime < model.MinLeaseTime || r.LeaseTime > model.MaxLeaseTime {
+		return fmt.Errorf("lease-time(%s) must be in [%s, %s]", r.LeaseTime, model.MinLeaseTime, model.MaxLeaseTime)
+	}
+
+	if r.LeaseTime < model.RuntimeKeepalive {
+		leaseTime := model.RuntimeKeepalive + time.Second
+		r.Warn().Msgf("gate:lease-time(%s) is shorter than the runtime keepalive(%s), use %s\n", r.LeaseTime, model.RuntimeKeepalive, leaseTime)
+		r.LeaseTime = leaseTime
+	}
+	return nil
+}
+
+// 判断租约是否还有效, 租约过期或者被回收时TTL <= 0
+func (r *Gate) leaseAlive(leaseID clientv3.LeaseID) (bool, error) {
+	if leaseID == 0 {
+		return false, nil
 	}
-	go func() {
-		if err := r.registerGateNode(); err != nil {
-			r.Error().Msgf("registerGateNode fail:%s\n", err)
+
+	rsp, err := defautlClient.TimeToLive(r.ctx, leaseID)
+	if err != nil {
+		if err == rpctypes.ErrLeaseNotFound {
+			return false, nil
 		}
-	}()
+		return false, err
+	}
+	return rsp.TTL > 0, nil
+}
+
+// 在写入和租约绑定的key之前调用, 如果gate的租约已经过期(比如网络分区), 重新申请一个
+// 只在registerGateNode里面调用, 由registerMu保护, 不会同时申请两个租约
+func (r *Gate) ensureLease() (clientv3.LeaseID, error) {
+	old := r.gateLease()
+	alive, err := r.leaseAlive(old)
+	if err != nil {
+		return 0, err
+	}
+
+	if alive {
+		return old, nil
+	}
+
+	if old != 0 {
+		r.Warn().Msgf("gate lease:%x has expired, grant a new lease\n", old)
+	}
+
+	leaseID, err := utils.NewLeaseWithKeepalive(r.ctx, r.Slog, defautlClient, r.LeaseTime, r.onGateLeaseStop)
+	if err != nil {
+		return 0, err
+	}
+
+	r.leaseID.Store(int64(leaseID))
+	return leaseID, nil
 }
 
 // gate的地址
 // model.GateNodePrefix 注册到/crab/gate/node/gate_name
+// 启动时拿到实际监听的地址之后注册一次, 租约停止之后重新注册, 同一时间只有一个在执行
 func (r *Gate) registerGateNode() (err error) {
+	r.registerMu.Lock()
+	defer r.registerMu.Unlock()
 	defer func() {
 		if err != nil {
 			r.Error().Msgf("registerGateNode err:%s\n", err)
 		}
 	}()
-	addr := r.ServerAddr
-	if addr == "" {
+	// 开启AutoFindAddr时地址是自动生成的, 注册的时候不依赖init已经执行过
+	addr := r.registryAddr()
+	if r.advertiseAddr() == "" {
 		r.Error().Msgf("The service startup address is empty, please set -s ip:port")
 		os.Exit(1)
 	}
 
-	leaseID, err := utils.NewLeaseWithKeepalive(r.ctx, r.Slog, defautlClient, r.LeaseTime)
-	if err != nil {
-		return err
-	}
-
-	r.leaseID = leaseID
 	// 注册自己的节点信息
 	nodeName := model.FullGateNode(r.NodeName())
-	r.Debug().Msgf("gate.register.node:%s, host:%s\n", nodeName, addr)
-	_, err = defautlClient.Put(r.ctx, nodeName, addr, clientv3.WithLease(leaseID))
+	// 检查租约和写入之间租约仍然可能过期, 这时候Put会返回ErrLeaseNotFound, 换个新租约再写一次
+	for i := 0; i < 2; i++ {
+		var leaseID clientv3.LeaseID
+		leaseID, err = r.ensureLease()
+		if err != nil {
+			return err
+		}
+
+		r.Debug().Msgf("gate.register.node:%s, host:%s\n", nodeName, addr)
+		_, err = defautlClient.Put(r.ctx, nodeName, addr, clientv3.WithLease(leaseID))
+		if err == nil {
+			r.registered.Store(true)
+			r.publishMeta()
+		}
+		if err != rpctypes.ErrLeaseNotFound {
+			return err
+		}
+		r.leaseID.Store(0)
+	}
 	return err
 }
 
+// runtime断开时删除节点信息, 配置了KeepLeaseOnDisconnect时留给租约过期
+func (r *Gate) disconnectRuntimeNode(who model.Whoami) {
+	if r.KeepLeaseOnDisconnect {
+		return
+	}
+	r.delRuntimeNode(who)
+}
+
+// 只删除这个gate用自己的租约注册的节点信息
+// runtime已经重新连到别的gate的话, 节点信息绑定的是新的租约, 不能删除
 func (r *Gate) delRuntimeNode(who model.Whoami) {
 	runtimeNode := who.Name
 	if len(runtimeNode) == 0 {
@@ -57,42 +134,97 @@ func (r *Gate) delRuntimeNode(who model.Whoami) {
 	}
 
 	nodeName := model.FullRuntimeNode(who)
-	_, err := defautlClient.Delete(r.ctx, nodeName)
+	v, ok := r.runtimeLeases.Load(nodeName)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	_, err := defaultKVC.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(nodeName), "=", v.(clientv3.LeaseID))).
+		Then(clientv3.OpDelete(nodeName)).
+		Commit()
 	if err != nil {
 		r.Error().Msgf("gate.delete.runtime.node %s\n", err)
 	}
 }
 
-// 注册runtime节点，并负责节点lease的续期
-func (r *Gate) registerRuntimeWithKeepalive(who model.Whoami, keepalive chan bool) error {
-
+// 申请新的租约，并把runtime节点信息写入etcd
+func (r *Gate) putRuntimeNode(who model.Whoami) (clientv3.Lease, clientv3.LeaseID, error) {
 	lease, leaseID, err := utils.NewLease(r.ctx, r.Slog, defautlClient, r.LeaseTime)
 	if err != nil {
 		r.Error().Msgf("registerRuntimeWithKeepalive.NewLease fail:%s\n", err)
-		return err
+		return nil, 0, err
 	}
 	// 注册runtime绑定的gate
 
 	// 注册自己的节点信息
 	nodeName := model.FullRuntimeNode(who)
-	r.Info().Msgf("gate.register.runtime.node:%s, host:%s\n", nodeName, r.ServerAddr)
-	info := model.RegisterRuntime{Whoami: who, Ip: r.ServerAddr}
+	addr := r.registryAddr()
+	r.Info().Msgf("gate.register.runtime.node:%s, host:%s\n", nodeName, addr)
+	info := model.RegisterRuntime{Whoami: who, Ip: addr}
 	all, err := json.Marshal(&info)
 	if err != nil {
-		r.Error().Msgf("gate.register.runtime.node:%s, host:%s, marshal json fail:%s\n", nodeName, r.ServerAddr, err)
-		return err
+		lease.Close()
+		r.Error().Msgf("gate.register.runtime.node:%s, host:%s, marshal json fail:%s\n", nodeName, addr, err)
+		return nil, 0, err
 	}
 
 	_, err = defautlClient.Put(r.ctx, nodeName, string(all), clientv3.WithLease(leaseID))
 
 	if err != nil {
+		// 没有写入节点信息, 租约留给etcd过期
+		lease.Close()
 		r.Error().Msgf("gate.register.runtime.node %s\n", err)
+		return nil, 0, err
+	}
+	r.runtimeLeases.Store(nodeName, leaseID)
+	return lease, leaseID, nil
+}
+
+// 注册runtime节点，并负责节点lease的续期
+func (r *Gate) registerRuntimeWithKeepalive(who model.Whoami, keepalive chan bool) error {
+
+	lease, leaseID, err := r.putRuntimeNode(who)
+	if err != nil {
 		return err
 	}
+	// 重新注册之后lease是新的, 退出时关闭最后一个
+	defer func() {
+		// runtime已经重新连上来的话, 记录的是新的租约, 不能删除
+		r.runtimeLeases.CompareAndDelete(model.FullRuntimeNode(who), leaseID)
+		if lease != nil {
+			lease.Close()
+		}
+	}()
 
 	for range keepalive {
-		lease.KeepAliveOnce(r.ctx, leaseID)
+		_, err = lease.KeepAliveOnce(r.ctx, leaseID)
+		if err != rpctypes.ErrLeaseNotFound {
+			continue
+		}
+
+		// 租约已经过期, runtime节点信息也被etcd删除了, 重新注册
+		r.Warn().Msgf("runtime lease:%x has expired, re-register runtime node:%s\n", leaseID, who.Name)
+		// 旧的租约已经过期, 先关闭, 不然每次重新注册都会泄露一个
+		lease.Close()
+		if lease, leaseID, err = r.putRuntimeNode(who); err != nil {
+			return err
+		}
 	}
 
+	if r.KeepLeaseOnDisconnect {
+		return err
+	}
+
+	// 长连接断开之后keepalive被关闭, 撤销租约, runtime节点信息立即删除, 不用等租约过期
+	// runtime已经重新连上来的话, 节点信息绑定的是新的租约, 不受影响
+	ctx, cancel := context.WithTimeout(context.Background(), r.etcdOpTimeout())
+	defer cancel()
+	if _, e := lease.Revoke(ctx, leaseID); e != nil && e != rpctypes.ErrLeaseNotFound {
+		r.Warn().Msgf("gate.stream:revoke runtime lease:%x, runtime:%s, err:%s\n", leaseID, who.Name, e)
+	}
 	return err
 }
@@ -0,0 +1,386 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/1whour/crab/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var (
+	testEtcdOnce sync.Once
+	testEtcdErr  error
+)
+
+// 此函数依赖etcd是否存在
+// 全局的client只初始化一次, 每个测试重新赋值时和上一个测试留下的goroutine有data race
+func testInitEtcdGate(t testing.TB) *Gate {
+	testEtcdOnce.Do(func() {
+		defautlClient, testEtcdErr = utils.NewEtcdClient([]string{"127.0.0.1:2379"})
+		if testEtcdErr != nil {
+			return
+		}
+		defaultKVC = clientv3.NewKV(defautlClient)
+		defaultStore = etcd.NewStoreWithClient(defautlClient, slog.New(os.Stdout).SetLevel("error"), nil)
+	})
+	if testEtcdErr != nil {
+		t.Fatal(testEtcdErr)
+	}
+
+	return &Gate{
+		ServerAddr: "127.0.0.1:3434",
+		Name:       uuid.New().String(),
+		LeaseTime:  model.RuntimeKeepalive + time.Second,
+		WriteTime:  time.Second,
+		Slog:       slog.New(os.Stdout).SetLevel("error"),
+		ctx:        context.TODO(),
+	}
+}
+
+// 租约被回收之后, 再次注册应该换一个新租约, 并且节点信息重新写入etcd
+func Test_RegisterGateNode_LeaseRevoked(t *testing.T) {
+	g := testInitEtcdGate(t)
+	nodeName := model.FullGateNode(g.NodeName())
+
+	assert.NoError(t, g.registerGateNode())
+	oldLeaseID := g.gateLease()
+
+	_, err := defautlClient.Revoke(g.ctx, oldLeaseID)
+	assert.NoError(t, err)
+
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+
+	assert.NoError(t, g.registerGateNode())
+	assert.NotEqual(t, oldLeaseID, g.gateLease())
+
+	rsp, err = defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+	assert.Equal(t, string(rsp.Kvs[0].Value), g.ServerAddr)
+	assert.Equal(t, clientv3.LeaseID(rsp.Kvs[0].Lease), g.gateLease())
+
+	defautlClient.Revoke(g.ctx, g.gateLease())
+}
+
+// runtime的租约被回收之后, 下一次心跳会重新注册runtime节点
+func Test_RegisterRuntime_LeaseRevoked(t *testing.T) {
+	g := testInitEtcdGate(t)
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	nodeName := model.FullRuntimeNode(who)
+
+	keepalive := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.registerRuntimeWithKeepalive(who, keepalive)
+	}()
+
+	getNode := func() *clientv3.GetResponse {
+		rsp, err := defaultKVC.Get(g.ctx, nodeName)
+		assert.NoError(t, err)
+		return rsp
+	}
+
+	assert.Eventually(t, func() bool { return len(getNode().Kvs) == 1 }, 3*time.Second, 10*time.Millisecond)
+	oldLeaseID := clientv3.LeaseID(getNode().Kvs[0].Lease)
+
+	_, err := defautlClient.Revoke(g.ctx, oldLeaseID)
+	assert.NoError(t, err)
+	assert.Len(t, getNode().Kvs, 0)
+
+	keepalive <- true
+	assert.Eventually(t, func() bool { return len(getNode().Kvs) == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	kv := getNode().Kvs[0]
+	assert.NotEqual(t, oldLeaseID, clientv3.LeaseID(kv.Lease))
+
+	var info model.RegisterRuntime
+	assert.NoError(t, json.Unmarshal(kv.Value, &info))
+	assert.Equal(t, who, info.Whoami)
+
+	close(keepalive)
+	assert.NoError(t, <-done)
+	defautlClient.Revoke(g.ctx, clientv3.LeaseID(kv.Lease))
+}
+
+// keepalive关闭之后注册协程退出, 租约被撤销, runtime节点信息立即删除
+func Test_RegisterRuntime_RevokeOnClose(t *testing.T) {
+	g := testInitEtcdGate(t)
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	nodeName := model.FullRuntimeNode(who)
+
+	keepalive := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.registerRuntimeWithKeepalive(who, keepalive)
+	}()
+
+	var leaseID clientv3.LeaseID
+	assert.Eventually(t, func() bool {
+		rsp, err := defaultKVC.Get(g.ctx, nodeName)
+		if err != nil || len(rsp.Kvs) != 1 {
+			return false
+		}
+		leaseID = clientv3.LeaseID(rsp.Kvs[0].Lease)
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+
+	close(keepalive)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("registerRuntimeWithKeepalive did not return")
+	}
+
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+
+	ttl, err := defautlClient.TimeToLive(g.ctx, leaseID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), ttl.TTL)
+}
+
+// 配置了KeepLeaseOnDisconnect时, keepalive关闭之后节点信息保留到租约过期
+func Test_RegisterRuntime_KeepLeaseOnDisconnect(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.KeepLeaseOnDisconnect = true
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	nodeName := model.FullRuntimeNode(who)
+
+	keepalive := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.registerRuntimeWithKeepalive(who, keepalive)
+	}()
+
+	assert.Eventually(t, func() bool {
+		rsp, err := defaultKVC.Get(g.ctx, nodeName)
+		return err == nil && len(rsp.Kvs) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	close(keepalive)
+	assert.NoError(t, <-done)
+	g.disconnectRuntimeNode(who)
+
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		leaseID := clientv3.LeaseID(rsp.Kvs[0].Lease)
+		ttl, err := defautlClient.TimeToLive(g.ctx, leaseID)
+		assert.NoError(t, err)
+		assert.Greater(t, ttl.TTL, int64(0))
+		defautlClient.Revoke(g.ctx, leaseID)
+	}
+}
+
+// 此函数依赖etcd是否存在
+// runtime已经用新的租约注册了(比如连到了别的gate), 旧的gate不能删除它的节点信息
+func Test_DelRuntimeNode_NewerLease(t *testing.T) {
+	g := testInitEtcdGate(t)
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	nodeName := model.FullRuntimeNode(who)
+
+	_, leaseID, err := g.putRuntimeNode(who)
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, leaseID)
+
+	// 别的gate用新的租约重新注册
+	other, err := defautlClient.Grant(g.ctx, 10)
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, other.ID)
+	_, err = defaultKVC.Put(g.ctx, nodeName, "other", clientv3.WithLease(other.ID))
+	assert.NoError(t, err)
+
+	g.delRuntimeNode(who)
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+
+	// 自己注册的可以删除
+	_, err = defaultKVC.Put(g.ctx, nodeName, "self", clientv3.WithLease(leaseID))
+	assert.NoError(t, err)
+	g.delRuntimeNode(who)
+	rsp, err = defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+}
+
+// 开启AutoFindAddr时, gate和runtime节点注册的都是自动生成的地址
+func Test_Register_AutoFindAddr(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ServerAddr = ""
+	g.AutoFindAddr = true
+
+	assert.NoError(t, g.registerGateNode())
+	assert.NotEmpty(t, g.ServerAddr)
+	defer defautlClient.Revoke(g.ctx, g.gateLease())
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGateNode(g.NodeName()))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+	assert.Equal(t, g.ServerAddr, string(rsp.Kvs[0].Value))
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	_, leaseID, err := g.putRuntimeNode(who)
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, leaseID)
+
+	rsp, err = defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+
+	var info model.RegisterRuntime
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &info))
+	assert.Equal(t, g.ServerAddr, info.Ip)
+}
+
+// 配置了AdvertiseAddr时注册的是这个地址, 而不是监听的地址
+func Test_Register_AdvertiseAddr(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ServerAddr = "0.0.0.0:3434"
+	g.AdvertiseAddr = "10.0.0.1:3434"
+
+	assert.NoError(t, g.registerGateNode())
+	defer defautlClient.Revoke(g.ctx, g.gateLease())
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGateNode(g.NodeName()))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 1)
+	assert.Equal(t, g.AdvertiseAddr, string(rsp.Kvs[0].Value))
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	_, leaseID, err := g.putRuntimeNode(who)
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, leaseID)
+
+	rsp, err = defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+	assert.NoError(t, err)
+	var info model.RegisterRuntime
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &info))
+	assert.Equal(t, g.AdvertiseAddr, info.Ip)
+
+	// 出口网卡的地址不是回环地址
+	g2 := Gate{AutoFindAddr: true, OutboundIP: true}
+	addr := g2.getAddress()
+	assert.NotEmpty(t, addr)
+	assert.NotContains(t, addr, "127.0.0.1")
+}
+
+// 开启tls时注册的地址带上wss://, runtime按这个scheme连接
+func Test_Register_TLSScheme(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.AdvertiseAddr = "10.0.0.1:3434"
+	g.CertFile, g.KeyFile = "cert.pem", "key.pem"
+
+	assert.NoError(t, g.registerGateNode())
+	defer defautlClient.Revoke(g.ctx, g.gateLease())
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGateNode(g.NodeName()))
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, "wss://10.0.0.1:3434", string(rsp.Kvs[0].Value))
+	}
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	_, leaseID, err := g.putRuntimeNode(who)
+	assert.NoError(t, err)
+	defer defautlClient.Revoke(g.ctx, leaseID)
+
+	rsp, err = defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+	assert.NoError(t, err)
+	var info model.RegisterRuntime
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &info))
+	assert.Equal(t, "wss://10.0.0.1:3434", info.Ip)
+}
+
+// AdvertiseAddr必须是别的节点能访问的host:port
+func Test_CheckAdvertiseAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"", true},
+		{"10.0.0.1:3434", true},
+		{"gate.example.com:3434", true},
+		{"10.0.0.1", false},
+		{":3434", false},
+		{"0.0.0.0:3434", false},
+		{"[::]:3434", false},
+		{"10.0.0.1:0", false},
+	} {
+		g := testInitEtcdGate(t)
+		g.ServerAddr = "0.0.0.0:3434"
+		g.AdvertiseAddr = tc.addr
+		err := g.checkAdvertiseAddr()
+		if tc.ok {
+			assert.NoError(t, err, tc.addr)
+		} else {
+			assert.Error(t, err, tc.addr)
+		}
+	}
+}
+
+// 租约被回收之后自动续约停止, gate会自己换一个新租约重新注册
+func Test_RegisterGateNode_AutoReregister(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var cancel context.CancelFunc
+	g.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	nodeName := model.FullGateNode(g.NodeName())
+
+	assert.NoError(t, g.registerGateNode())
+	oldLeaseID := g.gateLease()
+
+	_, err := defautlClient.Revoke(g.ctx, oldLeaseID)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		rsp, err := defaultKVC.Get(g.ctx, nodeName)
+		return err == nil && len(rsp.Kvs) == 1 && clientv3.LeaseID(rsp.Kvs[0].Lease) != oldLeaseID
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, g.registered.Load())
+
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	assert.Equal(t, g.ServerAddr, string(rsp.Kvs[0].Value))
+	defautlClient.Revoke(g.ctx, clientv3.LeaseID(rsp.Kvs[0].Lease))
+}
+
+// 租约时间超出范围时报错, 比runtime心跳还短时调大
+func Test_CheckLeaseTime(t *testing.T) {
+	for _, tc := range []struct {
+		lease time.Duration
+		want  time.Duration
+		ok    bool
+	}{
+		{0, 0, false},
+		{500 * time.Millisecond, 0, false},
+		{time.Second, model.RuntimeKeepalive + time.Second, true},
+		{7 * time.Second, 7 * time.Second, true},
+		{model.MaxLeaseTime, model.MaxLeaseTime, true},
+		{time.Hour, 0, false},
+	} {
+		g := testInitEtcdGate(t)
+		g.LeaseTime = tc.lease
+		err := g.checkLeaseTime()
+		if !tc.ok {
+			assert.Error(t, err, tc.lease)
+			continue
+		}
+		assert.NoError(t, err, tc.lease)
+		assert.Equal(t, tc.want, g.LeaseTime, tc.lease)
+	}
+}
@@ -0,0 +1,127 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 注册中心里面的原始节点信息, 排查问题用
+type registryItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// 0表示没有绑定租约
+	Lease int64 `json:"lease"`
+	// 租约剩余的秒数, -1表示租约已经不存在
+	TTL int64 `json:"ttl"`
+}
+
+type registryReq struct {
+	// gate, runtime, 为空时两个都返回
+	Kind string `form:"kind" json:"kind"`
+}
+
+type registryDelete struct {
+	Key string `json:"key" binding:"required"`
+}
+
+// 注册中心的前缀, kind只能是gate, runtime或者为空
+func registryPrefix(kind string) ([]string, error) {
+	switch kind {
+	case "gate":
+		return []string{model.GateNodePrefix}, nil
+	case "runtime":
+		return []string{model.RuntimeNodePrefix}, nil
+	case "":
+		return []string{model.GateNodePrefix, model.RuntimeNodePrefix}, nil
+	}
+	return nil, fmt.Errorf("unknown kind:%q, must be gate or runtime", kind)
+}
+
+// 只能删除注册中心前缀下面的key
+func isRegistryKey(key string) bool {
+	prefixes, _ := registryPrefix("")
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix+"/") && len(key) > len(prefix)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// 列出etcd里面的gate和runtime节点信息, 包含租约信息
+// 和runtime列表接口不一样，这里是etcd里面的原始数据, 包括已经没有连接的孤儿节点
+func (g *Gate) registryList(c *gin.Context) {
+	var req registryReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		g.error(c, model.ErrValidation, "%s", err)
+		return
+	}
+
+	prefixes, err := registryPrefix(req.Kind)
+	if err != nil {
+		g.error(c, model.ErrValidation, "%s", err)
+		return
+	}
+
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+
+	ttlCache := make(map[clientv3.LeaseID]int64)
+	list := make([]registryItem, 0, 8)
+	for _, prefix := range prefixes {
+		rsp, err := defaultKVC.Get(ctx, prefix+"/", clientv3.WithPrefix())
+		if err != nil {
+			g.etcdError(c, ctx, err, "registryList")
+			return
+		}
+
+		for _, kv := range rsp.Kvs {
+			item := registryItem{Key: string(kv.Key), Value: string(kv.Value), Lease: kv.Lease}
+			if kv.Lease != 0 {
+				leaseID := clientv3.LeaseID(kv.Lease)
+				ttl, ok := ttlCache[leaseID]
+				if !ok {
+					ttl = -1
+					if ttlRsp, err := defautlClient.TimeToLive(ctx, leaseID); err == nil {
+						ttl = ttlRsp.TTL
+					}
+					ttlCache[leaseID] = ttl
+				}
+				item.TTL = ttl
+			}
+			list = append(list, item)
+		}
+	}
+
+	c.JSON(200, wrapData{Data: list})
+}
+
+// 直接删除注册中心里面的节点, 用来清理进程崩溃之后留下的孤儿节点
+func (g *Gate) registryDelete(c *gin.Context) {
+	var req registryDelete
+	if err := c.ShouldBindJSON(&req); err != nil {
+		g.error(c, model.ErrValidation, "%s", err)
+		return
+	}
+
+	if !isRegistryKey(req.Key) {
+		g.error(c, model.ErrValidation, "not a registry key:%s", req.Key)
+		return
+	}
+
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+
+	rsp, err := defaultKVC.Delete(ctx, req.Key)
+	if err != nil {
+		g.etcdError(c, ctx, err, "registryDelete")
+		return
+	}
+
+	g.Warn().RequestID(getRequestID(c)).Msgf("registry delete key:%s, deleted:%d, by user:%s", req.Key, rsp.Deleted, c.GetString(userNameKey))
+	c.JSON(200, wrapData{Data: gin.H{"deleted": rsp.Deleted}})
+}
@@ -0,0 +1,44 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 只允许删除注册中心前缀下面的节点
+func Test_IsRegistryKey(t *testing.T) {
+	assert.True(t, isRegistryKey(model.GateNodePrefix+"/gate-1"))
+	assert.True(t, isRegistryKey(model.RuntimeNodePrefix+"/runtime-1"))
+	assert.False(t, isRegistryKey(model.GateNodePrefix+"/"))
+	assert.False(t, isRegistryKey(model.GateNodePrefix))
+	assert.False(t, isRegistryKey(model.GlobalTaskPrefix+"/task"))
+	assert.False(t, isRegistryKey("/other"))
+}
+
+// kind只能是gate, runtime或者为空, 写错的时候返回400, 不会悄悄返回全部
+func Test_RegistryPrefix(t *testing.T) {
+	prefixes, err := registryPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{model.GateNodePrefix, model.RuntimeNodePrefix}, prefixes)
+
+	prefixes, err = registryPrefix("runtime")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{model.RuntimeNodePrefix}, prefixes)
+
+	_, err = registryPrefix("runtimes")
+	assert.Error(t, err)
+
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled")}
+	router := gin.New()
+	router.GET("/registry", g.registryList)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/registry?kind=gates", nil))
+	assert.Equal(t, 400, w.Code, w.Body.String())
+}
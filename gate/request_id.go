@@ -0,0 +1,99 @@
+package gate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-Id"
+	// 保存在gin.Context里面的key
+	requestIDKey = "crab_request_id"
+	// 外部传入的request id最大长度, 太长的直接丢弃重新生成
+	maxRequestIDLen = 128
+)
+
+// 解析可信代理的地址, 支持CIDR和单个ip
+func parseTrustedProxy(proxy []string) (rv []*net.IPNet, err error) {
+	for _, p := range proxy {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy:%s", p)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			rv = append(rv, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy:%s, %w", p, err)
+		}
+		rv = append(rv, ipNet)
+	}
+	return
+}
+
+// 判断直连的对端是否是可信代理
+func (r *Gate) isTrustedProxy(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range r.trustedProxy {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 只接受可打印的ascii字符, 防止日志注入
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// 给每个请求分配request id
+// 只有来自可信代理的请求才会复用header里面的id, 其余的请求都重新生成, 防止伪造
+func (r *Gate) requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := ""
+		if r.isTrustedProxy(c.RemoteIP()) {
+			id = c.GetHeader(r.RequestIDHeader)
+			if !validRequestID(id) {
+				id = ""
+			}
+		}
+
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(r.RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// 获取当前请求的request id
+func getRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
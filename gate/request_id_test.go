@@ -0,0 +1,54 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 可信代理过来的请求复用request id, 其他来源重新生成
+func Test_RequestID_TrustedProxy(t *testing.T) {
+	var err error
+	g := Gate{RequestIDHeader: defaultRequestIDHeader}
+	g.trustedProxy, err = parseTrustedProxy([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(g.requestID())
+	router.GET("/", func(c *gin.Context) { c.String(200, getRequestID(c)) })
+
+	for _, tc := range []struct {
+		remoteAddr string
+		header     string
+		reuse      bool
+	}{
+		{"10.1.2.3:1234", "upstream-id", true},
+		{"192.168.1.1:1234", "upstream-id", true},
+		{"192.168.1.2:1234", "upstream-id", false},
+		{"10.1.2.3:1234", "", false},
+		{"10.1.2.3:1234", "bad id\n", false},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.header != "" {
+			req.Header.Set(defaultRequestIDHeader, tc.header)
+		}
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(defaultRequestIDHeader)
+		assert.Equal(t, w.Body.String(), id)
+		if tc.reuse {
+			assert.Equal(t, tc.header, id, tc.remoteAddr)
+		} else {
+			assert.NotEqual(t, tc.header, id, tc.remoteAddr)
+			assert.NotEmpty(t, id)
+		}
+	}
+
+	_, err = parseTrustedProxy([]string{"not-a-cidr"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const runtimeWatchRetry = time.Second
+
+var errRuntimeWatchClosed = errors.New("runtime watch:watch closed")
+
+// 主节点watch runtime节点, runtime断开(节点被删除或者租约过期)之后
+// 把绑定在上面的任务重置成CanRun, 让mjobs分配给其他的runtime
+func (r *Gate) watchRuntimeDown(ctx context.Context) {
+	r.watchRuntimeDownFrom(ctx, 0)
+}
+
+// watch断开之后从上一次的revision接着watch, 直到退出或者不再是主节点
+// 被compact之后中间的删除事件已经丢了, 对账一次把绑定在已经断开的runtime上的任务重置掉
+func (r *Gate) watchRuntimeDownFrom(ctx context.Context, rev int64) {
+	for {
+		var err error
+		rev, err = r.watchRuntimeDownOnce(ctx, rev)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			r.Warn().Msgf("gate.watchRuntimeDown:%s, resync tasks\n", err)
+			rev = 0
+			r.syncOnLeader(ctx)
+			continue
+		}
+
+		r.Warn().Msgf("gate.watchRuntimeDown:%s, rewatch in %s\n", err, runtimeWatchRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(runtimeWatchRetry):
+		}
+	}
+}
+
+// rev为0时从当前的revision开始, 返回最后处理过的revision
+func (r *Gate) watchRuntimeDownOnce(ctx context.Context, rev int64) (int64, error) {
+	prefix := model.RuntimeNodePrefix + "/"
+	if rev == 0 {
+		rsp, err := defaultKVC.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return 0, err
+		}
+		rev = rsp.Header.Revision
+	}
+
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	runtimeNode := defautlClient.Watch(wctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for ersp := range runtimeNode {
+		if err := ersp.Err(); err != nil {
+			return rev, err
+		}
+
+		for _, ev := range ersp.Events {
+			if ev.Type != clientv3.EventTypeDelete {
+				continue
+			}
+
+			// lambda节点和任务是绑定的, 不需要重新分配
+			key := string(ev.Kv.Key)
+			if strings.HasPrefix(key, model.RuntimeNodeLambdaPrefix+"/") {
+				continue
+			}
+
+			r.rescheduleRuntime(ctx, key)
+		}
+		rev = ersp.Header.Revision
+	}
+	return rev, errRuntimeWatchClosed
+}
+
+func (r *Gate) rescheduleRuntime(ctx context.Context, runtimeNode string) {
+	taskNames, err := defaultStore.LockResetRuntime(ctx, runtimeNode, r.leaderFence()...)
+	if err != nil {
+		r.Warn().Msgf("gate.rescheduleRuntime:%s, runtime:%s", err, runtimeNode)
+	}
+
+	if len(taskNames) > 0 {
+		r.Info().Msgf("runtime:%s disconnected, reschedule tasks:%v", runtimeNode, taskNames)
+	}
+}
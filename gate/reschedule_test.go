@@ -0,0 +1,145 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// 模拟runtime断开, 绑定在上面的任务被重置成CanRun, 本地队列被清理
+func Test_RescheduleRuntime_Disconnect(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ctx, g.cancel = context.WithCancel(context.TODO())
+	defer g.cancel()
+
+	var err error
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	runtimeNode := model.FullRuntimeNode(who)
+	info, err := json.Marshal(model.RegisterRuntime{Whoami: who})
+	assert.NoError(t, err)
+	_, err = defaultKVC.Put(g.ctx, runtimeNode, string(info))
+	assert.NoError(t, err)
+
+	// 一个普通任务, 一个指定了runtime的任务
+	var taskNames []string
+	for _, target := range []string{"", who.Name} {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Runtime: target, Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+
+		// 模拟任务已经分配给runtime
+		stateKey := model.FullGlobalTaskState(taskName)
+		rsp, err := defaultKVC.Get(g.ctx, stateKey)
+		assert.NoError(t, err)
+		state, err := model.UpdateState(rsp.Kvs[0].Value, runtimeNode, model.Running, model.Create, nil, taskName, who.Id)
+		assert.NoError(t, err)
+		_, err = defaultKVC.Put(g.ctx, stateKey, string(state))
+		assert.NoError(t, err)
+		_, err = defaultKVC.Put(g.ctx, model.ToLocalTask(runtimeNode, taskName), string(state))
+		assert.NoError(t, err)
+		taskNames = append(taskNames, taskName)
+	}
+
+	go g.watchRuntimeDown(g.ctx)
+	time.Sleep(200 * time.Millisecond)
+
+	// runtime断开, gate删除runtime节点
+	_, err = defaultKVC.Delete(g.ctx, runtimeNode)
+	assert.NoError(t, err)
+
+	getState := func(taskName string) model.State {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return state
+	}
+
+	assert.Eventually(t, func() bool { return getState(taskNames[0]).IsCanRun() }, 3*time.Second, 10*time.Millisecond)
+	state := getState(taskNames[0])
+	assert.Empty(t, state.RuntimeNode)
+	assert.Empty(t, state.RuntimeID)
+	assert.False(t, state.Ack)
+
+	rsp, err := defaultKVC.Get(g.ctx, model.ToLocalTask(runtimeNode, taskNames[0]))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+
+	// 指定runtime的任务只能等这个runtime回来
+	state = getState(taskNames[1])
+	assert.True(t, state.IsRunning())
+	assert.Equal(t, runtimeNode, state.RuntimeNode)
+
+	for _, taskName := range taskNames {
+		assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, taskName))
+		defaultKVC.Delete(g.ctx, model.ToLocalTask(runtimeNode, taskName))
+	}
+}
+
+// 此函数依赖etcd是否存在
+// watch的revision被compact之后, 删除事件已经丢了, 对账一次把断开的runtime上的任务重置掉, 然后接着watch
+func Test_WatchRuntimeDown_Compacted(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ctx, g.cancel = context.WithCancel(context.TODO())
+	defer g.cancel()
+
+	var err error
+
+	// 已经分配一段时间的任务, 本地队列里面也有, 对账时才会处理
+	assign := func(who model.Whoami) string {
+		taskName := testCreateRunningTask(t, g, who)
+		state := testGetState(t, g, taskName)
+		state.UpdateTime = time.Now().Add(-time.Minute)
+		value, _ := json.Marshal(state)
+		_, err := defaultKVC.Put(g.ctx, model.FullGlobalTaskState(taskName), string(value))
+		assert.NoError(t, err)
+		localKey := model.ToLocalTask(model.FullRuntimeNode(who), taskName)
+		_, err = defaultKVC.Put(g.ctx, localKey, string(value))
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultKVC.Delete(g.ctx, localKey) })
+		return taskName
+	}
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	taskName := assign(who)
+
+	// runtime在watch断开期间下线, 之后被compact
+	rsp, err := defaultKVC.Put(g.ctx, model.FullRuntimeNode(who), "{}")
+	assert.NoError(t, err)
+	rev := rsp.Header.Revision
+	_, err = defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who))
+	assert.NoError(t, err)
+	// compact到删除之后, 删除事件所在的revision也被回收
+	later, err := defaultKVC.Put(g.ctx, model.FullRuntimeNode(who)+"-compact", "")
+	assert.NoError(t, err)
+	defer defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who)+"-compact")
+	_, err = defautlClient.Compact(g.ctx, later.Header.Revision)
+	assert.NoError(t, err)
+
+	_, err = g.watchRuntimeDownOnce(g.ctx, rev)
+	assert.ErrorIs(t, err, rpctypes.ErrCompacted)
+
+	go g.watchRuntimeDownFrom(g.ctx, rev)
+	assert.Eventually(t, func() bool { return testGetState(t, g, taskName).IsCanRun() }, 3*time.Second, 10*time.Millisecond)
+
+	// 对账之后继续watch新的删除事件
+	other := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	otherTask := assign(other)
+	_, err = defaultKVC.Put(g.ctx, model.FullRuntimeNode(other), "{}")
+	assert.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, testGetState(t, g, otherTask).IsRunning())
+	_, err = defaultKVC.Delete(g.ctx, model.FullRuntimeNode(other))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return testGetState(t, g, otherTask).IsCanRun() }, 3*time.Second, 10*time.Millisecond)
+}
@@ -20,22 +20,31 @@ func (g *Gate) saveResult(ctx *gin.Context) {
 	// 获取数据
 	var rc model.ResultCore
 	if err := ctx.ShouldBindJSON(&rc); err != nil {
-		g.error2(ctx, 500, err.Error())
+		g.error(ctx, model.ErrValidation, "%s", err)
 		return
 	}
 
 	// 写入数据库
 	if err := g.resultTable.insert(rc); err != nil {
-		g.error2(ctx, 500, err.Error())
+		g.error(ctx, model.ErrDatabase, "%s", err)
 		return
 	}
+
+	if g.WebhookURL != "" {
+		// 结果已经保存, webhook入队失败只记录日志
+		ectx, cancel := g.etcdCtx(ctx)
+		defer cancel()
+		if err := g.enqueueWebhook(ectx, rc); err != nil {
+			g.Warn().RequestID(getRequestID(ctx)).Msgf("saveResult:enqueue webhook:%s", err)
+		}
+	}
 }
 
 // 获取列表里面的数据
 func (g *Gate) getResultList(c *gin.Context) {
 	p := PageResult{}
 	if err := c.ShouldBindQuery(&p); err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
 		return
 	}
 
@@ -46,7 +55,7 @@ func (g *Gate) getResultList(c *gin.Context) {
 
 	rv, count, err := g.resultTable.queryAndPage(p)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
 
@@ -63,7 +72,7 @@ func (g *Gate) deleteResult(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&p)
 	if err != nil {
-		g.error2(c, 500, err.Error())
+		g.error(c, model.ErrValidation, "%s", err)
 		return
 	}
 
@@ -75,7 +84,7 @@ func (g *Gate) deleteResult(c *gin.Context) {
 
 	rv, count, err := g.resultTable.queryAndPage(p)
 	if err != nil {
-		g.error(c, 500, err.Error())
+		g.error(c, model.ErrDatabase, "%s", err)
 		return
 	}
 
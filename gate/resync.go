@@ -0,0 +1,96 @@
+package gate
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// runtime连上之后, 把本地队列里面还分配给它的任务重新推一遍, 最后推送syncdone带上任务列表
+// 断开期间被分配到别的runtime上的任务, 本地队列里面已经没有了, runtime收到syncdone之后会停掉
+// 返回读本地队列时的revision, watch从下一个revision开始, 中间的变化不会漏掉
+func (r *Gate) resync(who *model.Whoami) (int64, error) {
+	// lambda的绑定关系和runtime不一样, 暂不支持
+	if who.Lambda {
+		return 0, nil
+	}
+
+	rsp, err := defaultKVC.Get(r.ctx, model.WatchLocalRuntimePrefix(who.Name)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	rev := rsp.Header.Revision
+
+	tasks := make([]string, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		// stop之后的任务runtime上已经没有了
+		if string(kv.Value) != model.CanRun {
+			continue
+		}
+
+		taskName := model.TaskName(string(kv.Key))
+		param, err := r.resyncParam(who, taskName, rev)
+		if err != nil {
+			return 0, err
+		}
+		if param == nil {
+			continue
+		}
+
+		if err = r.dispatch(who.Name, param); err != nil {
+			return 0, err
+		}
+		tasks = append(tasks, taskName)
+	}
+
+	done := model.Param{Action: model.SyncDone, SyncTasks: tasks}
+	if err = r.dispatch(who.Name, &done); err != nil {
+		return 0, err
+	}
+
+	r.Debug().Msgf("gate.resync:runtime(%s) resync %d tasks, revision(%d)", who.Name, len(tasks), rev)
+	return rev, nil
+}
+
+// 取出需要重新推送的任务, 绑定关系变了或者不需要运行的返回nil
+func (r *Gate) resyncParam(who *model.Whoami, taskName string, rev int64) (*model.Param, error) {
+	rspState, err := defaultKVC.Get(r.ctx, model.FullGlobalTaskState(taskName), clientv3.WithRev(rev))
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := defaultKVC.Get(r.ctx, model.FullGlobalTask(taskName), clientv3.WithRev(rev))
+	if err != nil {
+		return nil, err
+	}
+	if len(rspState.Kvs) == 0 || len(rsp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	state, err := model.ValueToState(rspState.Kvs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// runtime重启过(id变了)的任务由mjobs重新分配
+	if state.RuntimeNode != model.FullRuntimeNode(*who) || state.RuntimeID != who.Id || state.IsFailed() {
+		return nil, nil
+	}
+
+	// 执行过的一次性任务不再推送, 否则runtime重启之后又会执行一次
+	if !(state.IsCreate() || state.IsUpdate() || state.IsContinue()) || state.IsCompleted() {
+		return nil, nil
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(rsp.Kvs[0].Value, &param); err != nil {
+		return nil, err
+	}
+	// 窗口外触发并且配置了skip的一次性任务不会执行, 不需要推送
+	if param.Trigger.Skipped(time.Now()) {
+		return nil, nil
+	}
+	param.Action = model.Sync
+	return &param, nil
+}
@@ -0,0 +1,99 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 此函数依赖etcd是否存在
+// runtime重连之后只重新推送还分配给它的任务, 最后推送syncdone带上任务列表
+func Test_Resync(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	node := model.FullRuntimeNode(who)
+	defer defaultKVC.Delete(g.ctx, model.WatchLocalRuntimePrefix(who.Name)+"/", clientv3.WithPrefix())
+
+	// 分配给runtime的任务, id是runtimeID
+	assign := func(action string, id string) string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.DeleteDataAndState(g.ctx, taskName) })
+
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		assert.NoError(t, defaultStore.UpdateLocalAndGlobal(g.ctx, taskName, node, rsp, action, id))
+		return taskName
+	}
+
+	running := assign(model.Create, who.Id)
+	assign(model.Stop, who.Id)
+	// runtime重启过, 由mjobs重新分配
+	assign(model.Create, uuid.New().String())
+	// 执行过的一次性任务不再推送
+	completed := model.FullGlobalTaskState(assign(model.Create, who.Id))
+	rsp, err := defaultKVC.Get(g.ctx, completed)
+	assert.NoError(t, err)
+	state, err := model.ValueToState(rsp.Kvs[0].Value)
+	assert.NoError(t, err)
+	state.State = model.Completed
+	value, _ := json.Marshal(state)
+	_, err = defaultKVC.Put(g.ctx, completed, string(value))
+	assert.NoError(t, err)
+
+	stored := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := testUpgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		g.addConn(who.Name, con)
+		close(stored)
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	<-stored
+
+	rev, err := g.resync(&who)
+	assert.NoError(t, err)
+	assert.NotZero(t, rev)
+
+	var got model.Param
+	assert.NoError(t, client.ReadJSON(&got))
+	assert.True(t, got.IsSync())
+	assert.Equal(t, running, got.Executer.TaskName)
+
+	got = model.Param{}
+	assert.NoError(t, client.ReadJSON(&got))
+	assert.True(t, got.IsSyncDone())
+	assert.Equal(t, []string{running}, got.SyncTasks)
+
+	// 被分配到别的runtime之后不再推送
+	rsp, err = defaultKVC.Get(g.ctx, model.FullGlobalTaskState(running))
+	assert.NoError(t, err)
+	other := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	assert.NoError(t, defaultStore.UpdateLocalAndGlobal(g.ctx, running, other, rsp, model.Create, who.Id))
+	defer defaultKVC.Delete(g.ctx, model.ToLocalTask(other, running))
+
+	_, err = g.resync(&who)
+	assert.NoError(t, err)
+	got = model.Param{}
+	assert.NoError(t, client.ReadJSON(&got))
+	assert.True(t, got.IsSyncDone())
+	assert.Empty(t, got.SyncTasks)
+}
@@ -3,10 +3,12 @@ package gate
 import (
 	"bytes"
 	"encoding/json"
+	"strconv"
 	"strings"
 
 	"github.com/1whour/crab/model"
 	"github.com/gin-gonic/gin"
+	"github.com/olekukonko/tablewriter"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -22,6 +24,28 @@ type pageRuntime struct {
 	Sort     string `form:"sort" json:"sort"`
 	ID       string `form:"id" json:"id"`
 	StartKey string `form:"start_key" json:"start_key"`
+	Format   string `form:"format" json:"format"`
+}
+
+// runtime列表支持的输出格式
+var runtimeFormats = []string{"json", "table"}
+
+var runtimeTitle = []string{"name", "id", "gate", "lambda", "connected", "load"}
+
+// runtime节点信息, connected表示是否和本gate有websocket长连接
+// load是已经分配到这个runtime上的任务数, 上限见max_concurrency
+type runtimeNodeRsp struct {
+	model.RegisterRuntime
+	Connected bool `json:"connected"`
+	Load      int  `json:"load"`
+}
+
+// table格式的负载, 没有上限时只显示任务数
+func (r runtimeNodeRsp) loadString() string {
+	if r.MaxConcurrency <= 0 {
+		return strconv.Itoa(r.Load)
+	}
+	return strconv.Itoa(r.Load) + "/" + strconv.Itoa(r.MaxConcurrency)
 }
 
 type runtimeNodeList struct {
@@ -34,7 +58,13 @@ func (g *Gate) runtimeList(ctx *gin.Context) {
 
 	p := pageRuntime{}
 	if err := ctx.ShouldBindQuery(&p); err != nil {
-		g.error2(ctx, 500, err.Error())
+		g.error(ctx, model.ErrValidation, "%s", err)
+		return
+	}
+
+	format, err := checkFormat(p.Format, runtimeFormats)
+	if err != nil {
+		g.error(ctx, model.ErrValidation, "runtimeList:%s", err)
 		return
 	}
 
@@ -53,52 +83,80 @@ func (g *Gate) runtimeList(ctx *gin.Context) {
 		sortOrder = clientv3.SortDescend
 	}
 	// 获取runtimeNode的值
-	resp, err := defaultKVC.Get(g.ctx,
+	ectx, cancel := g.etcdCtx(ctx)
+	defer cancel()
+
+	resp, err := defaultKVC.Get(ectx,
 		startKey,
 		clientv3.WithRange(endRuntimeKey),
 		clientv3.WithSort(clientv3.SortByKey, sortOrder),
 		clientv3.WithLimit(p.Limit))
 	if err != nil {
-		g.error2(ctx, 500, err.Error())
+		if !g.etcdTimeout(ctx, ectx, err, "runtimeList") {
+			g.error(ctx, model.ErrEtcd, "%s", err)
+		}
 		return
 	}
 
-	resp2, err2 := defaultKVC.Get(g.ctx, model.RuntimeNodePrefix, clientv3.WithCountOnly(), clientv3.WithPrefix())
+	resp2, err2 := defaultKVC.Get(ectx, model.RuntimeNodePrefix, clientv3.WithCountOnly(), clientv3.WithPrefix())
 	if err2 != nil {
-		g.error2(ctx, 500, err2.Error())
+		if !g.etcdTimeout(ctx, ectx, err2, "runtimeList") {
+			g.error(ctx, model.ErrEtcd, "%s", err2)
+		}
 		return
 	}
 	n := len(resp.Kvs)
 	if len(p.ID) > 0 {
 		n = 1
 	}
-	list := make([]model.RegisterRuntime, 0, n)
+	list := make([]runtimeNodeRsp, 0, n)
 
 	for _, v := range resp.Kvs {
 		var info model.RegisterRuntime
 		err = json.Unmarshal(v.Value, &info)
 		if err != nil {
-			g.error2(ctx, 500, err.Error())
+			g.error(ctx, model.ErrInternal, "%s", err)
 			return
 		}
 
-		if len(p.ID) > 0 {
-			if info.Id == p.ID {
-				list = append(list, info)
-				break
-			}
+		if len(p.ID) > 0 && info.Id != p.ID {
 			continue
 		}
 
-		list = append(list, info)
+		load, err := defaultStore.RuntimeLoad(ectx, string(v.Key))
+		if err != nil {
+			if !g.etcdTimeout(ctx, ectx, err, "runtimeList") {
+				g.error(ctx, model.ErrEtcd, "%s", err)
+			}
+			return
+		}
+
+		_, connected := g.loadConn(info.Name)
+		list = append(list, runtimeNodeRsp{RegisterRuntime: info, Connected: connected, Load: load})
+		if len(p.ID) > 0 {
+			break
+		}
 	}
 
-	if len(list) > 0 {
-		startKey = string(bytes.Join([][]byte{resp.Kvs[0].Key, startKeyPrefix}, bytesEmpty))
+	// 下一页从本页最后一个key之后开始
+	if len(resp.Kvs) > 0 {
+		startKey = string(bytes.Join([][]byte{resp.Kvs[len(resp.Kvs)-1].Key, startKeyPrefix}, bytesEmpty))
 	} else {
 		startKey = ""
 	}
 
+	if format == "table" {
+		var buf bytes.Buffer
+		table := tablewriter.NewWriter(&buf)
+		table.SetHeader(runtimeTitle)
+		for _, v := range list {
+			table.Append([]string{v.Name, v.Id, v.Ip, strconv.FormatBool(v.Lambda), strconv.FormatBool(v.Connected), v.loadString()})
+		}
+		table.Render()
+		ctx.String(200, buf.String())
+		return
+	}
+
 	ctx.JSON(200, wrapData{Data: runtimeNodeList{
 		Total:    resp2.Count,
 		Items:    list,
@@ -0,0 +1,80 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 此函数依赖etcd是否存在
+// runtime列表带上是否和本gate有长连接和负载, 支持table格式
+func Test_RuntimeList(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String(), MaxConcurrency: 2}
+	all, err := json.Marshal(model.RegisterRuntime{Whoami: who, Ip: g.ServerAddr})
+	assert.NoError(t, err)
+
+	_, err = defaultKVC.Put(g.ctx, model.FullRuntimeNode(who), string(all))
+	assert.NoError(t, err)
+	defer defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who))
+
+	// 一个运行中的任务, 一个已经停止的任务, 只有前一个算负载
+	localPrefix := model.ToLocalTaskPrefix(model.FullRuntimeNode(who)) + "/"
+	_, err = defaultKVC.Put(g.ctx, localPrefix+"a", model.CanRun)
+	assert.NoError(t, err)
+	_, err = defaultKVC.Put(g.ctx, localPrefix+"b", model.Stop)
+	assert.NoError(t, err)
+	defer defaultKVC.Delete(g.ctx, localPrefix, clientv3.WithPrefix())
+
+	router := gin.New()
+	router.GET("/", g.runtimeList)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?limit=10000&id="+who.Id+query, nil))
+		return w
+	}
+
+	items := func() []runtimeNodeRsp {
+		var rsp struct {
+			Data struct {
+				Items []runtimeNodeRsp `json:"items"`
+			} `json:"data"`
+		}
+		w := get("")
+		assert.Equal(t, 200, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		assert.Len(t, rsp.Data.Items, 1)
+		return rsp.Data.Items
+	}
+	connected := func() bool {
+		list := items()
+		return len(list) == 1 && list[0].Connected
+	}
+
+	list := items()
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, 1, list[0].Load)
+		assert.Equal(t, 2, list[0].MaxConcurrency)
+	}
+
+	assert.False(t, connected())
+	rc := g.addConn(who.Name, nil)
+	assert.True(t, connected())
+	g.removeConn(who.Name, rc)
+
+	w := get("&format=table")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), who.Id)
+	assert.Contains(t, w.Body.String(), "1/2")
+
+	assert.Equal(t, 400, get("&format=xml").Code)
+}
@@ -0,0 +1,139 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// 监听地址, 失败时换个地址重试
+// 在注册gate节点之前调用, 注册到etcd里面的地址就是实际监听的地址
+func (r *Gate) listen() (ln net.Listener, err error) {
+	for i := 0; i < 3; i++ {
+		if ln, err = net.Listen("tcp", r.ServerAddr); err == nil {
+			r.ServerAddr = resolveListenAddr(r.ServerAddr, ln.Addr())
+			return ln, nil
+		}
+
+		r.Debug().Msgf("run fail:%v\n", err)
+		r.autoNewAddr()
+		r.Debug().Msgf("gate:serverAddr:%s\n", r.ServerAddr)
+		time.Sleep(time.Millisecond * 500)
+	}
+	return nil, err
+}
+
+// 端口为0时由系统分配, 换成实际监听的端口
+func resolveListenAddr(addr string, ln net.Addr) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != "0" {
+		return addr
+	}
+
+	tcp, ok := ln.(*net.TCPAddr)
+	if !ok {
+		return addr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(tcp.Port))
+}
+
+// 启动http服务, 收到SIGINT/SIGTERM之后优雅退出
+func (r *Gate) serve(handler http.Handler) error {
+	ln, err := r.listen()
+	if err != nil {
+		return err
+	}
+
+	// 地址确定之后再注册, 注册失败时一直重试
+	go r.registerGateNodeLoop()
+
+	srv := r.newServer(handler)
+	errCh := make(chan error, 1)
+	go func() {
+		if r.tlsEnabled() {
+			errCh <- srv.ServeTLS(ln, r.CertFile, r.KeyFile)
+			return
+		}
+		errCh <- srv.Serve(ln)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err = <-errCh:
+		return err
+	case s := <-sig:
+		r.Info().Msgf("gate:receive signal(%s), shutdown", s)
+	}
+
+	return r.shutdown(srv)
+}
+
+// 优雅退出
+// 1.停掉后台的任务和状态推送这种不会自己结束的请求, 否则Shutdown会一直等到超时
+// 2.不再接收新的连接, 等待进行中的http请求结束
+// 3.关闭runtime的长连接, runtime会重连到别的gate
+// 4.回收gate的租约, 不用等租约过期, 重启的时候也不会和旧的节点信息冲突
+func (r *Gate) shutdown(srv *http.Server) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	// websocket连接已经被hijack, Shutdown不会管它们
+	r.closeConns()
+
+	// Shutdown可能已经用完了ShutdownTimeout, etcd的操作用单独的超时
+	etcdCtx, etcdCancel := context.WithTimeout(context.Background(), r.etcdOpTimeout())
+	defer etcdCancel()
+
+	// r.ctx取消之后主节点先停掉任务再放弃身份, 这里等它做完
+	r.waitLeader(etcdCtx)
+
+	if leaseID := r.gateLease(); leaseID != 0 {
+		if _, e := defautlClient.Revoke(etcdCtx, leaseID); e != nil {
+			r.Warn().Msgf("gate:shutdown revoke lease:%x, %s", leaseID, e)
+		}
+	}
+
+	if r.tracerProvider != nil {
+		traceCtx, traceCancel := context.WithTimeout(context.Background(), r.ShutdownTimeout)
+		defer traceCancel()
+		if e := r.tracerProvider.Shutdown(traceCtx); e != nil {
+			r.Warn().Msgf("gate:shutdown tracer provider:%s", e)
+		}
+	}
+	return err
+}
+
+// 关闭所有runtime的长连接
+func (r *Gate) closeConns() {
+	r.conns.Range(func(key, value any) bool {
+		c := value.(*runtimeConn)
+		c.mu.Lock()
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "gate shutdown"), time.Now().Add(time.Second))
+		c.mu.Unlock()
+		c.conn.Close()
+		return true
+	})
+}
@@ -0,0 +1,118 @@
+package gate
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 优雅退出时关闭runtime的长连接, 并且回收gate的租约
+func Test_Shutdown(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ShutdownTimeout = time.Second
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	assert.NoError(t, g.registerGateNode())
+
+	stored := make(chan struct{})
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := testUpgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		g.addConn("runtime-1", con)
+		close(stored)
+	}))
+	defer ws.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	<-stored
+
+	srv := &http.Server{}
+	assert.NoError(t, g.shutdown(srv))
+
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	rsp, err := defaultKVC.Get(context.Background(), model.FullGateNode(g.NodeName()))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+	assert.Error(t, g.ctx.Err())
+}
+
+// 状态推送的请求不会自己结束, 退出时先停掉, Shutdown不用等到超时
+func Test_Shutdown_WatchState(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ShutdownTimeout = 5 * time.Second
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+
+	router := gin.New()
+	router.GET(model.TASK_UI_WATCH_URL, g.watchState)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + model.TASK_UI_WATCH_URL)
+	assert.NoError(t, err)
+	defer rsp.Body.Close()
+
+	start := time.Now()
+	assert.NoError(t, g.shutdown(srv.Config))
+	assert.Less(t, time.Since(start), g.ShutdownTimeout)
+
+	_, err = io.ReadAll(rsp.Body)
+	assert.NoError(t, err)
+}
+
+// 监听的地址和注册到etcd里面的地址一致, 端口为0时换成系统分配的端口
+// 和serve一样先监听再注册, 同时触发的注册只申请一个租约
+func Test_Listen_RegisteredAddr(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.ServerAddr = "127.0.0.1:0"
+
+	ln, err := g.listen()
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	assert.Equal(t, ln.Addr().String(), g.ServerAddr)
+	assert.NotEqual(t, "127.0.0.1:0", g.ServerAddr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, g.registerGateNode())
+		}()
+	}
+	wg.Wait()
+
+	nodeName := model.FullGateNode(g.NodeName())
+	rsp, err := defaultKVC.Get(g.ctx, nodeName)
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, g.ServerAddr, string(rsp.Kvs[0].Value))
+		assert.Equal(t, g.gateLease(), clientv3.LeaseID(rsp.Kvs[0].Lease))
+	}
+	defautlClient.Revoke(g.ctx, g.gateLease())
+
+	// 指定的端口直接使用
+	addr := ln.Addr().String()
+	ln.Close()
+	g2 := testInitEtcdGate(t)
+	g2.ServerAddr = addr
+	ln2, err := g2.listen()
+	assert.NoError(t, err)
+	defer ln2.Close()
+	assert.Equal(t, addr, ln2.Addr().String())
+	assert.Equal(t, addr, g2.ServerAddr)
+}
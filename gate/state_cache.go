@@ -0,0 +1,203 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 缓存同步到的revision和距离上次确认同步过了多久, 只有从缓存读状态时才有
+	stateCacheRevisionHeader = "X-State-Cache-Revision"
+	stateCacheAgeHeader      = "X-State-Cache-Age"
+
+	// 加载缓存时一次Get多少个状态
+	stateCacheLoadBatch = 1000
+	// 同步失败之后等一会再重新加载
+	stateCacheRetry = time.Second
+)
+
+var errStateCacheWatchClosed = errors.New("state cache:watch closed")
+
+// task状态的内存缓存, 由GlobalTaskPrefixState上的一个watch维护
+// status接口从这里读状态, 每页少一半的etcd读; watch断开或者太久没有确认同步时回退到直接读etcd
+type stateCache struct {
+	sync.RWMutex
+	// key是task名
+	states map[string]*mvccpb.KeyValue
+	// 已经同步到的revision
+	rev int64
+	// 最后一次确认和etcd同步的时间, 没有同步时是零值
+	syncedAt time.Time
+}
+
+func newStateCache() *stateCache {
+	return &stateCache{states: make(map[string]*mvccpb.KeyValue)}
+}
+
+// 缓存健康时返回taskNames的状态, 超过maxAge没有确认同步认为不健康, ok为false
+func (s *stateCache) lookup(taskNames []string, maxAge time.Duration, now time.Time) (states map[string]*mvccpb.KeyValue, rev int64, age time.Duration, ok bool) {
+	if s == nil {
+		return nil, 0, 0, false
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	if s.syncedAt.IsZero() {
+		return nil, 0, 0, false
+	}
+	if age = now.Sub(s.syncedAt); age > maxAge {
+		return nil, 0, 0, false
+	}
+
+	states = make(map[string]*mvccpb.KeyValue, len(taskNames))
+	for _, name := range taskNames {
+		if kv := s.states[name]; kv != nil {
+			states[name] = kv
+		}
+	}
+	return states, s.rev, age, true
+}
+
+// 全量替换, 重新加载之后调用
+func (s *stateCache) reset(states map[string]*mvccpb.KeyValue, rev int64, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.states = states
+	s.rev = rev
+	s.syncedAt = now
+}
+
+// 应用watch的事件, 进度通知没有事件, 只刷新revision和同步时间
+func (s *stateCache) apply(rsp clientv3.WatchResponse, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	for _, ev := range rsp.Events {
+		name := model.TaskName(string(ev.Kv.Key))
+		if ev.Type == clientv3.EventTypeDelete {
+			delete(s.states, name)
+			continue
+		}
+		s.states[name] = ev.Kv
+	}
+
+	if rsp.Header.Revision > s.rev {
+		s.rev = rsp.Header.Revision
+	}
+	s.syncedAt = now
+}
+
+// watch断开之后, 重新加载之前不能再用
+func (s *stateCache) unhealthy() {
+	s.Lock()
+	defer s.Unlock()
+	s.syncedAt = time.Time{}
+}
+
+// 维护状态缓存, 同步失败之后重新加载, ctx取消时退出
+func (r *Gate) stateCacheLoop(ctx context.Context) {
+	for {
+		err := r.syncStateCache(ctx)
+		r.stateCache.unhealthy()
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.Warn().Msgf("stateCache:%s, reload in %s\n", err, stateCacheRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(stateCacheRetry):
+		}
+	}
+}
+
+// 先全量加载, 再从加载的revision之后开始watch
+// 没有事件时每隔StateCacheMaxAge/2请求一次进度通知, 确认缓存还是新的
+func (r *Gate) syncStateCache(ctx context.Context) error {
+	states, rev, err := loadStates(ctx)
+	if err != nil {
+		return err
+	}
+	r.stateCache.reset(states, rev, time.Now())
+
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	wch := defautlClient.Watch(wctx, model.GlobalTaskPrefixState+"/", clientv3.WithPrefix(), clientv3.WithRev(rev+1), clientv3.WithProgressNotify())
+	tk := time.NewTicker(r.StateCacheMaxAge / 2)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tk.C:
+			if err := defautlClient.RequestProgress(wctx); err != nil {
+				return err
+			}
+		case rsp, ok := <-wch:
+			if !ok {
+				return errStateCacheWatchClosed
+			}
+			// 被compact或者没有leader, 重新加载
+			if err := rsp.Err(); err != nil {
+				return err
+			}
+			r.stateCache.apply(rsp, time.Now())
+		}
+	}
+}
+
+// 分页读出所有的状态, 每一页都在第一页的revision上读, 得到的是同一个快照
+func loadStates(ctx context.Context) (states map[string]*mvccpb.KeyValue, rev int64, err error) {
+	key := model.GlobalTaskPrefixState + "/"
+	end := clientv3.GetPrefixRangeEnd(key)
+	states = make(map[string]*mvccpb.KeyValue)
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(stateCacheLoadBatch)}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		rsp, err := defaultKVC.Get(ctx, key, opts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		rev = rsp.Header.Revision
+
+		for _, kv := range rsp.Kvs {
+			states[model.TaskName(string(kv.Key))] = kv
+		}
+
+		if !rsp.More || len(rsp.Kvs) == 0 {
+			return states, rev, nil
+		}
+		key = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// 从缓存读taskNames的状态, 命中时带上新鲜度的header
+func (g *Gate) cachedStates(c *gin.Context, taskNames []string) (map[string]*mvccpb.KeyValue, bool) {
+	states, rev, age, ok := g.stateCache.lookup(taskNames, g.StateCacheMaxAge, time.Now())
+	if !ok {
+		return nil, false
+	}
+
+	c.Header(stateCacheRevisionHeader, strconv.FormatInt(rev, 10))
+	c.Header(stateCacheAgeHeader, age.Round(time.Millisecond).String())
+	return states, true
+}
+
+func (r *Gate) checkStateCache() error {
+	if r.StateCache && r.StateCacheMaxAge <= 0 {
+		return errors.New("state-cache-max-age must be positive")
+	}
+	return nil
+}
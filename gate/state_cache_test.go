@@ -0,0 +1,97 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// 没有同步过, 太久没有确认同步, watch断开时都不能用缓存
+func Test_StateCache_Lookup(t *testing.T) {
+	now := time.Now()
+	var none *stateCache
+	_, _, _, ok := none.lookup([]string{"a"}, time.Second, now)
+	assert.False(t, ok)
+
+	s := newStateCache()
+	_, _, _, ok = s.lookup([]string{"a"}, time.Second, now)
+	assert.False(t, ok)
+
+	s.reset(map[string]*mvccpb.KeyValue{"a": {Key: []byte(model.FullGlobalTaskState("a"))}}, 10, now)
+	states, rev, age, ok := s.lookup([]string{"a", "b"}, time.Second, now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), rev)
+	assert.Equal(t, 500*time.Millisecond, age)
+	assert.Len(t, states, 1)
+	assert.NotNil(t, states["a"])
+
+	_, _, _, ok = s.lookup([]string{"a"}, time.Second, now.Add(2*time.Second))
+	assert.False(t, ok)
+
+	s.unhealthy()
+	_, _, _, ok = s.lookup([]string{"a"}, time.Second, now)
+	assert.False(t, ok)
+}
+
+// 此函数依赖etcd是否存在
+// 缓存跟着watch更新, 没有事件时靠进度通知保持新鲜, status从缓存读状态时带上新鲜度的header
+func Test_StateCache_Sync(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.StateCache = true
+	g.StateCacheMaxAge = time.Second
+	names, cleanup := testCreateStatusTasks(t, g, 3)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g.stateCache = newStateCache()
+	go g.stateCacheLoop(ctx)
+
+	cached := func() map[string]*mvccpb.KeyValue {
+		states, _, _, ok := g.stateCache.lookup(names, g.StateCacheMaxAge, time.Now())
+		if !ok {
+			return nil
+		}
+		return states
+	}
+	assert.Eventually(t, func() bool { return len(cached()) == len(names) }, 3*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, names[0]))
+	assert.Eventually(t, func() bool {
+		states := cached()
+		return states != nil && states[names[0]] == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 超过StateCacheMaxAge没有事件, 缓存仍然可用
+	time.Sleep(2 * g.StateCacheMaxAge)
+	assert.Len(t, cached(), len(names)-1)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL, nil)
+	tasks, states, err := g.getTasksAndStates(c, names)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, len(names)-1)
+	assert.Len(t, states, len(names)-1)
+	assert.Equal(t, model.FullGlobalTaskState(names[1]), string(states[names[1]].Key))
+	assert.NotEmpty(t, w.Header().Get(stateCacheRevisionHeader))
+	assert.NotEmpty(t, w.Header().Get(stateCacheAgeHeader))
+
+	// 停掉之后回退到直接读etcd
+	cancel()
+	assert.Eventually(t, func() bool { return cached() == nil }, 3*time.Second, 10*time.Millisecond)
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL, nil)
+	_, states, err = g.getTasksAndStates(c, names)
+	assert.NoError(t, err)
+	assert.Len(t, states, len(names)-1)
+	assert.Empty(t, w.Header().Get(stateCacheRevisionHeader))
+}
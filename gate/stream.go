@@ -1,49 +1,197 @@
 package gate
 
 import (
+	"context"
+	"errors"
+	"runtime/debug"
 	"sync/atomic"
+	"time"
 
 	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
+const defaultHeartbeatTimeout = 10 * time.Second
+
+// 心跳超时不能比runtime发心跳的间隔短, 不然连接会被反复断开
+func (r *Gate) checkHeartbeatTimeout() {
+	if r.HeartbeatTimeout <= 0 {
+		r.HeartbeatTimeout = defaultHeartbeatTimeout
+		return
+	}
+
+	if r.HeartbeatTimeout <= model.RuntimeKeepalive {
+		r.Warn().Msgf("gate:heartbeat-timeout(%s) must be longer than the runtime keepalive(%s), use %s\n", r.HeartbeatTimeout, model.RuntimeKeepalive, defaultHeartbeatTimeout)
+		r.HeartbeatTimeout = defaultHeartbeatTimeout
+	}
+}
+
 func (r *Gate) stream(c *gin.Context) {
 
 	w := c.Writer
 	req := c.Request
 
-	con, err := upgrader.Upgrade(w, req, nil)
+	// 升级之前先占一个名额, 超过上限直接拒绝
+	n := atomic.AddInt32(&r.runtimeCount, 1)
+	defer atomic.AddInt32(&r.runtimeCount, -1)
+	if r.MaxRuntimeConns > 0 && int(n) > r.MaxRuntimeConns {
+		runtimeConnRejected.Inc()
+		r.error(c, model.ErrUnavailable, "stream:too many runtime connections, max(%d)", r.MaxRuntimeConns)
+		return
+	}
+
+	con, err := r.upgrader.Upgrade(w, req, nil)
 	if err != nil {
-		r.Error().Msgf("upgrade:", err)
+		r.Error().Msgf("upgrade:%s", err)
 		return
 	}
 	defer con.Close()
-
-	atomic.AddInt32(&r.runtimeCount, 1)
-	defer atomic.AddInt32(&r.runtimeCount, -1)
+	offered := websocket.Subprotocols(req)
+	protocol, ok := r.negotiateProtocol(con, offered)
+	if !ok {
+		r.Warn().Msgf("gate.stream:reject runtime, unsupported protocol:%v, remote:%s\n", offered, req.RemoteAddr)
+		return
+	}
+	// 没有协商成功时不生效
+	con.EnableWriteCompression(r.WsCompression)
 
 	keepalive := make(chan bool)
-	runtimeNode := ""
+	// 退出时关闭, 续租的goroutine跟着退出, runtime的节点信息不会一直续期
+	defer close(keepalive)
+
+	var lastPong atomic.Int64
+	setPongHandler(con, &lastPong)
+	done := make(chan struct{})
+	defer close(done)
+	// 连接断开之后本地队列的watch跟着退出
+	watchCtx, cancelWatch := context.WithCancel(r.ctx)
+	defer cancelWatch()
+
+	var who model.Whoami
+	defer func() { r.untrackRuntime(who) }()
+	defer func() { r.untrackAcks(who) }()
+	// 一个有问题的包不能让整个gate挂掉, panic之后和读出错一样清理
+	// 在removeConn之后, close(keepalive)之前执行
+	defer func() {
+		if e := recover(); e != nil {
+			streamPanics.Inc()
+			r.disconnectRuntimeNode(who)
+			r.Error().Msgf("gate.stream:panic:%v, runtime:%s\n%s", e, who.Name, debug.Stack())
+		}
+	}()
 	for {
-		// 读取心跳
-		req := model.Whoami{}
-		err := con.ReadJSON(&req)
+		// 读取心跳, 超过HeartbeatTimeout没有心跳, 认为runtime已经挂了
+		con.SetReadDeadline(time.Now().Add(r.HeartbeatTimeout))
+		var msg model.RuntimeMsg
+		err := con.ReadJSON(&msg)
 		if err != nil {
-			r.delRuntimeNode(req)
-			r.Warn().Msgf("gate.stream.read:%s\n", err)
+			r.disconnectRuntimeNode(who)
+			r.Warn().Msgf("gate.stream.read:%s, runtime:%s\n", err, who.Name)
 			break
 		}
 
+		// 开始执行, 必须在第一个包之后
+		if msg.Started != nil {
+			if who.Name != "" {
+				r.trackStart(who, msg.Started)
+			}
+			continue
+		}
+
+		// 执行中的心跳, 必须在第一个包之后
+		if msg.Alive != nil {
+			if who.Name != "" {
+				r.trackAlive(who, msg.Alive)
+			}
+			continue
+		}
+
+		// 收到下发的task, 必须在第一个包之后
+		if msg.Ack != nil {
+			if who.Name != "" {
+				r.ackDispatch(who, msg.Ack)
+			}
+			continue
+		}
+
+		// 执行结果, 必须在第一个包之后
+		if msg.Result != nil {
+			if who.Name != "" {
+				r.saveLastResult(who, msg.Result)
+			}
+			continue
+		}
+
+		req := msg.Whoami
+
 		// 只会起动一次
-		if runtimeNode == "" {
+		if who.Name == "" {
+			// runtime名是etcd key的一部分, 不合法的名字会破坏key的结构
+			if err = model.ValidateNodeName(req.Name); err != nil {
+				r.Warn().Msgf("gate.stream:%s\n", err)
+				break
+			}
+			r.Info().Msgf("gate.stream:runtime:%s, protocol:%s\n", req.Name, protocol)
+			rc := r.addConn(req.Name, con)
+			rc.ack.Store(r.needAck(protocol))
+			defer r.removeConn(req.Name, rc)
+			defer rc.close()
+			go r.pingLoop(rc, &lastPong, done)
+
+			go func() {
+				// 续租的goroutine退出之后继续读keepalive, 否则stream会一直阻塞在发送上
+				defer func() {
+					for range keepalive {
+					}
+				}()
+				defer r.recoverStream("gate.registerRuntimeWithKeepalive", con)
+				if err := r.registerRuntimeWithKeepalive(req, keepalive); err != nil {
+					r.Warn().Msgf("gate.stream:keepalive runtime(%s):%s\n", req.Name, err)
+				}
+			}()
 			go func() {
-				r.registerRuntimeWithKeepalive(req, keepalive)
+				defer r.recoverStream("gate.watchLocalRunq", con)
+				r.watchLocalRunq(watchCtx, &req)
 			}()
-			go r.watchLocalRunq(&req, con)
-			runtimeNode = req.Name
+			who = req
 		} else {
 			keepalive <- true
 		}
 
 	}
 }
+
+// 保存runtime上报的执行结果, 任务已经被删除时忽略
+func (r *Gate) saveLastResult(who model.Whoami, result *model.TaskResult) {
+	result.Runtime = who.Name
+	result.Truncate()
+	r.untrackTask(who, result.TaskName)
+
+	span := r.startResultSpan(who, result)
+	err := defaultStore.UpdateLastResult(r.ctx, result)
+	endSpan(span, err)
+	if errors.Is(err, etcd.ErrTaskNotFound) {
+		r.Warn().Msgf("gate.saveLastResult:ignore the result of unknown task:%s, runtime:%s", result.TaskName, who.Name)
+		return
+	}
+	if err != nil {
+		r.Warn().Msgf("gate.saveLastResult:%s, task:%s, runtime:%s", err, result.TaskName, who.Name)
+		return
+	}
+	r.appendHistory(result)
+
+	if r.OnceTaskTTL <= 0 {
+		return
+	}
+
+	expired, err := defaultStore.LockExpireOnceTask(r.ctx, result.TaskName, r.OnceTaskTTL)
+	if err != nil {
+		r.Warn().Msgf("gate.saveLastResult:expire once task:%s, task:%s", err, result.TaskName)
+		return
+	}
+	if expired {
+		r.Debug().Msgf("gate.saveLastResult:once task(%s) will be removed after %s", result.TaskName, r.OnceTaskTTL)
+	}
+}
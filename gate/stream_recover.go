@@ -0,0 +1,20 @@
+package gate
+
+import (
+	"runtime/debug"
+
+	"github.com/gorilla/websocket"
+)
+
+// 长连接相关的goroutine里面的panic, 记录日志之后关闭连接, 不影响整个gate, runtime会重新连接
+// 必须直接defer调用, recover只在defer的函数里面生效
+func (r *Gate) recoverStream(where string, con *websocket.Conn) {
+	e := recover()
+	if e == nil {
+		return
+	}
+
+	streamPanics.Inc()
+	r.Error().Msgf("%s:panic:%v\n%s", where, e, debug.Stack())
+	con.Close()
+}
@@ -0,0 +1,49 @@
+package gate
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/slog"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// goroutine里面的panic被恢复, 连接被关闭, gate进程不受影响
+func Test_RecoverStream(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled")}
+	before := testutil.ToFloat64(streamPanics)
+
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		con, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer g.recoverStream("test", con)
+			var m map[string]int
+			m["boom"]++
+		}()
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = client.ReadMessage()
+	assert.Error(t, err)
+	// 是连接被关闭, 不是读超时
+	var ne net.Error
+	assert.False(t, errors.As(err, &ne) && ne.Timeout(), "%v", err)
+	assert.Equal(t, before+1, testutil.ToFloat64(streamPanics))
+}
@@ -0,0 +1,410 @@
+package gate
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 没有配置时用默认值, 比runtime的心跳间隔短时打印警告并用默认值
+func Test_CheckHeartbeatTimeout(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.checkHeartbeatTimeout()
+	assert.Equal(t, defaultHeartbeatTimeout, g.HeartbeatTimeout)
+
+	g.HeartbeatTimeout = model.RuntimeKeepalive
+	g.checkHeartbeatTimeout()
+	assert.Equal(t, defaultHeartbeatTimeout, g.HeartbeatTimeout)
+
+	g.HeartbeatTimeout = model.RuntimeKeepalive + time.Second
+	g.checkHeartbeatTimeout()
+	assert.Equal(t, model.RuntimeKeepalive+time.Second, g.HeartbeatTimeout)
+}
+
+// runtime不再发心跳, gate超时之后断开连接, 并删除runtime的节点信息
+func Test_Stream_HeartbeatTimeout(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = 300 * time.Millisecond
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	assert.NoError(t, client.WriteJSON(who))
+
+	nodeCount := func() int {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+		assert.NoError(t, err)
+		return len(rsp.Kvs)
+	}
+	assert.Eventually(t, func() bool { return nodeCount() == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	// 心跳间隔内连接还在
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, client.WriteJSON(who))
+	_, ok := g.getConn(who.Name)
+	assert.True(t, ok)
+
+	// 不再发送心跳, 连上之后先收到syncdone, 然后连接被断开
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var done model.Param
+	assert.NoError(t, client.ReadJSON(&done))
+	assert.True(t, done.IsSyncDone())
+	_, _, err = client.ReadMessage()
+	assert.Error(t, err)
+
+	assert.Eventually(t, func() bool { return nodeCount() == 0 }, 3*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { _, ok := g.getConn(who.Name); return !ok }, time.Second, 10*time.Millisecond)
+}
+
+// 超过MaxRuntimeConns的连接在升级之前返回503
+func Test_Stream_MaxRuntimeConns(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = 3 * time.Second
+	g.MaxRuntimeConns = 1
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + model.TASK_STREAM_URL
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, rsp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, rsp) {
+		assert.Equal(t, 503, rsp.StatusCode)
+	}
+
+	// 断开之后名额释放
+	client.Close()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&g.runtimeCount) == 0 }, 3*time.Second, 10*time.Millisecond)
+	client, _, err = websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	client.Close()
+}
+
+// runtime通过长连接上报执行结果, 写入任务的状态, 已经删除的任务忽略
+func Test_Stream_ReportResult(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = 3 * time.Second
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+	defer defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+	assert.NoError(t, client.WriteJSON(who))
+
+	for _, result := range []model.TaskResult{
+		{TaskName: uuid.New().String(), Stdout: "unknown task"},
+		{TaskName: taskName, ExitCode: 2, Stdout: strings.Repeat("a", model.MaxResultTail+10), Stderr: "boom", Duration: time.Second},
+	} {
+		result := result
+		assert.NoError(t, client.WriteJSON(model.RuntimeMsg{Whoami: who, Result: &result}))
+	}
+
+	getState := func() model.State {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return state
+	}
+
+	assert.Eventually(t, func() bool { return getState().LastResult != nil }, 3*time.Second, 10*time.Millisecond)
+	state := getState()
+	assert.True(t, state.IsCanRun())
+	assert.Equal(t, 2, state.LastResult.ExitCode)
+	assert.Equal(t, "boom", state.LastResult.Stderr)
+	assert.Equal(t, who.Name, state.LastResult.Runtime)
+	assert.Len(t, state.LastResult.Stdout, model.MaxResultTail)
+	assert.Equal(t, time.Second, state.LastResult.Duration)
+
+	defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who))
+}
+
+// 连接还在但是不回pong, gate按ping/pong超时断开; 正常读的客户端会自动回pong
+func Test_Stream_PingPong(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = 10 * time.Second
+	g.PingInterval = 50 * time.Millisecond
+	g.WriteTime = time.Second
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	dial := func() (*websocket.Conn, model.Whoami) {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+		assert.NoError(t, err)
+		who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+		assert.NoError(t, client.WriteJSON(who))
+		assert.Eventually(t, func() bool { _, ok := g.getConn(who.Name); return ok }, 3*time.Second, 10*time.Millisecond)
+		return client, who
+	}
+
+	// 读消息的时候会自动回pong
+	alive, aliveWho := dial()
+	defer alive.Close()
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 不读消息, pong不会发出去
+	halfOpen, halfOpenWho := dial()
+	defer halfOpen.Close()
+
+	assert.Eventually(t, func() bool { _, ok := g.getConn(halfOpenWho.Name); return !ok }, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(pongWaitPings * 2 * g.PingInterval)
+	_, ok := g.getConn(aliveWho.Name)
+	assert.True(t, ok)
+
+	for _, who := range []model.Whoami{aliveWho, halfOpenWho} {
+		defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who))
+	}
+}
+
+// 一次性任务上报结果之后绑定租约, 到期自动删除, cron任务不受影响
+func Test_SaveLastResult_ExpireOnceTask(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.OnceTaskTTL = 30 * time.Second
+	var err error
+
+	create := func(trigger model.Trigger) string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: trigger}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	lease := func(key string) int64 {
+		rsp, err := defaultKVC.Get(g.ctx, key)
+		assert.NoError(t, err)
+		assert.Len(t, rsp.Kvs, 1)
+		return rsp.Kvs[0].Lease
+	}
+
+	once := create(model.Trigger{})
+	cron := create(model.Trigger{Cron: "* * * * * *"})
+	who := model.Whoami{Name: "runtime-1"}
+	for _, taskName := range []string{once, cron} {
+		g.saveLastResult(who, &model.TaskResult{TaskName: taskName, EndTime: time.Now()})
+	}
+
+	leaseID := lease(model.FullGlobalTask(once))
+	assert.NotZero(t, leaseID)
+	assert.Equal(t, leaseID, lease(model.FullGlobalTaskState(once)))
+	ttl, err := defautlClient.TimeToLive(g.ctx, clientv3.LeaseID(leaseID))
+	assert.NoError(t, err)
+	assert.True(t, ttl.TTL > 0 && ttl.TTL <= 30, ttl.TTL)
+
+	// 再次上报不会换租约
+	g.saveLastResult(who, &model.TaskResult{TaskName: once, EndTime: time.Now()})
+	assert.Equal(t, leaseID, lease(model.FullGlobalTask(once)))
+	assert.Equal(t, leaseID, lease(model.FullGlobalTaskState(once)))
+
+	assert.Zero(t, lease(model.FullGlobalTask(cron)))
+	assert.Zero(t, lease(model.FullGlobalTaskState(cron)))
+	defautlClient.Revoke(g.ctx, clientv3.LeaseID(leaseID))
+}
+
+// 开启压缩之后和支持压缩的runtime协商成功, 不支持的runtime退回不压缩
+func Test_Stream_Compression(t *testing.T) {
+	compressDialer := *websocket.DefaultDialer
+	compressDialer.EnableCompression = true
+
+	for _, tc := range []struct {
+		enable   bool
+		dialer   *websocket.Dialer
+		deflated bool
+	}{
+		{true, &compressDialer, true},
+		{true, websocket.DefaultDialer, false},
+		{false, &compressDialer, false},
+	} {
+		g := testInitEtcdGate(t)
+		g.HeartbeatTimeout = 3 * time.Second
+		g.WsCompression = tc.enable
+		g.upgrader = g.newUpgrader()
+
+		router := gin.New()
+		router.GET(model.TASK_STREAM_URL, g.stream)
+		srv := httptest.NewServer(router)
+
+		client, rsp, err := tc.dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.deflated, strings.Contains(rsp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"), tc)
+
+		client.EnableWriteCompression(true)
+		who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+		assert.NoError(t, client.WriteJSON(who))
+
+		var done model.Param
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		assert.NoError(t, client.ReadJSON(&done))
+		assert.True(t, done.IsSyncDone())
+
+		client.Close()
+		srv.Close()
+		defaultKVC.Delete(g.ctx, model.FullRuntimeNode(who))
+	}
+}
+
+// runtime名不合法时直接断开连接, 不注册节点
+func Test_Stream_InvalidName(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = time.Second
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+model.TASK_STREAM_URL, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	who := model.Whoami{Name: uuid.New().String() + "/x", Id: uuid.New().String()}
+	assert.NoError(t, client.WriteJSON(who))
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = client.ReadMessage()
+	assert.Error(t, err)
+	_, ok := g.getConn(who.Name)
+	assert.False(t, ok)
+}
+
+// 此函数依赖etcd是否存在
+// 老的runtime不带子协议也能连上, 带了支持的子协议时返回协商的版本, 一个都不支持时收到close帧
+func Test_Stream_Subprotocol(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = time.Second
+	g.upgrader = g.newUpgrader()
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + model.TASK_STREAM_URL
+
+	for _, tc := range []struct {
+		offered []string
+		need    string
+	}{
+		{nil, ""},
+		{[]string{"crab.v99", model.StreamProtocolV1}, model.StreamProtocolV1},
+		{model.StreamProtocols, model.StreamProtocolV2},
+	} {
+		d := *websocket.DefaultDialer
+		d.Subprotocols = tc.offered
+		client, _, err := d.Dial(url, nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tc.need, client.Subprotocol())
+			client.Close()
+		}
+	}
+
+	d := *websocket.DefaultDialer
+	d.Subprotocols = []string{"crab.v99"}
+	client, _, err := d.Dial(url, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseProtocolError), "%v", err)
+	assert.Contains(t, err.Error(), model.StreamProtocolV1)
+}
+
+// 此函数依赖etcd是否存在
+// runtime反复连上断开, 每个连接的协程(心跳续约, 本地队列watch)都要退出, 协程数不会一直涨
+func Test_Stream_NoGoroutineLeak(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.HeartbeatTimeout = 3 * time.Second
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + model.TASK_STREAM_URL
+
+	connect := func() {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		who := model.Whoami{Name: uuid.New().String(), Id: uuid.New().String()}
+		assert.NoError(t, client.WriteJSON(who))
+		assert.Eventually(t, func() bool {
+			rsp, err := defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+			return err == nil && len(rsp.Kvs) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		client.Close()
+
+		// 断开之后runtime节点信息被删除
+		assert.Eventually(t, func() bool {
+			rsp, err := defaultKVC.Get(g.ctx, model.FullRuntimeNode(who))
+			return err == nil && len(rsp.Kvs) == 0
+		}, 3*time.Second, 10*time.Millisecond)
+	}
+
+	// 先连一次, etcd客户端和http server的常驻协程不算在里面
+	connect()
+	time.Sleep(100 * time.Millisecond)
+	base := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		connect()
+	}
+
+	// assert.Eventually自己会起协程, 这里直接轮询
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), base)
+}
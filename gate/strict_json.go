@@ -0,0 +1,149 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// 严格模式下请求有问题, 返回400
+type badRequestError struct {
+	err error
+}
+
+func (b *badRequestError) Error() string {
+	return b.err.Error()
+}
+
+// 结构体的json字段, key是小写的字段名, encoding/json匹配字段时不区分大小写
+func jsonFields(typ reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			t := f.Type
+			if t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Struct {
+				jsonFields(t, fields)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+}
+
+// 找出json里面结构体没有的字段, 返回完整路径, 比如executer.http.timout
+func unknownJSONFields(v any, typ reflect.Type, prefix string, unknown []string) []string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	// 自定义解析的类型不检查
+	if reflect.PointerTo(typ).Implements(jsonUnmarshaler) {
+		return unknown
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		if typ.Kind() != reflect.Struct {
+			return unknown
+		}
+
+		fields := make(map[string]reflect.Type)
+		jsonFields(typ, fields)
+		for key, child := range val {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+			unknown = unknownJSONFields(child, fieldType, name, unknown)
+		}
+	case []any:
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			return unknown
+		}
+
+		for i, child := range val {
+			unknown = unknownJSONFields(child, typ.Elem(), fmt.Sprintf("%s[%d]", prefix, i), unknown)
+		}
+	}
+	return unknown
+}
+
+// 和ShouldBind一样, 开启StrictJSON之后, json请求里面有未知字段直接报错, 并列出所有的未知字段
+func (r *Gate) shouldBindStrict(c *gin.Context, obj any) error {
+	if !r.StrictJSON || c.ContentType() != binding.MIMEJSON {
+		return c.ShouldBind(obj)
+	}
+
+	all, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	var v any
+	if err = json.Unmarshal(all, &v); err != nil {
+		return &badRequestError{err: err}
+	}
+
+	if unknown := unknownJSONFields(v, reflect.TypeOf(obj), "", nil); len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &badRequestError{err: fmt.Errorf("unknown fields:%s", strings.Join(unknown, ", "))}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(all))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(obj); err != nil {
+		return &badRequestError{err: err}
+	}
+
+	if err = binding.Validator.ValidateStruct(obj); err != nil {
+		return &badRequestError{err: err}
+	}
+	return nil
+}
+
+// 严格模式的错误返回400, body太大返回413, 其他的错误由调用方处理
+func (r *Gate) badRequest(c *gin.Context, err error, prefix string) bool {
+	if isBodyTooLarge(err) {
+		r.error(c, model.ErrTooLarge, "%s:%v", prefix, err)
+		return true
+	}
+
+	bad, ok := err.(*badRequestError)
+	if ok {
+		r.error(c, model.ErrValidation, "%s:%v", prefix, bad)
+	}
+	return ok
+}
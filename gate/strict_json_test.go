@@ -0,0 +1,59 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 严格模式下列出所有的未知字段
+func Test_ShouldBindStrict(t *testing.T) {
+	for _, tc := range []struct {
+		strict  bool
+		body    string
+		code    int
+		unknown []string
+	}{
+		{strict: false, body: `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"t"},"timout":1}`, code: 200},
+		{strict: true, body: `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"t"}}`, code: 200},
+		// 字段名不区分大小写, 和encoding/json保持一致
+		{strict: true, body: `{"APIVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"t"}}`, code: 200},
+		{
+			strict:  true,
+			body:    `{"apiVersion":"v0.0.1","kind":"oneRuntime","timout":1,"trigger":{"cron":"* * * * * *","windows":[{"start":"09:00","end":"10:00","day":["mon"]}]},"executer":{"taskName":"t","http":{"hots":"x","querys":[{"name":"a","vaule":"b"}]}}}`,
+			code:    400,
+			unknown: []string{"executer.http.hots", "executer.http.querys[0].vaule", "timout", "trigger.windows[0].day"},
+		},
+		{strict: true, body: `{"apiVersion":`, code: 400},
+		// 缺少必填字段
+		{strict: true, body: `{"apiVersion":"v0.0.1"}`, code: 400},
+	} {
+		g := Gate{StrictJSON: tc.strict}
+		router := gin.New()
+		router.POST("/", func(c *gin.Context) {
+			var req model.Param
+			if err := g.shouldBindStrict(c, &req); err != nil {
+				_, ok := err.(*badRequestError)
+				assert.True(t, ok)
+				c.String(400, err.Error())
+				return
+			}
+			c.String(200, "")
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.code, w.Code, tc.body)
+		if len(tc.unknown) > 0 {
+			assert.Equal(t, "unknown fields:"+strings.Join(tc.unknown, ", "), w.Body.String())
+		}
+	}
+}
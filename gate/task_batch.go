@@ -0,0 +1,169 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 一个task在事务里面占三个操作(数据, 状态, 审计记录), etcd默认一个事务最多128个操作
+const maxBatchTasks = 42
+
+// 批量创建时每个task的结果
+type batchItemRsp struct {
+	TaskName string `json:"taskName"`
+	Created  bool   `json:"created"`
+	Error    string `json:"error,omitempty"`
+	// 只是因为太大不合法
+	tooLarge bool
+}
+
+// 批量创建的响应
+type batchCreateRsp struct {
+	// 创建成功时事务的revision, 所有task的revision都是这个值
+	Revision int64          `json:"revision"`
+	Items    []batchItemRsp `json:"items"`
+}
+
+// 检查批量创建的task, 有一个不合法就都不创建, 返回是否全部合法
+func (r *Gate) checkBatch(reqs []model.Param) (items []batchItemRsp, ok bool) {
+	items = make([]batchItemRsp, len(reqs))
+	seen := make(map[string]int, len(reqs))
+	ok = true
+	for i := range reqs {
+		err := r.checkCreate(&reqs[i])
+		taskName := reqs[i].Executer.TaskName
+		if err == nil {
+			if err = r.checkTaskSize(&reqs[i]); err != nil {
+				items[i].tooLarge = true
+			}
+		}
+		if err == nil {
+			if j, exists := seen[taskName]; exists {
+				err = fmt.Errorf("duplicate task name in the batch, same as item %d", j)
+			}
+			seen[taskName] = i
+		}
+
+		items[i].TaskName = taskName
+		if err != nil {
+			items[i].Error = err.Error()
+			ok = false
+		}
+	}
+	return
+}
+
+// 不合法的task都只是太大时返回413, 否则400
+func invalidBatchCode(items []batchItemRsp) model.ErrCode {
+	for _, item := range items {
+		if item.Error != "" && !item.tooLarge {
+			return model.ErrValidation
+		}
+	}
+	return model.ErrTooLarge
+}
+
+// 出错时带上每个task的结果
+func (r *Gate) batchError(c *gin.Context, code model.ErrCode, items []batchItemRsp, format string, a ...any) {
+	countTaskRequest(c, true)
+
+	msg := fmt.Sprintf(format, a...)
+	r.Error().RequestID(getRequestID(c)).Caller(1).Msg(msg)
+	rsp := newErrorRsp(code, msg)
+	rsp.Data = batchCreateRsp{Items: items}
+	c.JSON(code.Status(), rsp)
+}
+
+// 批量创建task, 所有task在一个etcd事务里面创建
+func (r *Gate) createBatch(c *gin.Context) {
+	var reqs []model.Param
+	if err := r.shouldBindStrict(c, &reqs); err != nil {
+		if r.badRequest(c, err, "createBatch") {
+			return
+		}
+		r.error(c, model.ErrValidation, "createBatch:%v", err)
+		return
+	}
+
+	if len(reqs) == 0 || len(reqs) > maxBatchTasks {
+		r.error(c, model.ErrValidation, "createBatch:the number of tasks must be in [1, %d], got %d", maxBatchTasks, len(reqs))
+		return
+	}
+
+	// 和createTask一样, 设置action和trace之后再检查大小, 检查的就是写入etcd的内容
+	for i := range reqs {
+		reqs[i].SetCreate()
+		injectTrace(c.Request.Context(), &reqs[i])
+	}
+
+	items, ok := r.checkBatch(reqs)
+	if !ok {
+		r.batchError(c, invalidBatchCode(items), items, "createBatch:invalid tasks")
+		return
+	}
+
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	for i := range reqs {
+		if err := r.checkTargetRuntime(ctx, &reqs[i]); err != nil {
+			if _, bad := err.(*badRequestError); !bad {
+				r.etcdError(c, ctx, err, "createBatch")
+				return
+			}
+			items[i].Error = err.Error()
+			ok = false
+		}
+	}
+
+	if !ok {
+		r.batchError(c, model.ErrValidation, items, "createBatch:invalid tasks")
+		return
+	}
+
+	params := make([]*model.Param, len(reqs))
+	for i := range reqs {
+		params[i] = &reqs[i]
+	}
+
+	spanCtx, span := r.startEtcdSpan(ctx, "createBatchDataAndState", model.GlobalTaskPrefix)
+	audits := make([]clientv3.Op, len(reqs))
+	for i := range reqs {
+		audits[i] = r.auditOp(c, model.Create, reqs[i].Executer.TaskName, 0)
+	}
+	revision, conflicts, err := defaultStore.CreateBatchDataAndState(spanCtx, params, audits...)
+	endSpan(span, err)
+	if err != nil {
+		if !errors.Is(err, etcd.ErrTaskExists) {
+			r.etcdError(c, ctx, err, "createBatch")
+			return
+		}
+
+		conflict := make(map[string]bool, len(conflicts))
+		for _, name := range conflicts {
+			conflict[name] = true
+		}
+		for i := range items {
+			if conflict[items[i].TaskName] {
+				items[i].Error = etcd.ErrTaskExists.Error()
+			} else {
+				items[i].Error = "not created, the batch has conflicts"
+			}
+		}
+		r.batchError(c, model.ErrDuplicateTask, items, "createBatch:%d tasks already exist", len(conflicts))
+		return
+	}
+
+	for i := range reqs {
+		items[i].Created = true
+		if err = r.statusTable.insert(paramToStatus(&reqs[i])); err != nil {
+			r.Warn().Msgf("status table:insert db fail:%s", err)
+		}
+	}
+	r.okWithData(c, "createBatch Execution succeeded", batchCreateRsp{Revision: revision, Items: items})
+}
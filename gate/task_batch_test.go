@@ -0,0 +1,124 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBatchParam(taskName string) model.Param {
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	return param
+}
+
+// 批次里面有重复的名字, 或者名字不合法的task都不创建
+func Test_CheckBatch(t *testing.T) {
+	g := Gate{NormalizeTaskName: true}
+
+	items, ok := g.checkBatch([]model.Param{testBatchParam("A"), testBatchParam("b"), testBatchParam(" a ")})
+	assert.False(t, ok)
+	assert.Equal(t, "a", items[0].TaskName)
+	assert.Empty(t, items[0].Error)
+	assert.Empty(t, items[1].Error)
+	assert.Contains(t, items[2].Error, "duplicate")
+
+	_, ok = g.checkBatch([]model.Param{testBatchParam("a"), testBatchParam("b")})
+	assert.True(t, ok)
+}
+
+// 此函数依赖etcd是否存在
+// 有一个task已经存在时整个批次都不创建, 并且返回冲突的task
+func Test_CreateBatchDataAndState(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	exists := testBatchParam(uuid.New().String())
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, exists.Executer.TaskName, &exists)
+	assert.NoError(t, err)
+
+	a, b := testBatchParam(uuid.New().String()), testBatchParam(uuid.New().String())
+	_, conflicts, err := defaultStore.CreateBatchDataAndState(g.ctx, []*model.Param{&a, &exists, &b})
+	assert.True(t, errors.Is(err, etcd.ErrTaskExists), err)
+	assert.Equal(t, []string{exists.Executer.TaskName}, conflicts)
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(a.Executer.TaskName))
+	assert.NoError(t, err)
+	assert.Len(t, rsp.Kvs, 0)
+
+	revision, conflicts, err := defaultStore.CreateBatchDataAndState(g.ctx, []*model.Param{&a, &b})
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	for _, p := range []model.Param{a, b} {
+		rsp, err = defaultKVC.Get(g.ctx, model.FullGlobalTaskState(p.Executer.TaskName))
+		assert.NoError(t, err)
+		assert.Len(t, rsp.Kvs, 1)
+		assert.Equal(t, revision, rsp.Kvs[0].ModRevision)
+		assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, p.Executer.TaskName))
+	}
+	assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, exists.Executer.TaskName))
+}
+
+// 此函数依赖etcd是否存在
+// 指定的runtime没有注册时返回400的错误
+func Test_CheckTargetRuntime(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	param := testBatchParam(uuid.New().String())
+	assert.NoError(t, g.checkTargetRuntime(g.ctx, &param))
+
+	param.Runtime = uuid.New().String()
+	err := g.checkTargetRuntime(g.ctx, &param)
+	_, bad := err.(*badRequestError)
+	assert.True(t, bad, err)
+
+	node := param.TargetRuntimeNode()
+	_, err = defaultKVC.Put(g.ctx, node, "{}")
+	assert.NoError(t, err)
+	defer defaultKVC.Delete(g.ctx, node)
+	assert.NoError(t, g.checkTargetRuntime(g.ctx, &param))
+}
+
+// 大小按写入etcd的内容检查, 算上action, 不合法的task都只是太大时返回413
+func Test_CreateBatch_TooLarge(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled")}
+	router := gin.New()
+	router.POST(model.TASK_BATCH_URL, g.createBatch)
+
+	post := func(reqs ...model.Param) *errorRsp {
+		body, err := json.Marshal(reqs)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, model.TASK_BATCH_URL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		var rsp errorRsp
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp), w.Body.String())
+		assert.Equal(t, rsp.Code.Status(), w.Code)
+		return &rsp
+	}
+
+	large := testBatchParam("large")
+	large.Action = ""
+	data, err := json.Marshal(large)
+	assert.NoError(t, err)
+	// 没有action时刚好不超过, 设置action之后超过
+	g.MaxTaskBytes = int64(len(data))
+
+	assert.Equal(t, model.ErrTooLarge, post(testBatchParam("ok"), large).Code)
+	assert.Equal(t, model.ErrValidation, post(large, testBatchParam("")).Code)
+}
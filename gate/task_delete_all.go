@@ -0,0 +1,130 @@
+package gate
+
+import (
+	"context"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const actionDeleteAll = "deleteAll"
+
+type deleteAllRsp struct {
+	// 匹配到的task个数
+	Matched int `json:"matched"`
+	Deleted int `json:"deleted"`
+	// 删除失败的task名, 可以再调用一次
+	Failed []string `json:"failed,omitempty"`
+
+	deletedTasks []etcd.DeletedTask
+}
+
+// 按task名前缀或者label批量删除task, 下线一整组task时用
+// label的格式和status接口一样是key:value, 比如DELETE /crab/task/batch?prefix=tmp-&label=team:a
+// 和单个删除一样, 删除命令通过本地队列推送给对应的runtime
+// 两个条件都没有时返回400, 避免误删所有的task
+func (r *Gate) deleteAll(c *gin.Context) {
+	prefix := c.Query("prefix")
+	selector, err := parseLabelSelector(c.QueryArray("label"))
+	if err != nil {
+		r.error(c, model.ErrValidation, "%s:%s", actionDeleteAll, err)
+		return
+	}
+
+	if prefix == "" && len(selector) == 0 {
+		r.error(c, model.ErrValidation, "%s:prefix or label is required", actionDeleteAll)
+		return
+	}
+
+	rsp, err := r.deleteAllTasks(c.Request.Context(), prefix, selector, r.auditFunc(c, model.Rm))
+	if err != nil {
+		r.etcdError(c, c.Request.Context(), err, actionDeleteAll)
+		return
+	}
+
+	for _, t := range rsp.deletedTasks {
+		var req model.OnlyParam
+		req.Action = model.Rm
+		req.Executer.TaskName = t.TaskName
+		if err = r.statusTable.delete(onlyParamToStatus(req, model.State{})); err != nil {
+			r.Warn().Msgf("status table:delete db fail:%s", err)
+		}
+		r.deleteHistory(c.Request.Context(), t.TaskName)
+	}
+
+	r.okWithData(c, actionDeleteAll+" Execution succeeded", rsp)
+}
+
+// audit不为nil时审计记录和删除在同一个事务里面写入
+func (r *Gate) deleteAllTasks(ctx context.Context, prefix string, selector map[string]string, audit auditFunc) (rsp deleteAllRsp, err error) {
+	names, err := r.scanTasksByPrefix(ctx, prefix, selector)
+	if err != nil {
+		return rsp, err
+	}
+	rsp.Matched = len(names)
+
+	var extra func(etcd.DeletedTask) clientv3.Op
+	if audit != nil {
+		extra = func(t etcd.DeletedTask) clientv3.Op { return audit(t.TaskName, t.Revision) }
+	}
+	deleted, failed := defaultStore.DeleteBatchDataAndState(ctx, names, extra)
+	for _, name := range names {
+		if err, ok := failed[name]; ok {
+			r.Warn().Msgf("%s:delete %s:%s", actionDeleteAll, name, err)
+			rsp.Failed = append(rsp.Failed, name)
+		}
+	}
+
+	rsp.Deleted = len(deleted)
+	rsp.deletedTasks = deleted
+	return rsp, nil
+}
+
+// 取出名字以prefix开头的task, 有label条件时再按label过滤
+func (r *Gate) scanTasksByPrefix(ctx context.Context, prefix string, selector map[string]string) ([]string, error) {
+	if len(selector) > 0 {
+		names, err := r.scanTasksByLabels(ctx, selector)
+		if err != nil {
+			return nil, err
+		}
+
+		match := names[:0]
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				match = append(match, name)
+			}
+		}
+		return match, nil
+	}
+
+	key := model.FullGlobalTask(prefix)
+	end := clientv3.GetPrefixRangeEnd(key)
+	names := []string{}
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(labelScanBatch), clientv3.WithKeysOnly()}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		gctx, cancel := context.WithTimeout(ctx, r.etcdOpTimeout())
+		rsp, err := defaultKVC.Get(gctx, key, opts...)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		rev = rsp.Header.Revision
+
+		for _, kv := range rsp.Kvs {
+			names = append(names, model.TaskName(string(kv.Key)))
+		}
+
+		if !rsp.More || len(rsp.Kvs) == 0 {
+			return names, nil
+		}
+		key = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
+	}
+}
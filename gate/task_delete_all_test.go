@@ -0,0 +1,69 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 此函数依赖etcd是否存在
+// 按前缀和label批量删除, 不匹配的task不受影响
+func Test_DeleteAllTasks(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	group := uuid.New().String()
+	create := func(taskName string, labels map[string]string) string {
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}, Labels: labels}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	exists := func(taskName string) bool {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName), clientv3.WithCountOnly())
+		assert.NoError(t, err)
+		return rsp.Count > 0
+	}
+
+	a := create(group+"-a", map[string]string{"team": "x"})
+	b := create(group+"-b", map[string]string{"team": "y"})
+	c := create(group+"-c", map[string]string{"team": "x"})
+	other := create(uuid.New().String(), map[string]string{"team": "x"})
+
+	// 前缀和label同时指定时取交集
+	rsp, err := g.deleteAllTasks(g.ctx, group, map[string]string{"team": "x"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rsp.Matched)
+	assert.Equal(t, 2, rsp.Deleted)
+	assert.Empty(t, rsp.Failed)
+	assert.False(t, exists(a))
+	assert.False(t, exists(c))
+	assert.True(t, exists(b))
+	assert.True(t, exists(other))
+
+	rsp, err = g.deleteAllTasks(g.ctx, group, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rsp.Matched)
+	assert.Equal(t, 1, rsp.Deleted)
+	assert.False(t, exists(b))
+	assert.True(t, exists(other))
+
+	// 没有条件时不删除任何task
+	router := gin.New()
+	router.DELETE(model.TASK_DELETE_BATCH_URL, g.deleteAll)
+	router.POST(model.TASK_BATCH_URL, func(c *gin.Context) {})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, model.TASK_DELETE_BATCH_URL, nil))
+	assert.Equal(t, 400, w.Code, w.Body.String())
+	assert.True(t, exists(other))
+}
@@ -0,0 +1,62 @@
+package gate
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 单个task的详情
+type taskDetailRsp struct {
+	TaskName string `json:"taskName"`
+	// 数据的revision, 更新时通过X-Task-Revision带回来
+	Revision int64        `json:"revision"`
+	Task     *model.Param `json:"task"`
+	State    *model.State `json:"state"`
+}
+
+// 获取单个task保存的数据和当前的状态
+func (r *Gate) taskDetail(c *gin.Context) {
+	taskName := c.Param("name")
+	if err := model.ValidateTaskName(taskName); err != nil {
+		r.error(c, model.ErrValidation, "taskDetail:%s", err)
+		return
+	}
+
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	// 数据和状态在同一个revision下读取
+	txn, err := r.etcdGetTxn(ctx, clientv3.OpGet(model.FullGlobalTask(taskName)), clientv3.OpGet(model.FullGlobalTaskState(taskName)))
+	if err != nil {
+		r.etcdError(c, ctx, err, "taskDetail")
+		return
+	}
+
+	task, state := txn.Responses[0].GetResponseRange(), txn.Responses[1].GetResponseRange()
+	if len(task.Kvs) == 0 {
+		r.error(c, model.ErrNotFound, "taskDetail:task not found:%s", taskName)
+		return
+	}
+
+	rsp := taskDetailRsp{TaskName: taskName, Revision: task.Kvs[0].ModRevision}
+	if err = json.Unmarshal(task.Kvs[0].Value, &rsp.Task); err != nil {
+		r.error(c, model.ErrInternal, "taskDetail:unmarshal task:%s", err)
+		return
+	}
+
+	if len(state.Kvs) > 0 {
+		s, err := model.ValueToState(state.Kvs[0].Value)
+		if err != nil {
+			r.error(c, model.ErrInternal, "taskDetail:unmarshal state:%s", err)
+			return
+		}
+		rsp.State = &s
+	}
+
+	c.Header(revisionHeader, strconv.FormatInt(rsp.Revision, 10))
+	r.okWithData(c, "taskDetail succeeded", rsp)
+}
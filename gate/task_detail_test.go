@@ -0,0 +1,57 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 返回task保存的数据和状态, 不存在时返回404
+func Test_TaskDetail(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	revision, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+	defer defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+
+	router := gin.New()
+	router.GET(model.TASK_STREAM_URL, g.stream)
+	router.GET(model.TASK_DETAIL_URL, g.taskDetail)
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/crab/task/"+name, nil))
+		return w
+	}
+
+	w := get(taskName)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.Equal(t, strconv.FormatInt(revision, 10), w.Header().Get(revisionHeader))
+
+	var rsp struct {
+		Data taskDetailRsp `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, taskName, rsp.Data.TaskName)
+	assert.Equal(t, revision, rsp.Data.Revision)
+	if assert.NotNil(t, rsp.Data.Task) {
+		assert.Equal(t, "* * * * * *", rsp.Data.Task.Trigger.Cron)
+	}
+	if assert.NotNil(t, rsp.Data.State) {
+		assert.Equal(t, model.CanRun, rsp.Data.State.State)
+	}
+
+	assert.Equal(t, 404, get(uuid.New().String()).Code)
+}
@@ -0,0 +1,248 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin/binding"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// 目录里面的一个task定义
+type taskFile struct {
+	path  string
+	param model.Param
+}
+
+// 对账的统计结果
+type reconcileSummary struct {
+	created, updated, deleted, unchanged, failed int
+}
+
+// 读取目录下面的yaml/json文件, 每个文件是一个task
+// 有问题的文件记录在errs里面, 不影响别的文件
+func loadTaskDir(dir string, normalize bool) (tasks []taskFile, errs []error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	names := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		all, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var param model.Param
+		if ext == ".json" {
+			err = json.Unmarshal(all, &param)
+		} else {
+			err = yaml.Unmarshal(all, &param)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%w", path, err))
+			continue
+		}
+
+		if normalize {
+			param.Executer.TaskName = normalizeTaskName(param.Executer.TaskName)
+		}
+
+		if err = binding.Validator.ValidateStruct(&param); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%w", path, err))
+			continue
+		}
+
+		if err = param.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%w", path, err))
+			continue
+		}
+
+		taskName := param.Executer.TaskName
+		if other, ok := names[taskName]; ok {
+			errs = append(errs, fmt.Errorf("%s:duplicate task name(%s), already defined in %s", path, taskName, other))
+			continue
+		}
+		names[taskName] = path
+
+		tasks = append(tasks, taskFile{path: path, param: param})
+	}
+	return
+}
+
+// 比较task定义是否一致, 忽略gate自己维护的字段
+func sameTask(a, b model.Param) bool {
+	a.Action, b.Action = "", ""
+	a.Trace, b.Trace = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// 启动时把TaskDir里面的task同步到etcd, 文件是期望的状态
+// 不存在的创建, 有变化的更新, TaskDirPrune打开时删除文件里面没有的task
+func (r *Gate) reconcileTaskDir() error {
+	tasks, errs := loadTaskDir(r.TaskDir, r.NormalizeTaskName)
+	for _, err := range errs {
+		r.Error().Msgf("reconcileTaskDir:%s", err)
+	}
+
+	if len(errs) > 0 && r.TaskDirStrict {
+		return fmt.Errorf("reconcileTaskDir: %d invalid task file(s) in %s", len(errs), r.TaskDir)
+	}
+
+	var sum reconcileSummary
+	sum.failed = len(errs)
+	desired := make(map[string]bool, len(tasks))
+	for i := range tasks {
+		t := &tasks[i]
+		desired[t.param.Executer.TaskName] = true
+		if err := r.reconcileTask(t, &sum); err != nil {
+			sum.failed++
+			r.Error().Msgf("reconcileTaskDir:%s:%s", t.path, err)
+		}
+	}
+
+	if r.TaskDirPrune {
+		if err := r.pruneTasks(desired, &sum); err != nil {
+			r.Error().Msgf("reconcileTaskDir:prune:%s", err)
+		}
+	}
+
+	r.Info().Msgf("reconcileTaskDir(%s): created:%d, updated:%d, deleted:%d, unchanged:%d, failed:%d",
+		r.TaskDir, sum.created, sum.updated, sum.deleted, sum.unchanged, sum.failed)
+	if sum.failed > 0 && r.TaskDirStrict {
+		return fmt.Errorf("reconcileTaskDir: %d task(s) failed", sum.failed)
+	}
+	return nil
+}
+
+// 同步单个task
+func (r *Gate) reconcileTask(t *taskFile, sum *reconcileSummary) error {
+	taskName := t.param.Executer.TaskName
+	globalTaskName := model.FullGlobalTask(taskName)
+
+	rsp, err := defaultKVC.Get(r.ctx, globalTaskName)
+	if err != nil {
+		return err
+	}
+
+	if len(rsp.Kvs) == 0 {
+		t.param.SetCreate()
+		if _, err = defaultStore.LockCreateDataAndState(r.ctx, taskName, &t.param); err != nil {
+			return err
+		}
+
+		if err = r.statusTable.insert(paramToStatus(&t.param)); err != nil {
+			r.Warn().Msgf("status table:insert db fail:%s", err)
+		}
+		sum.created++
+		return nil
+	}
+
+	var current model.Param
+	if err = json.Unmarshal(rsp.Kvs[0].Value, &current); err != nil {
+		return err
+	}
+
+	// 已经删除的task, 等同于不存在, 这里没办法重新创建, 留给下次启动
+	if current.IsRemove() {
+		return fmt.Errorf("task(%s) is being removed", taskName)
+	}
+
+	if sameTask(current, t.param) {
+		sum.unchanged++
+		return nil
+	}
+
+	t.param.SetUpdate()
+	_, err = defaultStore.LockUpdateDataAndState(r.ctx, taskName, &t.param, rsp.Kvs[0].ModRevision, model.CanRun, model.Update)
+	if err != nil {
+		return err
+	}
+
+	if err = r.statusTable.updateParam(&t.param); err != nil {
+		r.Warn().Msgf("status table:update db fail:%s", err)
+	}
+	sum.updated++
+	return nil
+}
+
+// 删除目录里面没有定义的task
+// 和deleteTask一样直接删除数据和状态, 删除命令通过本地队列推送给runtime
+func (r *Gate) pruneTasks(desired map[string]bool, sum *reconcileSummary) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	rsp, err := defaultKVC.Get(ctx, model.GlobalTaskPrefix+"/", clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		var current model.Param
+		if err := json.Unmarshal(kv.Value, &current); err == nil && current.IsRemove() {
+			continue
+		}
+
+		taskName := model.TaskName(string(kv.Key))
+		if desired[taskName] {
+			continue
+		}
+		names = append(names, taskName)
+	}
+	sort.Strings(names)
+
+	for _, taskName := range names {
+		if err := r.pruneTask(taskName); err != nil {
+			if errors.Is(err, etcd.ErrTaskNotFound) {
+				continue
+			}
+			sum.failed++
+			r.Error().Msgf("reconcileTaskDir:remove task(%s):%s", taskName, err)
+			continue
+		}
+
+		var req model.OnlyParam
+		req.Action = model.Rm
+		req.Executer.TaskName = taskName
+		if err := r.statusTable.delete(onlyParamToStatus(req, model.State{})); err != nil {
+			r.Warn().Msgf("status table:delete db fail:%s", err)
+		}
+		r.Info().Msgf("reconcileTaskDir:remove task(%s)", taskName)
+		sum.deleted++
+	}
+	return nil
+}
+
+// 每个task单独超时, task很多时整个删除可能超过EtcdOpTimeout
+func (r *Gate) pruneTask(taskName string) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	if err := defaultStore.LockDeleteDataAndState(ctx, taskName); err != nil {
+		return err
+	}
+	r.deleteHistory(ctx, taskName)
+	return nil
+}
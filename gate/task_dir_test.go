@@ -0,0 +1,82 @@
+package gate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 有问题的文件单独报错, 不影响别的文件
+func Test_LoadTaskDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	write("a.yaml", `
+apiVersion: v0.0.1
+kind: oneRuntime
+trigger:
+  cron: "* * * * * *"
+executer:
+  taskName: " Task  A"
+  shell:
+    command: echo
+    args: ["a"]
+`)
+	write("b.json", `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"task-b"}}`)
+	// 缺少必填字段
+	write("c.yml", "apiVersion: v0.0.1\nexecuter:\n  taskName: task-c\n")
+	// task名和a.yaml重复
+	write("d.json", `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"task a"}}`)
+	write("readme.md", "ignore")
+
+	tasks, errs := loadTaskDir(dir, true)
+	assert.Len(t, errs, 2)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, "task a", tasks[0].param.Executer.TaskName)
+	assert.Equal(t, "task-b", tasks[1].param.Executer.TaskName)
+
+	_, errs = loadTaskDir(filepath.Join(dir, "not-exist"), false)
+	assert.Len(t, errs, 1)
+}
+
+// gate维护的字段不算变化
+func Test_SameTask(t *testing.T) {
+	a := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime"}
+	a.Executer.TaskName = "task"
+	b := a
+	b.Action = model.Create
+	b.Trace = map[string]string{"traceparent": "x"}
+	assert.True(t, sameTask(a, b))
+
+	b.Trigger.Cron = "* * * * * *"
+	assert.False(t, sameTask(a, b))
+}
+
+// 此函数依赖etcd是否存在
+// 目录里面没有的task直接删除数据和状态, 已经不存在时返回ErrTaskNotFound
+func Test_PruneTask(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+
+	assert.NoError(t, g.pruneTask(taskName))
+	for _, key := range []string{model.FullGlobalTask(taskName), model.FullGlobalTaskState(taskName)} {
+		rsp, err := defaultKVC.Get(g.ctx, key)
+		assert.NoError(t, err)
+		assert.Len(t, rsp.Kvs, 0, key)
+	}
+
+	assert.ErrorIs(t, g.pruneTask(taskName), etcd.ErrTaskNotFound)
+}
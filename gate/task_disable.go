@@ -0,0 +1,120 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	actionDisable = "disable"
+	actionEnable  = "enable"
+)
+
+// 禁用或者启用task, 修改的是task数据里面的Disabled字段
+// 1.运行中的task按update下发, runtime收到禁用的task之后停止调度
+// 2.已经stop的task只修改数据, continue的时候带上这个字段
+func (r *Gate) setTaskDisabled(disabled bool) gin.HandlerFunc {
+	action := actionEnable
+	if disabled {
+		action = actionDisable
+	}
+
+	return func(c *gin.Context) {
+		taskName := c.Param("name")
+		if err := model.ValidateTaskName(taskName); err != nil {
+			r.error(c, model.ErrValidation, "%s:%s", action, err)
+			return
+		}
+
+		ctx, cancel := r.etcdCtx(c)
+		defer cancel()
+
+		globalTaskName := model.FullGlobalTask(taskName)
+		txn, err := r.etcdGetTxn(ctx, clientv3.OpGet(globalTaskName), clientv3.OpGet(model.FullGlobalTaskState(taskName)))
+		if err != nil {
+			r.etcdError(c, ctx, err, action)
+			return
+		}
+
+		task, stateRsp := txn.Responses[0].GetResponseRange(), txn.Responses[1].GetResponseRange()
+		if len(task.Kvs) == 0 || len(stateRsp.Kvs) == 0 {
+			r.error(c, model.ErrNotFound, "%s:task not found:%s", action, taskName)
+			return
+		}
+
+		var req model.Param
+		if err = json.Unmarshal(task.Kvs[0].Value, &req); err != nil {
+			r.error(c, model.ErrInternal, "%s:unmarshal task:%s", action, err)
+			return
+		}
+
+		state, err := model.ValueToState(stateRsp.Kvs[0].Value)
+		if err != nil {
+			r.error(c, model.ErrInternal, "%s:unmarshal state:%s", action, err)
+			return
+		}
+
+		revision := task.Kvs[0].ModRevision
+		rsp := taskRevisionRsp{TaskName: taskName, Revision: revision, Key: globalTaskName, State: state.State}
+		// 已经是这个状态了, 不需要再下发
+		if req.Disabled == disabled {
+			c.Header(revisionHeader, strconv.FormatInt(revision, 10))
+			r.okWithData(c, action+" Execution succeeded", rsp)
+			return
+		}
+
+		req.Disabled = disabled
+		if state.IsStop() {
+			err = defaultStore.LockUnlock(ctx, taskName, func() (err error) {
+				rsp.Revision, err = r.putTaskData(ctx, &req, revision, r.auditOp(c, action, taskName, revision))
+				return err
+			})
+		} else {
+			req.SetUpdate()
+			injectTrace(c.Request.Context(), &req)
+			rsp.Revision, err = defaultStore.LockUpdateDataAndState(ctx, taskName, &req, revision, model.CanRun, model.Update,
+				r.auditOp(c, action, taskName, revision))
+			rsp.State = model.CanRun
+		}
+		if err != nil {
+			if errors.Is(err, etcd.ErrRevisionMismatch) {
+				r.error(c, model.ErrConflict, "%s:task has been modified, revision(%d)", action, revision)
+				return
+			}
+			r.etcdError(c, ctx, err, action)
+			return
+		}
+
+		c.Header(revisionHeader, strconv.FormatInt(rsp.Revision, 10))
+		r.okWithData(c, action+" Execution succeeded", rsp)
+	}
+}
+
+// 只更新task数据, 不修改状态, 不会下发到runtime
+func (r *Gate) putTaskData(ctx context.Context, req *model.Param, revision int64, extra ...clientv3.Op) (int64, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+
+	globalTaskName := model.FullGlobalTask(req.Executer.TaskName)
+	txn, err := defaultKVC.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(globalTaskName), "=", revision)).
+		Then(append([]clientv3.Op{clientv3.OpPut(globalTaskName, string(data))}, extra...)...).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !txn.Succeeded {
+		return 0, fmt.Errorf("%w:%s", etcd.ErrRevisionMismatch, req.Executer.TaskName)
+	}
+	return txn.Header.Revision, nil
+}
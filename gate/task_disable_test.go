@@ -0,0 +1,88 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 禁用和启用修改task数据里面的Disabled字段, 已经stop的task不修改状态
+func Test_SetTaskDisabled(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	router := gin.New()
+	router.POST(model.TASK_DISABLE_URL, g.setTaskDisabled(true))
+	router.POST(model.TASK_ENABLE_URL, g.setTaskDisabled(false))
+
+	post := func(taskName, action string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, strings.Replace(model.TASK_DISABLE_URL, ":name/disable", taskName+"/"+action, 1), nil))
+		return w
+	}
+
+	get := func(taskName string) (param model.Param, state model.State) {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &param))
+		rsp, err = defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		state, err = model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return
+	}
+
+	create := func() string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	// 运行中的task按update下发
+	taskName := create()
+	w := post(taskName, actionDisable)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	param, state := get(taskName)
+	assert.True(t, param.Disabled)
+	assert.True(t, state.IsUpdate())
+
+	// 重复禁用不再下发
+	revision := w.Header().Get(revisionHeader)
+	w = post(taskName, actionDisable)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.Equal(t, revision, w.Header().Get(revisionHeader))
+
+	w = post(taskName, actionEnable)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	param, _ = get(taskName)
+	assert.False(t, param.Disabled)
+
+	// 已经stop的task只修改数据
+	taskName = create()
+	var req model.OnlyParam
+	req.Executer.TaskName = taskName
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+	assert.NoError(t, err)
+	_, err = defaultStore.LockUpdateAction(g.ctx, taskName, &req, rsp.Kvs[0].ModRevision, model.CanRun, model.Stop)
+	assert.NoError(t, err)
+
+	w = post(taskName, actionDisable)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	param, state = get(taskName)
+	assert.True(t, param.Disabled)
+	assert.True(t, state.IsStop())
+
+	assert.Equal(t, 404, post(uuid.New().String(), actionDisable).Code)
+}
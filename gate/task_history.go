@@ -0,0 +1,164 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const defaultTaskHistoryLimit = 100
+
+// 一次执行的记录, 输出只保存在最近一次的结果里面, 历史里面不保存
+type taskRun struct {
+	ID        string        `json:"id"`
+	Runtime   string        `json:"runtime"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Status    string        `json:"status"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"duration"`
+}
+
+type pageHistory struct {
+	Limit int64 `form:"limit" json:"limit"`
+	// 和audit接口一样的游标分页, 按时间升序, 结果包含start_key本身
+	StartKey string `form:"start_key" json:"start_key"`
+}
+
+// 没有配置时默认保留100次, 负数表示不保存历史
+func (r *Gate) taskHistoryLimit() int {
+	if r.TaskHistoryLimit == 0 {
+		return defaultTaskHistoryLimit
+	}
+	return r.TaskHistoryLimit
+}
+
+func resultToRun(result *model.TaskResult) taskRun {
+	run := taskRun{
+		ID:        newAuditID(result.EndTime),
+		Runtime:   result.Runtime,
+		StartTime: result.EndTime.Add(-result.Duration),
+		EndTime:   result.EndTime,
+		Status:    "success",
+		ExitCode:  result.ExitCode,
+		Duration:  result.Duration,
+	}
+	if result.ExitCode != 0 {
+		run.Status = "failed"
+	}
+	return run
+}
+
+// 追加一条执行历史, 超过上限时删掉最老的, 写失败只打印日志
+func (r *Gate) appendHistory(result *model.TaskResult) {
+	limit := r.taskHistoryLimit()
+	if limit < 0 {
+		return
+	}
+
+	run := resultToRun(result)
+	value, err := json.Marshal(run)
+	if err != nil {
+		r.Warn().Msgf("gate.appendHistory:marshal:%s, task:%s", err, result.TaskName)
+		return
+	}
+
+	prefix := model.FullTaskHistory(result.TaskName)
+	if _, err = defaultKVC.Put(r.ctx, prefix+run.ID, string(value)); err != nil {
+		r.Warn().Msgf("gate.appendHistory:%s, task:%s", err, result.TaskName)
+		return
+	}
+
+	if err = r.trimHistory(r.ctx, prefix, limit); err != nil {
+		r.Warn().Msgf("gate.appendHistory:trim:%s, task:%s", err, result.TaskName)
+	}
+}
+
+// 只保留最近limit条
+func (r *Gate) trimHistory(ctx context.Context, prefix string, limit int) error {
+	total, err := defaultKVC.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+
+	n := total.Count - int64(limit)
+	if n <= 0 {
+		return nil
+	}
+
+	old, err := defaultKVC.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithLimit(n))
+	if err != nil || len(old.Kvs) == 0 {
+		return err
+	}
+
+	// 删除[prefix, 最后一个要删的key]这个范围
+	_, err = defaultKVC.Delete(ctx, prefix, clientv3.WithRange(string(old.Kvs[len(old.Kvs)-1].Key)+"\x00"))
+	return err
+}
+
+// 删除任务的时候一起删掉执行历史
+func (r *Gate) deleteHistory(ctx context.Context, taskName string) {
+	if _, err := defaultKVC.Delete(ctx, model.FullTaskHistory(taskName), clientv3.WithPrefix()); err != nil {
+		r.Warn().Msgf("gate.deleteHistory:%s, task:%s", err, taskName)
+	}
+}
+
+// 任务的执行历史, 按时间升序
+func (r *Gate) taskHistory(c *gin.Context) {
+	taskName := c.Param("name")
+	if err := model.ValidateTaskName(taskName); err != nil {
+		r.error(c, model.ErrValidation, "taskHistory:%s", err)
+		return
+	}
+
+	p := pageHistory{}
+	if err := c.ShouldBindQuery(&p); err != nil {
+		r.error(c, model.ErrValidation, "taskHistory:%s", err)
+		return
+	}
+
+	if p.Limit <= 0 {
+		p.Limit = defaultStatusLimit
+	}
+
+	ctx, cancel := r.etcdCtx(c)
+	defer cancel()
+
+	prefix := model.FullTaskHistory(taskName)
+	total, err := r.etcdGet(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		r.etcdError(c, ctx, err, "taskHistory")
+		return
+	}
+
+	rsp, err := r.etcdGet(ctx, prefix+p.StartKey,
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		clientv3.WithLimit(p.Limit))
+	if err != nil {
+		r.etcdError(c, ctx, err, "taskHistory")
+		return
+	}
+
+	list := make([]taskRun, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		var run taskRun
+		if err := json.Unmarshal(kv.Value, &run); err != nil {
+			r.Warn().Msgf("taskHistory:unmarshal %s:%s", kv.Key, err)
+			continue
+		}
+		list = append(list, run)
+	}
+
+	next := ""
+	if rsp.More && len(list) > 0 {
+		next = list[len(list)-1].ID + "\x00"
+	}
+
+	c.JSON(200, wrapData{Data: taskStatusList{Total: total.Count, Items: list, NextStartKey: next}})
+}
@@ -0,0 +1,82 @@
+package gate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 每次上报结果追加一条历史, 超过上限删除最老的, 接口按时间升序分页
+func Test_TaskHistory(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.TaskHistoryLimit = 2
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err = defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+	defer defaultStore.LockDeleteDataAndState(g.ctx, taskName)
+	defer defaultKVC.Delete(g.ctx, model.FullTaskHistory(taskName), clientv3.WithPrefix())
+
+	who := model.Whoami{Name: "runtime-1"}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		g.saveLastResult(who, &model.TaskResult{
+			TaskName: taskName,
+			ExitCode: i,
+			Duration: time.Second,
+			EndTime:  now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	router := gin.New()
+	router.GET(model.TASK_HISTORY_URL, g.taskHistory)
+
+	get := func(query string) (rsp struct {
+		Data struct {
+			Total        int64     `json:"total"`
+			Items        []taskRun `json:"items"`
+			NextStartKey string    `json:"next_start_key"`
+		} `json:"data"`
+	}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/crab/task/"+taskName+"/history?"+query, nil))
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return
+	}
+
+	// 第一次的结果被删掉了
+	all := get("")
+	assert.Equal(t, int64(2), all.Data.Total)
+	if assert.Len(t, all.Data.Items, 2) {
+		run := all.Data.Items[0]
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Equal(t, "failed", run.Status)
+		assert.Equal(t, who.Name, run.Runtime)
+		assert.True(t, run.EndTime.Sub(run.StartTime) == time.Second)
+		assert.Equal(t, 2, all.Data.Items[1].ExitCode)
+	}
+
+	page := get("limit=1")
+	assert.Len(t, page.Data.Items, 1)
+	assert.NotEmpty(t, page.Data.NextStartKey)
+
+	next := get("limit=1&start_key=" + url.QueryEscape(page.Data.NextStartKey))
+	if assert.Len(t, next.Data.Items, 1) {
+		assert.Equal(t, 2, next.Data.Items[0].ExitCode)
+	}
+	assert.Empty(t, next.Data.NextStartKey)
+}
@@ -0,0 +1,85 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 扫描task数据时每次读取的条数
+const labelScanBatch = 500
+
+// 解析label过滤参数, 格式是key:value, 多个label之间是and的关系
+func parseLabelSelector(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	selector := make(map[string]string, len(labels))
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label:%q, must be key:value", l)
+		}
+		selector[k] = v
+	}
+	return selector, nil
+}
+
+// task的标签包含selector里面所有的key:value
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// etcd没有标签的索引, 扫描所有的task数据, 返回标签匹配的task名
+// 分批读取, 所有批次都读同一个revision, 保证看到的是同一个快照, 每一批单独超时
+func (g *Gate) scanTasksByLabels(ctx context.Context, selector map[string]string) ([]string, error) {
+	prefix := model.GlobalTaskPrefix + "/"
+	end := clientv3.GetPrefixRangeEnd(prefix)
+
+	names := []string{}
+	key := prefix
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(labelScanBatch)}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		gctx, cancel := context.WithTimeout(ctx, g.etcdOpTimeout())
+		rsp, err := defaultKVC.Get(gctx, key, opts...)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		rev = rsp.Header.Revision
+
+		for _, kv := range rsp.Kvs {
+			var task struct {
+				Labels map[string]string `json:"labels"`
+			}
+			if err := json.Unmarshal(kv.Value, &task); err != nil {
+				g.Warn().Msgf("scanTasksByLabels:unmarshal %s:%s", kv.Key, err)
+				continue
+			}
+
+			if matchLabels(task.Labels, selector) {
+				names = append(names, model.TaskName(string(kv.Key)))
+			}
+		}
+
+		if !rsp.More || len(rsp.Kvs) == 0 {
+			return names, nil
+		}
+		key = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
+	}
+}
@@ -0,0 +1,70 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLabelSelector(t *testing.T) {
+	selector, err := parseLabelSelector([]string{"team:payments", "env:", "url:http://a"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "", "url": "http://a"}, selector)
+
+	selector, err = parseLabelSelector(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, selector)
+
+	_, err = parseLabelSelector([]string{"team"})
+	assert.Error(t, err)
+	_, err = parseLabelSelector([]string{":payments"})
+	assert.Error(t, err)
+}
+
+// 多个标签之间是and的关系
+func Test_MatchLabels(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	assert.True(t, matchLabels(labels, nil))
+	assert.True(t, matchLabels(labels, map[string]string{"team": "payments"}))
+	assert.True(t, matchLabels(labels, map[string]string{"team": "payments", "env": "prod"}))
+	assert.False(t, matchLabels(labels, map[string]string{"team": "payments", "env": "test"}))
+	assert.False(t, matchLabels(labels, map[string]string{"owner": ""}))
+	assert.False(t, matchLabels(nil, map[string]string{"team": "payments"}))
+}
+
+// 扫描etcd里面的task数据, 返回标签匹配的task名
+func Test_ScanTasksByLabels(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	team := uuid.New().String()
+	create := func(labels map[string]string) string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}, Labels: labels}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	prod := create(map[string]string{"team": team, "env": "prod"})
+	test := create(map[string]string{"team": team, "env": "test"})
+	create(nil)
+
+	names, err := g.scanTasksByLabels(g.ctx, map[string]string{"team": team})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{prod, test}, names)
+
+	names, err = g.scanTasksByLabels(g.ctx, map[string]string{"team": team, "env": "prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{prod}, names)
+
+	names, err = g.scanTasksByLabels(g.ctx, map[string]string{"team": uuid.New().String()})
+	assert.NoError(t, err)
+	assert.NotNil(t, names)
+	assert.Len(t, names, 0)
+}
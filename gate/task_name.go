@@ -0,0 +1,8 @@
+package gate
+
+import "strings"
+
+// 归一化task名: 去掉首尾空白, 转成小写, 中间连续的空白合并成一个空格
+func normalizeTaskName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
@@ -0,0 +1,22 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NormalizeTaskName(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		need string
+	}{
+		{"first-task", "first-task"},
+		{"  First-Task  ", "first-task"},
+		{"My \t  Daily\nJob", "my daily job"},
+		{" \t\n ", ""},
+		{"", ""},
+	} {
+		assert.Equal(t, tc.need, normalizeTaskName(tc.in), tc.in)
+	}
+}
@@ -0,0 +1,37 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/1whour/crab/model"
+)
+
+// 保存到etcd的task超过了MaxTaskBytes
+type taskTooLargeError struct {
+	size  int
+	limit int64
+}
+
+func (e *taskTooLargeError) Error() string {
+	return fmt.Sprintf("task spec is %d bytes, larger than the limit of %d bytes(--max-task-bytes)", e.size, e.limit)
+}
+
+// 检查序列化之后的task大小, 要在写入etcd之前检查
+// etcd单个请求默认最大1.5MiB(--max-request-bytes), 超过之后事务直接失败, 返回的错误看不出是task太大
+// 0表示不限制, 这时候超过etcd的限制返回500
+func (r *Gate) checkTaskSize(req *model.Param) error {
+	if r.MaxTaskBytes <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) > r.MaxTaskBytes {
+		return &taskTooLargeError{size: len(data), limit: r.MaxTaskBytes}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 创建n个task, 返回task名和清理函数
+func testCreateStatusTasks(tb testing.TB, g *Gate, n int) ([]string, func()) {
+	var err error
+
+	names := make([]string, n)
+	for i := range names {
+		names[i] = uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = names[i]
+		param.SetCreate()
+		if _, err = defaultStore.LockCreateDataAndState(g.ctx, names[i], &param); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return names, func() {
+		for _, name := range names {
+			defaultStore.LockDeleteDataAndState(g.ctx, name)
+		}
+	}
+}
+
+func testStatusContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL, nil)
+	return c
+}
+
+// 此函数依赖etcd是否存在
+// 超过一批的task也能全部查到, 不存在的task不在结果里面
+func Test_GetTasksAndStates(t *testing.T) {
+	g := testInitEtcdGate(t)
+	names, cleanup := testCreateStatusTasks(t, g, statusBatchSize+6)
+	defer cleanup()
+
+	missing := uuid.New().String()
+	tasks, states, err := g.getTasksAndStates(testStatusContext(), append(names, missing))
+	assert.NoError(t, err)
+	assert.Len(t, tasks, len(names))
+	assert.Len(t, states, len(names))
+	for _, name := range names {
+		if assert.NotNil(t, tasks[name], name) {
+			assert.Equal(t, model.FullGlobalTask(name), string(tasks[name].Key))
+		}
+		if assert.NotNil(t, states[name], name) {
+			assert.Equal(t, model.FullGlobalTaskState(name), string(states[name].Key))
+		}
+	}
+	assert.Nil(t, tasks[missing])
+}
+
+// 统计etcd往返的次数, txn在Commit的时候算一次
+type countKV struct {
+	clientv3.KV
+	calls int64
+}
+
+func (k *countKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	atomic.AddInt64(&k.calls, 1)
+	return k.KV.Get(ctx, key, opts...)
+}
+
+func (k *countKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	atomic.AddInt64(&k.calls, 1)
+	return k.KV.Do(ctx, op)
+}
+
+func (k *countKV) Txn(ctx context.Context) clientv3.Txn {
+	return &countTxn{Txn: k.KV.Txn(ctx), kv: k}
+}
+
+type countTxn struct {
+	clientv3.Txn
+	kv *countKV
+}
+
+func (t *countTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.Txn = t.Txn.If(cs...)
+	return t
+}
+
+func (t *countTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Then(ops...)
+	return t
+}
+
+func (t *countTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Else(ops...)
+	return t
+}
+
+func (t *countTxn) Commit() (*clientv3.TxnResponse, error) {
+	atomic.AddInt64(&t.kv.calls, 1)
+	return t.Txn.Commit()
+}
+
+// 此函数依赖etcd是否存在
+// 一页100个task, 逐个查询是200次etcd往返, 批量查询是2次
+func Benchmark_StatusLookup(b *testing.B) {
+	g := testInitEtcdGate(b)
+	names, cleanup := testCreateStatusTasks(b, g, 100)
+	defer cleanup()
+	c := testStatusContext()
+
+	kv := &countKV{KV: defaultKVC}
+	old := defaultKVC
+	defaultKVC = kv
+	defer func() { defaultKVC = old }()
+
+	// 按实际的etcd调用次数报告
+	run := func(name string, lookup func() error) {
+		b.Run(name, func(b *testing.B) {
+			atomic.StoreInt64(&kv.calls, 0)
+			for i := 0; i < b.N; i++ {
+				if err := lookup(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&kv.calls))/float64(b.N), "etcd-calls/op")
+		})
+	}
+
+	run("per_task", func() error {
+		for _, name := range names {
+			if _, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(name)); err != nil {
+				return err
+			}
+			if _, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	run("batch", func() error {
+		_, _, err := g.getTasksAndStates(c, names)
+		return err
+	})
+}
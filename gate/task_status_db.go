@@ -1,20 +1,56 @@
 package gate
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/1whour/crab/model"
 	"gorm.io/gorm"
 )
 
+const defaultStatusLimit = 10
+
+// status接口默认的单页上限
+const defaultMaxStatusLimit = 1000
+
+// status表里面归一化之后的状态
+var statusStates = []string{"running", "stop"}
+
+// 检查state过滤参数, 为空表示不过滤
+func validStatusState(state string) error {
+	if state == "" {
+		return nil
+	}
+
+	for _, s := range statusStates {
+		if s == state {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown state:%q, must be one of %s", state, strings.Join(statusStates, ","))
+}
+
 var (
-	statusColumm = []string{"task_name", "trigger", "trigger_value", "status", "create_time", "update_time", "runtime_id"}
+	statusColumm = []string{"task_name", "trigger", "trigger_value", "status", "create_time", "update_time", "runtime_id", "priority"}
 )
 
 type pageStatus struct {
 	Page `gorm:"-" json:"page"`
 
 	Format string `gorm:"-" form:"format" json:"format"`
+	// 游标分页, 按task名升序, 从这个key开始取, 值是上一页返回的next_start_key
+	StartKey string `gorm:"-" form:"start_key" json:"-"`
+	// 只返回这个状态的task, 可选值见statusStates
+	State string `gorm:"-" form:"state" json:"-"`
+	// 只返回task名是这个前缀的task
+	NamePrefix string `gorm:"-" form:"name_prefix" json:"-"`
+	// 只返回带有这些标签的task, 格式是key:value, 多个标签之间是and的关系
+	Label []string `gorm:"-" form:"label" json:"-"`
+	// 标签匹配的task名, 从etcd扫描出来, 为nil时不过滤
+	labelTasks []string `gorm:"-"`
+	// 增量轮询, 只返回etcd里面ModRevision大于这个值的状态, 见statusSince
+	SinceRevision *int64 `gorm:"-" form:"since_revision" json:"-"`
 	// 任务名
 	TaskName string `gorm:"index:,unique;not null;type:varchar(40)" json:"task_name"`
 	// cron任务或者一次性任务
@@ -34,6 +70,9 @@ type pageStatus struct {
 
 	// Runtime ID, runtime唯一无二的标识
 	RuntimeID string `gorm:"column:runtime_id;type:varchar(40)" json:"runtime_id"`
+
+	// 任务的优先级
+	Priority int `gorm:"column:priority;default:0" json:"priority"`
 }
 
 func paramToStatus(req *model.Param) (rv pageStatus) {
@@ -49,6 +88,7 @@ func paramToStatus(req *model.Param) (rv pageStatus) {
 	if req.IsCreate() || req.IsUpdate() {
 		rv.Status = "running"
 	}
+	rv.Priority = req.Priority
 	return
 }
 
@@ -103,17 +143,24 @@ func (l *StatusTable) update(result pageStatus) (err error) {
 	return
 }
 
+// 任务数据被修改时调用, Updates会忽略零值, 优先级单独更新, 改回0也能生效
+func (l *StatusTable) updateParam(req *model.Param) (err error) {
+	result := paramToStatus(req)
+	if err = l.update(result); err != nil {
+		return err
+	}
+	return l.DB.Model(&pageStatus{}).Where("task_name = ?", result.TaskName).UpdateColumn("priority", result.Priority).Error
+}
+
 // 查询
 func (l *StatusTable) queryAndPage(p pageStatus) (rv []pageStatus, count int64, err error) {
 	if p.Limit == 0 {
-		p.Limit = 10
+		p.Limit = defaultStatusLimit
 	}
 	c := statusColumm
-	order := ""
-	if len(p.Sort) > 0 {
-		if p.Sort[0] == '-' {
-			order = p.Sort[1:] + " desc"
-		}
+	order, err := statusOrder(p.Sort)
+	if err != nil {
+		return nil, 0, err
 	}
 	db := l.DB.Debug().Model(&pageStatus{}).Select(c)
 
@@ -121,6 +168,16 @@ func (l *StatusTable) queryAndPage(p pageStatus) (rv []pageStatus, count int64,
 		db.Where("task_name", p.TaskName)
 	}
 
+	db = p.filter(db)
+
+	// 游标分页不受中间删除数据的影响, 忽略page和sort
+	if len(p.StartKey) > 0 {
+		op, key := startKeyCond(p.StartKey)
+		db.Where("task_name "+op+" ?", key)
+		order = "task_name"
+		p.Page.Page = 0
+	}
+
 	if !p.StartTime.IsZero() {
 		db.Where("create_time >= ?", p.CreateTime)
 	}
@@ -138,10 +195,92 @@ func (l *StatusTable) queryAndPage(p pageStatus) (rv []pageStatus, count int64,
 		return
 	}
 
-	l.DB.Debug().Model(&pageStatus{}).Count(&count)
+	p.filter(l.DB.Debug().Model(&pageStatus{})).Count(&count)
 	return
 }
 
+// 默认按task名排序, 保证翻页的顺序稳定
+// 按优先级排序时, 相同优先级的再按task名排序
+// sort会拼到sql里面, 只允许statusColumm里面的列
+func statusOrder(sort string) (string, error) {
+	if sort == "" {
+		return "task_name", nil
+	}
+
+	column := strings.TrimPrefix(sort, "+")
+	desc := strings.HasPrefix(sort, "-")
+	if desc {
+		column = sort[1:]
+	}
+
+	valid := false
+	for _, c := range statusColumm {
+		if c == column {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("unknown sort column:%q, must be one of %s", column, strings.Join(statusColumm, ","))
+	}
+
+	order := column
+	if desc {
+		order += " desc"
+	}
+	if column == "priority" {
+		order += ", task_name"
+	}
+	return order, nil
+}
+
+// 在数据库里面过滤, limit和total都是过滤之后的条数
+func (p *pageStatus) filter(db *gorm.DB) *gorm.DB {
+	if len(p.State) > 0 {
+		db = db.Where("status = ?", p.State)
+	}
+
+	// 前缀匹配可以走task_name的索引, 只扫描这个前缀的范围
+	if len(p.NamePrefix) > 0 {
+		db = db.Where("task_name LIKE ?", escapeLike(p.NamePrefix)+"%")
+	}
+
+	if p.labelTasks != nil {
+		db = db.Where("task_name IN ?", p.labelTasks)
+	}
+	return db
+}
+
+// 转义like语句里面的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// 按task名升序的第一页或者带了start_key的请求, 才有下一页的游标
+func (p *pageStatus) cursorMode() bool {
+	if len(p.StartKey) > 0 {
+		return true
+	}
+	return p.Page.Page <= 1 && (p.Sort == "" || strings.TrimPrefix(p.Sort, "+") == "task_name")
+}
+
+// 游标分页的语义和etcd一样: 结果包含start_key本身, 下一页的start_key是本页最后一个key + "\x00"
+// 数据库的排序规则可能会忽略末尾的"\x00", 这里转成大于上一页的最后一个key
+func startKeyCond(startKey string) (op string, key string) {
+	if strings.HasSuffix(startKey, "\x00") {
+		return ">", strings.TrimSuffix(startKey, "\x00")
+	}
+	return ">=", startKey
+}
+
+// 取满一页才可能还有下一页, 没有下一页时返回空
+func nextStartKey(rv []pageStatus, limit int) string {
+	if len(rv) == 0 || len(rv) < limit {
+		return ""
+	}
+	return rv[len(rv)-1].TaskName + "\x00"
+}
+
 // 删除
 func (r *StatusTable) delete(p pageStatus) (err error) {
 	db := r.DB.Unscoped()
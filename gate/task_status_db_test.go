@@ -1,12 +1,17 @@
 package gate
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -122,3 +127,48 @@ func Test_status_GetList(t *testing.T) {
 	}
 
 }
+
+// 此函数依赖mysql是否存在
+// 游标分页, 翻页中间删除数据也不会跳过或者重复
+func Test_status_GetListByStartKey(t *testing.T) {
+	status := testInitStatusTable(t)
+
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		err := status.insert(pageStatus{TaskName: fmt.Sprintf("guo:%d", i), Status: "stop", CreateTime: now, UpdateTime: now})
+		assert.NoError(t, err)
+	}
+
+	first, _, err := status.queryAndPage(pageStatus{Page: Page{Limit: 3}})
+	assert.NoError(t, err)
+	assert.Len(t, first, 3)
+
+	// 删除第一页的数据
+	assert.NoError(t, status.delete(pageStatus{TaskName: first[0].TaskName}))
+
+	next := nextStartKey(first, 3)
+	second, _, err := status.queryAndPage(pageStatus{Page: Page{Limit: 3}, StartKey: next})
+	assert.NoError(t, err)
+	assert.Len(t, second, 3)
+	assert.Equal(t, "guo:3", second[0].TaskName)
+}
+
+// 此函数依赖mysql和etcd是否存在
+// limit超过上限时被截断, 响应里面带上实际使用的limit
+func Test_status_LimitClamped(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.statusTable = testInitStatusTable(t)
+
+	router := gin.New()
+	router.GET(model.TASK_UI_STATUS_URL, g.status)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL+"?limit=1000000", nil))
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	var rsp struct {
+		Data taskStatusList `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, defaultMaxStatusLimit, rsp.Data.Limit)
+}
@@ -2,80 +2,375 @@ package gate
 
 import (
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/1whour/crab/model"
 	"github.com/gin-gonic/gin"
 	"github.com/olekukonko/tablewriter"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-var title = []string{"taskName", "status", "runtimeNode", "createTime", "updateTime", "runtimeID"}
+// status表里面没有runtimeNode, 标题和statusRows的列一一对应
+var title = []string{"taskName", "status", "createTime", "updateTime", "runtimeID"}
+
+// csv格式时通过这个header返回下一页的start_key, 值经过url编码, 可以直接拼到查询字符串里面
+const nextStartKeyHeader = "X-Next-Start-Key"
+
+// status支持的输出格式
+var statusFormats = []string{"json", "table", "csv"}
+
+// 没有指定格式时返回json, 不认识的格式返回错误, 避免返回空的200
+func checkFormat(format string, formats []string) (string, error) {
+	if format == "" {
+		return "json", nil
+	}
+
+	for _, f := range formats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format:%q, must be one of %s", format, strings.Join(formats, ","))
+}
+
+func statusFormat(format string) (string, error) {
+	return checkFormat(format, statusFormats)
+}
+
+// table和csv格式共用的行数据
+func statusRows(rv []pageStatus) [][]string {
+	data := make([][]string, 0, len(rv))
+	for _, v := range rv {
+		data = append(data, []string{v.TaskName, v.Status, v.CreateTime.String(), v.UpdateTime.String(), v.RuntimeID})
+	}
+	return data
+}
+
+// 写csv, 第一行是标题
+func writeStatusCSV(w io.Writer, rv []pageStatus) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(title); err != nil {
+		return err
+	}
+
+	if err := cw.WriteAll(statusRows(rv)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
 
 type stateWithTaskRsp struct {
 	pageStatus
 	Task json.RawMessage `json:"task"`
+	// 数据的revision, 更新时通过X-Task-Revision带回来
+	Revision int64 `json:"revision"`
+	// 下一个可以执行的时间窗口, 没有配置窗口时为空
+	NextWindow *time.Time `json:"next_window,omitempty"`
+	// 下一次触发的时间, 一次性任务执行过之后为空
+	NextRun *time.Time `json:"next_run,omitempty"`
+	// 分配到的runtime节点名, 还没有分配时为空
+	RuntimeNode string `json:"runtime_node,omitempty"`
+	// 最近一次的执行结果, 还没有执行过时为空
+	LastResult *model.TaskResult `json:"last_result,omitempty"`
+	// 禁用的task不会被调度
+	Disabled bool `json:"disabled"`
+	// 停止的原因, 执行超时被停止时是timedout
+	StopReason string `json:"stop_reason,omitempty"`
+	// 执行中超过stallWindow没有心跳时是stalled
+	Liveness string `json:"liveness,omitempty"`
+	// 最近一次变更推送给runtime的次数, 大于1说明runtime没有及时ack
+	DispatchAttempts int `json:"dispatch_attempts,omitempty"`
+}
+
+// task是否被禁用
+func taskDisabled(task []byte) bool {
+	var param model.Param
+	if err := json.Unmarshal(task, &param); err != nil {
+		return false
+	}
+	return param.Disabled
+}
+
+// 计算task下一次触发的时间
+func nextRun(task []byte, now time.Time) *time.Time {
+	var param model.Param
+	if err := json.Unmarshal(task, &param); err != nil {
+		return nil
+	}
+
+	next, ok := param.Trigger.NextRun(now)
+	if !ok {
+		return nil
+	}
+	return &next
+}
+
+// 计算task下一个可以执行的时间窗口
+func nextWindow(task []byte, now time.Time) *time.Time {
+	var param model.Param
+	if err := json.Unmarshal(task, &param); err != nil || len(param.Trigger.Windows) == 0 {
+		return nil
+	}
+
+	next, err := param.Trigger.NextWindow(now)
+	if err != nil {
+		return nil
+	}
+	return &next
+}
+
+// 没有传limit时用默认值, 超过上限时截断, 避免一次查询太多的数据
+func (g *Gate) statusLimit(limit int) int {
+	max := g.MaxStatusLimit
+	if max <= 0 {
+		max = defaultMaxStatusLimit
+	}
+
+	if limit <= 0 {
+		limit = defaultStatusLimit
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit
+}
+
+// 把status表的每一行和etcd里面的数据, 状态按task名拼起来
+func statusItems(rv []pageStatus, tasks, states map[string]*mvccpb.KeyValue, now time.Time) []stateWithTaskRsp {
+	rsp := make([]stateWithTaskRsp, len(rv))
+	for i, v := range rv {
+		rsp[i].pageStatus = v
+		if task := tasks[v.TaskName]; task != nil {
+			rsp[i].Task = task.Value
+			rsp[i].Revision = task.ModRevision
+			rsp[i].NextWindow = nextWindow(task.Value, now)
+			rsp[i].NextRun = nextRun(task.Value, now)
+			rsp[i].Disabled = taskDisabled(task.Value)
+		}
+
+		if state := states[v.TaskName]; state != nil {
+			if s, err := model.ValueToState(state.Value); err == nil {
+				// 路径的最后一段是runtime名
+				rsp[i].RuntimeNode = model.TaskName(s.RuntimeNode)
+				rsp[i].LastResult = s.LastResult
+				rsp[i].StopReason = s.StopReason
+				rsp[i].Liveness = s.Liveness
+				rsp[i].DispatchAttempts = s.DispatchAttempts
+				// 优先用状态里面保存的时间, 老的状态里面没有时才按触发器计算
+				if s.NextRun != nil {
+					rsp[i].NextRun = s.NextRun
+				}
+			}
+		}
+	}
+	return rsp
+}
+
+// 一次事务里面最多查询多少个task, 每个task两个Get, 不能超过etcd的max-txn-ops(默认128)
+const statusBatchSize = 64
+
+// 批量查询task的数据和状态, 一批task在一个事务里面读, 一页只需要几次往返
+// 每一批都有自己的超时, 返回的map的key是task名
+// 打开状态缓存并且缓存健康时, 状态从缓存里面读, etcd只读task的数据
+func (g *Gate) getTasksAndStates(c *gin.Context, taskNames []string) (tasks, states map[string]*mvccpb.KeyValue, err error) {
+	tasks = make(map[string]*mvccpb.KeyValue, len(taskNames))
+	cached, ok := g.cachedStates(c, taskNames)
+	if !ok {
+		states = make(map[string]*mvccpb.KeyValue, len(taskNames))
+	}
+
+	for start := 0; start < len(taskNames); start += statusBatchSize {
+		end := start + statusBatchSize
+		if end > len(taskNames) {
+			end = len(taskNames)
+		}
+
+		if err = g.getTasksAndStatesBatch(c, taskNames[start:end], tasks, states); err != nil {
+			return tasks, states, err
+		}
+	}
+
+	if ok {
+		return tasks, cached, nil
+	}
+	return tasks, states, nil
+}
+
+func (g *Gate) getTasksAndStatesBatch(c *gin.Context, taskNames []string, tasks, states map[string]*mvccpb.KeyValue) error {
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+
+	// states为nil时只读task的数据
+	step := 2
+	if states == nil {
+		step = 1
+	}
+
+	ops := make([]clientv3.Op, 0, step*len(taskNames))
+	for _, name := range taskNames {
+		ops = append(ops, clientv3.OpGet(model.FullGlobalTask(name)))
+		if states != nil {
+			ops = append(ops, clientv3.OpGet(model.FullGlobalTaskState(name)))
+		}
+	}
+
+	txn, err := g.etcdGetTxn(ctx, ops...)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range taskNames {
+		if kvs := txn.Responses[step*i].GetResponseRange().Kvs; len(kvs) > 0 {
+			tasks[name] = kvs[0]
+		}
+		if states == nil {
+			continue
+		}
+		if kvs := txn.Responses[step*i+1].GetResponseRange().Kvs; len(kvs) > 0 {
+			states[name] = kvs[0]
+		}
+	}
+	return nil
 }
 
 // 响应的壳
 type taskStatusList struct {
 	Total int64 `json:"total"`
-	Items any   `json:"items"`
+	// 实际使用的limit, 超过上限时被截断
+	Limit int `json:"limit"`
+	Items any `json:"items"`
+	// 还有下一页时才有值, 作为下一次请求的start_key
+	NextStartKey string `json:"next_start_key,omitempty"`
 }
 
 // 构造status数据
 // 内部使用接口， 直接返回格式化后的数据
 // 标题如下
 // taskName, status, runtimeNode, runtimeIP
+// 分页:
+// 1.page/limit是offset分页, 翻页期间有task被删除时会跳过或者重复
+// 2.start_key是游标分页, json格式在还有数据时返回next_start_key(本页最后一个task名 + "\x00"), 没有时表示已经取完
+// 3.since_revision是增量轮询, 只返回这个revision之后修改过的状态, 见statusSince
+// csv格式的游标放在X-Next-Start-Key header里面
 func (g *Gate) status(ctx *gin.Context) {
 	p := pageStatus{}
 
 	err := ctx.ShouldBindQuery(&p)
 	if err != nil {
-		g.error2(ctx, 500, "bind query:"+err.Error())
+		g.error(ctx, model.ErrValidation, "bind query:%s", err)
+		return
+	}
+
+	if p.Format, err = statusFormat(p.Format); err != nil {
+		g.error(ctx, model.ErrValidation, "status:%s", err)
+		return
+	}
+
+	if err = validStatusState(p.State); err != nil {
+		g.error(ctx, model.ErrValidation, "status:%s", err)
+		return
+	}
+
+	if _, err = statusOrder(p.Sort); err != nil {
+		g.error(ctx, model.ErrValidation, "status:%s", err)
+		return
+	}
+
+	p.Limit = g.statusLimit(p.Limit)
+
+	if p.SinceRevision != nil {
+		if err = checkSinceRevision(&p); err != nil {
+			g.error(ctx, model.ErrValidation, "status:%s", err)
+			return
+		}
+		g.statusSince(ctx, p)
+		return
+	}
+
+	selector, err := parseLabelSelector(p.Label)
+	if err != nil {
+		g.error(ctx, model.ErrValidation, "status:%s", err)
 		return
 	}
 
+	if selector != nil {
+		ectx, cancel := g.etcdCtx(ctx)
+		p.labelTasks, err = g.scanTasksByLabels(ectx, selector)
+		cancel()
+		if err != nil {
+			if !g.etcdTimeout(ctx, ectx, err, "status") {
+				g.error(ctx, model.ErrEtcd, "scan labels:%s", err)
+			}
+			return
+		}
+	}
+
 	rv, count, err := g.statusTable.queryAndPage(p)
 	if err != nil {
-		g.error2(ctx, 500, "query data:"+err.Error())
+		g.error(ctx, model.ErrDatabase, "query data:%s", err)
 		return
 	}
 
 	if p.Format == "table" {
 
-		data := [][]string{}
-		for _, v := range rv {
-			one := []string{v.TaskName, v.Status, v.CreateTime.String(), v.UpdateTime.String(), v.RuntimeID}
-			data = append(data, one)
-		}
-
 		var buf bytes.Buffer
 
 		table := tablewriter.NewWriter(&buf)
 		table.SetHeader(title)
-		for _, d := range data {
+		for _, d := range statusRows(rv) {
 			table.Append(d)
 		}
 		table.Render()
 
 		ctx.String(200, buf.String())
+	} else if p.Format == "csv" {
+
+		var buf bytes.Buffer
+		if err = writeStatusCSV(&buf, rv); err != nil {
+			g.error(ctx, model.ErrInternal, "write csv:%s", err)
+			return
+		}
+
+		if p.cursorMode() {
+			if next := nextStartKey(rv, p.Limit); next != "" {
+				ctx.Header(nextStartKeyHeader, url.QueryEscape(next))
+			}
+		}
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="task_status_%s.csv"`, time.Now().Format("20060102150405")))
+		ctx.Data(200, "text/csv; charset=utf-8", buf.Bytes())
 	} else if p.Format == "json" {
 
-		rsp := make([]stateWithTaskRsp, len(rv))
+		taskNames := make([]string, len(rv))
 		for i, v := range rv {
-			task, err := defautlClient.Get(g.ctx, model.FullGlobalTask(v.TaskName))
-			if err != nil {
-				g.Warn().Msgf("get state fail:%s", err)
-				continue
+			taskNames[i] = v.TaskName
+		}
+
+		tasks, states, err := g.getTasksAndStates(ctx, taskNames)
+		if err != nil {
+			if g.etcdUnavailable(ctx, err, "status") {
+				return
 			}
-			rsp[i].pageStatus = v
-			if len(task.Kvs) > 0 {
-				rsp[i].Task = task.Kvs[0].Value
+			if errors.Is(err, context.DeadlineExceeded) {
+				g.error(ctx, model.ErrEtcdTimeout, "status:etcd timeout:%s", err)
+				return
 			}
+			g.Warn().Msgf("get state fail:%s", err)
+		}
+
+		rsp := statusItems(rv, tasks, states, time.Now())
+		list := taskStatusList{Total: count, Limit: p.Limit, Items: rsp}
+		if p.cursorMode() {
+			list.NextStartKey = nextStartKey(rv, p.Limit)
 		}
-		ctx.JSON(200, wrapData{Data: taskStatusList{
-			Total: count,
-			Items: rsp,
-		}})
+		ctx.JSON(200, wrapData{Data: list})
 	}
 }
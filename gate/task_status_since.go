@@ -0,0 +1,73 @@
+package gate
+
+import (
+	"errors"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 增量轮询的响应
+type statusSinceList struct {
+	// 这次查询时etcd的revision, 下一轮轮询的since_revision
+	Revision int64 `json:"revision"`
+	Limit    int   `json:"limit"`
+	// 和watchState推送的事件是同一种结构
+	Items []stateEvent `json:"items"`
+	// 还有下一页时才有值, 作为下一次请求的start_key
+	NextStartKey string `json:"next_start_key,omitempty"`
+}
+
+func checkSinceRevision(p *pageStatus) error {
+	if *p.SinceRevision < 0 {
+		return errors.New("since_revision must not be negative")
+	}
+	if p.Format != "json" {
+		return errors.New("since_revision only supports the json format")
+	}
+	if p.State != "" || len(p.Label) > 0 {
+		return errors.New("since_revision can not be used with state or label")
+	}
+	return nil
+}
+
+// 增量轮询, 直接从etcd读ModRevision大于since_revision的状态, 不经过status表
+// 1.按task名升序, start_key和name_prefix的语义和普通的status一样, 忽略page和sort
+// 2.revision是这一页查询时etcd的revision, 翻页时用第一页的revision作为下一轮的since_revision,
+// 翻页期间修改的状态, 要么在后面的页里面, 要么ModRevision比第一页的revision大, 下一轮还会返回
+// 3.只返回还存在的状态, 被删除的task不会出现, 需要删除事件的用watchState
+// 4.过滤在etcd服务端做, 不受compact影响, 但是etcd仍然要扫描整个范围
+func (g *Gate) statusSince(c *gin.Context, p pageStatus) {
+	key := model.GlobalTaskPrefixState + "/"
+	if p.NamePrefix != "" {
+		key = model.FullGlobalTaskState(p.NamePrefix)
+	}
+	end := clientv3.GetPrefixRangeEnd(key)
+	if p.StartKey != "" {
+		if start := model.FullGlobalTaskState(p.StartKey); start > key {
+			key = start
+		}
+	}
+
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+
+	rsp, err := g.etcdGet(ctx, key,
+		clientv3.WithRange(end),
+		clientv3.WithMinModRev(*p.SinceRevision+1),
+		clientv3.WithLimit(int64(p.Limit)))
+	if err != nil {
+		g.etcdError(c, ctx, err, "status")
+		return
+	}
+
+	list := statusSinceList{Revision: rsp.Header.Revision, Limit: p.Limit, Items: make([]stateEvent, len(rsp.Kvs))}
+	for i, kv := range rsp.Kvs {
+		list.Items[i] = stateEvent{TaskName: model.TaskName(string(kv.Key)), Revision: kv.ModRevision, State: kv.Value}
+	}
+	if rsp.More && len(rsp.Kvs) > 0 {
+		list.NextStartKey = list.Items[len(list.Items)-1].TaskName + "\x00"
+	}
+	c.JSON(200, wrapData{Data: list})
+}
@@ -0,0 +1,94 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 此函数依赖etcd是否存在
+// since_revision只返回之后修改过的状态, 按task名翻页, 下一轮用第一页的revision
+func Test_Status_SinceRevision(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	prefix := uuid.New().String()
+	names := []string{prefix + "-a", prefix + "-b", prefix + "-c"}
+	for _, name := range names {
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = name
+		param.SetCreate()
+		_, err = defaultStore.LockCreateDataAndState(g.ctx, name, &param)
+		assert.NoError(t, err)
+		defer defaultStore.LockDeleteDataAndState(g.ctx, name)
+	}
+
+	router := gin.New()
+	router.GET(model.TASK_UI_STATUS_URL, g.status)
+	get := func(query url.Values) (int, statusSinceList) {
+		query.Set("name_prefix", prefix)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL+"?"+query.Encode(), nil))
+
+		var rsp struct {
+			Data statusSinceList `json:"data"`
+		}
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		}
+		return w.Code, rsp.Data
+	}
+
+	code, first := get(url.Values{"since_revision": {"0"}, "limit": {"2"}})
+	assert.Equal(t, 200, code)
+	if assert.Len(t, first.Items, 2) {
+		assert.Equal(t, names[0], first.Items[0].TaskName)
+		assert.Equal(t, names[1], first.Items[1].TaskName)
+		assert.NotEmpty(t, first.Items[0].State)
+	}
+	assert.Equal(t, names[1]+"\x00", first.NextStartKey)
+
+	code, second := get(url.Values{"since_revision": {"0"}, "limit": {"2"}, "start_key": {first.NextStartKey}})
+	assert.Equal(t, 200, code)
+	if assert.Len(t, second.Items, 1) {
+		assert.Equal(t, names[2], second.Items[0].TaskName)
+	}
+	assert.Empty(t, second.NextStartKey)
+
+	// 第一页的revision之后没有修改, 下一轮是空的
+	code, rsp := get(url.Values{"since_revision": {fmt.Sprint(first.Revision)}})
+	assert.Equal(t, 200, code)
+	assert.Empty(t, rsp.Items)
+
+	state, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(names[1]))
+	assert.NoError(t, err)
+	_, err = defaultKVC.Put(g.ctx, model.FullGlobalTaskState(names[1]), string(state.Kvs[0].Value))
+	assert.NoError(t, err)
+
+	code, rsp = get(url.Values{"since_revision": {fmt.Sprint(first.Revision)}})
+	assert.Equal(t, 200, code)
+	if assert.Len(t, rsp.Items, 1) {
+		assert.Equal(t, names[1], rsp.Items[0].TaskName)
+		assert.Greater(t, rsp.Items[0].Revision, first.Revision)
+	}
+	assert.GreaterOrEqual(t, rsp.Revision, rsp.Items[0].Revision)
+
+	for _, query := range []url.Values{
+		{"since_revision": {"-1"}},
+		{"since_revision": {"x"}},
+		{"since_revision": {"0"}, "format": {"csv"}},
+		{"since_revision": {"0"}, "state": {"running"}},
+		{"since_revision": {"0"}, "label": {"env:prod"}},
+	} {
+		code, _ := get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query.Encode())
+	}
+}
@@ -0,0 +1,171 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// 只有取满一页的时候才返回下一页的游标
+func Test_NextStartKey(t *testing.T) {
+	rv := []pageStatus{{TaskName: "a"}, {TaskName: "b"}}
+	assert.Equal(t, "b\x00", nextStartKey(rv, 2))
+	assert.Equal(t, "", nextStartKey(rv, 3))
+	assert.Equal(t, "", nextStartKey(nil, 2))
+
+	op, key := startKeyCond("b\x00")
+	assert.Equal(t, ">", op)
+	assert.Equal(t, "b", key)
+
+	op, key = startKeyCond("b")
+	assert.Equal(t, ">=", op)
+	assert.Equal(t, "b", key)
+}
+
+func Test_CursorMode(t *testing.T) {
+	for _, tc := range []struct {
+		p    pageStatus
+		need bool
+	}{
+		{pageStatus{}, true},
+		{pageStatus{Page: Page{Page: 1, Sort: "+task_name"}}, true},
+		{pageStatus{Page: Page{Page: 2}}, false},
+		{pageStatus{Page: Page{Page: 1, Sort: "-create_time"}}, false},
+		{pageStatus{Page: Page{Page: 3, Sort: "-create_time"}, StartKey: "a\x00"}, true},
+	} {
+		assert.Equal(t, tc.need, tc.p.cursorMode(), tc.p)
+	}
+}
+
+func Test_StatusOrder(t *testing.T) {
+	for sort, want := range map[string]string{
+		"":             "task_name",
+		"+task_name":   "task_name",
+		"-create_time": "create_time desc",
+		"create_time":  "create_time",
+		"-priority":    "priority desc, task_name",
+		"+priority":    "priority, task_name",
+		"priority":     "priority, task_name",
+	} {
+		order, err := statusOrder(sort)
+		assert.NoError(t, err, sort)
+		assert.Equal(t, want, order, sort)
+	}
+
+	// 不在列表里面的列直接拒绝, 避免拼到sql里面
+	for _, sort := range []string{"-", "name", "-task_name; drop table status", "+create_time desc"} {
+		_, err := statusOrder(sort)
+		assert.Error(t, err, sort)
+	}
+
+	// 接口在查询数据库之前返回400
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled")}
+	router := gin.New()
+	router.GET(model.TASK_UI_STATUS_URL, g.status)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, model.TASK_UI_STATUS_URL+"?sort="+url.QueryEscape("-task_name; drop table status"), nil))
+	assert.Equal(t, 400, w.Code, w.Body.String())
+}
+
+func Test_ValidStatusState(t *testing.T) {
+	assert.NoError(t, validStatusState(""))
+	assert.NoError(t, validStatusState("running"))
+	assert.NoError(t, validStatusState("stop"))
+	assert.Error(t, validStatusState("paused"))
+}
+
+func Test_EscapeLike(t *testing.T) {
+	assert.Equal(t, `a\%b\_c\\d`, escapeLike(`a%b_c\d`))
+	assert.Equal(t, "guo:", escapeLike("guo:"))
+}
+
+func Test_WriteStatusCSV(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	err := writeStatusCSV(&buf, []pageStatus{{TaskName: "a,b", Status: "running", CreateTime: now, UpdateTime: now, RuntimeID: "r1"}})
+	assert.NoError(t, err)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{title, {"a,b", "running", now.String(), now.String(), "r1"}}, records)
+}
+
+func Test_StatusFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		want   string
+		err    bool
+	}{
+		{"", "json", false},
+		{"xml", "", true},
+		{"json", "json", false},
+		{"table", "table", false},
+		{"csv", "csv", false},
+	} {
+		got, err := statusFormat(tc.format)
+		assert.Equal(t, tc.want, got, tc.format)
+		assert.Equal(t, tc.err, err != nil, tc.format)
+	}
+}
+
+func Test_StatusLimit(t *testing.T) {
+	g := Gate{}
+	for _, tc := range []struct {
+		limit int
+		want  int
+	}{
+		{0, defaultStatusLimit},
+		{-1, defaultStatusLimit},
+		{20, 20},
+		{1000000, defaultMaxStatusLimit},
+	} {
+		assert.Equal(t, tc.want, g.statusLimit(tc.limit), tc.limit)
+	}
+
+	g.MaxStatusLimit = 50
+	assert.Equal(t, 50, g.statusLimit(1000000))
+}
+
+// 多个task分配在不同的runtime上, 每一行拿到的都是自己的数据和runtime
+func Test_StatusItems(t *testing.T) {
+	rv := []pageStatus{{TaskName: "t1"}, {TaskName: "t2"}, {TaskName: "t3"}}
+	tasks := map[string]*mvccpb.KeyValue{
+		"t1": {Value: []byte(`{"executer":{"taskName":"t1"}}`), ModRevision: 11},
+		"t2": {Value: []byte(`{"executer":{"taskName":"t2"}}`), ModRevision: 12},
+	}
+	states := map[string]*mvccpb.KeyValue{}
+	for name, runtime := range map[string]string{"t1": "r1", "t2": "r2", "t3": "r3"} {
+		value, err := json.Marshal(model.State{RuntimeNode: model.FullRuntimeNode(model.Whoami{Name: runtime})})
+		assert.NoError(t, err)
+		states[name] = &mvccpb.KeyValue{Value: value}
+	}
+
+	items := statusItems(rv, tasks, states, time.Now())
+	assert.Len(t, items, 3)
+	for i, want := range []struct {
+		name     string
+		runtime  string
+		revision int64
+	}{
+		{"t1", "r1", 11},
+		{"t2", "r2", 12},
+		{"t3", "r3", 0},
+	} {
+		assert.Equal(t, want.name, items[i].TaskName)
+		assert.Equal(t, want.runtime, items[i].RuntimeNode)
+		assert.Equal(t, want.revision, items[i].Revision)
+	}
+	assert.Nil(t, items[2].Task)
+}
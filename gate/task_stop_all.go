@@ -0,0 +1,153 @@
+package gate
+
+import (
+	"context"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const actionStopAll = "stopAll"
+
+type stopAllRsp struct {
+	// 匹配到的task个数, 包括已经停止的
+	Matched int `json:"matched"`
+	Stopped int `json:"stopped"`
+	// 已经是stop或者remove的task
+	Skipped int `json:"skipped"`
+	// 停止失败的task名, 可以再调用一次
+	Failed []string `json:"failed,omitempty"`
+
+	stoppedTasks []stoppedTask
+}
+
+// 停止前后的revision
+type stoppedTask struct {
+	name          string
+	before, after int64
+}
+
+// 停止所有运行中的task, 升级runtime之前用, 可以按label只停某个团队的task
+// 和stop接口一样置为CanRun, 由mjobs把stop命令下发到对应的runtime
+func (r *Gate) stopAll(c *gin.Context) {
+	selector, err := parseLabelSelector(c.QueryArray("label"))
+	if err != nil {
+		r.error(c, model.ErrValidation, "%s:%s", actionStopAll, err)
+		return
+	}
+
+	rsp, err := r.stopAllTasks(c.Request.Context(), selector, r.auditFunc(c, model.Stop))
+	if err != nil {
+		r.etcdError(c, c.Request.Context(), err, actionStopAll)
+		return
+	}
+
+	for _, t := range rsp.stoppedTasks {
+		var req model.OnlyParam
+		req.Action = model.Stop
+		req.Executer.TaskName = t.name
+		if err = r.statusTable.update(onlyParamToStatus(req, model.State{})); err != nil {
+			r.Warn().Msgf("status table:update db fail:%s", err)
+		}
+	}
+
+	r.okWithData(c, actionStopAll+" Execution succeeded", rsp)
+}
+
+// audit不为nil时审计记录和停止在同一个事务里面写入
+func (r *Gate) stopAllTasks(ctx context.Context, selector map[string]string, audit auditFunc) (rsp stopAllRsp, err error) {
+	var match map[string]bool
+	if len(selector) > 0 {
+		names, err := r.scanTasksByLabels(ctx, selector)
+		if err != nil {
+			return rsp, err
+		}
+
+		match = make(map[string]bool, len(names))
+		for _, name := range names {
+			match[name] = true
+		}
+	}
+
+	prefix := model.GlobalTaskPrefixState + "/"
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	key := prefix
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(labelScanBatch)}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+
+		// 每一批单独超时, 请求的ctx没有超时时间
+		gctx, cancel := context.WithTimeout(ctx, r.etcdOpTimeout())
+		states, err := defaultKVC.Get(gctx, key, opts...)
+		cancel()
+		if err != nil {
+			return rsp, err
+		}
+		rev = states.Header.Revision
+
+		for _, kv := range states.Kvs {
+			taskName := model.TaskName(string(kv.Key))
+			if match != nil && !match[taskName] {
+				continue
+			}
+
+			state, err := model.ValueToState(kv.Value)
+			if err != nil {
+				r.Warn().Msgf("%s:unmarshal %s:%s", actionStopAll, kv.Key, err)
+				continue
+			}
+
+			rsp.Matched++
+			if state.IsStop() || state.IsRemove() {
+				rsp.Skipped++
+				continue
+			}
+
+			t, err := r.stopOne(ctx, taskName, audit)
+			if err != nil {
+				r.Warn().Msgf("%s:stop %s:%s", actionStopAll, taskName, err)
+				rsp.Failed = append(rsp.Failed, taskName)
+				continue
+			}
+			// 扫描的时候被删除了
+			if t.after == 0 {
+				continue
+			}
+			rsp.Stopped++
+			rsp.stoppedTasks = append(rsp.stoppedTasks, t)
+		}
+
+		if !states.More || len(states.Kvs) == 0 {
+			return rsp, nil
+		}
+		key = string(states.Kvs[len(states.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// 每个task单独设置超时, task很多时整个扫描可能超过EtcdOpTimeout
+func (r *Gate) stopOne(ctx context.Context, taskName string, audit auditFunc) (t stoppedTask, err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	t.name = taskName
+	globalTaskName := model.FullGlobalTask(taskName)
+	rsp, err := defaultKVC.Get(ctx, globalTaskName, clientv3.WithKeysOnly())
+	if err != nil || len(rsp.Kvs) == 0 {
+		return t, err
+	}
+
+	var req model.OnlyParam
+	req.Action = model.Stop
+	req.Executer.TaskName = taskName
+	t.before = rsp.Kvs[0].ModRevision
+	var extra []clientv3.Op
+	if audit != nil {
+		extra = append(extra, audit(taskName, t.before))
+	}
+	t.after, err = defaultStore.LockUpdateAction(ctx, taskName, &req, t.before, model.CanRun, model.Stop, extra...)
+	return t, err
+}
@@ -0,0 +1,72 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 按label停止运行中的task, 已经停止的跳过, 其他label的不受影响
+func Test_StopAllTasks(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	team := uuid.New().String()
+	create := func(labels map[string]string) string {
+		taskName := uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}, Labels: labels}
+		param.Executer.TaskName = taskName
+		param.SetCreate()
+		_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+		return taskName
+	}
+
+	getState := func(taskName string) model.State {
+		rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return state
+	}
+
+	a := create(map[string]string{"team": team})
+	b := create(map[string]string{"team": team})
+	other := create(map[string]string{"team": team + "-other"})
+
+	rsp, err := g.stopAllTasks(g.ctx, map[string]string{"team": team}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rsp.Matched)
+	assert.Equal(t, 2, rsp.Stopped)
+	var stopped []string
+	for _, s := range rsp.stoppedTasks {
+		stopped = append(stopped, s.name)
+		assert.True(t, s.after > s.before)
+	}
+	assert.ElementsMatch(t, []string{a, b}, stopped)
+	assert.Empty(t, rsp.Failed)
+
+	for _, taskName := range []string{a, b} {
+		state := getState(taskName)
+		assert.Equal(t, model.Stop, state.Action)
+		assert.Equal(t, model.CanRun, state.State)
+	}
+	assert.Equal(t, model.Create, getState(other).Action)
+
+	// 再调用一次, 已经停止的跳过
+	rsp, err = g.stopAllTasks(g.ctx, map[string]string{"team": team}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rsp.Matched)
+	assert.Equal(t, 0, rsp.Stopped)
+	assert.Equal(t, 2, rsp.Skipped)
+
+	// 和task名的路由不冲突
+	router := gin.New()
+	router.POST(model.TASK_STOP_ALL_URL, func(c *gin.Context) {})
+	router.POST(model.TASK_DISABLE_URL, func(c *gin.Context) {})
+	router.GET(model.TASK_DETAIL_URL, func(c *gin.Context) {})
+}
@@ -0,0 +1,155 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/store/etcd"
+)
+
+const defaultTimeoutCheckInterval = time.Second
+
+// runtime上报的正在执行的任务
+type runningTask struct {
+	who     model.Whoami
+	started model.TaskStarted
+	// 收到开始执行的时间, 超时从这里算, StartTime是runtime的时钟, 有偏差时会误判
+	received time.Time
+	// 最近一次收到心跳的时间, 用gate自己的时钟, 不受runtime时钟偏差的影响
+	lastAlive time.Time
+	// 已经标记为stalled
+	stalled bool
+}
+
+func runningKey(runtimeName, taskName string) string {
+	return runtimeName + "/" + taskName
+}
+
+// 没有配置时默认每秒检查一次
+func (r *Gate) timeoutCheckInterval() time.Duration {
+	if r.TimeoutCheckInterval <= 0 {
+		return defaultTimeoutCheckInterval
+	}
+	return r.TimeoutCheckInterval
+}
+
+// 记录开始执行的时间, 同一个任务在同一个runtime上只记录最近的一次
+func (r *Gate) trackStart(who model.Whoami, started *model.TaskStarted) {
+	if started.Timeout <= 0 && started.StallWindow <= 0 {
+		return
+	}
+	now := time.Now()
+	r.running.Store(runningKey(who.Name, started.TaskName), runningTask{who: who, started: *started, received: now, lastAlive: now})
+}
+
+// 收到心跳, 之前标记为stalled的恢复正常
+func (r *Gate) trackAlive(who model.Whoami, alive *model.TaskAlive) {
+	key := runningKey(who.Name, alive.TaskName)
+	v, ok := r.running.Load(key)
+	if !ok {
+		return
+	}
+
+	t := v.(runningTask)
+	t.lastAlive = time.Now()
+	stalled := t.stalled
+	t.stalled = false
+	r.running.Store(key, t)
+
+	if stalled {
+		r.setLiveness(t, "")
+	}
+}
+
+// 收到执行结果之后不再检查
+func (r *Gate) untrackTask(who model.Whoami, taskName string) {
+	r.running.Delete(runningKey(who.Name, taskName))
+}
+
+// runtime断开之后任务会被重新分配, 这个runtime上的记录都不再检查
+func (r *Gate) untrackRuntime(who model.Whoami) {
+	r.running.Range(func(key, value any) bool {
+		if value.(runningTask).who.Name == who.Name {
+			r.running.Delete(key)
+		}
+		return true
+	})
+}
+
+// 定时检查执行超时的任务和超时没有ack的下发, runtime自己实现了超时的话, 这里只是兜底
+func (r *Gate) timeoutLoop(ctx context.Context) {
+	tk := time.NewTicker(r.timeoutCheckInterval())
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tk.C:
+			r.checkTimeout(now)
+			r.checkAcks(now)
+		}
+	}
+}
+
+func (r *Gate) checkTimeout(now time.Time) {
+	r.running.Range(func(key, value any) bool {
+		t := value.(runningTask)
+		if t.started.Timeout > 0 && now.Sub(t.received) > t.started.Timeout {
+			r.running.Delete(key)
+			r.stopTimedOut(t, now)
+			return true
+		}
+
+		// 没有心跳只是标记, 任务可能只是慢, 是否停止由用户决定
+		if t.started.StallWindow > 0 && !t.stalled && now.Sub(t.lastAlive) > t.started.StallWindow {
+			t.stalled = true
+			r.running.Store(key, t)
+			if r.setLiveness(t, model.Stalled) {
+				r.Warn().Msgf("gate.checkTimeout:task(%s) on runtime(%s) has no liveness for %s, longer than the stall window(%s), stalled",
+					t.started.TaskName, t.who.Name, now.Sub(t.lastAlive).Round(time.Millisecond), t.started.StallWindow)
+			}
+		}
+		return true
+	})
+}
+
+// 修改状态里面的存活状态, 修改成功返回true
+func (r *Gate) setLiveness(t runningTask, liveness string) bool {
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	taskName := t.started.TaskName
+	changed, err := defaultStore.SetLiveness(ctx, taskName, model.FullRuntimeNode(t.who), liveness)
+	if errors.Is(err, etcd.ErrTaskNotFound) {
+		return false
+	}
+	if err != nil {
+		r.Warn().Msgf("gate.setLiveness:%s, task:%s, runtime:%s", err, taskName, t.who.Name)
+		return false
+	}
+	return changed
+}
+
+// 下发stop, 状态里面记录是因为超时停止的
+func (r *Gate) stopTimedOut(t runningTask, now time.Time) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.etcdOpTimeout())
+	defer cancel()
+
+	taskName := t.started.TaskName
+	stopped, err := defaultStore.LockStopTimedOut(ctx, taskName, model.FullRuntimeNode(t.who))
+	if errors.Is(err, etcd.ErrTaskNotFound) {
+		return
+	}
+	if err != nil {
+		r.Warn().Msgf("gate.stopTimedOut:%s, task:%s, runtime:%s", err, taskName, t.who.Name)
+		return
+	}
+
+	if stopped {
+		r.Warn().Msgf("gate.stopTimedOut:task(%s) has run %s on runtime(%s), longer than the timeout(%s), stopped",
+			taskName, now.Sub(t.received).Round(time.Millisecond), t.who.Name, t.started.Timeout)
+	}
+}
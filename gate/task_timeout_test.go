@@ -0,0 +1,133 @@
+package gate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 创建一个task, 模拟mjobs分配到who上
+func testCreateRunningTask(t *testing.T, g *Gate, who model.Whoami) string {
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}, Timeout: time.Second}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+	t.Cleanup(func() { defaultStore.LockDeleteDataAndState(g.ctx, taskName) })
+
+	stateKey := model.FullGlobalTaskState(taskName)
+	rsp, err := defaultKVC.Get(g.ctx, stateKey)
+	assert.NoError(t, err)
+	state, err := model.ValueToState(rsp.Kvs[0].Value)
+	assert.NoError(t, err)
+	state.State = model.Running
+	state.RuntimeNode = model.FullRuntimeNode(who)
+	value, _ := json.Marshal(state)
+	_, err = defaultKVC.Put(g.ctx, stateKey, string(value))
+	assert.NoError(t, err)
+	return taskName
+}
+
+func testGetState(t *testing.T, g *Gate, taskName string) model.State {
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTaskState(taskName))
+	assert.NoError(t, err)
+	state, err := model.ValueToState(rsp.Kvs[0].Value)
+	assert.NoError(t, err)
+	return state
+}
+
+// 超过Timeout还没有上报结果的任务被置为stop, 状态里面记录TimedOut
+func Test_CheckTimeout(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	who := model.Whoami{Name: uuid.New().String()}
+	create := func() string { return testCreateRunningTask(t, g, who) }
+	getState := func(taskName string) model.State { return testGetState(t, g, taskName) }
+
+	now := time.Now()
+	slow, fast, reported := create(), create(), create()
+	g.trackStart(who, &model.TaskStarted{TaskName: slow, StartTime: now, Timeout: time.Second})
+	// runtime的时钟慢了一个小时, 超时按gate收到的时间算, 不会马上超时
+	g.trackStart(who, &model.TaskStarted{TaskName: fast, StartTime: now.Add(-time.Hour), Timeout: time.Second})
+	g.trackStart(who, &model.TaskStarted{TaskName: reported, StartTime: now, Timeout: time.Second})
+	g.saveLastResult(who, &model.TaskResult{TaskName: reported, EndTime: now})
+
+	// 模拟slow是2秒之前收到的
+	v, _ := g.running.Load(runningKey(who.Name, slow))
+	rt := v.(runningTask)
+	rt.received = now.Add(-2 * time.Second)
+	g.running.Store(runningKey(who.Name, slow), rt)
+
+	g.checkTimeout(now)
+
+	state := getState(slow)
+	assert.Equal(t, model.Stop, state.Action)
+	assert.Equal(t, model.CanRun, state.State)
+	assert.Equal(t, model.TimedOut, state.StopReason)
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(slow))
+	assert.NoError(t, err)
+	var param model.Param
+	assert.NoError(t, json.Unmarshal(rsp.Kvs[0].Value, &param))
+	assert.True(t, param.IsStop())
+
+	for _, taskName := range []string{fast, reported} {
+		state = getState(taskName)
+		assert.Equal(t, model.Create, state.Action, taskName)
+		assert.Empty(t, state.StopReason, taskName)
+	}
+
+	_, ok := g.running.Load(runningKey(who.Name, slow))
+	assert.False(t, ok)
+	_, ok = g.running.Load(runningKey(who.Name, fast))
+	assert.True(t, ok)
+
+	// runtime断开之后不再检查
+	g.untrackRuntime(who)
+	_, ok = g.running.Load(runningKey(who.Name, fast))
+	assert.False(t, ok)
+}
+
+// 此函数依赖etcd是否存在
+// 超过StallWindow没有心跳的任务标记为stalled, 不会被停止, 收到心跳或者结果之后恢复
+func Test_CheckStalled(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	who := model.Whoami{Name: uuid.New().String()}
+	hung, alive := testCreateRunningTask(t, g, who), testCreateRunningTask(t, g, who)
+	for _, taskName := range []string{hung, alive} {
+		g.trackStart(who, &model.TaskStarted{TaskName: taskName, StartTime: time.Now(), StallWindow: 3 * time.Second})
+	}
+
+	now := time.Now().Add(4 * time.Second)
+	// alive在检查之前有心跳, 用gate收到心跳的时间计算
+	v, _ := g.running.Load(runningKey(who.Name, alive))
+	at := v.(runningTask)
+	at.lastAlive = now
+	g.running.Store(runningKey(who.Name, alive), at)
+
+	g.checkTimeout(now)
+	assert.Equal(t, model.Stalled, testGetState(t, g, hung).Liveness)
+	assert.Equal(t, model.Create, testGetState(t, g, hung).Action)
+	assert.Empty(t, testGetState(t, g, alive).Liveness)
+	_, ok := g.running.Load(runningKey(who.Name, hung))
+	assert.True(t, ok)
+
+	// 心跳恢复
+	g.trackAlive(who, &model.TaskAlive{TaskName: hung, Time: now})
+	assert.Empty(t, testGetState(t, g, hung).Liveness)
+
+	// 再次stalled之后上报结果
+	g.checkTimeout(time.Now().Add(4 * time.Second))
+	assert.Equal(t, model.Stalled, testGetState(t, g, hung).Liveness)
+	g.saveLastResult(who, &model.TaskResult{TaskName: hung, EndTime: time.Now()})
+	state := testGetState(t, g, hung)
+	assert.Empty(t, state.Liveness)
+	assert.NotNil(t, state.LastResult)
+}
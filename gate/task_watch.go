@@ -0,0 +1,84 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	stateEventPut    = "put"
+	stateEventDelete = "delete"
+)
+
+// 推送给客户端的状态变化
+type stateEvent struct {
+	TaskName string `json:"taskName"`
+	Revision int64  `json:"revision"`
+	// 删除时为空
+	State json.RawMessage `json:"state,omitempty"`
+}
+
+// 用SSE推送task状态的变化, 客户端断开时请求的ctx被取消, watch跟着关闭
+// 断线重连时带上Last-Event-ID, 从这个revision之后继续推送
+func (r *Gate) watchState(c *gin.Context) {
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		rev, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || rev <= 0 {
+			r.error(c, model.ErrValidation, "watchState:invalid Last-Event-ID:%s", id)
+			return
+		}
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	wch := defautlClient.Watch(clientv3.WithRequireLeader(ctx), model.GlobalTaskPrefixState+"/", opts...)
+
+	c.Header("Cache-Control", "no-cache")
+	// 关掉nginx的缓冲, 不然事件会攒着一起发
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("Content-Type", "text/event-stream")
+	// 先把响应头发出去, 客户端不用等到第一个事件才知道连接成功
+	c.Status(200)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		// gate退出时主动结束, 不然Shutdown要等到超时
+		case <-r.ctx.Done():
+			return false
+		case rsp, ok := <-wch:
+			if !ok {
+				return false
+			}
+			if err := rsp.Err(); err != nil {
+				r.Warn().Msgf("watchState:%s", err)
+				return false
+			}
+
+			for _, ev := range rsp.Events {
+				e := stateEvent{TaskName: model.TaskName(string(ev.Kv.Key)), Revision: ev.Kv.ModRevision}
+				name := stateEventPut
+				if ev.Type == clientv3.EventTypeDelete {
+					name = stateEventDelete
+				} else {
+					e.State = ev.Kv.Value
+				}
+
+				c.Render(-1, sse.Event{Id: strconv.FormatInt(e.Revision, 10), Event: name, Data: e})
+			}
+			return true
+		}
+	})
+}
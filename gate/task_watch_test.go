@@ -0,0 +1,82 @@
+package gate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 状态变化通过SSE推送, 客户端断开之后handler退出
+func Test_WatchState(t *testing.T) {
+	g := testInitEtcdGate(t)
+
+	done := make(chan struct{})
+	router := gin.New()
+	router.GET(model.TASK_UI_WATCH_URL, func(c *gin.Context) {
+		g.watchState(c)
+		close(done)
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+model.TASK_UI_WATCH_URL, nil)
+	assert.NoError(t, err)
+	rsp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+
+	taskName := uuid.New().String()
+	stateKey := model.FullGlobalTaskState(taskName)
+	value := `{"state":"canrun"}`
+	// 等watch建立好之后再写
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		defaultKVC.Put(g.ctx, stateKey, value)
+		defaultKVC.Delete(g.ctx, stateKey)
+	}()
+
+	events := map[string]stateEvent{}
+	scanner := bufio.NewScanner(rsp.Body)
+	name := ""
+	for len(events) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = line[len("event:"):]
+		case strings.HasPrefix(line, "data:"):
+			var e stateEvent
+			assert.NoError(t, json.Unmarshal([]byte(line[len("data:"):]), &e))
+			if e.TaskName == taskName {
+				events[name] = e
+			}
+		}
+	}
+
+	if assert.Contains(t, events, stateEventPut) {
+		assert.JSONEq(t, value, string(events[stateEventPut].State))
+		assert.NotZero(t, events[stateEventPut].Revision)
+	}
+	if assert.Contains(t, events, stateEventDelete) {
+		assert.Empty(t, events[stateEventDelete].State)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("watchState did not return after the client disconnected")
+	}
+}
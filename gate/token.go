@@ -0,0 +1,120 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	defaultTokenTTL          = 24 * time.Hour
+	defaultTokenRefreshGrace = time.Hour
+)
+
+// 没有配置jwt的参数时, 退回到内置的常量, 只适合本地调试
+func (r *Gate) initToken() {
+	if r.JWTSecret == "" {
+		r.Warn().Msgf("jwt-secret is not set, fall back to the built-in secret, do not use it in production")
+		r.JWTSecret = secretToken
+	}
+
+	if r.JWTIssuer == "" {
+		r.Warn().Msgf("jwt-issuer is not set, fall back to %s", serverName)
+		r.JWTIssuer = serverName
+	}
+
+	if r.TokenTTL <= 0 {
+		r.TokenTTL = defaultTokenTTL
+	}
+
+	if r.TokenRefreshGrace < 0 {
+		r.TokenRefreshGrace = defaultTokenRefreshGrace
+	}
+}
+
+// 生成token, 用户名保存在Subject
+// 返回的签发和过期时间和token里面的一致, 精确到秒, 客户端可以在过期之前主动刷新
+func (r *Gate) genToken(userName string) (wrapToken, error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		ExpiresAt: now.Add(r.TokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		Issuer:    r.JWTIssuer,
+		Subject:   userName,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(r.JWTSecret))
+	if err != nil {
+		return wrapToken{}, err
+	}
+	return wrapToken{
+		Token:     token,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// 解析token, 返回用户名
+func (r *Gate) parseToken(token string) (userName string, err error) {
+	return r.parseTokenWithGrace(token, 0, time.Now())
+}
+
+// 和parseToken一样, 只是过期时间不超过grace的token也认为有效, 刷新token时使用
+func (r *Gate) parseTokenWithGrace(token string, grace time.Duration, now time.Time) (userName string, err error) {
+	claims := &jwt.StandardClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method:%v", t.Header["alg"])
+		}
+		return []byte(r.JWTSecret), nil
+	})
+	if err != nil {
+		var ve *jwt.ValidationError
+		// 签名正确, 只是过期了
+		if grace <= 0 || !errors.As(err, &ve) || ve.Errors != jwt.ValidationErrorExpired {
+			return "", err
+		}
+
+		if now.Sub(time.Unix(claims.ExpiresAt, 0)) > grace {
+			return "", fmt.Errorf("token expired more than %s ago", grace)
+		}
+	}
+
+	if !claims.VerifyIssuer(r.JWTIssuer, true) {
+		return "", fmt.Errorf("unexpected issuer:%s", claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// 用还没有过期或者刚过期的token换一个新的token
+func (r *Gate) refreshToken(c *gin.Context) {
+	userName, err := r.parseTokenWithGrace(getToken(c), r.TokenRefreshGrace, time.Now())
+	if err != nil {
+		r.error(c, model.ErrUnauthorized, "refresh token:%s", err)
+		return
+	}
+
+	// 用户已经被删除, 不再续期
+	if _, err = r.loginTable.query(LoginCore{UserName: userName}); err != nil {
+		r.error(c, model.ErrUnauthorized, "refresh token:user(%s) not found:%s", userName, err)
+		return
+	}
+
+	token, err := r.genToken(userName)
+	if err != nil {
+		r.error(c, model.ErrInternal, "%s", err)
+		return
+	}
+
+	c.JSON(200, wrapData{
+		Data: token,
+	})
+}
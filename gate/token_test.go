@@ -0,0 +1,85 @@
+package gate
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/slog"
+	"github.com/stretchr/testify/assert"
+)
+
+// 密钥, 签发者不一致或者过期的token都解析失败
+func Test_Token(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error"), JWTSecret: "s1", JWTIssuer: "prod", TokenTTL: time.Hour}
+	g.initToken()
+
+	tok, err := g.genToken("guest")
+	assert.NoError(t, err)
+	token := tok.Token
+	// 签发和过期时间和token里面的一致
+	assert.WithinDuration(t, time.Now(), tok.IssuedAt, 2*time.Second)
+	assert.Equal(t, time.Hour, tok.ExpiresAt.Sub(tok.IssuedAt))
+
+	userName, err := g.parseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "guest", userName)
+
+	for _, other := range []*Gate{
+		{JWTSecret: "s2", JWTIssuer: "prod", TokenTTL: time.Hour},
+		{JWTSecret: "s1", JWTIssuer: "test", TokenTTL: time.Hour},
+	} {
+		_, err = other.parseToken(token)
+		assert.Error(t, err)
+	}
+
+	g.TokenTTL = -time.Second
+	tok, err = g.genToken("guest")
+	assert.NoError(t, err)
+	_, err = g.parseToken(tok.Token)
+	assert.Error(t, err)
+
+	// 没有配置时使用默认值
+	d := Gate{Slog: g.Slog}
+	d.initToken()
+	assert.Equal(t, secretToken, d.JWTSecret)
+	assert.Equal(t, serverName, d.JWTIssuer)
+	assert.Equal(t, defaultTokenTTL, d.TokenTTL)
+}
+
+// 过期时间在grace之内的token可以刷新, 超过grace或者签名不对的拒绝
+func Test_ParseTokenWithGrace(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("error"), TokenTTL: time.Minute}
+	g.initToken()
+
+	var token wrapToken
+	var err error
+	now := time.Now()
+	for _, tc := range []struct {
+		now   time.Time
+		grace time.Duration
+		ok    bool
+	}{
+		{now, 0, true},
+		{now.Add(2 * time.Minute), 0, false},
+		{now.Add(30 * time.Minute), time.Hour, true},
+		{now.Add(2 * time.Hour), time.Hour, false},
+	} {
+		// jwt库用的是系统时间判断过期, 这里通过修改有效期模拟时间流逝
+		g.TokenTTL = time.Minute - tc.now.Sub(now)
+		token, err = g.genToken("guest")
+		assert.NoError(t, err)
+
+		userName, err := g.parseTokenWithGrace(token.Token, tc.grace, now)
+		if tc.ok {
+			assert.NoError(t, err, tc.now)
+			assert.Equal(t, "guest", userName)
+		} else {
+			assert.Error(t, err, tc.now)
+		}
+	}
+
+	other := &Gate{JWTSecret: "other", JWTIssuer: g.JWTIssuer}
+	_, err = other.parseTokenWithGrace(token.Token, time.Hour, now)
+	assert.Error(t, err)
+}
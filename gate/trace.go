@@ -0,0 +1,164 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/utils"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/1whour/crab/gate"
+
+// 初始化opentelemetry, TraceEndpoint为空时不导出span
+// 传播器总是设置的, 这样即使本gate不导出span, 上游的trace context也能继续往runtime传递
+func (r *Gate) initTrace() error {
+	// 0表示不采样新的trace, 上游已经采样的还是会跟着采样
+	if r.TraceSampleRatio < 0 || r.TraceSampleRatio > 1 {
+		return fmt.Errorf("trace-sample-ratio(%v) must be in [0, 1]", r.TraceSampleRatio)
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	r.tracer = otel.Tracer(tracerName)
+
+	if r.TraceEndpoint == "" {
+		return nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(r.TraceEndpoint)}
+	if r.TraceInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(r.ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("create otlp exporter:%w", err)
+	}
+
+	r.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(r.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("crab-gate"),
+			semconv.ServiceInstanceIDKey.String(r.Name),
+		)),
+	)
+	otel.SetTracerProvider(r.tracerProvider)
+	r.tracer = r.tracerProvider.Tracer(tracerName)
+	return nil
+}
+
+// http接口的span, 从请求头里面提取上游的trace context
+func (r *Gate) traceHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 长连接的生命周期太长, 不适合作为一个span, 下发的span见startDispatchSpan
+		if c.Request.URL.Path == model.TASK_STREAM_URL {
+			c.Next()
+			return
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		ctx, span := r.tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(c.Request.Method),
+				semconv.HTTPRouteKey.String(route),
+				attribute.String("request_id", getRequestID(c)),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// etcd操作的span, etcd操作要用返回的ctx, 里面的调用才会挂在这个span下面
+func (r *Gate) startEtcdSpan(ctx context.Context, op string, key string) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, "etcd."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("etcd.key", key)))
+}
+
+// 结束span, 出错时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 把当前的trace context写入task, 跟随task保存到etcd, 下发时再取出来
+func injectTrace(ctx context.Context, req *model.Param) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	req.Trace = carrier
+}
+
+// 下发task至runtime的span
+// 从task里面取出创建时的trace context, 并把下发的span写回task, 让runtime能接着这个trace
+func (r *Gate) startDispatchSpan(param *model.Param, runtimeName string) trace.Span {
+	ctx := otel.GetTextMapPropagator().Extract(r.ctx, propagation.MapCarrier(param.Trace))
+	ctx, span := r.getTracer().Start(ctx, "websocket.dispatch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("task.name", param.Executer.TaskName),
+			attribute.String("task.action", param.Action),
+			attribute.String("runtime.name", runtimeName),
+		))
+
+	if span.SpanContext().IsValid() {
+		injectTrace(ctx, param)
+	}
+	r.Debug().Msgf("gate.dispatch:taskName:%s, action:%s, runtime:%s, trace_id:%s",
+		param.Executer.TaskName, param.Action, runtimeName, utils.TraceID(param.Trace))
+	return span
+}
+
+// runtime上报执行结果的span
+// 结果里面带回了下发时的trace context, 这个span是下发span的子span
+func (r *Gate) startResultSpan(who model.Whoami, result *model.TaskResult) trace.Span {
+	ctx := otel.GetTextMapPropagator().Extract(r.ctx, propagation.MapCarrier(result.Trace))
+	_, span := r.getTracer().Start(ctx, "websocket.result",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task.name", result.TaskName),
+			attribute.String("runtime.name", who.Name),
+			attribute.Int("task.exit_code", result.ExitCode),
+			attribute.Int64("task.duration_ms", result.Duration.Milliseconds()),
+		))
+
+	r.Debug().Msgf("gate.result:taskName:%s, runtime:%s, exit_code:%d, trace_id:%s",
+		result.TaskName, who.Name, result.ExitCode, utils.TraceID(result.Trace))
+	return span
+}
+
+// 没有调用initTrace时(比如测试里面)使用全局的tracer
+func (r *Gate) getTracer() trace.Tracer {
+	if r.tracer == nil {
+		return otel.Tracer(tracerName)
+	}
+	return r.tracer
+}
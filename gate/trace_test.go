@@ -0,0 +1,94 @@
+package gate
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/1whour/crab/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// runtime原样带回下发时的trace context, 结果的span和下发的span在同一个trace里面
+func Test_ResultSpan_FollowsDispatch(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.TODO())
+
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled"), ctx: context.TODO(), tracer: provider.Tracer(tracerName)}
+
+	var param model.Param
+	param.Action = model.Create
+	param.Executer.TaskName = "trace-task"
+	endSpan(g.startDispatchSpan(&param, "runtime-1"), nil)
+
+	traceID := utils.TraceID(param.Trace)
+	assert.NotEmpty(t, traceID)
+
+	result := model.TaskResult{TaskName: param.Executer.TaskName, ExitCode: 2, Trace: param.Trace}
+	endSpan(g.startResultSpan(model.Whoami{Name: "runtime-1"}, &result), nil)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		dispatch, rsp := spans[0], spans[1]
+		assert.Equal(t, "websocket.dispatch", dispatch.Name())
+		assert.Equal(t, "websocket.result", rsp.Name())
+		assert.Equal(t, traceID, dispatch.SpanContext().TraceID().String())
+		assert.Equal(t, traceID, rsp.SpanContext().TraceID().String())
+		assert.Equal(t, dispatch.SpanContext().SpanID(), rsp.Parent().SpanID())
+	}
+
+	// 老的runtime不带trace context, 结果的span是一个新的trace
+	endSpan(g.startResultSpan(model.Whoami{Name: "runtime-1"}, &model.TaskResult{TaskName: "trace-task"}), nil)
+	spans = recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		assert.False(t, spans[2].Parent().IsValid())
+	}
+}
+
+// etcd的span挂在请求的span下面, 返回的ctx带上这个span, 也保留etcd操作的超时
+func Test_EtcdSpan_Context(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.TODO())
+
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled"), ctx: context.TODO(), tracer: provider.Tracer(tracerName)}
+	reqCtx, reqSpan := g.tracer.Start(context.TODO(), "http")
+	ctx, cancel := context.WithTimeout(reqCtx, time.Second)
+	defer cancel()
+
+	spanCtx, span := g.startEtcdSpan(ctx, "get", "key")
+	_, hasDeadline := spanCtx.Deadline()
+	assert.True(t, hasDeadline)
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(spanCtx))
+	endSpan(span, nil)
+	reqSpan.End()
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "etcd.get", spans[0].Name())
+		assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	}
+}
+
+// 采样率不在[0, 1]里面时启动失败
+func Test_InitTrace_SampleRatio(t *testing.T) {
+	for _, ratio := range []float64{-0.1, 1.5} {
+		g := &Gate{TraceSampleRatio: ratio, ctx: context.Background()}
+		assert.Error(t, g.initTrace(), ratio)
+	}
+
+	for _, ratio := range []float64{0, 0.5, 1} {
+		g := &Gate{TraceSampleRatio: ratio, ctx: context.Background()}
+		assert.NoError(t, g.initTrace(), ratio)
+	}
+}
@@ -0,0 +1,65 @@
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/1whour/crab/store/etcd"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 带旧的revision更新会失败, 带最新的revision可以更新成功
+func Test_UpdateDataAndState_Revision(t *testing.T) {
+	g := testInitEtcdGate(t)
+	var err error
+
+	taskName := uuid.New().String()
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	revision, err := defaultStore.LockCreateDataAndState(g.ctx, taskName, &param)
+	assert.NoError(t, err)
+
+	rsp, err := defaultKVC.Get(g.ctx, model.FullGlobalTask(taskName))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.Kvs[0].ModRevision, revision)
+
+	param.SetUpdate()
+	newRevision, err := defaultStore.LockUpdateDataAndState(g.ctx, taskName, &param, revision, model.CanRun, model.Update)
+	assert.NoError(t, err)
+	assert.Greater(t, newRevision, revision)
+
+	// 另外一个客户端还拿着旧的revision
+	_, err = defaultStore.LockUpdateDataAndState(g.ctx, taskName, &param, revision, model.CanRun, model.Update)
+	assert.True(t, errors.Is(err, etcd.ErrRevisionMismatch), err)
+
+	assert.NoError(t, defaultStore.LockDeleteDataAndState(g.ctx, taskName))
+}
+
+// 开启RequireRevision之后, 没有带X-Task-Revision的更新返回428
+func Test_UpdateTask_RequireRevision(t *testing.T) {
+	g := Gate{Slog: slog.New(os.Stdout).SetLevel("disabled"), RequireRevision: true}
+	router := gin.New()
+	router.PUT(model.TASK_UPDATE_URL, g.updateTask)
+
+	body := `{"apiVersion":"v0.0.1","kind":"oneRuntime","trigger":{"cron":"* * * * * *"},"executer":{"taskName":"a","shell":{"command":"echo"}}}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, model.TASK_UPDATE_URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code, w.Body.String())
+
+	var rsp errorRsp
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	assert.Equal(t, model.ErrPreconditionRequired, rsp.Code)
+	assert.Contains(t, rsp.Message, revisionHeader)
+}
@@ -0,0 +1,284 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guonaihong/gout"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 退避时间的上限
+	maxWebhookBackoff = 10 * time.Minute
+	// 扫描重试队列的间隔
+	webhookScanInterval = time.Second
+)
+
+// 保存在etcd里面的待投递的webhook
+// 先落盘再投递, gate重启之后还能继续投递, 语义是至少一次
+type webhookDelivery struct {
+	ID        string          `json:"id"`
+	URL       string          `json:"url"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// 下次投递的时间
+	NextAt    time.Time `json:"nextAt"`
+	LastError string    `json:"lastError"`
+}
+
+// 设置webhook的默认值
+func (r *Gate) initWebhook() {
+	r.webhookNotify = make(chan struct{}, 1)
+	if r.WebhookMaxRetries <= 0 {
+		r.WebhookMaxRetries = 10
+	}
+	if r.WebhookBackoff <= 0 {
+		r.WebhookBackoff = time.Second
+	}
+	if r.WebhookMaxAge <= 0 {
+		r.WebhookMaxAge = 24 * time.Hour
+	}
+	if r.WebhookTimeout <= 0 {
+		r.WebhookTimeout = 3 * time.Second
+	}
+	if r.OutboundWorkers <= 0 {
+		r.OutboundWorkers = 8
+	}
+	if r.OutboundQueueSize <= 0 {
+		r.OutboundQueueSize = 128
+	}
+
+	// 没有配置webhook时不会有出站调用, 不启动协程池
+	if r.WebhookURL == "" {
+		return
+	}
+	r.outbound = newOutboundPool(r.OutboundWorkers, r.OutboundQueueSize, r.WebhookTimeout)
+	r.outbound.start(r.ctx, r.OutboundWorkers)
+}
+
+// 第attempts次失败之后的退避时间, 指数退避
+func (r *Gate) webhookBackoff(attempts int) time.Duration {
+	backoff := r.WebhookBackoff
+	for i := 1; i < attempts && backoff < maxWebhookBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxWebhookBackoff {
+		backoff = maxWebhookBackoff
+	}
+	return backoff
+}
+
+// 任务执行结束之后, 把通知写入etcd的重试队列
+func (r *Gate) enqueueWebhook(ctx context.Context, rc model.ResultCore) error {
+	body, err := json.Marshal(rc)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d := webhookDelivery{ID: uuid.New().String(), URL: r.WebhookURL, Body: body, CreatedAt: now, NextAt: now}
+	all, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	if _, err = defaultKVC.Put(ctx, model.FullWebhookQueue(d.ID), string(all)); err != nil {
+		return err
+	}
+
+	// 唤醒投递的goroutine, 不用等下一个扫描周期
+	select {
+	case r.webhookNotify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 投递一次webhook, 2xx算成功
+func (r *Gate) deliverWebhook(d *webhookDelivery) error {
+	code := 0
+	err := gout.New(r.outbound.client).POST(d.URL).Debug(false).SetJSON(d.Body).Code(&code).Do()
+	if err != nil {
+		return err
+	}
+
+	if code < 200 || code >= 300 {
+		return fmt.Errorf("webhook status code:%d", code)
+	}
+	return nil
+}
+
+// 基于ModRevision的cas写入, 多个gate同时处理一个queue时, 只有一个会成功
+// 带上主节点的条件, 已经不是主节点时写不进去
+func (r *Gate) casPutWebhook(key string, modRevision int64, d *webhookDelivery) (bool, error) {
+	all, err := json.Marshal(d)
+	if err != nil {
+		return false, err
+	}
+
+	cmps := append(r.leaderFence(), clientv3.Compare(clientv3.ModRevision(key), "=", modRevision))
+	rsp, err := defaultKVC.Txn(r.ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(key, string(all))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return rsp.Succeeded, nil
+}
+
+// 处理一个到期的webhook, 在出站协程池里面执行
+func (r *Gate) processWebhook(key string, modRevision int64, d webhookDelivery, now time.Time) error {
+	// 先抢占, 投递期间别的gate不会重复投递; 如果gate在投递中退出, 抢占过期之后会被重新投递
+	d.NextAt = now.Add(r.WebhookTimeout * 2)
+	ok, err := r.casPutWebhook(key, modRevision, &d)
+	if err != nil || !ok {
+		return err
+	}
+
+	rsp, err := defaultKVC.Get(r.ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(rsp.Kvs) == 0 {
+		return nil
+	}
+	modRevision = rsp.Kvs[0].ModRevision
+
+	if err = r.deliverWebhook(&d); err == nil {
+		_, err = defaultKVC.Delete(r.ctx, key)
+		return err
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= r.WebhookMaxRetries || now.Sub(d.CreatedAt) >= r.WebhookMaxAge {
+		// 超过重试次数或者最大时间, 移到死信队列
+		all, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+
+		r.Warn().Msgf("webhook(%s) move to dead letter, attempts:%d, lastError:%s", d.ID, d.Attempts, d.LastError)
+		cmps := append(r.leaderFence(), clientv3.Compare(clientv3.ModRevision(key), "=", modRevision))
+		_, err = defaultKVC.Txn(r.ctx).
+			If(cmps...).
+			Then(clientv3.OpPut(model.FullWebhookDead(d.ID), string(all)), clientv3.OpDelete(key)).
+			Commit()
+		return err
+	}
+
+	d.NextAt = now.Add(r.webhookBackoff(d.Attempts))
+	_, err = r.casPutWebhook(key, modRevision, &d)
+	return err
+}
+
+// 扫描一遍重试队列, 到期的webhook交给出站协程池投递, 等这一批处理完再返回
+// 队列满了被丢弃的webhook还在etcd里面, 下一次扫描会重新投递
+func (r *Gate) processWebhookQueue(now time.Time) error {
+	rsp, err := defaultKVC.Get(r.ctx, model.WebhookQueuePrefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, kv := range rsp.Kvs {
+		key := string(kv.Key)
+
+		var d webhookDelivery
+		if err := json.Unmarshal(kv.Value, &d); err != nil {
+			// 坏数据没有重试的意义
+			r.Warn().Msgf("webhook:unmarshal %s:%s, delete it", key, err)
+			defaultKVC.Delete(r.ctx, key)
+			continue
+		}
+
+		if now.Before(d.NextAt) {
+			continue
+		}
+
+		modRevision := kv.ModRevision
+		wg.Add(1)
+		ok := r.outbound.submit(func() {
+			defer wg.Done()
+			// 单个失败不影响别的webhook, 返回最后一个错误
+			if e := r.processWebhook(key, modRevision, d, now); e != nil {
+				mu.Lock()
+				err = e
+				mu.Unlock()
+			}
+		})
+		if !ok {
+			wg.Done()
+		}
+	}
+
+	// 退出时协程池不再执行队列里面的任务, 不能一直等
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return err
+}
+
+// 后台投递webhook的goroutine, 只在主节点上运行
+func (r *Gate) webhookLoop(ctx context.Context) {
+	tk := time.NewTicker(webhookScanInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		case <-r.webhookNotify:
+		}
+
+		if err := r.processWebhookQueue(time.Now()); err != nil {
+			r.Warn().Msgf("gate.webhookLoop:%s", err)
+		}
+	}
+}
+
+// 死信队列, 超过重试次数的webhook
+func (g *Gate) webhookDeadList(c *gin.Context) {
+	ctx, cancel := g.etcdCtx(c)
+	defer cancel()
+
+	rsp, err := defaultKVC.Get(ctx, model.WebhookDeadPrefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		g.etcdError(c, ctx, err, "webhookDeadList")
+		return
+	}
+
+	list := make([]webhookDelivery, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		var d webhookDelivery
+		if err := json.Unmarshal(kv.Value, &d); err != nil {
+			g.Warn().Msgf("webhookDeadList:unmarshal %s:%s", kv.Key, err)
+			continue
+		}
+		list = append(list, d)
+	}
+
+	c.JSON(200, wrapData{Data: list})
+}
@@ -0,0 +1,106 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 投递失败之后留在队列里面退避重试, 成功之后从队列删除, 超过重试次数进入死信队列
+func Test_Webhook_RetryAndDeadLetter(t *testing.T) {
+	var fail int32 = 1
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer srv.Close()
+
+	g := testInitEtcdGate(t)
+	g.WebhookURL = srv.URL
+	g.WebhookMaxRetries = 3
+	g.initWebhook()
+
+	getQueue := func(prefix string, id string) []byte {
+		rsp, err := defaultKVC.Get(g.ctx, prefix+"/"+id)
+		assert.NoError(t, err)
+		if len(rsp.Kvs) == 0 {
+			return nil
+		}
+		return rsp.Kvs[0].Value
+	}
+
+	rc := model.ResultCore{TaskID: "id", TaskName: "webhook-test"}
+	deliver := func() string {
+		assert.NoError(t, g.enqueueWebhook(g.ctx, rc))
+		rsp, err := defaultKVC.Get(g.ctx, model.WebhookQueuePrefix+"/", clientv3.WithPrefix())
+		assert.NoError(t, err)
+		assert.Len(t, rsp.Kvs, 1)
+		return model.TaskName(string(rsp.Kvs[0].Key))
+	}
+
+	// 失败一次之后成功
+	id := deliver()
+	now := time.Now()
+	g.processWebhookQueue(now)
+	assert.NotNil(t, getQueue(model.WebhookQueuePrefix, id))
+	// 还在退避时间内, 不会再投递
+	g.processWebhookQueue(now)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	atomic.StoreInt32(&fail, 0)
+	g.processWebhookQueue(now.Add(time.Minute))
+	assert.Nil(t, getQueue(model.WebhookQueuePrefix, id))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// 一直失败, 进入死信队列
+	atomic.StoreInt32(&fail, 1)
+	id = deliver()
+	for i := 0; i < g.WebhookMaxRetries; i++ {
+		now = now.Add(time.Hour)
+		g.processWebhookQueue(now)
+	}
+	assert.Nil(t, getQueue(model.WebhookQueuePrefix, id))
+	assert.NotNil(t, getQueue(model.WebhookDeadPrefix, id))
+
+	defaultKVC.Delete(g.ctx, model.FullWebhookDead(id))
+}
+
+// 此函数依赖etcd是否存在
+// 没有配置webhook时不启动协程池, 退出时不再等没有执行的投递
+func Test_Webhook_StopOnShutdown(t *testing.T) {
+	g := testInitEtcdGate(t)
+	g.initWebhook()
+	assert.Nil(t, g.outbound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.ctx = ctx
+	g.WebhookURL = "http://127.0.0.1:1"
+	g.initWebhook()
+	// 换成没有worker的协程池, 模拟worker已经退出
+	g.outbound = newOutboundPool(1, 8, time.Second)
+
+	assert.NoError(t, g.enqueueWebhook(context.Background(), model.ResultCore{TaskID: "id", TaskName: "webhook-stop-test"}))
+	defer defaultKVC.Delete(context.Background(), model.WebhookQueuePrefix+"/", clientv3.WithPrefix())
+
+	done := make(chan error, 1)
+	go func() { done <- g.processWebhookQueue(time.Now()) }()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("processWebhookQueue blocked after shutdown")
+	}
+}
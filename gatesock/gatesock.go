@@ -1,7 +1,7 @@
 package gatesock
 
 import (
-	"strings"
+	"crypto/tls"
 	"sync"
 	"time"
 
@@ -11,6 +11,15 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// 总是请求压缩, gate没有开启时协商不成功, 退回不压缩
+// 带上支持的子协议, gate选出双方都支持的消息格式版本
+var dialer = func() websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.EnableCompression = true
+	d.Subprotocols = model.StreamProtocols
+	return d
+}()
+
 type Callback func(conn *websocket.Conn, param *model.Param) (payload []byte, err error)
 
 type GateSock struct {
@@ -22,10 +31,15 @@ type GateSock struct {
 	writeTimeout time.Duration
 	lambda       bool
 	mu           *sync.Mutex
+
+	// 同时运行的任务上限, 通过whoami上报给gate
+	maxConcurrency int
+	// 连接wss://的gate时使用
+	tlsConfig *tls.Config
 }
 
-func New(slog *slog.Slog, cb Callback, gateAddr string, name string, writeTimeout time.Duration, mu *sync.Mutex, lambda bool, id string) *GateSock {
-	return &GateSock{Slog: slog, callback: cb, gateAddr: gateAddr, name: name, writeTimeout: writeTimeout, mu: mu, lambda: lambda, id: id}
+func New(slog *slog.Slog, cb Callback, gateAddr string, name string, writeTimeout time.Duration, mu *sync.Mutex, lambda bool, id string, maxConcurrency int, tlsConfig *tls.Config) *GateSock {
+	return &GateSock{Slog: slog, callback: cb, gateAddr: gateAddr, name: name, writeTimeout: writeTimeout, mu: mu, lambda: lambda, id: id, maxConcurrency: maxConcurrency, tlsConfig: tlsConfig}
 }
 
 // 接受来自gate服务的命令, 执行并返回结果
@@ -43,6 +57,8 @@ func (g *GateSock) readLoop(conn *websocket.Conn) error {
 		}
 	}()
 
+	// 每个task最近一次收到的DispatchID, gate没有收到ack时会用同一个DispatchID重发
+	dispatched := make(map[string]string)
 	for {
 		var param model.Param
 		err := conn.ReadJSON(&param) //这里不加超时时间, 一直监听gate推过来的信息
@@ -50,6 +66,25 @@ func (g *GateSock) readLoop(conn *websocket.Conn) error {
 			return err
 		}
 
+		// v2协议下发的task带DispatchID, 收到之后先回ack, 重发的只回ack不再执行
+		if param.DispatchID != "" {
+			if err := g.writeAck(conn, &param); err != nil {
+				g.Warn().Msgf("write ack:%s, taskName:%s\n", err, param.Executer.TaskName)
+			}
+			if dispatched[param.Executer.TaskName] == param.DispatchID {
+				continue
+			}
+			dispatched[param.Executer.TaskName] = param.DispatchID
+		}
+
+		// syncdone要在后面的任务之前处理完, 不然会把刚下发的任务停掉
+		if param.IsSyncDone() {
+			if _, err := g.callback(conn, &param); err != nil {
+				g.Error().Msgf("runtime.runCrud, action(%s):%s\n", param.Action, err)
+			}
+			continue
+		}
+
 		go func() {
 			g.Debug().Msgf("crud action:%s, taskName:%s\n", param.Action, param.Executer.TaskName)
 			payload, err := g.callback(conn, &param)
@@ -65,16 +100,20 @@ func (g *GateSock) readLoop(conn *websocket.Conn) error {
 
 }
 
-func genGateAddr(gateAddr string) string {
-	if strings.HasPrefix(gateAddr, "ws://") || strings.HasPrefix(gateAddr, "wss://") {
-		return gateAddr
-	}
-	return "ws://" + gateAddr
+func (g *GateSock) writeWhoami(conn *websocket.Conn) (err error) {
+	g.mu.Lock()
+	err = utils.WriteJsonTimeout(conn, model.Whoami{Name: g.name, Lambda: g.lambda, Id: g.id, MaxConcurrency: g.maxConcurrency}, g.writeTimeout)
+	g.mu.Unlock()
+	return err
 }
 
-func (g *GateSock) writeWhoami(conn *websocket.Conn) (err error) {
+func (g *GateSock) writeAck(conn *websocket.Conn, param *model.Param) (err error) {
+	msg := model.RuntimeMsg{
+		Whoami: model.Whoami{Name: g.name, Lambda: g.lambda, Id: g.id, MaxConcurrency: g.maxConcurrency},
+		Ack:    &model.TaskAck{TaskName: param.Executer.TaskName, DispatchID: param.DispatchID},
+	}
 	g.mu.Lock()
-	err = utils.WriteJsonTimeout(conn, model.Whoami{Name: g.name, Lambda: g.lambda, Id: g.id}, g.writeTimeout)
+	err = utils.WriteJsonTimeout(conn, msg, g.writeTimeout)
 	g.mu.Unlock()
 	return err
 }
@@ -82,18 +121,29 @@ func (g *GateSock) writeWhoami(conn *websocket.Conn) (err error) {
 // 创建一个长连接
 func (g *GateSock) CreateConntion() error {
 
-	gateAddr := genGateAddr(g.gateAddr) + model.TASK_STREAM_URL
-	c, _, err := websocket.DefaultDialer.Dial(gateAddr, nil)
+	gateAddr := utils.GateWsAddr(g.gateAddr) + model.TASK_STREAM_URL
+	d := dialer
+	// gate开启tls时用来校验证书, 为空时用系统的根证书
+	d.TLSClientConfig = g.tlsConfig
+	c, _, err := d.Dial(gateAddr, nil)
 	if err != nil {
 		g.Error().Msgf("runtime:dial:%s, address:%s\n", err, gateAddr)
 		return err
 	}
 
 	defer c.Close()
+	// 没有协商成功时不生效
+	c.EnableWriteCompression(true)
+	// 老版本的gate不返回子协议, 当作v1
+	g.Info().Msgf("runtime:connected to gate:%s, protocol:%s\n", gateAddr, model.StreamProtocol(c.Subprotocol()))
 
 	if err := g.writeWhoami(c); err != nil {
 		return err
 	}
 
-	return g.readLoop(c)
+	err = g.readLoop(c)
+	if websocket.IsCloseError(err, websocket.CloseProtocolError) {
+		g.Error().Msgf("runtime:gate rejected the protocol:%s, address:%s\n", err, gateAddr)
+	}
+	return err
 }
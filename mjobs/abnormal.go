@@ -1,9 +1,11 @@
 package mjobs
 
 import (
+	"sort"
 	"time"
 
 	"github.com/1whour/crab/model"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -95,12 +97,9 @@ func (m *Mjobs) restartRunning() {
 		}
 
 		// 遍历所有的全局任务
-		for _, kv := range rsp.Kvs {
-			state, err := model.ValueToState(kv.Value)
-			if err != nil {
-				m.Error().Msgf("restartRunning: value to state:%v\n", err)
-				continue
-			}
+		// 排队的任务按优先级分配, runtime空出名额时高优先级的先拿到
+		for _, task := range sortByPriority(m.toStates(rsp.Kvs)) {
+			kv, state := task.kv, task.state
 
 			if defaultStore.NeedFix(m.ctx, state) {
 				m.Debug().Msgf("restartRunning, need fix %s, state:%v\n", kv.Key, state)
@@ -133,3 +132,33 @@ func (m *Mjobs) restartRunning() {
 	}
 
 }
+
+type stateKv struct {
+	kv    *mvccpb.KeyValue
+	state model.State
+}
+
+// 解析状态, 解析失败的跳过
+func (m *Mjobs) toStates(kvs []*mvccpb.KeyValue) []stateKv {
+	tasks := make([]stateKv, 0, len(kvs))
+	for _, kv := range kvs {
+		state, err := model.ValueToState(kv.Value)
+		if err != nil {
+			m.Error().Msgf("restartRunning: value to state:%v\n", err)
+			continue
+		}
+		tasks = append(tasks, stateKv{kv: kv, state: state})
+	}
+	return tasks
+}
+
+// 优先级高的排前面, 相同优先级按创建的先后顺序
+func sortByPriority(tasks []stateKv) []stateKv {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if tasks[i].state.Priority != tasks[j].state.Priority {
+			return tasks[i].state.Priority > tasks[j].state.Priority
+		}
+		return tasks[i].kv.CreateRevision < tasks[j].kv.CreateRevision
+	})
+	return tasks
+}
@@ -1,7 +1,34 @@
 package mjobs
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
 
 func Test_NeedRestart(t *testing.T) {
 
 }
+
+// 优先级高的先分配, 相同优先级按创建顺序
+func Test_SortByPriority(t *testing.T) {
+	task := func(name string, priority int, rev int64) stateKv {
+		return stateKv{kv: &mvccpb.KeyValue{Key: []byte(name), CreateRevision: rev}, state: model.State{Priority: priority}}
+	}
+
+	tasks := sortByPriority([]stateKv{
+		task("a", 0, 3),
+		task("b", 0, 1),
+		task("c", 5, 4),
+		task("d", -1, 0),
+		task("e", 5, 2),
+	})
+
+	var got []string
+	for _, v := range tasks {
+		got = append(got, string(v.kv.Key))
+	}
+	assert.Equal(t, []string{"e", "c", "b", "a", "d"}, got)
+}
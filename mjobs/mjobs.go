@@ -25,6 +25,14 @@ type Mjobs struct {
 	NodeName  string        `clop:"short;long" usage:"node name"`
 	Level     string        `clop:"short;long" usage:"log level"`
 	LeaseTime time.Duration `clop:"long" usage:"lease time" default:"10s"`
+	// etcd开启认证或者tls时配置
+	EtcdUsername string `clop:"long" usage:"etcd username"`
+	EtcdPassword string `clop:"long;env=CRAB_ETCD_PASSWORD" usage:"etcd password"`
+	EtcdCA       string `clop:"long" usage:"etcd tls ca file"`
+	EtcdCert     string `clop:"long" usage:"etcd tls client certificate file"`
+	EtcdKey      string `clop:"long" usage:"etcd tls client private key file"`
+	// 多个部署共用一个etcd集群时用来隔离key, 同一个部署的gate, mjobs, runtime必须一致
+	Namespace string `clop:"long;env=CRAB_NAMESPACE" usage:"etcd key namespace, isolates deployments sharing one etcd cluster"`
 
 	*slog.Slog
 	ctx context.Context
@@ -45,15 +53,27 @@ func (m *Mjobs) init() (err error) {
 	if m.NodeName == "" {
 		m.NodeName = uuid.New().String()
 	}
+	if err = model.ValidateNodeName(m.NodeName); err != nil {
+		return err
+	}
 	m.Slog = slog.New(os.Stdout).SetLevel(m.Level).Str("mjobs", m.NodeName)
 
-	if defautlClient, err = utils.NewEtcdClient(m.EtcdAddr); err != nil { //初始etcd客户端
+	conf := utils.EtcdConfig{
+		Username:  m.EtcdUsername,
+		Password:  m.EtcdPassword,
+		CAFile:    m.EtcdCA,
+		CertFile:  m.EtcdCert,
+		KeyFile:   m.EtcdKey,
+		Namespace: m.Namespace,
+	}
+	if defautlClient, err = utils.NewEtcdClientWithConfig(m.EtcdAddr, conf); err != nil { //初始etcd客户端
 		return err
 	}
 
-	defaultKVC = clientv3.NewKV(defautlClient) // 内置自动重试的逻辑
-	defaultStore, err = etcd.NewStore(m.EtcdAddr, m.Slog, &m.runtimeNode)
-	return err
+	defaultKVC = defautlClient.KV // 内置自动重试的逻辑, 配置了namespace时自动加上前缀
+	defaultStore = etcd.NewStoreWithClient(defautlClient, m.Slog, &m.runtimeNode)
+	go utils.WatchEtcdConn(m.ctx, m.Slog, defautlClient)
+	return nil
 }
 
 func (m *Mjobs) SubMain() {
@@ -90,7 +90,7 @@ func (m *Mjobs) watchRuntimeNode() {
 				// 被删除
 				go func() {
 					if err := m.failover(string(ev.Kv.Key)); err != nil {
-						m.Warn().Msgf("Is this key() modified??? Not expected\n", string(ev.Kv.Key))
+						m.Warn().Msgf("Is this key(%s) modified??? Not expected\n", string(ev.Kv.Key))
 					}
 				}()
 				m.runtimeNode.Delete(string(ev.Kv.Key))
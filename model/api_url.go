@@ -2,13 +2,27 @@ package model
 
 const (
 	// 管理task相关接口
-	TASK_STREAM_URL    = "/crab/task/stream"
-	TASK_CREATE_URL    = "/crab/task/"
-	TASK_DELETE_URL    = "/crab/task/"
-	TASK_UPDATE_URL    = "/crab/task/"
-	TASK_STOP_URL      = "/crab/task/stop"
-	TASK_CONTINUE_URL  = "/crab/task/continue"
+	TASK_STREAM_URL   = "/crab/task/stream"
+	TASK_CREATE_URL   = "/crab/task/"
+	TASK_BATCH_URL    = "/crab/task/batch"
+	TASK_DELETE_URL   = "/crab/task/"
+	TASK_UPDATE_URL   = "/crab/task/"
+	TASK_STOP_URL     = "/crab/task/stop"
+	TASK_CONTINUE_URL = "/crab/task/continue"
+	// 按task名前缀或者label批量删除, DELETE
+	TASK_DELETE_BATCH_URL = "/crab/task/batch"
+	// 停止所有运行中的task, 可以按label过滤, POST
+	TASK_STOP_ALL_URL = "/crab/task/stop-all"
+	// 禁用和启用task, POST
+	TASK_DISABLE_URL = "/crab/task/:name/disable"
+	TASK_ENABLE_URL  = "/crab/task/:name/enable"
+	// task的执行历史, GET
+	TASK_HISTORY_URL = "/crab/task/:name/history"
+	// 单个task的数据和状态, GET
+	TASK_DETAIL_URL    = "/crab/task/:name"
 	TASK_UI_STATUS_URL = "/crab/ui/task/status"
+	// 状态变化的推送, SSE
+	TASK_UI_WATCH_URL = "/crab/ui/task/watch"
 
 	// 执行任务时的保存结果
 	TASK_EXECUTER_RESULT_URL = "/crab/ui/task/result"
@@ -21,18 +35,47 @@ const (
 	UI_RUNTIME_LIST = "/crab/ui/runtime-node/list"
 	// 获取gate 结果列表
 	UI_GATE_LIST = "/crab/ui/gate/list"
+	// 所有gate的地址和元数据, GET
+	GATES_URL = "/crab/gates"
 	// 获取gate 连接的runtime个数
 	UI_GATE_COUNT = "/crab/ui/gate/count"
 	// 用户登录, POST
 	UI_USER_LOGIN = "/crab/ui/user/login"
+	// 刷新token, POST
+	UI_USER_REFRESH = "/crab/ui/user/refresh"
+	// 修改密码, POST
+	UI_USER_PASSWORD = "/crab/ui/user/password"
 	// 退出
 	UI_USER_LOGOUT = "/crab/ui/user/logout"
 	// 删除用户, DELETE
 	UI_USER_DELETE_URL = "/crab/ui/user"
+	// 恢复删除的用户, POST
+	UI_USER_RESTORE_URL = "/crab/ui/user/restore"
 	// 获取一批用户信息或者单个，如果带name就过滤单个用户的信息
 	UI_USER_INFO = "/crab/ui/user/info"
 
 	UI_USER_UPDATE = "/crab/ui/user"
 	// 获取用户列表
 	UI_USERS_INFO_LIST = "/crab/ui/users/list"
+
+	// 注册中心里面的gate和runtime节点, 管理员使用
+	UI_REGISTRY_LIST = "/crab/ui/registry/list"
+	// 删除注册中心里面的节点, DELETE
+	UI_REGISTRY_URL = "/crab/ui/registry"
+
+	// 投递失败的webhook
+	UI_WEBHOOK_DEAD_LIST = "/crab/ui/webhook/dead/list"
+
+	// 任务变更的审计记录
+	UI_AUDIT_LIST = "/crab/ui/audit/list"
+
+	// prometheus指标
+	METRICS_URL = "/metrics"
+
+	// 存活检查
+	HEALTHZ_URL = "/healthz"
+	// 就绪检查
+	READYZ_URL = "/readyz"
+	// 版本信息
+	VERSION_URL = "/version"
 )
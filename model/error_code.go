@@ -0,0 +1,66 @@
+package model
+
+import "net/http"
+
+// http接口返回的错误码, 客户端根据code判断错误类型, 数值一旦发布就不能修改
+type ErrCode int
+
+const (
+	CodeOK ErrCode = 0
+
+	// 1xxx 请求本身的错误, 重试也不会成功
+	ErrValidation      ErrCode = 1001
+	ErrUnauthorized    ErrCode = 1002
+	ErrForbidden       ErrCode = 1003
+	ErrNotFound        ErrCode = 1004
+	ErrDuplicateTask   ErrCode = 1005
+	ErrConflict        ErrCode = 1006
+	ErrTooLarge        ErrCode = 1007
+	ErrTooManyRequests ErrCode = 1008
+	// 开启了RequireRevision, 更新时没有带X-Task-Revision
+	ErrPreconditionRequired ErrCode = 1009
+
+	// 2xxx 服务端的错误, 可以重试
+	ErrInternal    ErrCode = 2001
+	ErrEtcd        ErrCode = 2002
+	ErrEtcdTimeout ErrCode = 2003
+	ErrDatabase    ErrCode = 2004
+	ErrUnavailable ErrCode = 2005
+)
+
+var errCodeInfo = map[ErrCode]struct {
+	status  int
+	message string
+}{
+	CodeOK:                  {http.StatusOK, "ok"},
+	ErrValidation:           {http.StatusBadRequest, "invalid request"},
+	ErrUnauthorized:         {http.StatusUnauthorized, "unauthorized"},
+	ErrForbidden:            {http.StatusForbidden, "forbidden"},
+	ErrNotFound:             {http.StatusNotFound, "not found"},
+	ErrDuplicateTask:        {http.StatusConflict, "duplicate task"},
+	ErrConflict:             {http.StatusConflict, "conflict"},
+	ErrTooLarge:             {http.StatusRequestEntityTooLarge, "request too large"},
+	ErrTooManyRequests:      {http.StatusTooManyRequests, "too many requests"},
+	ErrPreconditionRequired: {http.StatusPreconditionRequired, "precondition required"},
+	ErrInternal:             {http.StatusInternalServerError, "internal error"},
+	ErrEtcd:                 {http.StatusInternalServerError, "etcd error"},
+	ErrEtcdTimeout:          {http.StatusGatewayTimeout, "etcd timeout"},
+	ErrDatabase:             {http.StatusInternalServerError, "database error"},
+	ErrUnavailable:          {http.StatusServiceUnavailable, "service unavailable"},
+}
+
+// 错误码对应的http状态码, 未知的错误码是500
+func (e ErrCode) Status() int {
+	if info, ok := errCodeInfo[e]; ok {
+		return info.status
+	}
+	return http.StatusInternalServerError
+}
+
+// 错误码的英文描述, 和code一样是稳定的
+func (e ErrCode) String() string {
+	if info, ok := errCodeInfo[e]; ok {
+		return info.message
+	}
+	return "unknown error"
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 每个错误码都有对应的http状态码和描述, 未知的错误码按500处理
+func Test_ErrCode(t *testing.T) {
+	for code, info := range errCodeInfo {
+		assert.Equal(t, info.status, code.Status())
+		assert.NotEmpty(t, code.String())
+	}
+
+	assert.Equal(t, 409, ErrDuplicateTask.Status())
+	assert.Equal(t, 504, ErrEtcdTimeout.Status())
+	assert.Equal(t, 500, ErrCode(9999).Status())
+	assert.Equal(t, "unknown error", ErrCode(9999).String())
+}
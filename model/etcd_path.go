@@ -11,6 +11,10 @@ const (
 	// val是ip
 	GateNodePrefix = "/crab/v1/node/gate"
 
+	//gate的元数据(版本, 启动时间, 连接数等), 路径后面是gate名, val是json
+	//不能放在GateNodePrefix下面, runtime会把那个前缀下面的值都当成gate的地址
+	GateMetaPrefix = "/crab/v1/meta/gate"
+
 	//全局任务队列, 消费者是mjobs模块，使用一定的负载均衡策略分配任务
 	GlobalTaskPrefix = "/crab/v1/global/runq/task/data"
 
@@ -31,6 +35,31 @@ const (
 
 	//分配task用的分布式锁
 	AssignTaskMutexPrefix = "/crab/v1/task/assign/mutex"
+
+	//检查runtime负载用的分布式锁, 路径后面是runtime的注册路径
+	//不能放在RuntimeNodePrefix下面, 会被当成runtime节点
+	RuntimeSlotMutexPrefix = "/crab/v1/slot/mutex"
+
+	//gate之间选主, 主节点负责投递webhook
+	GateLeaderPrefix = "/crab/v1/leader/gate"
+
+	//readyz检查etcd是否可以访问时读的key, 不需要存在
+	HealthKey = "/crab/v1/health"
+
+	//待投递的webhook, 路径后面是id
+	WebhookQueuePrefix = "/crab/v1/webhook/queue"
+
+	//超过重试次数的webhook, 路径后面是id
+	WebhookDeadPrefix = "/crab/v1/webhook/dead"
+
+	//任务变更的审计记录, 路径后面是按时间排序的id
+	AuditPrefix = "/crab/v1/audit"
+
+	//任务的执行历史, 路径后面是taskName和按时间排序的id
+	TaskHistoryPrefix = "/crab/v1/history"
+
+	//创建task的幂等key, 路径后面是用户名和客户端传过来的key, 带租约
+	IdempotencyPrefix = "/crab/v1/idempotency"
 )
 
 // 加锁需调用该函数，生成唯一的锁key
@@ -39,6 +68,11 @@ func AssignTaskMutex(fullPath string) string {
 	return fmt.Sprintf("%s/%s", AssignTaskMutexPrefix, taskName)
 }
 
+// 同一个runtime上检查负载和写入本地队列要在这个锁里面, 不然并发分配会超过上限
+func RuntimeSlotMutex(runtimeNode string) string {
+	return RuntimeSlotMutexPrefix + runtimeNode
+}
+
 // 生成本地任务队列全路径
 func WatchLocalRuntimePrefix(runtimeName string) string {
 	return fmt.Sprintf("%s/%s", LocalRuntimeTaskPrefix, runtimeName)
@@ -86,6 +120,26 @@ func FullGlobalTaskState(taskName string) string {
 	return fmt.Sprintf("%s/%s", GlobalTaskPrefixState, taskName)
 }
 
+// 生成待投递webhook的路径
+func FullWebhookQueue(id string) string {
+	return fmt.Sprintf("%s/%s", WebhookQueuePrefix, id)
+}
+
+// 生成webhook死信的路径
+func FullWebhookDead(id string) string {
+	return fmt.Sprintf("%s/%s", WebhookDeadPrefix, id)
+}
+
+// 生成任务执行历史的前缀, 后面跟着每次执行的id
+func FullTaskHistory(taskName string) string {
+	return fmt.Sprintf("%s/%s/", TaskHistoryPrefix, taskName)
+}
+
+// 生成幂等key的路径
+func FullIdempotencyKey(userName, key string) string {
+	return fmt.Sprintf("%s/%s/%s", IdempotencyPrefix, userName, key)
+}
+
 // 从路径提取taskName
 func TaskName(fullPath string) string {
 	return takeNameFromPath(fullPath)
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 项目改过名, 只能引用github.com/1whour/crab, 引用老的路径会得到两个不同的model包
+func Test_NoOldModulePath(t *testing.T) {
+	const oldPath = "github.com/gnh123/scheduler"
+
+	fset := token.NewFileSet()
+	err := filepath.WalkDir("..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name != ".." && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, imp := range f.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			assert.False(t, p == oldPath || strings.HasPrefix(p, oldPath+"/"), "%s imports %s", path, p)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -21,6 +21,24 @@ type Param struct {
 	//create, stop, rm, update, gate会修改这个字段，方便传递到runtime
 	Action   string        `yaml:"action" json:"action"`
 	Executer ExecuterParam `json:"executer" yaml:"executer"`
+	//指定运行的runtime节点名, 只对oneRuntime生效, 为空时由mjobs轮询分配
+	Runtime string `yaml:"runtime" json:"runtime,omitempty"`
+	//trace context, gate写入，随着任务下发到runtime
+	Trace map[string]string `yaml:"-" json:"trace,omitempty"`
+	//标签, status接口可以按标签过滤, 比如team:payments
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+	//禁用之后runtime不再调度这个任务, 数据保留, 启用之后继续调度
+	Disabled bool `yaml:"disabled" json:"disabled,omitempty"`
+	//单次执行的最长时间, 超过之后gate下发stop, 0表示不限制
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+	//执行中超过这么久没有收到runtime的心跳, gate把任务标记为stalled, 只是标记不会停止, 0表示不检查
+	StallWindow time.Duration `yaml:"stallWindow" json:"stallWindow,omitempty"`
+	//优先级, 值越大越先分配给runtime, 相同优先级按创建的先后顺序, 默认0
+	Priority int `yaml:"priority" json:"priority,omitempty"`
+	//runtime重连之后gate下发syncdone时带上, 是这个runtime上应该运行的全部任务
+	SyncTasks []string `yaml:"-" json:"syncTasks,omitempty"`
+	//gate每次下发时生成, v2协议的runtime收到之后带着它回ack, 重新下发时不变
+	DispatchID string `yaml:"-" json:"dispatchId,omitempty"`
 	//ExecTime time.Time     `json:"execTime" yaml:"execTime"`
 }
 
@@ -30,18 +48,40 @@ const (
 	Stop     = "stop"
 	Update   = "update"
 	Continue = "continue"
+
+	//runtime重连之后gate重新推送的任务, 和runtime上已有的一样时不重建
+	Sync = "sync"
+	//重新推送结束, 不在SyncTasks里面的任务runtime要停掉
+	SyncDone = "syncdone"
 )
 
 // 任务的触发器
 type Trigger struct {
-	Cron string `yaml:"cron" json:"cron" binding:"required"`
+	// cron表达式, 为空时是一次性任务
+	Cron string `yaml:"cron" json:"cron"`
+	// 一次性任务的执行时间, RFC3339格式, 为空表示立即执行
+	// 还没到时间的任务先是Waiting, 由gate主节点的定时器到点置为CanRun再分配, 执行过之后是Completed
 	Once string `yaml:"once" json:"once"`
+	// 允许执行的时间窗口, 为空表示不限制
+	Windows []Window `yaml:"windows" json:"windows,omitempty"`
+	// 窗口使用的时区, 比如Asia/Shanghai, 为空是UTC
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// 在窗口外触发时的处理方式, defer或者skip, 默认defer
+	OutsideWindow string `yaml:"outsideWindow" json:"outsideWindow,omitempty"`
 }
 
 func (p *Param) IsLambda() bool {
 	return p.Executer.Lambda != nil && p.Executer.Lambda.Funcs != nil
 }
 
+// 指定的runtime节点在etcd里面的key, 没有指定时为空
+func (p *Param) TargetRuntimeNode() string {
+	if p.Runtime == "" {
+		return ""
+	}
+	return FullRuntimeNode(Whoami{Name: p.Runtime})
+}
+
 func (p *Param) SetCreate() {
 	p.Action = Create
 }
@@ -78,6 +118,14 @@ func (p *Param) IsContinue() bool {
 	return p.Action == Continue
 }
 
+func (p *Param) IsSync() bool {
+	return p.Action == Sync
+}
+
+func (p *Param) IsSyncDone() bool {
+	return p.Action == SyncDone
+}
+
 type ExecuterParam struct {
 	TaskName  string  `yaml:"taskName" json:"taskName" binding:"required"` //自定义执行器，需要给到TaskName
 	GroupName string  `yaml:"groupName" json:"groupName"`                  //TODO, 还没想好
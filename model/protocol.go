@@ -0,0 +1,24 @@
+package model
+
+// runtime和gate之间长连接的websocket子协议, 一个子协议对应一个消息格式的版本
+// 消息格式有不兼容的修改时增加新的版本, 放在最前面, 老的版本继续保留一段时间
+const StreamProtocolV1 = "crab.v1"
+
+// v2: gate下发的task带上DispatchID, runtime收到之后回ack, gate收到ack之后才更新状态
+const StreamProtocolV2 = "crab.v2"
+
+// 支持的版本, 越新的越靠前, gate按这个顺序选择
+var StreamProtocols = []string{StreamProtocolV2, StreamProtocolV1}
+
+// 这个版本的runtime收到task之后会回ack
+func StreamAck(protocol string) bool {
+	return StreamProtocol(protocol) != StreamProtocolV1
+}
+
+// 老的runtime和gate不带子协议, 当作v1
+func StreamProtocol(negotiated string) string {
+	if negotiated == "" {
+		return StreamProtocolV1
+	}
+	return negotiated
+}
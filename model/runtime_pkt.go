@@ -16,6 +16,8 @@ type Whoami struct {
 	Name   string `json:"name"`
 	Lambda bool   `json:"lambda"`
 	Id     string `json:"id"`
+	// 同时运行的任务上限, 0表示不限制
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
 }
 
 // TODO: 通过http接口返回
@@ -9,6 +9,12 @@ const (
 	CanRun  = "canrun"  //可以运行，任务被创建时的状态
 	Running = "running" //任务被分配之后，运行中, oneRuntime字段绑定runtimeNode的节点
 	Failed  = "failed"  //这个任务发送到runtime节点失败
+
+	TimedOut = "timedout" //执行超过了Timeout, 被gate停止
+	Stalled  = "stalled"  //执行中超过StallWindow没有收到心跳, 只是标记, 收到心跳或者结果之后清空
+
+	Waiting   = "waiting"   //还没到触发时间, 由主节点的定时器到点置为CanRun, mjobs不分配
+	Completed = "completed" //一次性任务已经执行过了, 不再分配, 重启, 重新推送
 )
 
 // 集群稳定的前提下(当runtime的个数>=1 gate的个数>=1)，什么样的任务可以被恢复?
@@ -38,6 +44,28 @@ type State struct {
 	Kind string `json:"kind"`
 	// 是否是Lambda函数，必须要绑定
 	Lambda bool `json:"lambda"`
+	// 创建时指定的runtime节点, 分配时只会选这个节点
+	TargetRuntime string `json:"target_runtime,omitempty"`
+	// 最近一次的执行结果, runtime通过长连接上报
+	LastResult *TaskResult `json:"last_result,omitempty"`
+	// 任务被停止的原因, 执行超时被gate停止时是TimedOut, 再次变更时清空
+	StopReason string `json:"stop_reason,omitempty"`
+	// 任务的优先级, 从数据字段复制过来, 排队时值越大越先分配
+	Priority int `json:"priority,omitempty"`
+	// 执行中的任务的存活状态, 超过StallWindow没有收到心跳时是Stalled, 正常时为空
+	Liveness string `json:"liveness,omitempty"`
+	// 最近一次变更推送给runtime的次数, v2协议的runtime没有及时回ack时会重新推送
+	DispatchAttempts int `json:"dispatch_attempts,omitempty"`
+	// 下一次触发的时间, 创建, 更新和每次执行完之后重新计算, 一次性任务执行过之后为空
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// 按触发器重新计算下一次触发的时间
+func (s *State) SetNextRun(t Trigger, now time.Time) {
+	s.NextRun = nil
+	if next, ok := t.NextRun(now); ok {
+		s.NextRun = &next
+	}
 }
 
 func (s State) IsOneRuntime() bool {
@@ -80,20 +108,39 @@ func (s State) IsCanRun() bool {
 	return s.State == CanRun
 }
 
+func (s State) IsWaiting() bool {
+	return s.State == Waiting
+}
+
+func (s State) IsCompleted() bool {
+	return s.State == Completed
+}
+
 // 创建任务时调用
 func NewState(kind string, req *Param) ([]byte, error) {
 	now := time.Now()
-	return json.Marshal(&State{State: CanRun,
+	s := State{State: CanRun,
 		Action:     Create,
 		CreateTime: now,
 		UpdateTime: now,
 		Kind:       kind,
-		Lambda:     req.IsLambda(),
-		TaskName:   req.Executer.TaskName,
-	})
+		Lambda:        req.IsLambda(),
+		TaskName:      req.Executer.TaskName,
+		TargetRuntime: req.TargetRuntimeNode(),
+		Priority:      req.Priority,
+	}
+	s.SetNextRun(req.Trigger, now)
+	switch {
+	case req.Trigger.Waiting(now):
+		s.State = Waiting
+	case req.Trigger.Skipped(now):
+		// 窗口外触发并且配置了skip, 这次执行被跳过, 一次性任务不会再执行
+		s.State = Completed
+	}
+	return json.Marshal(&s)
 }
 
-func UpdateStateAck(value []byte, successed bool) ([]byte, error) {
+func UpdateStateAck(value []byte, successed bool, attempts int) ([]byte, error) {
 	s, err := ValueToState(value)
 	if err != nil {
 		return nil, err
@@ -112,6 +159,7 @@ func UpdateStateAck(value []byte, successed bool) ([]byte, error) {
 	}
 	// ack消费标记
 	s.Ack = true
+	s.DispatchAttempts = attempts
 
 	s.UpdateTime = time.Now()
 	return json.Marshal(s)
@@ -131,14 +179,30 @@ func UpdateState(value []byte, runtimeNode string, state string, action string,
 	if req != nil {
 		s.Lambda = req.IsLambda()
 		s.TaskName = req.Executer.TaskName
+		s.TargetRuntime = req.TargetRuntimeNode()
+		s.Priority = req.Priority
+		s.SetNextRun(req.Trigger, time.Now())
 	}
 	if len(id) > 0 {
 		s.RuntimeID = id
 	}
+	// 还没有分配过的任务不用推给runtime, 等主节点到点再触发
+	// 已经在runtime上的任务需要把变更推过去, 由runtime自己的定时器到点执行
+	waitable := action == Create || action == Update || action == Continue
+	// 窗口外触发并且配置了skip的一次性任务不再执行
+	if state == CanRun && waitable && req != nil && s.RuntimeNode == "" && !s.IsBroadcast() {
+		if now := time.Now(); req.Trigger.Waiting(now) {
+			state = Waiting
+		} else if req.Trigger.Skipped(now) {
+			state = Completed
+		}
+	}
 	s.State = state
 	s.Action = action
 	s.UpdateTime = time.Now()
 	s.Ack = false
+	s.StopReason = ""
+	s.DispatchAttempts = 0
 	return json.Marshal(&s)
 }
 
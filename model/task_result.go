@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// 保存到状态里面的输出最多保留末尾这么多字节, 防止状态的value太大
+const MaxResultTail = 4096
+
+// runtime发给gate的包, 心跳只有Whoami, 上报执行结果时带上Result
+type RuntimeMsg struct {
+	Whoami
+	Result *TaskResult `json:"result,omitempty"`
+	// 开始执行, 只有设置了Timeout或者StallWindow的任务才会上报
+	Started *TaskStarted `json:"started,omitempty"`
+	// 执行中的心跳, 只有设置了StallWindow的任务才会上报
+	Alive *TaskAlive `json:"alive,omitempty"`
+	// 收到了gate下发的task, 只有v2协议的runtime会发
+	Ack *TaskAck `json:"ack,omitempty"`
+}
+
+// 确认收到gate下发的task
+type TaskAck struct {
+	TaskName   string `json:"task_name"`
+	DispatchID string `json:"dispatch_id"`
+}
+
+// 任务开始执行, gate用来检查执行是否超时
+type TaskStarted struct {
+	TaskName  string        `json:"task_name"`
+	StartTime time.Time     `json:"start_time"`
+	Timeout   time.Duration `json:"timeout"`
+	// 超过这么久没有心跳标记为stalled
+	StallWindow time.Duration `json:"stall_window,omitempty"`
+	// 下发时带过去的trace context, 原样带回
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// 执行中的任务还活着
+type TaskAlive struct {
+	TaskName string    `json:"task_name"`
+	Time     time.Time `json:"time"`
+}
+
+// 任务最近一次的执行结果, 保存在全局状态里面
+type TaskResult struct {
+	TaskName string `json:"task_name"`
+	// 执行的runtime名, gate根据长连接填写
+	Runtime  string        `json:"runtime"`
+	ExitCode int           `json:"exit_code"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Duration time.Duration `json:"duration"`
+	EndTime  time.Time     `json:"end_time"`
+	// 下发时带过去的trace context, 原样带回, gate用来接上下发的span
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// 只保留输出的末尾
+func (t *TaskResult) Truncate() {
+	t.Stdout = tail(t.Stdout, MaxResultTail)
+	t.Stderr = tail(t.Stderr, MaxResultTail)
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
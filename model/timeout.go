@@ -5,3 +5,12 @@ import "time"
 var (
 	RuntimeKeepalive = 3 * time.Second
 )
+
+// stallWindow的下限, runtime每隔stallWindow/3上报一次心跳, 太短心跳太频繁
+const MinStallWindow = 3 * time.Second
+
+// 租约时间的合理范围, 太短续约太频繁, 太长节点挂掉之后很久才能发现
+const (
+	MinLeaseTime = time.Second
+	MaxLeaseTime = 5 * time.Minute
+)
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antlabs/cronex"
+)
+
+// 没有配置cron的任务只执行一次
+func (t Trigger) IsOnce() bool {
+	return t.Cron == ""
+}
+
+// 一次性任务的执行时间, once为空表示立即执行, 返回零值
+func (t Trigger) OnceTime() (time.Time, error) {
+	if t.Once == "" {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, t.Once)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid once(%s), must be RFC3339:%w", t.Once, err)
+	}
+	return at, nil
+}
+
+// 一次性任务还没到执行时间, 创建之后先不分配, 等主节点的定时器触发
+// 配置了时间窗口时, 到了once的时间还要等到窗口开始
+func (t Trigger) Waiting(now time.Time) bool {
+	if !t.IsOnce() {
+		return false
+	}
+	at, run := t.OnceRunAt(now)
+	return run && at.After(now)
+}
+
+// 一次性任务在窗口外触发并且配置了skip, 这次执行被跳过, 不再分配
+func (t Trigger) Skipped(now time.Time) bool {
+	if !t.IsOnce() {
+		return false
+	}
+	_, run := t.OnceRunAt(now)
+	return !run
+}
+
+// 检查cron表达式, 一次性任务的执行时间和时间窗口
+func (t Trigger) Validate() error {
+	if t.Cron != "" && t.Once != "" {
+		return errors.New("cron and once can not be set at the same time")
+	}
+
+	if t.Cron != "" {
+		if _, err := cronex.ParseStandard(t.Cron); err != nil {
+			return fmt.Errorf("invalid cron(%s):%w", t.Cron, err)
+		}
+	}
+
+	if _, err := t.OnceTime(); err != nil {
+		return err
+	}
+
+	return t.ValidateWindows()
+}
+
+// 下一次执行的时间, 窗口外的触发推迟到窗口开始或者跳过, 一次性任务已经执行过时返回false
+func (t Trigger) NextRun(now time.Time) (time.Time, bool) {
+	if !t.IsOnce() {
+		schedule, err := cronex.ParseStandard(t.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t.nextCronRun(schedule, now)
+	}
+
+	at, err := t.OnceTime()
+	if err != nil || !at.After(now) {
+		return time.Time{}, false
+	}
+	return t.OnceRunAt(now)
+}
+
+// 最多往后找几个窗口, 窗口很窄并且cron很稀疏时可能找不到
+const maxSkipWindows = 64
+
+// cron任务下一次执行的时间, 和runtime的runInWindow一样处理窗口
+func (t Trigger) nextCronRun(schedule cronex.Schedule, now time.Time) (time.Time, bool) {
+	next := schedule.Next(now)
+	if len(t.Windows) == 0 {
+		return next, true
+	}
+
+	for i := 0; i < maxSkipWindows; i++ {
+		w, err := t.NextWindow(next)
+		if err != nil || w.Equal(next) {
+			return next, true
+		}
+		if !t.SkipOutsideWindow() {
+			return w, true
+		}
+		// 跳过窗口外的触发, 从窗口开始找下一次触发
+		next = schedule.Next(w.Add(-time.Nanosecond))
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Trigger_Validate(t *testing.T) {
+	assert.NoError(t, Trigger{Cron: "*/5 * * * * *"}.Validate())
+	assert.NoError(t, Trigger{}.Validate())
+	assert.NoError(t, Trigger{Once: "2022-12-01T08:00:00+08:00"}.Validate())
+
+	assert.Error(t, Trigger{Cron: "not a cron"}.Validate())
+	assert.Error(t, Trigger{Once: "2022-12-01 08:00"}.Validate())
+	assert.Error(t, Trigger{Cron: "* * * * *", Once: "2022-12-01T08:00:00Z"}.Validate())
+}
+
+func Test_Trigger_NextRun(t *testing.T) {
+	now := time.Date(2022, 12, 1, 8, 0, 30, 0, time.UTC)
+
+	next, ok := Trigger{Cron: "0 * * * * *"}.NextRun(now)
+	assert.True(t, ok)
+	assert.True(t, next.Equal(time.Date(2022, 12, 1, 8, 1, 0, 0, time.UTC)), next)
+
+	next, ok = Trigger{Once: "2022-12-01T09:00:00Z"}.NextRun(now)
+	assert.True(t, ok)
+	assert.True(t, next.Equal(time.Date(2022, 12, 1, 9, 0, 0, 0, time.UTC)), next)
+
+	// 已经执行过的一次性任务
+	_, ok = Trigger{Once: "2022-12-01T07:00:00Z"}.NextRun(now)
+	assert.False(t, ok)
+	_, ok = Trigger{}.NextRun(now)
+	assert.False(t, ok)
+}
+
+func Test_Trigger_Waiting(t *testing.T) {
+	now := time.Date(2022, 12, 1, 8, 0, 30, 0, time.UTC)
+
+	assert.True(t, Trigger{Once: "2022-12-01T09:00:00Z"}.Waiting(now))
+	assert.False(t, Trigger{Once: "2022-12-01T07:00:00Z"}.Waiting(now))
+	assert.False(t, Trigger{}.Waiting(now))
+	assert.False(t, Trigger{Cron: "0 * * * * *"}.Waiting(now))
+}
+
+// 配置了时间窗口, 窗口外的触发推迟到窗口开始或者跳过
+func Test_Trigger_Window(t *testing.T) {
+	// 2022-12-05是周一
+	now := time.Date(2022, 12, 5, 8, 0, 30, 0, time.UTC)
+	windows := []Window{{Days: []string{"mon"}, Start: "09:00", End: "10:00"}}
+	start := time.Date(2022, 12, 5, 9, 0, 0, 0, time.UTC)
+
+	deferOnce := Trigger{Once: "2022-12-05T08:30:00Z", Windows: windows}
+	next, ok := deferOnce.NextRun(now)
+	assert.True(t, ok)
+	assert.True(t, next.Equal(start), next)
+	// 到了once的时间还要等窗口开始
+	assert.True(t, deferOnce.Waiting(time.Date(2022, 12, 5, 8, 40, 0, 0, time.UTC)))
+	assert.False(t, deferOnce.Skipped(now))
+
+	skipOnce := Trigger{Once: "2022-12-05T08:30:00Z", Windows: windows, OutsideWindow: OutsideWindowSkip}
+	_, ok = skipOnce.NextRun(now)
+	assert.False(t, ok)
+	assert.False(t, skipOnce.Waiting(now))
+	assert.True(t, skipOnce.Skipped(now))
+
+	// 窗口里面的一次性任务不受影响
+	inOnce := Trigger{Once: "2022-12-05T09:30:00Z", Windows: windows, OutsideWindow: OutsideWindowSkip}
+	assert.True(t, inOnce.Waiting(now))
+	assert.False(t, inOnce.Skipped(now))
+
+	next, ok = Trigger{Cron: "0 0 * * * *", Windows: windows}.NextRun(now)
+	assert.True(t, ok)
+	assert.True(t, next.Equal(start), next)
+
+	// 每天08:30触发, 永远在窗口外
+	_, ok = Trigger{Cron: "0 30 8 * * *", Windows: windows, OutsideWindow: OutsideWindowSkip}.NextRun(now)
+	assert.False(t, ok)
+
+	// 跳过窗口外的触发, 找窗口里面的下一次
+	next, ok = Trigger{Cron: "0 30 * * * *", Windows: windows, OutsideWindow: OutsideWindowSkip}.NextRun(now)
+	assert.True(t, ok)
+	assert.True(t, next.Equal(time.Date(2022, 12, 5, 9, 30, 0, 0, time.UTC)), next)
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// task名的最大长度, 和gate里面status表task_name字段的长度一致
+const MaxTaskNameLen = 40
+
+// 检查task名, task名是etcd key的一部分, 不能包含"/"
+func ValidateTaskName(taskName string) error {
+	if strings.TrimSpace(taskName) == "" {
+		return errors.New("the task name is empty")
+	}
+
+	if strings.Contains(taskName, "/") {
+		return fmt.Errorf("the task name(%s) must not contain '/'", taskName)
+	}
+
+	if n := utf8.RuneCountInString(taskName); n > MaxTaskNameLen {
+		return fmt.Errorf("the task name is too long, %d > %d", n, MaxTaskNameLen)
+	}
+	return nil
+}
+
+// 节点名的最大长度
+const MaxNodeNameLen = 128
+
+// 检查gate, runtime, mjobs的节点名, 节点名是etcd key的一部分
+// 只允许字母, 数字和-_., lambda是runtime里面lambda节点的前缀, 不能作为节点名
+func ValidateNodeName(name string) error {
+	if name == "" {
+		return errors.New("the node name is empty")
+	}
+
+	if len(name) > MaxNodeNameLen {
+		return fmt.Errorf("the node name is too long, %d > %d", len(name), MaxNodeNameLen)
+	}
+
+	if name == "." || name == ".." || name == LambdaKey {
+		return fmt.Errorf("the node name(%s) is reserved", name)
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("the node name(%q) may only contain letters, digits and -_.", name)
+		}
+	}
+	return nil
+}
+
+// 标签key和value的最大长度
+const (
+	MaxLabelKeyLen   = 63
+	MaxLabelValueLen = 255
+)
+
+// 检查标签, 过滤时用key:value的形式, 所以key里面不能有':'
+func ValidateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if strings.TrimSpace(k) == "" {
+			return errors.New("the label key is empty")
+		}
+
+		if strings.Contains(k, ":") {
+			return fmt.Errorf("the label key(%s) must not contain ':'", k)
+		}
+
+		if n := utf8.RuneCountInString(k); n > MaxLabelKeyLen {
+			return fmt.Errorf("the label key(%s) is too long, %d > %d", k, n, MaxLabelKeyLen)
+		}
+
+		if n := utf8.RuneCountInString(v); n > MaxLabelValueLen {
+			return fmt.Errorf("the value of label(%s) is too long, %d > %d", k, n, MaxLabelValueLen)
+		}
+	}
+	return nil
+}
+
+// 写入etcd之前的检查, binding tag检查不了的规则放在这里
+func (p *Param) Validate() error {
+	if err := ValidateTaskName(p.Executer.TaskName); err != nil {
+		return err
+	}
+
+	// 指定的runtime和注册的节点名用同一个规则
+	if p.Runtime != "" {
+		if err := ValidateNodeName(p.Runtime); err != nil {
+			return fmt.Errorf("runtime:%w", err)
+		}
+	}
+
+	if err := ValidateLabels(p.Labels); err != nil {
+		return err
+	}
+
+	if p.Timeout < 0 {
+		return fmt.Errorf("the timeout(%s) must not be negative", p.Timeout)
+	}
+
+	if p.StallWindow != 0 && p.StallWindow < MinStallWindow {
+		return fmt.Errorf("the stallWindow(%s) must be 0 or at least %s", p.StallWindow, MinStallWindow)
+	}
+
+	return p.Trigger.Validate()
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateTaskName(t *testing.T) {
+	assert.NoError(t, ValidateTaskName("guo:1"))
+	assert.NoError(t, ValidateTaskName(strings.Repeat("任", MaxTaskNameLen)))
+
+	assert.Error(t, ValidateTaskName(""))
+	assert.Error(t, ValidateTaskName(" \t"))
+	assert.Error(t, ValidateTaskName("a/b"))
+	assert.Error(t, ValidateTaskName(strings.Repeat("a", MaxTaskNameLen+1)))
+}
+
+func Test_Param_Validate(t *testing.T) {
+	p := Param{}
+	p.Executer.TaskName = "a"
+	assert.NoError(t, p.Validate())
+
+	p.Trigger.Timezone = "Not/Exist"
+	assert.Error(t, p.Validate())
+
+	p = Param{}
+	assert.Error(t, p.Validate())
+
+	p = Param{Timeout: -time.Second}
+	p.Executer.TaskName = "a"
+	assert.Error(t, p.Validate())
+
+	p = Param{StallWindow: time.Second}
+	p.Executer.TaskName = "a"
+	assert.Error(t, p.Validate())
+
+	// 指定的runtime按节点名检查
+	p = Param{Runtime: "runtime-1"}
+	p.Executer.TaskName = "a"
+	assert.NoError(t, p.Validate())
+	for _, name := range []string{"a/b", "a b", "..", LambdaKey} {
+		p.Runtime = name
+		assert.Error(t, p.Validate(), name)
+	}
+}
+
+// 一次性任务不需要cron
+func Test_Param_Once(t *testing.T) {
+	p := Param{APIVersion: "v0.0.1", Kind: "oneRuntime"}
+	p.Executer.TaskName = "once"
+	assert.NoError(t, binding.Validator.ValidateStruct(&p))
+	assert.NoError(t, p.Validate())
+	assert.True(t, p.Trigger.IsOnce())
+}
+
+func Test_ValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(nil))
+	assert.NoError(t, ValidateLabels(map[string]string{"team": "payments", "env": ""}))
+
+	assert.Error(t, ValidateLabels(map[string]string{"": "a"}))
+	assert.Error(t, ValidateLabels(map[string]string{"a:b": "c"}))
+	assert.Error(t, ValidateLabels(map[string]string{strings.Repeat("a", MaxLabelKeyLen+1): "c"}))
+	assert.Error(t, ValidateLabels(map[string]string{"a": strings.Repeat("c", MaxLabelValueLen+1)}))
+
+	p := Param{Labels: map[string]string{"a:b": "c"}}
+	p.Executer.TaskName = "a"
+	assert.Error(t, p.Validate())
+}
+
+func Test_ValidateNodeName(t *testing.T) {
+	for _, name := range []string{"gate-1", "runtime_a.b", "0b5a3f2e-4c1d-4e8f-9a6b-7c2d1e0f3a4b"} {
+		assert.NoError(t, ValidateNodeName(name), name)
+	}
+
+	for _, name := range []string{"", "a/b", "/crab", "a b", "a:b", ".", "..", LambdaKey, strings.Repeat("a", MaxNodeNameLen+1)} {
+		assert.Error(t, ValidateNodeName(name), name)
+	}
+}
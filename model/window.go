@@ -0,0 +1,197 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// 容器里面可能没有时区数据库
+	_ "time/tzdata"
+)
+
+const (
+	// 在窗口外触发时, 推迟到下一个窗口开始时执行, 默认值
+	OutsideWindowDefer = "defer"
+	// 在窗口外触发时, 跳过这次执行
+	OutsideWindowSkip = "skip"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var errNoWindow = errors.New("no execution window in the next week")
+
+// 允许执行的时间窗口, 时间是Trigger.Timezone里面的本地时间
+type Window struct {
+	// mon, tue, wed, thu, fri, sat, sun, 为空表示每一天
+	Days []string `yaml:"days" json:"days,omitempty"`
+	// 开始时间 15:04
+	Start string `yaml:"start" json:"start"`
+	// 结束时间 15:04, 不包含. 小于开始时间表示跨天
+	End string `yaml:"end" json:"end"`
+}
+
+// 解析HH:MM, 返回从0点开始的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time(%s), want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// 判断窗口是否可以从weekday这一天开始
+func (w Window) onDay(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查窗口配置
+func (w Window) validate() error {
+	for _, d := range w.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day(%s)", d)
+		}
+	}
+
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+
+	if start == end {
+		return fmt.Errorf("empty window(%s-%s)", w.Start, w.End)
+	}
+	return nil
+}
+
+// 从某一天的本地时间构造窗口, 使用time.Date让夏令时切换的那天也能算对
+func (w Window) at(y int, m time.Month, d int, loc *time.Location) (start, end time.Time) {
+	s, _ := parseClock(w.Start)
+	e, _ := parseClock(w.End)
+	start = time.Date(y, m, d, s/60, s%60, 0, 0, loc)
+	if e <= s {
+		d++
+	}
+	end = time.Date(y, m, d, e/60, e%60, 0, 0, loc)
+	return
+}
+
+// 窗口的时区, 为空是UTC
+func (t Trigger) Location() (*time.Location, error) {
+	if t.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(t.Timezone)
+}
+
+// 检查窗口相关的配置
+func (t Trigger) ValidateWindows() error {
+	if _, err := t.Location(); err != nil {
+		return fmt.Errorf("invalid timezone(%s):%w", t.Timezone, err)
+	}
+
+	switch t.OutsideWindow {
+	case "", OutsideWindowDefer, OutsideWindowSkip:
+	default:
+		return fmt.Errorf("invalid outsideWindow(%s), want %s or %s", t.OutsideWindow, OutsideWindowDefer, OutsideWindowSkip)
+	}
+
+	for _, w := range t.Windows {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 返回now之后(包括now)最近的可以执行的时间, 在窗口里面直接返回now
+// 没有配置窗口时, 任何时间都可以执行
+func (t Trigger) NextWindow(now time.Time) (time.Time, error) {
+	if len(t.Windows) == 0 {
+		return now, nil
+	}
+
+	if err := t.ValidateWindows(); err != nil {
+		return time.Time{}, err
+	}
+
+	loc, _ := t.Location()
+	local := now.In(loc)
+
+	var next time.Time
+	// 从前一天开始, 跨天的窗口可能是昨天开始的
+	for i := -1; i <= 7; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, loc)
+		for _, w := range t.Windows {
+			if !w.onDay(day.Weekday()) {
+				continue
+			}
+
+			start, end := w.at(day.Year(), day.Month(), day.Day(), loc)
+			if !now.Before(start) && now.Before(end) {
+				return now, nil
+			}
+
+			if start.After(now) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+
+	if next.IsZero() {
+		return time.Time{}, errNoWindow
+	}
+	return next, nil
+}
+
+// 判断now是否在允许执行的窗口里面
+func (t Trigger) InWindow(now time.Time) bool {
+	next, err := t.NextWindow(now)
+	return err == nil && next.Equal(now)
+}
+
+// 在窗口外触发时是否跳过
+func (t Trigger) SkipOutsideWindow() bool {
+	return t.OutsideWindow == OutsideWindowSkip
+}
+
+// 一次性任务实际开始执行的时间, 到了once的时间之后还要等到窗口里面
+// once为空或者已经过了时从now开始算, 窗口外并且配置了skip时这次执行被跳过, 返回false
+func (t Trigger) OnceRunAt(now time.Time) (time.Time, bool) {
+	at, err := t.OnceTime()
+	if err != nil || at.Before(now) {
+		at = now
+	}
+
+	next, err := t.NextWindow(at)
+	// 窗口配置有问题时不拦着, 由runtime打印警告
+	if err != nil || next.Equal(at) {
+		return at, true
+	}
+
+	if t.SkipOutsideWindow() {
+		return time.Time{}, false
+	}
+	return next, true
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NextWindow(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+
+	tr := Trigger{
+		Timezone: "Asia/Shanghai",
+		Windows:  []Window{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00"}},
+	}
+
+	// 2022-12-05是周一
+	in := time.Date(2022, 12, 5, 10, 0, 0, 0, loc)
+	assert.True(t, tr.InWindow(in))
+
+	// 结束时间不包含
+	next, err := tr.NextWindow(time.Date(2022, 12, 5, 18, 0, 0, 0, loc))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 12, 6, 9, 0, 0, 0, loc), next)
+
+	// 周五晚上推迟到下周一
+	next, err = tr.NextWindow(time.Date(2022, 12, 9, 20, 0, 0, 0, loc))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 12, 12, 9, 0, 0, 0, loc), next)
+
+	// 没有窗口, 任何时间都可以执行
+	assert.True(t, Trigger{}.InWindow(in))
+}
+
+// 跨天的窗口
+func Test_NextWindow_Overnight(t *testing.T) {
+	tr := Trigger{Windows: []Window{{Days: []string{"sat"}, Start: "22:00", End: "02:00"}}}
+
+	// 2022-12-10是周六, 窗口周日凌晨还有效
+	assert.True(t, tr.InWindow(time.Date(2022, 12, 11, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, tr.InWindow(time.Date(2022, 12, 11, 2, 0, 0, 0, time.UTC)))
+
+	next, err := tr.NextWindow(time.Date(2022, 12, 11, 2, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 12, 17, 22, 0, 0, 0, time.UTC), next)
+}
+
+// 夏令时切换的那天按本地时间算窗口
+func Test_NextWindow_DST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	tr := Trigger{Timezone: "America/New_York", Windows: []Window{{Start: "09:00", End: "10:00"}}}
+
+	// 2022-03-13凌晨2点开始夏令时, 前一天的9点到当天的9点只有23个小时
+	before := time.Date(2022, 3, 12, 9, 30, 0, 0, loc)
+	next, err := tr.NextWindow(before.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 3, 13, 9, 0, 0, 0, loc), next)
+	assert.Equal(t, 9, next.In(loc).Hour())
+	assert.Equal(t, 23*time.Hour-30*time.Minute, next.Sub(before))
+
+	// 2022-11-06凌晨2点结束夏令时, 这一天有25个小时
+	before = time.Date(2022, 11, 5, 10, 0, 0, 0, loc)
+	next, err = tr.NextWindow(before)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, next.In(loc).Hour())
+	assert.Equal(t, 24*time.Hour, next.Sub(before))
+
+	// 落在跳过的本地时间里面的窗口
+	tr.Windows = []Window{{Start: "02:30", End: "04:00"}}
+	assert.True(t, tr.InWindow(time.Date(2022, 3, 13, 3, 45, 0, 0, loc)))
+}
+
+func Test_ValidateWindows(t *testing.T) {
+	assert.NoError(t, Trigger{}.ValidateWindows())
+	assert.Error(t, Trigger{Timezone: "Not/Exist"}.ValidateWindows())
+	assert.Error(t, Trigger{OutsideWindow: "later"}.ValidateWindows())
+	assert.Error(t, Trigger{Windows: []Window{{Days: []string{"monday"}, Start: "09:00", End: "10:00"}}}.ValidateWindows())
+	assert.Error(t, Trigger{Windows: []Window{{Start: "9am", End: "10:00"}}}.ValidateWindows())
+	assert.Error(t, Trigger{Windows: []Window{{Start: "09:00", End: "09:00"}}}.ValidateWindows())
+}
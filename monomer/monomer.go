@@ -19,14 +19,33 @@ type Monomer struct {
 	Endpoint     []string      `clop:"long" usage:"endpoint address"`
 	Level        string        `clop:"short;long" usage:"log level" default:"error"`
 	WriteTimeout time.Duration `clop:"short;long" usage:"Timeout when writing messages" default:"3s"`
+	// etcd开启认证或者tls时配置
+	EtcdUsername string `clop:"long" usage:"etcd username"`
+	EtcdPassword string `clop:"long;env=CRAB_ETCD_PASSWORD" usage:"etcd password"`
+	EtcdCA       string `clop:"long" usage:"etcd tls ca file"`
+	EtcdCert     string `clop:"long" usage:"etcd tls client certificate file"`
+	EtcdKey      string `clop:"long" usage:"etcd tls client private key file"`
+	// 多个部署共用一个etcd集群时用来隔离key, 会复制给gate, mjobs, runtime
+	Namespace string `clop:"long;env=CRAB_NAMESPACE" usage:"etcd key namespace, isolates deployments sharing one etcd cluster"`
 
 	// gate
-	ServerAddr   string        `clop:"short;long" usage:"server address"`
-	AutoFindAddr bool          `clop:"short;long" usage:"Automatically find unused ip:port, Only takes effect when ServerAddr is empty"`
-	Name         string        `clop:"short;long" usage:"The name of the gate. If it is not filled, the default is uuid"`
-	LeaseTime    time.Duration `clop:"long" usage:"lease time" default:"7s"`
-	WriteTime    time.Duration `clop:"long" usage:"write timeout" default:"4s"`
-	DSN          string        `clop:"--dsn" usage:"database dsn" valid:"requried"`
+	ServerAddr    string        `clop:"short;long" usage:"server address"`
+	AutoFindAddr  bool          `clop:"short;long" usage:"Automatically find unused ip:port, Only takes effect when ServerAddr is empty"`
+	Name          string        `clop:"short;long" usage:"The name of the gate. If it is not filled, the default is uuid"`
+	LeaseTime     time.Duration `clop:"long" usage:"lease time" default:"7s"`
+	WriteTime     time.Duration `clop:"long" usage:"write timeout" default:"4s"`
+	DSN           string        `clop:"--dsn" usage:"database dsn" valid:"requried"`
+	JWTSecret     string        `clop:"long;env=CRAB_JWT_SECRET" usage:"secret used to sign the login token"`
+	JWTIssuer     string        `clop:"long" usage:"issuer of the login token" default:"crab"`
+	TokenTTL      time.Duration `clop:"long" usage:"lifetime of the login token" default:"24h"`
+	LogFile       string        `clop:"long" usage:"log file of the gate, log to stdout when empty"`
+	LogFormat     string        `clop:"long" usage:"log format of the gate, json or console" default:"json"`
+	LogMaxSize    int           `clop:"long" usage:"max size(MB) of the log file before it gets rotated, 0 disables rotation"`
+	LogMaxBackups int           `clop:"long" usage:"max number of rotated log files to retain, 0 retains all"`
+	LogMaxAge     int           `clop:"long" usage:"max days to retain rotated log files, 0 retains all"`
+	// 注册到etcd的地址, 多机部署时填其他节点能访问的地址
+	AdvertiseAddr string `clop:"long" usage:"address registered to etcd, defaults to the server address"`
+	OutboundIP    bool   `clop:"long" usage:"use the ip of the primary outbound interface when AutoFindAddr is set"`
 
 	// mjobs的字段是runtime和gate字段的一部分
 	// ....
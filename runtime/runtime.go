@@ -2,9 +2,14 @@ package runtime
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1whour/crab/executer"
@@ -38,22 +43,51 @@ type Runtime struct {
 	Endpoint     []string      `clop:"long" usage:"endpoint address"`
 	Level        string        `clop:"short;long" usage:"log level" default:"error"`
 	WriteTimeout time.Duration `clop:"short;long" usage:"Timeout when writing messages" default:"3s"`
+	// etcd开启认证或者tls时配置
+	EtcdUsername string `clop:"long" usage:"etcd username"`
+	EtcdPassword string `clop:"long;env=CRAB_ETCD_PASSWORD" usage:"etcd password"`
+	EtcdCA       string `clop:"long" usage:"etcd tls ca file"`
+	EtcdCert     string `clop:"long" usage:"etcd tls client certificate file"`
+	EtcdKey      string `clop:"long" usage:"etcd tls client private key file"`
+	// gate开启tls时校验证书用的ca, 为空时用系统的根证书
+	GateCA string `clop:"long" usage:"ca file to verify the gate tls certificate"`
+	// 多个部署共用一个etcd集群时用来隔离key, 同一个部署的gate, mjobs, runtime必须一致
+	Namespace string `clop:"long;env=CRAB_NAMESPACE" usage:"etcd key namespace, isolates deployments sharing one etcd cluster"`
 	// 节点名称，如果不填写，默认是uuid
 	NodeName string `clop:"short;long" usage:"node name"`
 	ctx      context.Context
 	*slog.Slog
 
+	// 同时运行的任务上限, 满了之后mjobs不会再往这个runtime分配任务
+	MaxConcurrency int `clop:"long" usage:"max number of tasks running at the same time, 0 means unlimited"`
+
 	MuConn sync.Mutex //保护多个go程写同一个conn
+	// 当前和gate的长连接, 上报执行结果用
+	conn atomic.Pointer[websocket.Conn]
 
 	cronFunc rwmap.RWMap[string, cronNode]
 	// 所以的gate地址都保存到这里
 	addrs rwmap.RWMap[string, string]
+	// 连接gate的tls配置, 长连接和上报结果的http请求共用
+	gateTLS    *tls.Config
+	gateClient *http.Client
 }
 
 type cronNode struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	tm     cronex.TimerNoder
+	// 创建时的任务, 重连之后同步时用来判断任务有没有变化
+	param *model.Param
+}
+
+// 一次性任务的定时器
+type onceTimer struct {
+	*time.Timer
+}
+
+func (o onceTimer) Stop() {
+	o.Timer.Stop()
 }
 
 func (c *cronNode) close() {
@@ -67,10 +101,20 @@ func (r *Runtime) Init() (err error) {
 	if r.NodeName == "" {
 		r.NodeName = uuid.New().String()
 	}
+	if err = model.ValidateNodeName(r.NodeName); err != nil {
+		return err
+	}
 
 	r.cron = cronex.New()
 	r.ctx = context.TODO()
 
+	if r.gateTLS, err = utils.GateTLSConfig(r.GateCA); err != nil {
+		return fmt.Errorf("load gate ca:%w", err)
+	}
+	if r.gateTLS != nil {
+		r.gateClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: r.gateTLS}}
+	}
+
 	// runtime被内嵌到lambda模块里面，可能Slog已经被初始化过, 所以不需要重复初始化
 	r.Debug().Msgf("runtime init start:%p", r.Slog)
 	if r.Slog == nil {
@@ -83,9 +127,18 @@ func (r *Runtime) Init() (err error) {
 
 	// 设置日志
 	if len(r.EtcdAddr) > 0 {
-		if defautlClient, err = utils.NewEtcdClient(r.EtcdAddr); err != nil {
+		conf := utils.EtcdConfig{
+			Username:  r.EtcdUsername,
+			Password:  r.EtcdPassword,
+			CAFile:    r.EtcdCA,
+			CertFile:  r.EtcdCert,
+			KeyFile:   r.EtcdKey,
+			Namespace: r.Namespace,
+		}
+		if defautlClient, err = utils.NewEtcdClientWithConfig(r.EtcdAddr, conf); err != nil {
 			return err
 		}
+		go utils.WatchEtcdConn(r.ctx, r.Slog, defautlClient)
 
 		rsp, err := defautlClient.Get(r.ctx, model.GateNodePrefix, clientv3.WithPrefix())
 		if err != nil {
@@ -165,6 +218,18 @@ func (r *Runtime) removeFromExec(param *model.Param) ([]byte, error) {
 	return nil, nil
 }
 
+// 禁用的任务不再调度, 只停掉定时器, 正在运行的继续执行完
+func (r *Runtime) disableCron(param *model.Param) {
+	e, ok := r.cronFunc.LoadAndDelete(param.Executer.TaskName)
+	if !ok {
+		return
+	}
+	if e.tm != nil {
+		e.tm.Stop()
+	}
+	r.Debug().Msgf("action(%s), task is disabled:%s, tm:%p\n", param.Action, param.Executer.TaskName, e.tm)
+}
+
 func (r *Runtime) createToExec(ctx context.Context, param *model.Param) ([]byte, error) {
 	e, err := executer.CreateExecuter(ctx, param)
 	if err != nil {
@@ -178,16 +243,31 @@ func (r *Runtime) createToExec(ctx context.Context, param *model.Param) ([]byte,
 func (r *Runtime) createCron(param *model.Param) (b []byte, err error) {
 
 	ctx, cancel := context.WithCancel(r.ctx)
-	tm, err := r.cron.AddFunc(param.Trigger.Cron, func() {
+	// 推迟到窗口里面执行的标记, 同一个任务只保留一个推迟的执行
+	var deferred int32
+	run := func() {
 		// 创建执行器
 		addr := r.getAddr()
 		start := time.Now()
+		traceID := utils.TraceID(param.Trace)
+		r.Debug().Msgf("run taskName:%s, trace_id:%s", param.Executer.TaskName, traceID)
+		if param.Timeout > 0 || param.StallWindow > 0 {
+			r.reportStart(param, start)
+		}
+		var stopAlive func()
+		if param.StallWindow > 0 {
+			stopAlive = r.reportAlive(param)
+		}
 		payload, err := r.createToExec(ctx, param)
+		if stopAlive != nil {
+			stopAlive()
+		}
 		if err != nil {
-			r.Error().Msgf("createToExec %s, taskName:%s\n", err, param.Executer.TaskName)
+			r.Error().Msgf("createToExec %s, taskName:%s, trace_id:%s\n", err, param.Executer.TaskName, traceID)
 		} else {
 			r.Debug().Msgf("result:%s", payload)
 		}
+		r.reportResult(param, start, payload, err)
 
 		code := 0
 		payloadStr := string(payload)
@@ -199,7 +279,7 @@ func (r *Runtime) createCron(param *model.Param) (b []byte, err error) {
 			}
 		}
 
-		err = gout.POST(addr + model.TASK_EXECUTER_RESULT_URL).Debug(false).SetJSON(model.ResultCore{
+		err = gout.New(r.httpClient()).POST(utils.GateHTTPAddr(addr) + model.TASK_EXECUTER_RESULT_URL).Debug(false).SetJSON(model.ResultCore{
 			TaskID:     param.Executer.TaskName,
 			TaskName:   param.Executer.TaskName,
 			StartTime:  start,
@@ -213,7 +293,18 @@ func (r *Runtime) createCron(param *model.Param) (b []byte, err error) {
 		if err != nil {
 			r.Warn().Msgf("result:%s", err)
 		}
-	})
+	}
+
+	var tm cronex.TimerNoder
+	if param.Trigger.IsOnce() {
+		tm, err = r.addOnce(param, func() {
+			r.runInWindow(ctx, param, &deferred, run)
+		})
+	} else {
+		tm, err = r.cron.AddFunc(param.Trigger.Cron, func() {
+			r.runInWindow(ctx, param, &deferred, run)
+		})
+	}
 
 	if err != nil {
 		cancel()
@@ -229,11 +320,151 @@ func (r *Runtime) createCron(param *model.Param) (b []byte, err error) {
 	}
 	// 按道理不应该old有值
 	r.Debug().Msgf("old(%t), createCron tm:%p, taskName:%s\n", ok, tm, param.Executer.TaskName)
-	r.cronFunc.Store(param.Executer.TaskName, cronNode{ctx: ctx, cancel: cancel, tm: tm})
+	r.cronFunc.Store(param.Executer.TaskName, cronNode{ctx: ctx, cancel: cancel, tm: tm, param: param})
 	return nil, nil
 }
 
+// 通过长连接告诉gate任务开始执行了, gate用来检查是否超时
+func (r *Runtime) reportStart(param *model.Param, start time.Time) {
+	conn := r.conn.Load()
+	if conn == nil {
+		return
+	}
+
+	started := model.TaskStarted{TaskName: param.Executer.TaskName, StartTime: start, Timeout: param.Timeout, StallWindow: param.StallWindow, Trace: param.Trace}
+	r.MuConn.Lock()
+	err := utils.WriteJsonTimeout(conn, model.RuntimeMsg{Whoami: model.Whoami{Name: r.NodeName}, Started: &started}, r.WriteTimeout)
+	r.MuConn.Unlock()
+	if err != nil {
+		r.Warn().Msgf("report start:%s, taskName:%s", err, param.Executer.TaskName)
+	}
+}
+
+// 执行期间每隔StallWindow/3上报一次心跳, gate超过StallWindow没有收到时标记为stalled
+// 返回的函数在执行结束之后调用, 停止上报
+func (r *Runtime) reportAlive(param *model.Param) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		tk := time.NewTicker(param.StallWindow / 3)
+		defer tk.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-tk.C:
+				conn := r.conn.Load()
+				if conn == nil {
+					continue
+				}
+
+				alive := model.TaskAlive{TaskName: param.Executer.TaskName, Time: now}
+				r.MuConn.Lock()
+				err := utils.WriteJsonTimeout(conn, model.RuntimeMsg{Whoami: model.Whoami{Name: r.NodeName}, Alive: &alive}, r.WriteTimeout)
+				r.MuConn.Unlock()
+				if err != nil {
+					r.Warn().Msgf("report alive:%s, taskName:%s", err, param.Executer.TaskName)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// 通过长连接把执行结果上报给gate, 写入任务的状态里面
+// 带上下发时的trace context, gate那边的span和日志能接上同一个trace
+func (r *Runtime) reportResult(param *model.Param, start time.Time, payload []byte, err error) {
+	conn := r.conn.Load()
+	if conn == nil {
+		return
+	}
+
+	taskName := param.Executer.TaskName
+	result := model.TaskResult{
+		TaskName: taskName,
+		Stdout:   string(payload),
+		Duration: time.Since(start),
+		EndTime:  time.Now(),
+		Trace:    param.Trace,
+	}
+
+	if err != nil {
+		result.ExitCode = 1
+		result.Stderr = err.Error()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+			if len(exitErr.Stderr) > 0 {
+				result.Stderr = string(exitErr.Stderr)
+			}
+		}
+	}
+	result.Truncate()
+
+	r.MuConn.Lock()
+	err = utils.WriteJsonTimeout(conn, model.RuntimeMsg{Whoami: model.Whoami{Name: r.NodeName}, Result: &result}, r.WriteTimeout)
+	r.MuConn.Unlock()
+	if err != nil {
+		r.Warn().Msgf("report result:%s, taskName:%s, trace_id:%s", err, taskName, utils.TraceID(param.Trace))
+	}
+}
+
+// 没有cron的任务, 到了once指定的时间执行一次, once为空立即执行
+// gate主节点到点才分配, 这里一般是立即执行; 已经在runtime上的任务被更新时由这里的定时器到点执行
+// 执行过的任务是Completed, gate不会再推过来, 所以once已经过去了也直接执行
+func (r *Runtime) addOnce(param *model.Param, cb func()) (cronex.TimerNoder, error) {
+	at, err := param.Trigger.OnceTime()
+	if err != nil {
+		return nil, err
+	}
+
+	delay := time.Until(at)
+	if delay < 0 {
+		delay = 0
+	}
+	return onceTimer{time.AfterFunc(delay, cb)}, nil
+}
+
+// 只在允许的时间窗口里面执行, 窗口外触发时推迟到下一个窗口或者跳过
+func (r *Runtime) runInWindow(ctx context.Context, param *model.Param, deferred *int32, run func()) {
+	now := time.Now()
+	next, err := param.Trigger.NextWindow(now)
+	if err != nil {
+		r.Warn().Msgf("taskName:%s, next window:%s\n", param.Executer.TaskName, err)
+		return
+	}
+
+	if next.Equal(now) {
+		run()
+		return
+	}
+
+	if param.Trigger.SkipOutsideWindow() {
+		r.Debug().Msgf("taskName:%s, outside window, skip, next window:%s\n", param.Executer.TaskName, next)
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(deferred, 0, 1) {
+		return
+	}
+
+	r.Debug().Msgf("taskName:%s, outside window, defer to:%s\n", param.Executer.TaskName, next)
+	time.AfterFunc(next.Sub(now), func() {
+		atomic.StoreInt32(deferred, 0)
+		// 任务已经被删除或者停止
+		if ctx.Err() != nil {
+			return
+		}
+		run()
+	})
+}
+
 func (r *Runtime) runCrudCmd(conn *websocket.Conn, param *model.Param) (payload []byte, err error) {
+	r.conn.Store(conn)
+
+	if param.Disabled && (param.IsCreate() || param.IsUpdate() || param.IsContinue()) {
+		r.disableCron(param)
+		return nil, nil
+	}
 
 	switch {
 	case param.IsCreate():
@@ -249,6 +480,10 @@ func (r *Runtime) runCrudCmd(conn *websocket.Conn, param *model.Param) (payload
 			//return payload, err
 		}
 		payload, err = r.createCron(param)
+	case param.IsSync():
+		payload, err = r.syncCron(param)
+	case param.IsSyncDone():
+		r.syncDone(param.SyncTasks)
 	default:
 		r.Debug().Msgf("Unknown action:%s", param.Action)
 	}
@@ -269,6 +504,14 @@ func (i *interval) sleep() {
 }
 
 // 获取gate的地址
+// 上报结果用的http客户端, 配置了gate的ca时带上tls配置
+func (r *Runtime) httpClient() *http.Client {
+	if r.gateClient == nil {
+		return http.DefaultClient
+	}
+	return r.gateClient
+}
+
 func (r *Runtime) getAddr() string {
 	addrs := r.addrs.Keys()
 	if len(addrs) == 0 {
@@ -301,7 +544,7 @@ func (r *Runtime) createConnRand(lambda bool) (err error) {
 
 		for i := 0; i < 2; i++ {
 			r.Debug().Msgf("# addr is %s, id:%s", addr, id)
-			gs := gatesock.New(r.Slog, r.runCrudCmd, addr, r.NodeName, r.WriteTimeout, &r.MuConn, lambda, id)
+			gs := gatesock.New(r.Slog, r.runCrudCmd, addr, r.NodeName, r.WriteTimeout, &r.MuConn, lambda, id, r.MaxConcurrency, r.gateTLS)
 			if err := gs.CreateConntion(); err != nil {
 				// 如果握手或者上传第一个包失败，sleep 下，再重连一次
 				r.Error().Msgf("createConnection fail:%v\n", err)
@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"reflect"
+
+	"github.com/1whour/crab/model"
+)
+
+// 重连之后gate重新推送的任务, 和正在运行的一样时不动, 避免打断正在执行的任务
+func (r *Runtime) syncCron(param *model.Param) ([]byte, error) {
+	if param.Disabled {
+		r.disableCron(param)
+		return nil, nil
+	}
+
+	if old, ok := r.cronFunc.Load(param.Executer.TaskName); ok && sameTask(old.param, param) {
+		return nil, nil
+	}
+
+	// 断开期间被修改过, 或者runtime上没有, 重新创建
+	return r.createCron(param)
+}
+
+// 重新推送结束, 停掉不在列表里面的任务, 它们在断开期间被删除或者分配到别的runtime上了
+func (r *Runtime) syncDone(taskNames []string) {
+	keep := make(map[string]struct{}, len(taskNames))
+	for _, name := range taskNames {
+		keep[name] = struct{}{}
+	}
+
+	for _, name := range r.cronFunc.Keys() {
+		if _, ok := keep[name]; ok {
+			continue
+		}
+
+		if e, ok := r.cronFunc.LoadAndDelete(name); ok {
+			e.close()
+			r.Debug().Msgf("syncdone, task is remove:%s, tm:%p\n", name, e.tm)
+		}
+	}
+}
+
+// 忽略action, trace和DispatchID, 其他的字段一样就是同一个任务
+// v2协议下发的任务带DispatchID, 重连之后从etcd里面同步的任务没有
+func sameTask(a, b *model.Param) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	x, y := *a, *b
+	x.Action, y.Action = "", ""
+	x.Trace, y.Trace = nil, nil
+	x.DispatchID, y.DispatchID = "", ""
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,39 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/antlabs/cronex"
+	"github.com/stretchr/testify/assert"
+)
+
+// v2协议下发的任务带DispatchID, 重连之后同步的同一个任务没有, 不能打断正在执行的任务
+func Test_SyncCron_IgnoreDispatchID(t *testing.T) {
+	r := &Runtime{ctx: context.TODO(), cron: cronex.New()}
+
+	var dispatched model.Param
+	dispatched.SetCreate()
+	dispatched.Executer.TaskName = "hello"
+	dispatched.Trigger.Cron = "* * * * * *"
+	dispatched.DispatchID = "d1"
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	r.cronFunc.Store("hello", cronNode{ctx: ctx, cancel: cancel, param: &dispatched})
+
+	resync := dispatched
+	resync.DispatchID = ""
+	_, err := r.syncCron(&resync)
+	assert.NoError(t, err)
+
+	node, ok := r.cronFunc.Load("hello")
+	assert.True(t, ok)
+	assert.Same(t, &dispatched, node.param)
+	assert.NoError(t, ctx.Err())
+
+	// 其他字段变了还是不一样
+	resync.Trigger.Cron = "*/2 * * * * *"
+	assert.False(t, sameTask(&dispatched, &resync))
+}
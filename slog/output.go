@@ -0,0 +1,63 @@
+package slog
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// 日志输出的配置, 都为空时和原来一样, json格式输出到stdout
+type Options struct {
+	// 日志文件, 为空时输出到stdout
+	File string
+	// json或者console
+	Format string
+	// 单个文件的大小(MB), 大于0时按大小切割
+	MaxSize int
+	// 保留的旧文件个数和天数, 0表示不限制
+	MaxBackups int
+	MaxAge     int
+}
+
+// 根据配置创建日志对象
+func NewWithOptions(o Options) (*Slog, error) {
+	w, err := o.writer()
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Format {
+	case "", FormatJSON:
+	case FormatConsole:
+		w = zerolog.ConsoleWriter{Out: w, NoColor: o.File != ""}
+	default:
+		return nil, fmt.Errorf("unknown log format:%s", o.Format)
+	}
+
+	return New(w), nil
+}
+
+func (o Options) writer() (io.Writer, error) {
+	if o.File == "" {
+		return os.Stdout, nil
+	}
+
+	if o.MaxSize > 0 {
+		return &lumberjack.Logger{
+			Filename:   o.File,
+			MaxSize:    o.MaxSize,
+			MaxBackups: o.MaxBackups,
+			MaxAge:     o.MaxAge,
+		}, nil
+	}
+
+	return os.OpenFile(o.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
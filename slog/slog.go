@@ -81,3 +81,7 @@ func (e *event) ID(id string) *event {
 func (e *event) IP(ip string) *event {
 	return &event{e.Str("IP", ip)}
 }
+
+func (e *event) RequestID(id string) *event {
+	return &event{e.Str("request_id", id)}
+}
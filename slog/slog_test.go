@@ -2,6 +2,9 @@ package slog
 
 import (
 	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -16,3 +19,31 @@ func Test_Slog(t *testing.T) {
 
 	assert.Equal(t, strings.Count(out.String(), "init"), 2)
 }
+
+// 输出到文件, json格式可以直接解析, console格式是可读的文本
+func Test_NewWithOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "json.log")
+	l, err := NewWithOptions(Options{File: jsonFile})
+	assert.NoError(t, err)
+	l.SetLevel("debug").Info().Msgf("hello")
+
+	data, err := os.ReadFile(jsonFile)
+	assert.NoError(t, err)
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, "hello", m["message"])
+
+	consoleFile := filepath.Join(dir, "console.log")
+	l, err = NewWithOptions(Options{File: consoleFile, Format: FormatConsole, MaxSize: 1})
+	assert.NoError(t, err)
+	l.SetLevel("debug").Info().Msgf("hello")
+
+	data, err = os.ReadFile(consoleFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "INF hello")
+
+	_, err = NewWithOptions(Options{Format: "xml"})
+	assert.Error(t, err)
+}
@@ -5,16 +5,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/1whour/crab/model"
-	"github.com/1whour/crab/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
 // mjobs子命令的的入口函数
-// 随机选择一个runtimeNode
-func (e *EtcdStore) selectRuntimeNode(state model.State) (string, error) {
+// 指定了runtime时只选这个节点, 否则轮询选择一个runtimeNode
+// checkCapacity为true时, 需要运行的任务会跳过已经满载的runtime
+// 都满了返回ErrNoCapacity, 任务保持CanRun等restartRunning下一轮再分配
+// claim不为nil时选中之后调用, 用来写入本地队列, 有并发上限的runtime在它的锁里面检查负载和调用claim
+func (e *EtcdStore) selectRuntimeNode(ctx context.Context, state model.State, checkCapacity bool, claim func(runtimeNode string) error) (string, error) {
+	selected := func(node string) (string, error) {
+		if claim == nil {
+			return node, nil
+		}
+		return node, claim(node)
+	}
 
 	if !state.Lambda && e.RuntimeNode.RuntimeNode.Len() == 0 {
 		e.Warn().Msgf("assign.runtimeNodes.size is 0\n")
@@ -32,16 +43,59 @@ func (e *EtcdStore) selectRuntimeNode(state model.State) (string, error) {
 		prefix := model.ToLocalTaskLambdaPrefix(state.TaskName)
 		_, ok := e.LambdaNode.Load(prefix)
 		if ok {
-			return prefix, nil
+			return selected(prefix)
 		}
 
 		e.Debug().Msgf("lambda not found:prefix(%s): taskName(%s)", prefix, state.TaskName)
 		return "", fmt.Errorf("lambda not found:%s", prefix)
 	}
 
+	// stop, rm不占用名额
+	needSlot := checkCapacity && (state.IsCreate() || state.IsUpdate() || state.IsContinue())
+
+	if state.TargetRuntime != "" {
+		value, ok := e.RuntimeNode.RuntimeNode.Load(state.TargetRuntime)
+		if !ok {
+			return "", fmt.Errorf("assign.target runtime not found:%s", state.TargetRuntime)
+		}
+		if !needSlot {
+			return selected(state.TargetRuntime)
+		}
+
+		ok, err := e.claimSlot(ctx, state.TargetRuntime, value, claim)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("%w:%s", ErrNoCapacity, state.TargetRuntime)
+		}
+		return state.TargetRuntime, nil
+	}
+
+	// map的遍历顺序是随机的, 排序之后轮询才有意义
 	runtimeNodes := e.RuntimeNode.RuntimeNode.Keys()
+	if len(runtimeNodes) == 0 {
+		return "", errors.New("assign.runtimeNodes.size is 0")
+	}
+	sort.Strings(runtimeNodes)
+	n := atomic.AddUint64(&e.next, 1) - 1
+	if !needSlot {
+		return selected(runtimeNodes[n%uint64(len(runtimeNodes))])
+	}
 
-	return utils.SliceRandOne(runtimeNodes), nil
+	// 从轮询的位置开始往后找第一个没有满载的runtime
+	for i := uint64(0); i < uint64(len(runtimeNodes)); i++ {
+		node := runtimeNodes[(n+i)%uint64(len(runtimeNodes))]
+		value, _ := e.RuntimeNode.RuntimeNode.Load(node)
+		ok, err := e.claimSlot(ctx, node, value, claim)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return node, nil
+		}
+	}
+	return "", ErrNoCapacity
 }
 
 // 使用分布式锁
@@ -50,8 +104,10 @@ func (e *EtcdStore) AssignMutex(ctx context.Context, oneTask model.KeyVal, failo
 }
 
 func (e *EtcdStore) LockUnlock(ctx context.Context, key string, cb func() error) error {
+	return e.lockMutex(ctx, model.AssignTaskMutex(key), cb)
+}
 
-	mutexName := model.AssignTaskMutex(key)
+func (e *EtcdStore) lockMutex(ctx context.Context, mutexName string, cb func() error) error {
 
 	s, err := concurrency.NewSession(e.defaultClient)
 	if err != nil {
@@ -136,18 +192,29 @@ func (e *EtcdStore) assign(ctx context.Context, oneTask model.KeyVal, failover b
 		return errors.New("taskName is empty")
 	}
 
-	// TODO 如果选出的runtimeNode和出错runtimeNode一样，需要重新选择
-	runtimeNode, err := e.selectRuntimeNode(state)
-	if err != nil {
-		return err
-	}
-
 	// 如果runtimeNode绑定好，除了出错，或者新建，会取目前绑定的runtimeNode直接使用
-	if !failover && !state.IsCreate() {
+	// runtime断开后被重置的任务没有绑定关系, 走重新选择的逻辑
+	reuse := !failover && !state.IsCreate() && state.RuntimeNode != ""
+
+	// TODO 如果选出的runtimeNode和出错runtimeNode一样，需要重新选择
+	// 已经绑定的任务本来就占着名额, 不需要再检查runtime是否满载
+	if reuse {
+		if _, err = e.selectRuntimeNode(ctx, state, false, nil); err != nil {
+			return err
+		}
 		e.Debug().Msgf("state:%v\n", state)
-		runtimeNode = state.RuntimeNode
+		return e.assignTo(ctx, oneTask, rspState, state, taskName, state.RuntimeNode)
 	}
 
+	// 写入本地队列在选runtime的时候完成, 这样检查负载和占用名额在同一个锁里面
+	_, err = e.selectRuntimeNode(ctx, state, true, func(runtimeNode string) error {
+		return e.assignTo(ctx, oneTask, rspState, state, taskName, runtimeNode)
+	})
+	return err
+}
+
+// 把任务写入选中的runtime的本地队列
+func (e *EtcdStore) assignTo(ctx context.Context, oneTask model.KeyVal, rspState *clientv3.GetResponse, state model.State, taskName, runtimeNode string) (err error) {
 	e.Debug().Msgf("assign, taskName %s, action:(%s)\n", taskName, oneTask.State.Action)
 	// 如果是没有在运行中的删除任务，直接清理data, state队列中的数据
 	if oneTask.State.IsRemove() && !oneTask.State.InRuntime && !oneTask.State.IsFailed() {
@@ -0,0 +1,133 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 没有指定runtime时轮询, 指定了只选这个runtime
+func Test_SelectRuntimeNode(t *testing.T) {
+	e := &EtcdStore{Slog: slog.New(os.Stdout).SetLevel("error"), RuntimeNode: &model.RuntimeNode{}}
+
+	_, err := e.selectRuntimeNode(context.TODO(), model.State{}, true, nil)
+	assert.Error(t, err)
+
+	a := model.FullRuntimeNode(model.Whoami{Name: "a"})
+	b := model.FullRuntimeNode(model.Whoami{Name: "b"})
+	e.RuntimeNode.Store(b, "")
+	e.RuntimeNode.Store(a, "")
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		node, err := e.selectRuntimeNode(context.TODO(), model.State{}, true, nil)
+		assert.NoError(t, err)
+		got = append(got, node)
+	}
+	assert.Equal(t, []string{a, b, a, b}, got)
+
+	node, err := e.selectRuntimeNode(context.TODO(), model.State{TargetRuntime: b}, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, b, node)
+
+	_, err = e.selectRuntimeNode(context.TODO(), model.State{TargetRuntime: model.FullRuntimeNode(model.Whoami{Name: "c"})}, true, nil)
+	assert.Error(t, err)
+}
+
+// 此函数依赖etcd是否存在
+// 满载的runtime会被跳过, 都满了返回ErrNoCapacity, stop的任务不占名额
+func Test_SelectRuntimeNode_Capacity(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), &model.RuntimeNode{})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	register := func(name string, max int) string {
+		who := model.Whoami{Name: name, MaxConcurrency: max}
+		all, err := json.Marshal(model.RegisterRuntime{Whoami: who})
+		assert.NoError(t, err)
+		node := model.FullRuntimeNode(who)
+		e.RuntimeNode.Store(node, string(all))
+		return node
+	}
+
+	a := register(uuid.New().String(), 1)
+	b := register(uuid.New().String(), 2)
+	for _, node := range []string{a, b} {
+		defer e.defaultKVC.Delete(ctx, model.ToLocalTaskPrefix(node)+"/", clientv3.WithPrefix())
+	}
+
+	create := model.State{Action: model.Create}
+	_, err = e.defaultKVC.Put(ctx, model.ToLocalTask(a, "t1"), model.CanRun)
+	assert.NoError(t, err)
+	_, err = e.defaultKVC.Put(ctx, model.ToLocalTask(b, "t2"), model.Stop)
+	assert.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		node, err := e.selectRuntimeNode(ctx, create, true, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, b, node)
+	}
+
+	_, err = e.defaultKVC.Put(ctx, model.ToLocalTask(b, "t3"), model.CanRun)
+	assert.NoError(t, err)
+	_, err = e.defaultKVC.Put(ctx, model.ToLocalTask(b, "t4"), model.CanRun)
+	assert.NoError(t, err)
+
+	_, err = e.selectRuntimeNode(ctx, create, true, nil)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	_, err = e.selectRuntimeNode(ctx, model.State{Action: model.Create, TargetRuntime: a}, true, nil)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	// stop和已经绑定的任务不检查负载
+	_, err = e.selectRuntimeNode(ctx, model.State{Action: model.Stop}, true, nil)
+	assert.NoError(t, err)
+	_, err = e.selectRuntimeNode(ctx, create, false, nil)
+	assert.NoError(t, err)
+}
+
+// 此函数依赖etcd是否存在
+// 多个任务同时分配到同一个runtime, 检查负载和写入本地队列在一个锁里面, 不会超过上限
+func Test_ClaimSlot_Concurrent(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), &model.RuntimeNode{})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	who := model.Whoami{Name: uuid.New().String(), MaxConcurrency: 2}
+	value, err := json.Marshal(model.RegisterRuntime{Whoami: who})
+	assert.NoError(t, err)
+	node := model.FullRuntimeNode(who)
+	defer e.defaultKVC.Delete(ctx, model.ToLocalTaskPrefix(node)+"/", clientv3.WithPrefix())
+
+	var claimed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := e.claimSlot(ctx, node, string(value), func(runtimeNode string) error {
+				_, err := e.defaultKVC.Put(ctx, model.ToLocalTask(runtimeNode, fmt.Sprintf("t%d", i)), model.CanRun)
+				return err
+			})
+			assert.NoError(t, err)
+			if ok {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), claimed)
+	n, err := e.RuntimeLoad(ctx, node)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,56 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 批量创建全局状态与数据队列, 所有task在一个事务里面创建, 要么都成功, 要么都失败
+// 有task已经存在时返回ErrTaskExists, conflicts是已经存在的task名, extra和task一起写入
+func (e *EtcdStore) CreateBatchDataAndState(ctx context.Context, reqs []*model.Param, extra ...clientv3.Op) (revision int64, conflicts []string, err error) {
+	cmps := make([]clientv3.Cmp, 0, len(reqs))
+	puts := make([]clientv3.Op, 0, len(reqs)*2)
+	gets := make([]clientv3.Op, 0, len(reqs))
+
+	for _, req := range reqs {
+		taskName := req.Executer.TaskName
+		globalData, err := json.Marshal(req)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		state, err := model.NewState(req.Kind, req)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		globalTaskName := model.FullGlobalTask(taskName)
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(globalTaskName), "=", 0))
+		puts = append(puts,
+			clientv3.OpPut(globalTaskName, string(globalData)),
+			clientv3.OpPut(model.FullGlobalTaskState(taskName), string(state)),
+		)
+		gets = append(gets, clientv3.OpGet(globalTaskName, clientv3.WithCountOnly()))
+	}
+
+	txnRsp, err := e.defaultKVC.Txn(ctx).If(cmps...).Then(append(puts, extra...)...).Else(gets...).Commit()
+	if err != nil {
+		return 0, nil, fmt.Errorf("Transaction execution failed err :%v", err)
+	}
+
+	if txnRsp.Succeeded {
+		return txnRsp.Header.Revision, nil, nil
+	}
+
+	// 失败时Else分支按顺序返回每个task是否存在
+	for i, rsp := range txnRsp.Responses {
+		if rsp.GetResponseRange().GetCount() > 0 {
+			conflicts = append(conflicts, reqs[i].Executer.TaskName)
+		}
+	}
+	return 0, conflicts, ErrTaskExists
+}
@@ -0,0 +1,69 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 本地队列的值, 需要运行的任务是CanRun, 其他的是对应的action
+func localValue(action string) string {
+	switch action {
+	case model.Create, model.Update, model.Continue:
+		return model.CanRun
+	}
+	return action
+}
+
+// 从runtime的注册信息里面取出并发上限, 0表示不限制
+func runtimeCapacity(value string) int {
+	var info model.RegisterRuntime
+	if err := json.Unmarshal([]byte(value), &info); err != nil {
+		return 0
+	}
+	return info.MaxConcurrency
+}
+
+// runtime上已经分配的任务数, 本地队列里面值是CanRun的才算, stop之后就不再占用名额
+func (e *EtcdStore) RuntimeLoad(ctx context.Context, runtimeNode string) (int, error) {
+	rsp, err := e.defaultKVC.Get(ctx, model.ToLocalTaskPrefix(runtimeNode)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, kv := range rsp.Kvs {
+		if string(kv.Value) == model.CanRun {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// runtime没有满载时占用一个名额, 返回false表示已经满了
+// 有并发上限的runtime在它的锁里面检查负载和调用claim, 多个任务同时分配到同一个runtime时不会超过上限
+func (e *EtcdStore) claimSlot(ctx context.Context, runtimeNode string, value string, claim func(runtimeNode string) error) (ok bool, err error) {
+	max := runtimeCapacity(value)
+	if max <= 0 {
+		if claim != nil {
+			err = claim(runtimeNode)
+		}
+		return true, err
+	}
+
+	err = e.lockMutex(ctx, model.RuntimeSlotMutex(runtimeNode), func() error {
+		n, err := e.RuntimeLoad(ctx, runtimeNode)
+		if err != nil || n >= max {
+			return err
+		}
+
+		ok = true
+		if claim != nil {
+			return claim(runtimeNode)
+		}
+		return nil
+	})
+	return ok, err
+}
@@ -18,6 +18,8 @@ type EtcdStore struct {
 	defaultClient *clientv3.Client
 	*slog.Slog
 	*model.RuntimeNode
+	// 轮询分配runtime的计数
+	next uint64
 }
 
 const maxRetry = 1
@@ -29,13 +31,18 @@ func NewStore(EtcdAddr []string, slog *slog.Slog, runtimeNode *model.RuntimeNode
 		return nil, err
 	}
 
-	defaultKVC := clientv3.NewKV(defautlClient) // 内置自动重试的逻辑
+	return NewStoreWithClient(defautlClient, slog, runtimeNode), nil
+}
+
+// 复用已经创建好的etcd客户端, 认证和tls的配置跟着客户端走
+func NewStoreWithClient(client *clientv3.Client, slog *slog.Slog, runtimeNode *model.RuntimeNode) *EtcdStore {
+	defaultKVC := client.KV // 内置自动重试的逻辑, 配置了namespace时自动加上前缀
 	return &EtcdStore{
 		defaultKVC:    defaultKVC,
-		defaultClient: defautlClient,
+		defaultClient: client,
 		Slog:          slog,
 		RuntimeNode:   runtimeNode,
-	}, nil
+	}
 }
 
 func (e *EtcdStore) RuntimeNodeCount() int {
@@ -43,11 +50,13 @@ func (e *EtcdStore) RuntimeNodeCount() int {
 }
 
 // 创建全局状态与数据队列, 调用create web接口时用到
-func (e *EtcdStore) CreateDataAndState(ctx context.Context, taskName string, req *model.Param) error {
+// 返回数据队列的revision, 客户端更新时带上用于乐观锁
+// extra在同一个事务里面执行, 只有创建成功时才会写入
+func (e *EtcdStore) CreateDataAndState(ctx context.Context, taskName string, req *model.Param, extra ...clientv3.Op) (int64, error) {
 
 	globalData, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// 创建数据队列
@@ -58,29 +67,36 @@ func (e *EtcdStore) CreateDataAndState(ctx context.Context, taskName string, req
 	// 创建全局状态队列里面的队列
 	state, err := model.NewState(req.Kind, req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	txn := e.defaultKVC.Txn(ctx)
 	txn.If(clientv3.Compare(clientv3.CreateRevision(globalTaskName), "=", 0)).
-		Then(
+		Then(append([]clientv3.Op{
 			clientv3.OpPut(globalTaskName, string(globalData)),
 			clientv3.OpPut(globalTaskStateName, string(state)),
-		).Else()
+		}, extra...)...).
+		// 并发创建时别人先创建成功了, 取出已经存在的revision
+		Else(clientv3.OpGet(globalTaskName, clientv3.WithKeysOnly()))
 
 	txnRsp, err := txn.Commit()
 	if err != nil {
-		return fmt.Errorf("Transaction execution failed err :%v", err)
+		return 0, fmt.Errorf("Transaction execution failed err :%v", err)
 	}
 
 	if !txnRsp.Succeeded {
-		return fmt.Errorf("create task??? Transaction execution failed:%v", taskName)
+		var revision int64
+		if kvs := txnRsp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+			revision = kvs[0].ModRevision
+		}
+		return 0, fmt.Errorf("%w:%s, revision(%d)", ErrTaskExists, taskName, revision)
 	}
-	return nil
+	return txnRsp.Header.Revision, nil
 }
 
 // delete，rm，continue
-func (e *EtcdStore) UpdateAction(ctx context.Context, req *model.OnlyParam, rspModRevision int64, state string, action string) error {
+// 成功时返回新的revision, 任务不存在时返回0, extra和数据在同一个事务里面写入
+func (e *EtcdStore) UpdateAction(ctx context.Context, req *model.OnlyParam, rspModRevision int64, state string, action string, extra ...clientv3.Op) (int64, error) {
 
 	taskName := req.Executer.TaskName
 
@@ -92,29 +108,29 @@ func (e *EtcdStore) UpdateAction(ctx context.Context, req *model.OnlyParam, rspM
 
 		rspData, err := e.defaultClient.Get(ctx, globalTaskName)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		if len(rspData.Kvs) == 0 {
 			e.Debug().Msgf("UpdateAction: data length = 0")
-			return nil
+			return 0, nil
 		}
 
 		var param model.Param
 		if err = json.Unmarshal(rspData.Kvs[0].Value, &param); err != nil {
-			return err
+			return 0, err
 		}
 
 		param.Action = req.Action
 		// 请求重新序列化成json, 把action的变化加进去
 		globalData, err := json.Marshal(param)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		// 获取全局状态队列里面的值
 		rspState, err := e.defaultKVC.Get(ctx, globalTaskStateName)
 		if err != nil {
-			return fmt.Errorf("get.globalTaskStateName err :%w", err)
+			return 0, fmt.Errorf("get.globalTaskStateName err :%w", err)
 		}
 
 		//rspModRevision := rsp.Kvs[0].ModRevision
@@ -123,7 +139,7 @@ func (e *EtcdStore) UpdateAction(ctx context.Context, req *model.OnlyParam, rspM
 		// 更新json中的State是CanRun
 		newValue, err := model.UpdateState(rspState.Kvs[0].Value, "", state, action, &param, taskName, "")
 		if err != nil {
-			return fmt.Errorf("updateTask, onlyUpdateState(CanRun) err :%v", err)
+			return 0, fmt.Errorf("updateTask, onlyUpdateState(CanRun) err :%v", err)
 		}
 
 		// 使用事务更新
@@ -131,39 +147,41 @@ func (e *EtcdStore) UpdateAction(ctx context.Context, req *model.OnlyParam, rspM
 		txn.If(clientv3.Compare(clientv3.ModRevision(globalTaskName), "=", rspModRevision),
 			clientv3.Compare(clientv3.ModRevision(globalTaskStateName), "=", rspStateModRevision),
 		).
-			Then(
+			Then(append([]clientv3.Op{
 				clientv3.OpPut(globalTaskName, string(globalData)),    //更新全局队列里面的数据
 				clientv3.OpPut(globalTaskStateName, string(newValue)), //更新全局状态队列里面的状态
-			).Else()
+			}, extra...)...).Else()
 
 		// 提交事务
 		txnRsp, err := txn.Commit()
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		// 事务失败
 		if !txnRsp.Succeeded {
 			// 最多重试三次
 			if i == maxRetry-1 {
-				return fmt.Errorf("action(%s)task, retry(%d), Transaction execution failed:%s", action, i, taskName)
+				return 0, fmt.Errorf("action(%s)task, retry(%d), Transaction execution failed:%s", action, i, taskName)
 			}
 			time.Sleep(time.Millisecond * time.Duration((i + 1)))
 			continue
 		}
 		// 执行成功直接返回
-		return nil
+		return txnRsp.Header.Revision, nil
 	}
-	return nil
+	return 0, nil
 }
 
 // 更新全局数据与状态队列, 仅仅更新数据
-func (e *EtcdStore) UpdateDataAndState(ctx context.Context, req *model.Param, rspModRevision int64, state string, action string) error {
+// rspModRevision是数据队列的revision, 不一致时返回ErrRevisionMismatch, 成功时返回新的revision
+// extra和数据在同一个事务里面写入, 比如审计记录
+func (e *EtcdStore) UpdateDataAndState(ctx context.Context, req *model.Param, rspModRevision int64, state string, action string, extra ...clientv3.Op) (int64, error) {
 
 	// 请求重新序列化成json, 把action的变化加进去
 	globalData, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	taskName := req.Executer.TaskName
@@ -177,7 +195,7 @@ func (e *EtcdStore) UpdateDataAndState(ctx context.Context, req *model.Param, rs
 		// 获取全局状态队列里面的值
 		rspState, err := e.defaultKVC.Get(ctx, globalTaskStateName)
 		if err != nil {
-			return fmt.Errorf("get.globalTaskStateName err :%w", err)
+			return 0, fmt.Errorf("get.globalTaskStateName err :%w", err)
 		}
 
 		//rspModRevision := rsp.Kvs[0].ModRevision
@@ -186,7 +204,7 @@ func (e *EtcdStore) UpdateDataAndState(ctx context.Context, req *model.Param, rs
 		// 更新json中的State是CanRun
 		newValue, err := model.UpdateState(rspState.Kvs[0].Value, "", state, action, req, taskName, "")
 		if err != nil {
-			return fmt.Errorf("updateTask, onlyUpdateState(CanRun) err :%v", err)
+			return 0, fmt.Errorf("updateTask, onlyUpdateState(CanRun) err :%v", err)
 		}
 
 		// 使用事务更新
@@ -194,30 +212,39 @@ func (e *EtcdStore) UpdateDataAndState(ctx context.Context, req *model.Param, rs
 		txn.If(clientv3.Compare(clientv3.ModRevision(globalTaskName), "=", rspModRevision),
 			clientv3.Compare(clientv3.ModRevision(globalTaskStateName), "=", rspStateModRevision),
 		).
-			Then(
+			Then(append([]clientv3.Op{
 				clientv3.OpPut(globalTaskName, string(globalData)),    //更新全局队列里面的数据
 				clientv3.OpPut(globalTaskStateName, string(newValue)), //更新全局状态队列里面的状态
-			).Else()
+			}, extra...)...).Else()
 
 		// 提交事务
 		txnRsp, err := txn.Commit()
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		// 事务失败
 		if !txnRsp.Succeeded {
+			// 数据被别人修改过, 重试也没有用
+			rspData, err := e.defaultKVC.Get(ctx, globalTaskName, clientv3.WithKeysOnly())
+			if err != nil {
+				return 0, err
+			}
+			if len(rspData.Kvs) == 0 || rspData.Kvs[0].ModRevision != rspModRevision {
+				return 0, fmt.Errorf("%w:%s", ErrRevisionMismatch, taskName)
+			}
+
 			// 最多重试三次
 			if i == maxRetry-1 {
-				return fmt.Errorf("action(%s)task, retry(%d), Transaction execution failed:%s", action, i, taskName)
+				return 0, fmt.Errorf("action(%s)task, retry(%d), Transaction execution failed:%s", action, i, taskName)
 			}
 			time.Sleep(time.Millisecond * time.Duration((i + 1)))
 			continue
 		}
 		// 执行成功直接返回
-		return nil
+		return txnRsp.Header.Revision, nil
 	}
-	return nil
+	return 0, nil
 }
 
 // 更新本地队列和全局队列, 设置state为running, 分配任务mjobs模块调用
@@ -244,8 +271,8 @@ func (e *EtcdStore) UpdateLocalAndGlobal(ctx context.Context, taskName string, r
 	).Then(
 		// 修改
 		clientv3.OpPut(fullTaskState, string(newValue)),
-		// 向本地队列写入任务, 目前本地队列的值没啥作用
-		clientv3.OpPut(ltaskPath, model.CanRun),
+		// 向本地队列写入任务, 值用来统计runtime的负载
+		clientv3.OpPut(ltaskPath, localValue(action)),
 	).Commit()
 
 	if err != nil {
@@ -264,7 +291,8 @@ func (e *EtcdStore) UpdateLocalAndGlobal(ctx context.Context, taskName string, r
 	return
 }
 
-func (e *EtcdStore) UpdateCallStateInner(ctx context.Context, taskName string, succeeded bool) (err error) {
+// attempts是推送给runtime的次数, 记录在状态里面
+func (e *EtcdStore) UpdateCallStateInner(ctx context.Context, taskName string, succeeded bool, attempts int) (err error) {
 
 	// 生成全局state key名
 	globalTaskState := model.ToGlobalTaskState(taskName)
@@ -287,7 +315,7 @@ func (e *EtcdStore) UpdateCallStateInner(ctx context.Context, taskName string, s
 		fullTaskState := model.FullGlobalTaskState(taskName)
 
 		// 更新状态中的runtimeNode
-		newValue, err := model.UpdateStateAck(rspState.Kvs[0].Value, succeeded)
+		newValue, err := model.UpdateStateAck(rspState.Kvs[0].Value, succeeded, attempts)
 		if err != nil {
 			return err
 		}
@@ -320,9 +348,9 @@ func (e *EtcdStore) UpdateCallStateInner(ctx context.Context, taskName string, s
 }
 
 func (e *EtcdStore) UpdateCallStateSuccessed(ctx context.Context, taskName string) error {
-	return e.UpdateCallStateInner(ctx, taskName, true)
+	return e.UpdateCallStateInner(ctx, taskName, true, 1)
 }
 
 func (e *EtcdStore) UpdateCallStateFailed(ctx context.Context, taskName string) error {
-	return e.UpdateCallStateInner(ctx, taskName, false)
+	return e.UpdateCallStateInner(ctx, taskName, false, 1)
 }
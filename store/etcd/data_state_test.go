@@ -0,0 +1,160 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// 此函数依赖etcd是否存在
+// 并发创建同名的task, 只有一个成功, 其他的返回ErrTaskExists
+func Test_CreateDataAndState_Race(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), nil)
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	taskName := uuid.New().String()
+	defer e.DeleteDataAndState(ctx, taskName)
+
+	const n = 8
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+			param.Executer.TaskName = taskName
+			param.SetCreate()
+			<-start
+			_, errs[i] = e.CreateDataAndState(ctx, taskName, &param)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	ok := 0
+	for _, err := range errs {
+		if err == nil {
+			ok++
+			continue
+		}
+		assert.True(t, errors.Is(err, ErrTaskExists), err)
+		assert.Contains(t, err.Error(), taskName)
+	}
+	assert.Equal(t, 1, ok)
+}
+
+// 此函数依赖etcd是否存在
+// 创建时保存下一次触发的时间, 每次执行完之后重新计算, 一次性任务执行过之后为空
+func Test_State_NextRun(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), nil)
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	getState := func(taskName string) model.State {
+		rsp, err := e.defaultKVC.Get(ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		if !assert.Len(t, rsp.Kvs, 1) {
+			t.FailNow()
+		}
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return state
+	}
+
+	create := func(trigger model.Trigger) string {
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: trigger}
+		param.Executer.TaskName = uuid.New().String()
+		param.SetCreate()
+		_, err := e.CreateDataAndState(ctx, param.Executer.TaskName, &param)
+		assert.NoError(t, err)
+		t.Cleanup(func() { e.DeleteDataAndState(ctx, param.Executer.TaskName) })
+		return param.Executer.TaskName
+	}
+
+	cron := create(model.Trigger{Cron: "0 0 * * * *"})
+	first := getState(cron).NextRun
+	if assert.NotNil(t, first) {
+		assert.True(t, first.After(time.Now()))
+	}
+	assert.NoError(t, e.UpdateLastResult(ctx, &model.TaskResult{TaskName: cron}))
+	assert.NotNil(t, getState(cron).NextRun)
+
+	once := create(model.Trigger{Once: time.Now().Add(time.Second).Format(time.RFC3339)})
+	assert.NotNil(t, getState(once).NextRun)
+	time.Sleep(time.Second)
+	assert.NoError(t, e.UpdateLastResult(ctx, &model.TaskResult{TaskName: once}))
+	assert.Nil(t, getState(once).NextRun)
+}
+
+// 此函数依赖etcd是否存在
+// 还没到时间的一次性任务是Waiting, 触发之后是CanRun, 执行过之后是Completed, runtime断开之后不再重置
+func Test_State_OnceLifecycle(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), nil)
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	getState := func(taskName string) model.State {
+		rsp, err := e.defaultKVC.Get(ctx, model.FullGlobalTaskState(taskName))
+		assert.NoError(t, err)
+		if !assert.Len(t, rsp.Kvs, 1) {
+			t.FailNow()
+		}
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		assert.NoError(t, err)
+		return state
+	}
+
+	param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Once: time.Now().Add(time.Hour).Format(time.RFC3339)}}
+	taskName := uuid.New().String()
+	param.Executer.TaskName = taskName
+	param.SetCreate()
+	_, err = e.CreateDataAndState(ctx, taskName, &param)
+	assert.NoError(t, err)
+	defer e.DeleteDataAndState(ctx, taskName)
+	assert.True(t, getState(taskName).IsWaiting())
+
+	ok, err := e.LockTriggerTask(ctx, taskName)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, getState(taskName).IsCanRun())
+
+	// 不是Waiting的不会再触发
+	ok, err = e.LockTriggerTask(ctx, taskName)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 模拟mjobs分配到一个已经断开的runtime上
+	runtimeNode := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	localKey := model.ToLocalTask(runtimeNode, taskName)
+	state := getState(taskName)
+	state.State = model.Running
+	state.RuntimeNode = runtimeNode
+	value, _ := json.Marshal(state)
+	_, err = e.defaultKVC.Put(ctx, model.FullGlobalTaskState(taskName), string(value))
+	assert.NoError(t, err)
+	_, err = e.defaultKVC.Put(ctx, localKey, model.CanRun)
+	assert.NoError(t, err)
+	defer e.defaultKVC.Delete(ctx, localKey)
+
+	assert.NoError(t, e.UpdateLastResult(ctx, &model.TaskResult{TaskName: taskName}))
+	state = getState(taskName)
+	assert.True(t, state.IsCompleted())
+	assert.False(t, e.NeedFix(ctx, state))
+
+	taskNames, err := e.LockResetRuntime(ctx, runtimeNode)
+	assert.NoError(t, err)
+	assert.Empty(t, taskNames)
+	assert.True(t, getState(taskName).IsCompleted())
+}
@@ -0,0 +1,117 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 任务绑定的本地队列, broadcast任务每个runtime都有一个
+func (e *EtcdStore) localTasks(ctx context.Context, taskName string, state model.State) ([]string, error) {
+	if !state.IsBroadcast() {
+		if state.RuntimeNode == "" {
+			return nil, nil
+		}
+		return []string{model.ToLocalTask(state.RuntimeNode, taskName)}, nil
+	}
+
+	rsp, err := e.defaultKVC.Get(ctx, model.LocalRuntimeTaskPrefix+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	var localKeys []string
+	for _, kv := range rsp.Kvs {
+		if strings.HasSuffix(string(kv.Key), "/"+taskName) {
+			localKeys = append(localKeys, string(kv.Key))
+		}
+	}
+	return localKeys, nil
+}
+
+// 删除全局数据和状态队列, 用一个事务完成, 不会留下只有一半的数据
+// 同一个事务里面把删除命令写入本地队列, 由连接runtime的gate推送下去, runtime可以马上停止执行
+// extra和删除在同一个事务里面写入
+func (e *EtcdStore) DeleteDataAndState(ctx context.Context, taskName string, extra ...clientv3.Op) error {
+	d, err := e.deleteOps(ctx, taskName)
+	if err != nil {
+		return err
+	}
+	d.ops = append(d.ops, extra...)
+
+	txnRsp, err := e.defaultKVC.Txn(ctx).If(d.cmps...).Then(d.ops...).Commit()
+	if err != nil {
+		return err
+	}
+
+	if !txnRsp.Succeeded {
+		return fmt.Errorf("delete task, Transaction execution failed:%s", taskName)
+	}
+	return nil
+}
+
+// 删除一个task需要的比较和操作
+type deleteTxn struct {
+	cmps []clientv3.Cmp
+	ops  []clientv3.Op
+	// 删除前task数据的revision
+	revision int64
+}
+
+// 读出task的数据和状态, 生成删除用的事务, 读和写之间被修改过时事务不会成功
+func (e *EtcdStore) deleteOps(ctx context.Context, taskName string) (d deleteTxn, err error) {
+	globalTaskName := model.FullGlobalTask(taskName)
+	globalTaskStateName := model.FullGlobalTaskState(taskName)
+
+	rspData, err := e.defaultKVC.Get(ctx, globalTaskName)
+	if err != nil {
+		return d, err
+	}
+
+	rspState, err := e.defaultKVC.Get(ctx, globalTaskStateName)
+	if err != nil {
+		return d, err
+	}
+
+	if len(rspData.Kvs) == 0 {
+		return d, fmt.Errorf("%w:%s", ErrTaskNotFound, taskName)
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(rspData.Kvs[0].Value, &param); err != nil {
+		return d, err
+	}
+	param.SetRemove()
+	rmData, err := json.Marshal(param)
+	if err != nil {
+		return d, err
+	}
+
+	d.revision = rspData.Kvs[0].ModRevision
+	d.cmps = []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(globalTaskName), "=", rspData.Kvs[0].ModRevision)}
+	d.ops = []clientv3.Op{clientv3.OpDelete(globalTaskName), clientv3.OpDelete(globalTaskStateName)}
+	if len(rspState.Kvs) > 0 {
+		d.cmps = append(d.cmps, clientv3.Compare(clientv3.ModRevision(globalTaskStateName), "=", rspState.Kvs[0].ModRevision))
+
+		state, err := model.ValueToState(rspState.Kvs[0].Value)
+		if err != nil {
+			return d, err
+		}
+
+		localKeys, err := e.localTasks(ctx, taskName, state)
+		if err != nil {
+			return d, err
+		}
+
+		for _, localKey := range localKeys {
+			d.ops = append(d.ops, clientv3.OpPut(localKey, string(rmData)))
+		}
+	} else {
+		d.cmps = append(d.cmps, clientv3.Compare(clientv3.CreateRevision(globalTaskStateName), "=", 0))
+	}
+	return d, nil
+}
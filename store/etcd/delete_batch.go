@@ -0,0 +1,92 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 一个事务里面最多的操作数, etcd的max-txn-ops默认是128
+const maxTxnOps = 128
+
+// 批量删除的结果
+type DeletedTask struct {
+	TaskName string
+	// 删除前task数据的revision
+	Revision int64
+}
+
+// 批量删除task的数据和状态, 尽量多的task放在一个事务里面, 删除命令一起写入本地队列
+// 事务没有成功说明这一批里面有task在读和写之间被修改过, 这一批再逐个加锁删除
+// 已经不存在的task跳过, failed是删除失败的task和原因
+// extra不为nil时给每个task生成一个额外的操作, 和这个task的删除在同一个事务里面写入, 比如审计记录
+func (e *EtcdStore) DeleteBatchDataAndState(ctx context.Context, taskNames []string, extra func(DeletedTask) clientv3.Op) (deleted []DeletedTask, failed map[string]error) {
+	failed = make(map[string]error)
+
+	var batch []string
+	var txns []deleteTxn
+	ops := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		deleted = append(deleted, e.commitDeleteBatch(ctx, batch, txns, failed, extra)...)
+		batch, txns, ops = nil, nil, 0
+	}
+
+	for _, taskName := range taskNames {
+		d, err := e.deleteOps(ctx, taskName)
+		if err != nil {
+			if !errors.Is(err, ErrTaskNotFound) {
+				failed[taskName] = err
+			}
+			continue
+		}
+		if extra != nil {
+			d.ops = append(d.ops, extra(DeletedTask{TaskName: taskName, Revision: d.revision}))
+		}
+
+		if ops+len(d.ops) > maxTxnOps || len(batch) >= maxTxnOps/2 {
+			flush()
+		}
+		batch = append(batch, taskName)
+		txns = append(txns, d)
+		ops += len(d.ops)
+	}
+	flush()
+	return deleted, failed
+}
+
+func (e *EtcdStore) commitDeleteBatch(ctx context.Context, batch []string, txns []deleteTxn, failed map[string]error, extra func(DeletedTask) clientv3.Op) (deleted []DeletedTask) {
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for _, d := range txns {
+		cmps = append(cmps, d.cmps...)
+		ops = append(ops, d.ops...)
+	}
+
+	rsp, err := e.defaultKVC.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err == nil && rsp.Succeeded {
+		for i, taskName := range batch {
+			deleted = append(deleted, DeletedTask{TaskName: taskName, Revision: txns[i].revision})
+		}
+		return deleted
+	}
+
+	for i, taskName := range batch {
+		t := DeletedTask{TaskName: taskName, Revision: txns[i].revision}
+		var ops []clientv3.Op
+		if extra != nil {
+			ops = append(ops, extra(t))
+		}
+		if err := e.LockDeleteDataAndState(ctx, taskName, ops...); err != nil {
+			if !errors.Is(err, ErrTaskNotFound) {
+				failed[taskName] = err
+			}
+			continue
+		}
+		deleted = append(deleted, t)
+	}
+	return deleted
+}
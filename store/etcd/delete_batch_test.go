@@ -0,0 +1,61 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/1whour/crab/model"
+	"github.com/1whour/crab/slog"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 此函数依赖etcd是否存在
+// 超过一个事务的task都能删除, 分配过的task删除命令写入本地队列, 不存在的task跳过
+func Test_DeleteBatchDataAndState(t *testing.T) {
+	e, err := NewStore([]string{"127.0.0.1:2379"}, slog.New(os.Stdout).SetLevel("error"), nil)
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	names := make([]string, maxTxnOps/2+6)
+	for i := range names {
+		names[i] = uuid.New().String()
+		param := model.Param{APIVersion: "v0.0.1", Kind: "oneRuntime", Trigger: model.Trigger{Cron: "* * * * * *"}}
+		param.Executer.TaskName = names[i]
+		param.SetCreate()
+		_, err = e.CreateDataAndState(ctx, names[i], &param)
+		assert.NoError(t, err)
+	}
+
+	// 第一个task分配到runtime上
+	runtimeNode := model.FullRuntimeNode(model.Whoami{Name: uuid.New().String()})
+	localKey := model.ToLocalTask(runtimeNode, names[0])
+	defer e.defaultKVC.Delete(ctx, localKey)
+	rsp, err := e.defaultKVC.Get(ctx, model.FullGlobalTaskState(names[0]))
+	assert.NoError(t, err)
+	assert.NoError(t, e.UpdateLocalAndGlobal(ctx, names[0], runtimeNode, rsp, model.Create, uuid.New().String()))
+
+	deleted, failed := e.DeleteBatchDataAndState(ctx, append(names, uuid.New().String()), nil)
+	assert.Empty(t, failed)
+	assert.Len(t, deleted, len(names))
+
+	for _, name := range names {
+		rsp, err := e.defaultKVC.Get(ctx, model.FullGlobalTask(name), clientv3.WithCountOnly())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), rsp.Count, name)
+		rsp, err = e.defaultKVC.Get(ctx, model.FullGlobalTaskState(name), clientv3.WithCountOnly())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), rsp.Count, name)
+	}
+
+	local, err := e.defaultKVC.Get(ctx, localKey)
+	assert.NoError(t, err)
+	if assert.Len(t, local.Kvs, 1) {
+		var param model.Param
+		assert.NoError(t, json.Unmarshal(local.Kvs[0].Value, &param))
+		assert.Equal(t, model.Rm, param.Action)
+	}
+}
@@ -0,0 +1,14 @@
+package etcd
+
+import "errors"
+
+var (
+	// 任务不存在
+	ErrTaskNotFound = errors.New("task not found")
+	// 任务已经存在
+	ErrTaskExists = errors.New("task already exists")
+	// 任务已经被别人修改过
+	ErrRevisionMismatch = errors.New("task revision mismatch")
+	// 所有的runtime都已经满载
+	ErrNoCapacity = errors.New("no runtime has free capacity")
+)
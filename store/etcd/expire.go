@@ -0,0 +1,81 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 一次性任务执行完之后, 数据和状态绑定一个租约, 保留ttl之后由etcd自动删除
+// 返回false表示不需要绑定: 不是一次性任务, 还没有执行结果, 或者已经绑定过租约
+func (e *EtcdStore) LockExpireOnceTask(ctx context.Context, taskName string, ttl time.Duration) (expired bool, err error) {
+	err = e.LockUnlock(ctx, taskName, func() (err error) {
+		expired, err = e.expireOnceTask(ctx, taskName, ttl)
+		return err
+	})
+	return
+}
+
+func (e *EtcdStore) expireOnceTask(ctx context.Context, taskName string, ttl time.Duration) (bool, error) {
+	dataKey := model.FullGlobalTask(taskName)
+	stateKey := model.FullGlobalTaskState(taskName)
+
+	rsp, err := e.defaultKVC.Txn(ctx).Then(clientv3.OpGet(dataKey), clientv3.OpGet(stateKey)).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	data, stateRsp := rsp.Responses[0].GetResponseRange(), rsp.Responses[1].GetResponseRange()
+	if len(data.Kvs) == 0 || len(stateRsp.Kvs) == 0 {
+		return false, ErrTaskNotFound
+	}
+
+	if data.Kvs[0].Lease != 0 {
+		return false, nil
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(data.Kvs[0].Value, &param); err != nil {
+		return false, err
+	}
+
+	state, err := model.ValueToState(stateRsp.Kvs[0].Value)
+	if err != nil {
+		return false, err
+	}
+
+	if !param.Trigger.IsOnce() || state.LastResult == nil {
+		return false, nil
+	}
+
+	lease, err := e.defaultClient.Grant(ctx, int64(ttl/time.Second))
+	if err != nil {
+		return false, err
+	}
+
+	ops := []clientv3.Op{
+		clientv3.OpPut(dataKey, string(data.Kvs[0].Value), clientv3.WithLease(lease.ID)),
+		clientv3.OpPut(stateKey, string(stateRsp.Kvs[0].Value), clientv3.WithLease(lease.ID)),
+	}
+	// 本地队列里面的key直接删除, 重新写入会让gate再下发一次
+	if state.RuntimeNode != "" {
+		ops = append(ops, clientv3.OpDelete(model.ToLocalTask(state.RuntimeNode, taskName)))
+	}
+
+	txn, err := e.defaultKVC.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(dataKey), "=", data.Kvs[0].ModRevision),
+			clientv3.Compare(clientv3.ModRevision(stateKey), "=", stateRsp.Kvs[0].ModRevision)).
+		Then(ops...).
+		Commit()
+	if err == nil && !txn.Succeeded {
+		err = ErrRevisionMismatch
+	}
+	if err != nil {
+		e.defaultClient.Revoke(ctx, lease.ID)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 修改执行中的任务的存活状态, 没有心跳时设置成model.Stalled, 恢复之后清空
+// 返回false表示不需要修改: 状态已经是这个值, 或者任务已经分配到别的runtime
+func (e *EtcdStore) SetLiveness(ctx context.Context, taskName string, runtimeNode string, liveness string) (bool, error) {
+	stateKey := model.FullGlobalTaskState(taskName)
+
+	for i := 0; i < maxResultRetry; i++ {
+		rsp, err := e.defaultKVC.Get(ctx, stateKey)
+		if err != nil {
+			return false, err
+		}
+		if len(rsp.Kvs) == 0 {
+			return false, ErrTaskNotFound
+		}
+
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		if err != nil {
+			return false, err
+		}
+
+		if state.Liveness == liveness {
+			return false, nil
+		}
+		// 广播任务每个runtime都在跑, 不用比较绑定的节点
+		if state.IsOneRuntime() && state.RuntimeNode != runtimeNode {
+			return false, nil
+		}
+
+		state.Liveness = liveness
+		value, err := json.Marshal(state)
+		if err != nil {
+			return false, err
+		}
+
+		// 和写结果一样只改这一个字段, 保留原来的租约
+		var opts []clientv3.OpOption
+		if rsp.Kvs[0].Lease != 0 {
+			opts = append(opts, clientv3.WithIgnoreLease())
+		}
+		txn, err := e.defaultKVC.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(stateKey), "=", rsp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(stateKey, string(value), opts...)).
+			Commit()
+		if err != nil {
+			return false, err
+		}
+
+		if txn.Succeeded {
+			return true, nil
+		}
+	}
+	return false, ErrRevisionMismatch
+}
@@ -4,29 +4,48 @@ import (
 	"context"
 
 	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-func (e *EtcdStore) LockCreateDataAndState(ctx context.Context, taskName string, req *model.Param) error {
-	return e.LockUnlock(ctx, taskName, func() error {
-		return e.CreateDataAndState(ctx, taskName, req)
+func (e *EtcdStore) LockCreateDataAndState(ctx context.Context, taskName string, req *model.Param, extra ...clientv3.Op) (revision int64, err error) {
+	err = e.LockUnlock(ctx, taskName, func() (err error) {
+		revision, err = e.CreateDataAndState(ctx, taskName, req, extra...)
+		return err
 	})
+	return
+}
+
+func (e *EtcdStore) LockUpdateAction(ctx context.Context, taskName string, req *model.OnlyParam, rspModRevision int64, state string, action string, extra ...clientv3.Op) (revision int64, err error) {
+	err = e.LockUnlock(ctx, taskName, func() (err error) {
+		revision, err = e.UpdateAction(ctx, req, rspModRevision, state, action, extra...)
+		return err
+	})
+	return
+}
 
+func (e *EtcdStore) LockUpdateDataAndState(ctx context.Context, taskName string, req *model.Param, rspModRevision int64, state string, action string, extra ...clientv3.Op) (revision int64, err error) {
+	err = e.LockUnlock(ctx, taskName, func() (err error) {
+		revision, err = e.UpdateDataAndState(ctx, req, rspModRevision, state, action, extra...)
+		return err
+	})
+	return
 }
 
-func (e *EtcdStore) LockUpdateAction(ctx context.Context, taskName string, req *model.OnlyParam, rspModRevision int64, state string, action string) error {
+func (e *EtcdStore) LockUpdateCallStateSuccessed(ctx context.Context, taskName string) error {
 	return e.LockUnlock(ctx, taskName, func() error {
-		return e.UpdateAction(ctx, req, rspModRevision, state, action)
+		return e.UpdateCallStateSuccessed(ctx, taskName)
 	})
 }
 
-func (e *EtcdStore) LockUpdateDataAndState(ctx context.Context, taskName string, req *model.Param, rspModRevision int64, state string, action string) error {
+// 等到runtime的ack或者重试次数用完之后调用
+func (e *EtcdStore) LockUpdateCallStateAttempts(ctx context.Context, taskName string, succeeded bool, attempts int) error {
 	return e.LockUnlock(ctx, taskName, func() error {
-		return e.UpdateDataAndState(ctx, req, rspModRevision, state, action)
+		return e.UpdateCallStateInner(ctx, taskName, succeeded, attempts)
 	})
 }
 
-func (e *EtcdStore) LockUpdateCallStateSuccessed(ctx context.Context, taskName string) error {
+func (e *EtcdStore) LockDeleteDataAndState(ctx context.Context, taskName string, extra ...clientv3.Op) error {
 	return e.LockUnlock(ctx, taskName, func() error {
-		return e.UpdateCallStateSuccessed(ctx, taskName)
+		return e.DeleteDataAndState(ctx, taskName, extra...)
 	})
 }
@@ -29,6 +29,11 @@ import (
 
 // 5.新加id字段，必须保证唯一性，taskName相同，id不同，说明被重启了，需要同步数据
 func (m *EtcdStore) NeedFix(ctx context.Context, state model.State) bool {
+	// 执行过的一次性任务和还没到时间的任务都不需要分配
+	if state.IsCompleted() || state.IsWaiting() {
+		return false
+	}
+
 	if state.IsFailed() {
 		return true
 	}
@@ -0,0 +1,35 @@
+package etcd
+
+import (
+	"context"
+
+	"github.com/1whour/crab/model"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 任务已经分配给runtime, 但是runtime没有确认(InRuntime为false), 比如推送的gate在推送之前挂了
+// 重新写一次本地队列, 连接着这个runtime的gate收到修改事件之后再推送一次
+// 状态在读和写之间被修改过, 或者runtime已经不在了时不处理, 返回false
+// cmps是额外的写入条件, 主节点用来保证只有当前的主节点能写
+func (e *EtcdStore) Redispatch(ctx context.Context, stateKv *mvccpb.KeyValue, state model.State, cmps ...clientv3.Cmp) (bool, error) {
+	if state.IsCompleted() {
+		return false, nil
+	}
+
+	stateKey := string(stateKv.Key)
+	localKey := model.ToLocalTask(state.RuntimeNode, model.TaskName(stateKey))
+
+	cmps = append(cmps,
+		clientv3.Compare(clientv3.ModRevision(stateKey), "=", stateKv.ModRevision),
+		clientv3.Compare(clientv3.CreateRevision(state.RuntimeNode), ">", 0),
+	)
+	txn, err := e.defaultKVC.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(localKey, localValue(state.Action))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return txn.Succeeded, nil
+}
@@ -0,0 +1,100 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// runtime断开之后, 把绑定在这个runtime上的任务重置成CanRun, 清空绑定关系, 由mjobs重新分配
+// 返回被重置的任务名, cmps是额外的写入条件, 主节点用来保证只有当前的主节点能写
+func (e *EtcdStore) LockResetRuntime(ctx context.Context, runtimeNode string, cmps ...clientv3.Cmp) (taskNames []string, err error) {
+	rsp, err := e.defaultKVC.Get(ctx, model.ToLocalTaskPrefix(runtimeNode)+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range rsp.Kvs {
+		taskName := model.TaskName(string(kv.Key))
+		if taskName == "" {
+			continue
+		}
+
+		// 和mjobs的failover用的是同一把锁, 谁先拿到锁谁处理, 另一方看到绑定关系变了就跳过
+		stateKey := model.FullGlobalTaskState(taskName)
+		var reset bool
+		err = e.LockUnlock(ctx, stateKey, func() (err error) {
+			reset, err = e.resetRuntime(ctx, runtimeNode, taskName, string(kv.Key), cmps)
+			return err
+		})
+		if err != nil {
+			return taskNames, err
+		}
+
+		if reset {
+			taskNames = append(taskNames, taskName)
+		}
+	}
+	return taskNames, nil
+}
+
+func (e *EtcdStore) resetRuntime(ctx context.Context, runtimeNode, taskName, localKey string, cmps []clientv3.Cmp) (bool, error) {
+	// runtime可能已经重连上了, 这时候不需要重新分配
+	node, err := e.defaultKVC.Get(ctx, runtimeNode, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	if node.Count > 0 {
+		return false, nil
+	}
+
+	stateKey := model.FullGlobalTaskState(taskName)
+	rsp, err := e.defaultKVC.Get(ctx, stateKey)
+	if err != nil {
+		return false, err
+	}
+	if len(rsp.Kvs) == 0 {
+		return false, nil
+	}
+
+	state, err := model.ValueToState(rsp.Kvs[0].Value)
+	if err != nil {
+		return false, err
+	}
+
+	// 已经被别的节点接管, 或者任务只能跑在指定的runtime上
+	if state.RuntimeNode != runtimeNode || state.TargetRuntime != "" || state.Lambda || state.IsBroadcast() {
+		return false, nil
+	}
+
+	// 执行过的一次性任务不用再分配
+	if !(state.IsCreate() || state.IsUpdate() || state.IsContinue()) || state.IsCompleted() {
+		return false, nil
+	}
+
+	state.State = model.CanRun
+	state.RuntimeNode = ""
+	state.RuntimeID = ""
+	state.InRuntime = false
+	state.Ack = false
+	state.UpdateTime = time.Now()
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+
+	cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(stateKey), "=", rsp.Kvs[0].ModRevision))
+	txn, err := e.defaultKVC.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(stateKey, string(value)), clientv3.OpDelete(localKey)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return txn.Succeeded, nil
+}
@@ -0,0 +1,79 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 写结果和mjobs分配任务可能同时修改状态, 冲突时多试几次
+const maxResultRetry = 3
+
+// 把runtime上报的执行结果写入全局状态, 任务已经被删除时返回ErrTaskNotFound
+func (e *EtcdStore) UpdateLastResult(ctx context.Context, result *model.TaskResult) error {
+	stateKey := model.FullGlobalTaskState(result.TaskName)
+
+	// 执行完之后按触发器计算下一次触发的时间
+	var param model.Param
+	task, err := e.defaultKVC.Get(ctx, model.FullGlobalTask(result.TaskName))
+	if err != nil {
+		return err
+	}
+	if len(task.Kvs) == 0 {
+		return ErrTaskNotFound
+	}
+	if err = json.Unmarshal(task.Kvs[0].Value, &param); err != nil {
+		return err
+	}
+
+	for i := 0; i < maxResultRetry; i++ {
+		rsp, err := e.defaultKVC.Get(ctx, stateKey)
+		if err != nil {
+			return err
+		}
+		if len(rsp.Kvs) == 0 {
+			return ErrTaskNotFound
+		}
+
+		state, err := model.ValueToState(rsp.Kvs[0].Value)
+		if err != nil {
+			return err
+		}
+
+		state.LastResult = result
+		// 已经执行完了, 不再是stalled
+		state.Liveness = ""
+		state.SetNextRun(param.Trigger, time.Now())
+		// 一次性任务执行过了, 标记为Completed, runtime重启或者重连之后不会再跑一次
+		// 只改正在运行的, 执行期间被continue或者更新过的任务还需要再跑
+		if param.Trigger.IsOnce() && state.IsRunning() && (state.IsCreate() || state.IsUpdate() || state.IsContinue()) {
+			state.State = model.Completed
+		}
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		// 只改结果, 状态被别人修改过时重新读一次
+		// 保留原来的租约, 执行完的一次性任务绑定了租约
+		var opts []clientv3.OpOption
+		if rsp.Kvs[0].Lease != 0 {
+			opts = append(opts, clientv3.WithIgnoreLease())
+		}
+		txn, err := e.defaultKVC.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(stateKey), "=", rsp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(stateKey, string(value), opts...)).
+			Commit()
+		if err != nil {
+			return err
+		}
+
+		if txn.Succeeded {
+			return nil
+		}
+	}
+	return ErrRevisionMismatch
+}
@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 执行超时的任务改成stop, 由mjobs下发到runtime, 状态里面记录停止的原因
+// 返回false表示不需要停止: 任务已经停止或者删除, 或者已经分配到别的runtime
+func (e *EtcdStore) LockStopTimedOut(ctx context.Context, taskName string, runtimeNode string) (stopped bool, err error) {
+	err = e.LockUnlock(ctx, taskName, func() (err error) {
+		stopped, err = e.stopTimedOut(ctx, taskName, runtimeNode)
+		return err
+	})
+	return
+}
+
+func (e *EtcdStore) stopTimedOut(ctx context.Context, taskName string, runtimeNode string) (bool, error) {
+	dataKey := model.FullGlobalTask(taskName)
+	stateKey := model.FullGlobalTaskState(taskName)
+
+	rsp, err := e.defaultKVC.Txn(ctx).Then(clientv3.OpGet(dataKey), clientv3.OpGet(stateKey)).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	data, stateRsp := rsp.Responses[0].GetResponseRange(), rsp.Responses[1].GetResponseRange()
+	if len(data.Kvs) == 0 || len(stateRsp.Kvs) == 0 {
+		return false, ErrTaskNotFound
+	}
+
+	state, err := model.ValueToState(stateRsp.Kvs[0].Value)
+	if err != nil {
+		return false, err
+	}
+
+	if state.IsStop() || state.IsRemove() {
+		return false, nil
+	}
+	// 广播任务每个runtime都在跑, 不用比较绑定的节点
+	if state.IsOneRuntime() && state.RuntimeNode != runtimeNode {
+		return false, nil
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(data.Kvs[0].Value, &param); err != nil {
+		return false, err
+	}
+	param.SetStop()
+
+	globalData, err := json.Marshal(&param)
+	if err != nil {
+		return false, err
+	}
+
+	// 和stop接口一样置为CanRun, mjobs看到之后下发stop
+	state.State = model.CanRun
+	state.Action = model.Stop
+	state.Ack = false
+	state.UpdateTime = time.Now()
+	state.StopReason = model.TimedOut
+	value, err := json.Marshal(&state)
+	if err != nil {
+		return false, err
+	}
+
+	txn, err := e.defaultKVC.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(dataKey), "=", data.Kvs[0].ModRevision),
+			clientv3.Compare(clientv3.ModRevision(stateKey), "=", stateRsp.Kvs[0].ModRevision)).
+		Then(clientv3.OpPut(dataKey, string(globalData)), clientv3.OpPut(stateKey, string(value))).
+		Commit()
+	if err == nil && !txn.Succeeded {
+		err = ErrRevisionMismatch
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/1whour/crab/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 主节点的定时器到点之后调用, 把等待触发的任务置为CanRun, 由mjobs分配到runtime
+// cmps是额外的写入条件, 主节点用来保证只有当前的主节点能写
+// 返回false表示不需要触发: 任务不是Waiting, 已经被停止, 删除或者禁用
+func (e *EtcdStore) LockTriggerTask(ctx context.Context, taskName string, cmps ...clientv3.Cmp) (triggered bool, err error) {
+	stateKey := model.FullGlobalTaskState(taskName)
+	err = e.LockUnlock(ctx, stateKey, func() (err error) {
+		triggered, err = e.triggerTask(ctx, taskName, cmps)
+		return err
+	})
+	return
+}
+
+func (e *EtcdStore) triggerTask(ctx context.Context, taskName string, cmps []clientv3.Cmp) (bool, error) {
+	dataKey := model.FullGlobalTask(taskName)
+	stateKey := model.FullGlobalTaskState(taskName)
+
+	rsp, err := e.defaultKVC.Txn(ctx).Then(clientv3.OpGet(dataKey), clientv3.OpGet(stateKey)).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	data, stateRsp := rsp.Responses[0].GetResponseRange(), rsp.Responses[1].GetResponseRange()
+	if len(data.Kvs) == 0 || len(stateRsp.Kvs) == 0 {
+		return false, ErrTaskNotFound
+	}
+
+	state, err := model.ValueToState(stateRsp.Kvs[0].Value)
+	if err != nil {
+		return false, err
+	}
+
+	if !state.IsWaiting() || !(state.IsCreate() || state.IsUpdate() || state.IsContinue()) {
+		return false, nil
+	}
+
+	var param model.Param
+	if err = json.Unmarshal(data.Kvs[0].Value, &param); err != nil {
+		return false, err
+	}
+	if param.Disabled {
+		return false, nil
+	}
+
+	state.State = model.CanRun
+	// 窗口外触发并且配置了skip, 这次不执行, 直接结束
+	if param.Trigger.Skipped(time.Now()) {
+		state.State = model.Completed
+	}
+	state.Ack = false
+	state.UpdateTime = time.Now()
+	value, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+
+	cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(stateKey), "=", stateRsp.Kvs[0].ModRevision))
+	txn, err := e.defaultKVC.Txn(ctx).If(cmps...).Then(clientv3.OpPut(stateKey, string(value))).Commit()
+	if err != nil {
+		return false, err
+	}
+	return txn.Succeeded, nil
+}
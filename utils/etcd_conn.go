@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/1whour/crab/slog"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/connectivity"
+)
+
+// 监听和etcd之间连接状态的变化并打印日志, clientv3断开之后会自己重连, 这里只是让运维能看到
+// ctx取消或者连接关闭时退出
+func WatchEtcdConn(ctx context.Context, log *slog.Slog, client *clientv3.Client) {
+	conn := client.ActiveConnection()
+	if conn == nil {
+		return
+	}
+
+	state := conn.GetState()
+	for conn.WaitForStateChange(ctx, state) {
+		newState := conn.GetState()
+		switch newState {
+		case connectivity.Ready:
+			log.Info().Msgf("etcd connection %s -> %s, endpoints:%v", state, newState, client.Endpoints())
+		case connectivity.TransientFailure:
+			log.Warn().Msgf("etcd connection %s -> %s, endpoints:%v", state, newState, client.Endpoints())
+		case connectivity.Shutdown:
+			log.Info().Msgf("etcd connection %s -> %s", state, newState)
+			return
+		default:
+			log.Debug().Msgf("etcd connection %s -> %s", state, newState)
+		}
+		state = newState
+	}
+}
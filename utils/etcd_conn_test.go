@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/slog"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 连不上etcd时打印连接状态的变化, ctx取消之后退出
+func Test_WatchEtcdConn(t *testing.T) {
+	client, err := NewEtcdClient([]string{"127.0.0.1:1"})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	var buf syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchEtcdConn(ctx, slog.New(&buf).SetLevel("debug"), client)
+		close(done)
+	}()
+
+	// 触发一次连接
+	go client.Get(ctx, "/crab/test/conn")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), "TRANSIENT_FAILURE")
+	}, 5*time.Second, 10*time.Millisecond, buf.String())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("WatchEtcdConn did not return after ctx was canceled")
+	}
+}
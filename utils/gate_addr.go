@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+)
+
+// gate注册的地址, 开启tls时带上wss://, 没有开启时是ip:port
+// 转成建立长连接用的地址, 没有scheme时当成ws://
+func GateWsAddr(addr string) string {
+	switch {
+	case strings.HasPrefix(addr, "ws://"), strings.HasPrefix(addr, "wss://"):
+		return addr
+	case strings.HasPrefix(addr, "https://"):
+		return "wss://" + strings.TrimPrefix(addr, "https://")
+	case strings.HasPrefix(addr, "http://"):
+		return "ws://" + strings.TrimPrefix(addr, "http://")
+	}
+	return "ws://" + addr
+}
+
+// 转成调用http接口用的地址, wss对应https
+func GateHTTPAddr(addr string) string {
+	switch {
+	case strings.HasPrefix(addr, "http://"), strings.HasPrefix(addr, "https://"):
+		return addr
+	case strings.HasPrefix(addr, "wss://"):
+		return "https://" + strings.TrimPrefix(addr, "wss://")
+	case strings.HasPrefix(addr, "ws://"):
+		return "http://" + strings.TrimPrefix(addr, "ws://")
+	}
+	return "http://" + addr
+}
+
+// 连接gate时校验证书用的tls配置, caFile为空时用系统的根证书
+func GateTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	return transport.TLSInfo{TrustedCAFile: caFile}.ClientConfig()
+}
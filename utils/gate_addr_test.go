@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GateAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		ws   string
+		http string
+	}{
+		{"127.0.0.1:3434", "ws://127.0.0.1:3434", "http://127.0.0.1:3434"},
+		{"ws://127.0.0.1:3434", "ws://127.0.0.1:3434", "http://127.0.0.1:3434"},
+		{"wss://127.0.0.1:3434", "wss://127.0.0.1:3434", "https://127.0.0.1:3434"},
+		{"http://127.0.0.1:3434", "ws://127.0.0.1:3434", "http://127.0.0.1:3434"},
+		{"https://127.0.0.1:3434", "wss://127.0.0.1:3434", "https://127.0.0.1:3434"},
+	} {
+		assert.Equal(t, tc.ws, GateWsAddr(tc.addr), tc.addr)
+		assert.Equal(t, tc.http, GateHTTPAddr(tc.addr), tc.addr)
+	}
+}
+
+func Test_GateTLSConfig(t *testing.T) {
+	c, err := GateTLSConfig("")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	_, err = GateTLSConfig("not-exist.pem")
+	assert.Error(t, err)
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"strings"
 	"sync"
 	"time"
 
@@ -59,13 +58,6 @@ func (g *GateSocket) readLoop(conn *websocket.Conn) error {
 
 }
 
-func genGateAddr(gateAddr string) string {
-	if strings.HasPrefix(gateAddr, "ws://") || strings.HasPrefix(gateAddr, "wss://") {
-		return gateAddr
-	}
-	return "ws://" + gateAddr
-}
-
 func (g *GateSocket) writeWhoami(conn *websocket.Conn) (err error) {
 	g.mu.Lock()
 	err = WriteJsonTimeout(conn, model.Whoami{Name: g.name}, g.writeTimeout)
@@ -76,7 +68,7 @@ func (g *GateSocket) writeWhoami(conn *websocket.Conn) (err error) {
 // 创建一个长连接
 func (g *GateSocket) createConntion() error {
 
-	gateAddr := genGateAddr(g.gateAddr) + model.TASK_STREAM_URL
+	gateAddr := GateWsAddr(g.gateAddr) + model.TASK_STREAM_URL
 	c, _, err := websocket.DefaultDialer.Dial(gateAddr, nil)
 	if err != nil {
 		g.Error().Msgf("runtime:dial:%s, address:%s\n", err, gateAddr)
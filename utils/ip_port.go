@@ -49,6 +49,31 @@ func GetIp() (rv string, err error) {
 	return ip[0], nil
 }
 
+// 主出口网卡的ip, udp的Dial不会真正发包, 只是通过路由表选出本地地址
+func GetOutboundIp() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.IP.IsUnspecified() || addr.IP.IsLoopback() {
+		return "", errors.New("outbound ip not found")
+	}
+	return addr.IP.String(), nil
+}
+
+// 主出口网卡的ip加上没有使用的端口, 没有默认路由时退回到GetUnusedAddr
+func GetUnusedOutboundAddr() string {
+	ip, err := GetOutboundIp()
+	if err != nil {
+		return GetUnusedAddr()
+	}
+
+	return ip + ":" + GetUnusedPort(ip)
+}
+
 func GetUnusedAddr() string {
 	ip, err := GetIp()
 	if err != nil {
@@ -8,7 +8,8 @@ import (
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-func NewLeaseWithKeepalive(ctx context.Context, log *slog.Slog, client *clientv3.Client, ttl time.Duration) (clientv3.LeaseID, error) {
+// onStop在自动续约停止时调用(租约过期或者和etcd断开)
+func NewLeaseWithKeepalive(ctx context.Context, log *slog.Slog, client *clientv3.Client, ttl time.Duration, onStop ...func()) (clientv3.LeaseID, error) {
 	// 创建一个lease对象
 	lease := clientv3.NewLease(client)
 	// 申请一个ttl/time.Second的lease
@@ -22,8 +23,16 @@ func NewLeaseWithKeepalive(ctx context.Context, log *slog.Slog, client *clientv3
 
 	// 自动续约
 	keepRespChan, err := lease.KeepAlive(ctx, leaseID)
+	if err != nil {
+		return 0, err
+	}
 
 	go func() {
+		defer func() {
+			for _, f := range onStop {
+				f()
+			}
+		}()
 
 		// 自动应答
 		for rsp := range keepRespChan {
@@ -42,5 +51,9 @@ func NewLease(ctx context.Context, log *slog.Slog, client *clientv3.Client, ttl
 	lease := clientv3.NewLease(client)
 	// 申请一个ttl/time.Second的lease
 	leaseGrantResp, err := lease.Grant(ctx, int64(ttl/time.Second))
-	return lease, leaseGrantResp.ID, err
+	if err != nil {
+		lease.Close()
+		return nil, 0, err
+	}
+	return lease, leaseGrantResp.ID, nil
 }
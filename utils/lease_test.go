@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1whour/crab/slog"
+	"github.com/stretchr/testify/assert"
+)
+
+// 此函数依赖etcd是否存在
+// 申请租约失败时返回错误, 不会panic
+func Test_NewLease_GrantFail(t *testing.T) {
+	client, err := NewEtcdClient([]string{"127.0.0.1:2379"})
+	assert.NoError(t, err)
+	client.Close()
+
+	lease, leaseID, err := NewLease(context.TODO(), slog.New(os.Stdout).SetLevel("error"), client, time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, lease)
+	assert.Zero(t, leaseID)
+}
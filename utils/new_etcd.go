@@ -1,18 +1,88 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
 )
 
+// etcd的认证和tls配置, 都为空时和原来一样直连
+type EtcdConfig struct {
+	Username string
+	Password string
+	// tls, 只配置CA时只校验服务端证书, 配置Cert和Key时走双向认证
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// 多个部署共用一个etcd集群时, 每个部署的所有key都放在/Namespace下面, 为空时不加前缀
+	Namespace string
+}
+
 // 创建etcd的连接池
 func NewEtcdClient(endpoints []string) (*clientv3.Client, error) {
+	return NewEtcdClientWithConfig(endpoints, EtcdConfig{})
+}
 
-	return clientv3.New(clientv3.Config{
+// 创建带认证或者tls的etcd连接池
+func NewEtcdClientWithConfig(endpoints []string, conf EtcdConfig) (*clientv3.Client, error) {
+	config := clientv3.Config{
 		//Endpoints:   []string{"localhost:2379", "localhost:22379", "localhost:32379"},
 		Endpoints:   endpoints,
 		DialTimeout: 5 * time.Second,
-	})
+		Username:    conf.Username,
+		Password:    conf.Password,
+	}
+
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		return nil, errors.New("etcd cert file and key file must be set together")
+	}
+
+	if conf.CAFile != "" || conf.CertFile != "" {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile: conf.CAFile,
+			CertFile:      conf.CertFile,
+			KeyFile:       conf.KeyFile,
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+	}
+
+	prefix, err := namespacePrefix(conf.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientv3.New(config)
+	if err != nil || prefix == "" {
+		return client, err
+	}
+
+	// 读写, watch, 租约都加上前缀, 分布式锁和选主也是在这个基础上实现的, 一起被隔离
+	// 返回的key会去掉前缀, 上层的路径函数不需要感知namespace
+	client.KV = namespace.NewKV(client.KV, prefix)
+	client.Watcher = namespace.NewWatcher(client.Watcher, prefix)
+	client.Lease = namespace.NewLease(client.Lease, prefix)
+	return client, nil
+}
+
+// namespace转成etcd key的前缀, 只允许字母, 数字和-_.
+func namespacePrefix(ns string) (string, error) {
+	if ns == "" {
+		return "", nil
+	}
 
+	for _, c := range ns {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("invalid etcd namespace:%q", ns)
+		}
+	}
+	return "/" + ns, nil
 }
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 此函数依赖etcd是否存在
+// 不同namespace的key互相看不到, 返回的key不带前缀
+func Test_EtcdNamespace(t *testing.T) {
+	ctx := context.TODO()
+	nsA, nsB := uuid.New().String(), uuid.New().String()
+
+	raw, err := NewEtcdClient([]string{"127.0.0.1:2379"})
+	assert.NoError(t, err)
+	defer raw.Close()
+	a, err := NewEtcdClientWithConfig([]string{"127.0.0.1:2379"}, EtcdConfig{Namespace: nsA})
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := NewEtcdClientWithConfig([]string{"127.0.0.1:2379"}, EtcdConfig{Namespace: nsB})
+	assert.NoError(t, err)
+	defer b.Close()
+
+	for _, ns := range []string{nsA, nsB} {
+		defer raw.Delete(ctx, "/"+ns+"/", clientv3.WithPrefix())
+	}
+
+	const prefix = "/crab/v1/global/runq/task/data"
+	ch := a.Watch(ctx, prefix, clientv3.WithPrefix())
+
+	_, err = a.Put(ctx, prefix+"/task", "a")
+	assert.NoError(t, err)
+	_, err = b.Put(ctx, prefix+"/task", "b")
+	assert.NoError(t, err)
+
+	rsp, err := a.KV.Get(ctx, prefix, clientv3.WithPrefix())
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, prefix+"/task", string(rsp.Kvs[0].Key))
+		assert.Equal(t, "a", string(rsp.Kvs[0].Value))
+	}
+
+	rsp, err = raw.Get(ctx, "/"+nsB+prefix+"/task")
+	assert.NoError(t, err)
+	if assert.Len(t, rsp.Kvs, 1) {
+		assert.Equal(t, "b", string(rsp.Kvs[0].Value))
+	}
+
+	select {
+	case w := <-ch:
+		if assert.Len(t, w.Events, 1) {
+			assert.Equal(t, prefix+"/task", string(w.Events[0].Kv.Key))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	_, err = NewEtcdClientWithConfig([]string{"127.0.0.1:2379"}, EtcdConfig{Namespace: "a/b"})
+	assert.Error(t, err)
+}
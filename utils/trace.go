@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 从task带的trace context里面取出trace id, 日志里面用来把gate和runtime两端对应起来
+// 没有trace context时返回空
+func TraceID(carrier map[string]string) string {
+	if len(carrier) == 0 {
+		return ""
+	}
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(carrier))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TraceID(t *testing.T) {
+	assert.Equal(t, "", TraceID(nil))
+	assert.Equal(t, "", TraceID(map[string]string{"traceparent": "bad"}))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736",
+		TraceID(map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}))
+}